@@ -0,0 +1,344 @@
+package main
+
+// Subcommands to work with config and devices without running the daemon
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/contactless/wbgo"
+	"github.com/gosnmp/gosnmp"
+	m "github.com/wirenboard/wb-mqtt-snmp/mqtt_snmp"
+	sim "github.com/wirenboard/wb-mqtt-snmp/snmp_simulator"
+)
+
+// Usage of template command, it is printed on wrong arguments too
+const templateUsage = "template [-type <device-type>] [-modules <MIB,...>] {<device-id> | -file <walk-file>} [<subtree>...]"
+
+// Usage of simulate command
+const simulateUsage = "simulate [-listen <addr>] [-community <community>] [-script <file>] <snmprec-or-walk-file>"
+
+// Subcommand runs with arguments following its name and returns exit code
+type command struct {
+	usage string
+	run   func(configFile, templatesDir string, args []string) int
+}
+
+var commands = map[string]command{
+	"check":    {"check - validate config and templates", runCheck},
+	"get":      {"get <device-id> <oid>... - read variables of configured device", runGet},
+	"walk":     {"walk <device-id> <subtree> - read subtree of configured device", runWalk},
+	"template": {templateUsage + " - make template from walk", runTemplate},
+	"simulate": {simulateUsage + " - serve variables as SNMP agent", runSimulate},
+}
+
+// Print usage with list of subcommands
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [options] [command [arguments]]\n\nWithout command the daemon is started.\n\nCommands:\n", os.Args[0])
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n", commands[name].usage)
+	}
+
+	fmt.Fprintf(out, "\nOptions:\n")
+	flag.PrintDefaults()
+}
+
+// Run subcommand given in arguments, exit code is returned
+func runCommand(args []string, configFile, templatesDir string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+		usage()
+		return 2
+	}
+	return cmd.run(configFile, templatesDir, args[1:])
+}
+
+// Validate config and templates and print report
+// Parser warnings are shown in report, so they're logged with debug only
+func runCheck(configFile, templatesDir string, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "check takes no arguments\n")
+		return 2
+	}
+
+	if !wbgo.DebuggingEnabled() {
+		wbgo.Warn.SetOutput(io.Discard)
+	}
+
+	r, err := os.Open(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't open config file %s: %s\n", configFile, err)
+		return 6
+	}
+	defer r.Close()
+
+	report := m.CheckConfig(r, templatesDir)
+	report.Write(os.Stdout)
+	if report.HasErrors() {
+		return 1
+	}
+	return 0
+}
+
+// Create query of configured device
+func newDeviceQuery(configFile, templatesDir, id string) (*m.DeviceQuery, error) {
+	cfg, err := readConfig(configFile, templatesDir, false)
+	if err != nil {
+		return nil, err
+	}
+	return m.NewDeviceQuery(m.NewGoSNMP, cfg, id, wbgo.DebuggingEnabled())
+}
+
+// Read variables of device and print them with values of channels
+func runQuery(configFile, templatesDir, id string, query func(q *m.DeviceQuery) ([]m.QueryResult, error)) int {
+	q, err := newDeviceQuery(configFile, templatesDir, id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 6
+	}
+
+	res, err := query(q)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", id, err)
+		return 1
+	}
+
+	m.WriteQueryResults(os.Stdout, res)
+	return 0
+}
+
+// Read variables of device by GET request
+func runGet(configFile, templatesDir string, args []string) int {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: get <device-id> <oid>...\n")
+		return 2
+	}
+	return runQuery(configFile, templatesDir, args[0], func(q *m.DeviceQuery) ([]m.QueryResult, error) {
+		return q.Get(args[1:])
+	})
+}
+
+// Read subtree of device
+func runWalk(configFile, templatesDir string, args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: walk <device-id> <subtree>\n")
+		return 2
+	}
+	return runQuery(configFile, templatesDir, args[0], func(q *m.DeviceQuery) ([]m.QueryResult, error) {
+		return q.Walk(args[1])
+	})
+}
+
+// SNMP versions as they're written in config
+var snmpVersionNames = map[gosnmp.SnmpVersion]string{
+	gosnmp.Version1:  "1",
+	gosnmp.Version2c: "2c",
+	gosnmp.Version3:  "3",
+}
+
+// Get MIB directories from config, defaults are used
+// if config can't be read, i.e. on developer's machine
+func configMibDirs(configFile, templatesDir string) []string {
+	r, err := os.Open(configFile)
+	if err != nil {
+		return m.DefaultMibDirs
+	}
+	defer r.Close()
+
+	cfg, err := m.NewDaemonConfig(r, templatesDir)
+	if _, ok := err.(m.DeviceConfigErrors); err != nil && !ok {
+		return m.DefaultMibDirs
+	}
+	return cfg.MibDirs
+}
+
+// Read variables of walk file
+func readWalkFile(name string, mib *m.Mib) ([]gosnmp.SnmpPDU, error) {
+	r, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	pdus, err := m.ReadWalk(r, mib)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return pdus, nil
+}
+
+// Make template from walk of configured device or from walk file
+func runTemplate(configFile, templatesDir string, args []string) int {
+	flags := flag.NewFlagSet("template", flag.ContinueOnError)
+	deviceType := flags.String("type", "", "Device type of template, device ID by default")
+	modules := flags.String("modules", "", "Comma-separated MIB modules to take variables from")
+	walkFile := flags.String("file", "", "Read variables from snmpwalk output instead of device")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+
+	opts := m.TemplateOptions{DeviceType: *deviceType}
+	if *modules != "" {
+		opts.Modules = strings.Split(*modules, ",")
+	}
+
+	var mib *m.Mib
+	var pdus []gosnmp.SnmpPDU
+	if *walkFile != "" {
+		if opts.DeviceType == "" {
+			fmt.Fprintf(os.Stderr, "device type is required for walk file\n")
+			return 2
+		}
+
+		mib = m.NewMib(configMibDirs(configFile, templatesDir))
+		var err error
+		if pdus, err = readWalkFile(*walkFile, mib); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+
+		for _, subtree := range args {
+			oid, err := mib.Translate(subtree)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "can't resolve OID %s: %s\n", subtree, err)
+				return 1
+			}
+			opts.Subtrees = append(opts.Subtrees, oid)
+		}
+	} else {
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "usage: %s\n", templateUsage)
+			return 2
+		}
+
+		q, err := newDeviceQuery(configFile, templatesDir, args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 6
+		}
+		mib = q.Mib()
+		if opts.DeviceType == "" {
+			opts.DeviceType = args[0]
+		}
+		opts.SnmpVersion = snmpVersionNames[q.Device.SnmpVersion]
+
+		subtrees := args[1:]
+		if len(subtrees) == 0 {
+			subtrees = []string{".1.3.6.1"}
+		}
+		for _, subtree := range subtrees {
+			res, err := q.WalkVariables(subtree)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], err)
+				return 1
+			}
+			pdus = append(pdus, res...)
+		}
+	}
+
+	data, err := m.GenerateTemplate(pdus, mib, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	os.Stdout.Write(data)
+	return 0
+}
+
+// Read variables to simulate, files with .snmprec extension are
+// in snmprec format, others are snmpwalk output
+func readSimulatorData(name, configFile, templatesDir string) ([]gosnmp.SnmpPDU, error) {
+	if filepath.Ext(name) != ".snmprec" {
+		return readWalkFile(name, m.NewMib(configMibDirs(configFile, templatesDir)))
+	}
+
+	r, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	pdus, err := sim.ReadSnmprec(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return pdus, nil
+}
+
+// Read script of simulated agent
+func readSimulatorScript(name string) (sim.Script, error) {
+	r, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	script, err := sim.ParseScript(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return script, nil
+}
+
+// Serve variables of file as SNMP agent until interrupted
+func runSimulate(configFile, templatesDir string, args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	listen := flags.String("listen", "127.0.0.1:1161", "UDP address to answer requests on")
+	community := flags.String("community", "", "Community of requests, any community is accepted by default")
+	scriptFile := flags.String("script", "", "Script of value changes and failures")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s\n", simulateUsage)
+		return 2
+	}
+
+	pdus, err := readSimulatorData(args[0], configFile, templatesDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	var script sim.Script
+	if *scriptFile != "" {
+		if script, err = readSimulatorScript(*scriptFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+	}
+
+	agent := sim.NewAgent(pdus)
+	agent.Community = *community
+	if err := agent.Listen(*listen); err != nil {
+		fmt.Fprintf(os.Stderr, "can't listen on %s: %s\n", *listen, err)
+		return 1
+	}
+	defer agent.Close()
+	agent.RunScript(script)
+
+	fmt.Fprintf(os.Stderr, "serving %d variables on %s\n", len(pdus), agent.Addr())
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	<-c
+
+	return 0
+}
@@ -3,19 +3,19 @@ module github.com/wirenboard/wb-mqtt-snmp
 go 1.20
 
 require (
-	github.com/alouca/gologger v0.0.0-20120904114645-7d4b7291de9c
 	github.com/contactless/wbgo v0.0.9
-	github.com/wirenboard/gosnmp v0.0.0-20260326145540-e9112d85831d
+	github.com/fsnotify/fsnotify v1.5.1
+	github.com/gosnmp/gosnmp v1.38.0
 )
 
 require (
 	github.com/contactless/org.eclipse.paho.mqtt.golang v0.9.2-0.20230303073519-735a2c3f9cde // indirect
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
-	github.com/fsnotify/fsnotify v1.5.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/objx v0.1.2-0.20180825064932-ef50b0de2877 // indirect
-	github.com/stretchr/testify v1.2.2 // indirect
-	golang.org/x/net v0.0.0-20170110034938-60c41d1de8da // indirect
-	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
+	github.com/stretchr/objx v0.5.2 // indirect
+	github.com/stretchr/testify v1.9.0 // indirect
+	golang.org/x/net v0.15.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 	gopkg.in/fsnotify.v1 v1.4.2 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -5,15 +5,42 @@ import (
 	"fmt"
 	"github.com/contactless/wbgo"
 	m "github.com/wirenboard/wb-mqtt-snmp/mqtt_snmp"
-	"io"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"runtime/debug"
 	"syscall"
+	"time"
 )
 
+// Read and parse config file
+// Invalid devices are skipped, error is returned if there are no valid ones
+func readConfig(configFile, templatesDir string, debug bool) (*m.DaemonConfig, error) {
+	r, err := os.Open(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't open config file %s: %s", configFile, err)
+	}
+	defer r.Close()
+
+	cfg, err := m.NewDaemonConfig(r, templatesDir)
+	if err != nil {
+		// invalid devices are skipped, the rest are still served
+		devErrs, ok := err.(m.DeviceConfigErrors)
+		if !ok || len(cfg.Devices) == 0 {
+			return nil, fmt.Errorf("error parsing config file %s: %s", configFile, err)
+		}
+		for _, e := range devErrs {
+			wbgo.Error.Printf("skipping device in config file %s: %s", configFile, e)
+		}
+	}
+
+	// update debug flag
+	cfg.Debug = cfg.Debug || debug
+
+	return cfg, nil
+}
+
 func main() {
 
 	defer func() {
@@ -29,46 +56,41 @@ func main() {
 	useSyslog := flag.Bool("syslog", false, "Use syslog for logging")
 	profile := flag.String("profile", "", "Run pprof server")
 
+	flag.Usage = usage
 	flag.Parse()
 
+	if flag.NArg() > 0 {
+		wbgo.SetDebuggingEnabled(*debug)
+		os.Exit(runCommand(flag.Args(), *configFile, *templatesDir))
+	}
+
 	if *profile != "" {
 		go func() {
 			wbgo.Debug.Println(http.ListenAndServe(*profile, nil))
 		}()
 	}
 
-	// open config file
-	var err error
-	var r io.Reader
-	if r, err = os.Open(*configFile); err != nil {
-		wbgo.Error.Printf("can't open config file %s: %s", *configFile, err)
-		os.Exit(6) // EXIT_NOTCONFIGURED, see https://www.freedesktop.org/software/systemd/man/latest/systemd.exec.html#Process_Exit_Codes
+	if *useSyslog {
+		wbgo.UseSyslog()
 	}
 
 	// read config
-	var cfg *m.DaemonConfig
-	if cfg, err = m.NewDaemonConfig(r, *templatesDir); err != nil {
-		wbgo.Error.Printf("error parsing config file %s: %s", *configFile, err)
+	cfg, err := readConfig(*configFile, *templatesDir, *debug)
+	if err != nil {
+		wbgo.Error.Printf("%s", err)
 		os.Exit(6) // EXIT_NOTCONFIGURED, see https://www.freedesktop.org/software/systemd/man/latest/systemd.exec.html#Process_Exit_Codes
 	}
 
-	if *useSyslog {
-		wbgo.UseSyslog()
-	}
-
-	// update debug flag
-	cfg.Debug = cfg.Debug || *debug
 	wbgo.SetDebuggingEnabled(cfg.Debug)
 
 	// translate OIDs
+	// channels which can't be translated are marked as failed
 	if err = m.TranslateOidsInDaemonConfig(cfg); err != nil {
-		wbgo.Error.Fatalf("error translating OIDs: %s", err)
+		wbgo.Error.Printf("error translating OIDs: %s", err)
 	}
 
-	// wbgo.Debug.Printf("Config structure: %#v\n", *(cfg.Devices["snmp_test.net-snmp.org"]))
-
 	// create driver object and start daemon
-	if driver, err := m.NewSnmpDriver(cfg, *broker); err != nil {
+	if driver, model, err := m.NewSnmpDriver(cfg, *broker); err != nil {
 		wbgo.Error.Fatalf("can't create driver object: %s", err)
 	} else {
 		if err := driver.Start(); err != nil {
@@ -79,13 +101,45 @@ func main() {
 			c := make(chan os.Signal, 1)
 			signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 
-			// block until SIGINT received
-			<-c
-			wbgo.Debug.Println("Termination signal caught, shutting down...")
+			// config is reloaded on SIGHUP and on changes of config files
+			reload := make(chan struct{}, 1)
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+
+			watcher, err := m.NewConfigWatcher(*configFile, *templatesDir, func() {
+				select {
+				case reload <- struct{}{}:
+				default:
+				}
+			})
+			if err != nil {
+				wbgo.Warn.Printf("can't watch config files, use SIGHUP to reload config: %s", err)
+			} else {
+				defer watcher.Stop()
+			}
+
+			for {
+				select {
+				case <-hup:
+					wbgo.Info.Println("SIGHUP caught, reloading config")
+				case <-reload:
+					wbgo.Info.Println("config files are changed, reloading config")
+				case <-c:
+					wbgo.Debug.Println("Termination signal caught, shutting down...")
+
+					// stop driver and exit gracefully
+					driver.Stop()
+					return
+				}
 
-			// stop driver and exit gracefully
-			driver.Stop()
-			return
+				// running config is kept if new one is invalid,
+				// OIDs of changed devices are translated by model
+				if newCfg, err := readConfig(*configFile, *templatesDir, *debug); err != nil {
+					wbgo.Error.Printf("can't reload config: %s", err)
+				} else {
+					model.Reload(newCfg, time.Now())
+				}
+			}
 		}
 	}
 }
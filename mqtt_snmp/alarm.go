@@ -0,0 +1,138 @@
+package mqtt_snmp
+
+// Alarm controls module
+// Channel with alarm thresholds gets switch control which is on
+// while value is out of thresholds; hysteresis keeps alarm from
+// flapping near threshold and delay requires new state to hold
+// for some time before it's published
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/contactless/wbgo"
+)
+
+// Alarm thresholds of channel, at least one of High and Low is set
+type AlarmConfig struct {
+	// Name of alarm control, "<channel> alarm" by default
+	Name string
+
+	High, Low *float64
+
+	// Alarm is cleared when value is back by hysteresis from threshold
+	Hysteresis float64
+
+	// Time which new alarm state must hold to be published
+	Delay time.Duration
+}
+
+// Check if alarm should be active for value given its current state
+func (a *AlarmConfig) check(active bool, v float64) bool {
+	h := 0.0
+	if active {
+		h = a.Hysteresis
+	}
+	return (a.High != nil && v > *a.High-h) || (a.Low != nil && v < *a.Low+h)
+}
+
+// Alarm state of channel, used by publisher worker only
+type alarmState struct {
+	// Pseudo-channel for alarm control
+	channel *ChannelConfig
+
+	active bool
+
+	// Time when value has started to require another state,
+	// zero if value matches current state
+	since time.Time
+}
+
+// Update alarm state with new value
+func (s *alarmState) update(config *AlarmConfig, v float64, now time.Time) {
+	if config.check(s.active, v) == s.active {
+		s.since = time.Time{}
+		return
+	}
+
+	if s.since.IsZero() {
+		s.since = now
+	}
+	if now.Sub(s.since) >= config.Delay {
+		s.active = !s.active
+		s.since = time.Time{}
+	}
+}
+
+// Create alarm states of device channels,
+// alarm controls are placed after all other controls of device
+func newAlarmStates(config *DeviceConfig) map[*ChannelConfig]*alarmState {
+	channels := make([]*ChannelConfig, 0)
+	for _, ch := range config.Channels {
+		if ch.Alarm != nil {
+			channels = append(channels, ch)
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Order < channels[j].Order || channels[i].Order == channels[j].Order && channels[i].Name < channels[j].Name
+	})
+
+	order := len(config.Channels) + 1
+	if config.LastTrapControl {
+		order++
+	}
+	if config.AvailabilityControls {
+		order += 2
+	}
+
+	res := make(map[*ChannelConfig]*alarmState, len(channels))
+	for i, ch := range channels {
+		res[ch] = &alarmState{
+			channel: &ChannelConfig{
+				Name:        ch.Alarm.Name,
+				ControlType: "switch",
+				Conv:        AsIs,
+				Readonly:    true,
+				Order:       order + i,
+				Device:      config,
+			},
+		}
+	}
+	return res
+}
+
+// Publish alarm control of channel after its value is published
+func (m *SnmpModel) publishAlarm(dev *SnmpDevice, channel *ChannelConfig, data string) {
+	s, ok := dev.Alarms[channel]
+	if !ok {
+		return
+	}
+
+	v, err := strconv.ParseFloat(data, 64)
+	if err != nil {
+		wbgo.Warn.Printf("value of %s:%s is not a number, alarm is not checked: %s", dev.DevName, channel.Name, data)
+		m.publishError(dev, s.channel, "r")
+		return
+	}
+
+	s.update(channel.Alarm, v, timeNow())
+
+	value := "0"
+	if s.active {
+		value = "1"
+	}
+	m.publishData(dev, s.channel, value)
+}
+
+// Publish read error of channel to its alarm control,
+// pending state change is cancelled
+func (m *SnmpModel) publishAlarmError(dev *SnmpDevice, channel *ChannelConfig, errorValue string) {
+	s, ok := dev.Alarms[channel]
+	if !ok || errorValue != "r" {
+		return
+	}
+
+	s.since = time.Time{}
+	m.publishError(dev, s.channel, errorValue)
+}
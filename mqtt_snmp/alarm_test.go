@@ -0,0 +1,73 @@
+package mqtt_snmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+)
+
+type AlarmSuite struct {
+	testutils.Suite
+}
+
+func (s *AlarmSuite) TestHysteresis() {
+	high, low := 45.0, 5.0
+	config := &AlarmConfig{High: &high, Low: &low, Hysteresis: 2}
+	t := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+
+	var state alarmState
+	cases := []struct {
+		value  float64
+		active bool
+	}{
+		{20, false},
+		{45, false},
+		{45.5, true},
+		{44, true},
+		{43, false},
+		{4, true},
+		{6.5, true},
+		{7.5, false},
+	}
+
+	for i, c := range cases {
+		state.update(config, c.value, t.Add(time.Duration(i)*time.Second))
+		s.Equal(c.active, state.active, "value %v", c.value)
+	}
+}
+
+func (s *AlarmSuite) TestDelay() {
+	high := 45.0
+	config := &AlarmConfig{High: &high, Delay: 10 * time.Second}
+	t := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+
+	var state alarmState
+	cases := []struct {
+		value   float64
+		seconds int
+		active  bool
+	}{
+		{50, 0, false},
+		{50, 5, false},
+		// short spike doesn't raise alarm
+		{40, 8, false},
+		{50, 12, false},
+		{50, 21, false},
+		{50, 22, true},
+		// and short drop doesn't clear it
+		{40, 25, true},
+		{50, 30, true},
+		{40, 31, true},
+		{40, 41, false},
+	}
+
+	for _, c := range cases {
+		state.update(config, c.value, t.Add(time.Duration(c.seconds)*time.Second))
+		s.Equal(c.active, state.active, "value %v at %d s", c.value, c.seconds)
+	}
+}
+
+func TestAlarm(t *testing.T) {
+	testutils.RunSuites(t, new(AlarmSuite))
+}
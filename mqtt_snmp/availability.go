@@ -0,0 +1,203 @@
+package mqtt_snmp
+
+// Device availability module
+// Tracks consecutive failed requests of device and marks it offline,
+// offline device is only probed by poll scheduler until it answers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/contactless/wbgo"
+)
+
+const (
+	// Names of availability controls
+	OnlineChannelName   = "online"
+	LastSeenChannelName = "last_seen"
+
+	// Format of last seen time
+	LastSeenFormat = time.RFC3339
+)
+
+// Current time, replaced in tests
+var timeNow = time.Now
+
+// Availability state of device
+type deviceStatus int
+
+const (
+	// No requests are completed yet
+	deviceStatusUnknown deviceStatus = iota
+	deviceStatusOnline
+	deviceStatusOffline
+)
+
+// Optional model observer extension to publish
+// device-level error meta
+type DeviceErrorPublisher interface {
+	OnDeviceError(dev wbgo.DeviceModel, value string)
+}
+
+// Availability state of device
+// Request fields are protected by own mutex, not by device one,
+// so state can be read while request is in progress;
+// published fields are used by publisher worker only
+type deviceAvailability struct {
+	status   deviceStatus
+	failures int
+	lastSeen time.Time
+	mutex    sync.Mutex
+
+	// Pseudo-channel polled instead of device channels when device is offline,
+	// probe interval starts from the fastest channel poll interval and grows
+	// up to max one
+	probeChannel                    *ChannelConfig
+	probeInterval, maxProbeInterval time.Duration
+
+	// Pseudo-channels for availability controls,
+	// nil if controls are disabled
+	onlineChannel, lastSeenChannel *ChannelConfig
+
+	publishedStatus   deviceStatus
+	publishedLastSeen string
+}
+
+// Create availability state for device config
+func newDeviceAvailability(config *DeviceConfig) *deviceAvailability {
+	a := &deviceAvailability{
+		probeInterval:    time.Duration(config.PollInterval) * time.Millisecond,
+		maxProbeInterval: time.Duration(config.MaxProbeInterval) * time.Millisecond,
+	}
+	for _, ch := range config.Channels {
+		if interval := time.Duration(ch.PollInterval) * time.Millisecond; interval > 0 && (a.probeInterval <= 0 || interval < a.probeInterval) {
+			a.probeInterval = interval
+		}
+	}
+	if a.maxProbeInterval <= 0 {
+		a.maxProbeInterval = DefaultMaxProbeInterval * time.Millisecond
+	}
+
+	probeOid := config.ProbeOid
+	if probeOid == "" {
+		probeOid = DefaultProbeOid
+	}
+	a.probeChannel = &ChannelConfig{
+		Name:   "probe",
+		Oid:    probeOid,
+		Conv:   AsIs,
+		Device: config,
+	}
+
+	if config.AvailabilityControls {
+		// placed after all device channels and last trap control
+		order := len(config.Channels) + 1
+		if config.LastTrapControl {
+			order++
+		}
+		a.onlineChannel = &ChannelConfig{
+			Name:        OnlineChannelName,
+			ControlType: "switch",
+			Conv:        AsIs,
+			Readonly:    true,
+			Order:       order,
+			Device:      config,
+		}
+		a.lastSeenChannel = &ChannelConfig{
+			Name:        LastSeenChannelName,
+			ControlType: "text",
+			Conv:        AsIs,
+			Readonly:    true,
+			Order:       order + 1,
+			Device:      config,
+		}
+	}
+
+	return a
+}
+
+// Perform request to device SNMP session with availability tracking
+// Must be called with mutex locked
+func (d *SnmpDevice) request(do func(snmp SnmpInterface) error) error {
+	snmp, err := d.session()
+	if err == nil {
+		err = do(snmp)
+	}
+
+	a := d.availability
+	now := timeNow()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err != nil {
+		a.failures++
+		if d.Config.OfflineThreshold > 0 && a.failures >= d.Config.OfflineThreshold && a.status != deviceStatusOffline {
+			wbgo.Warn.Printf("device %s is offline after %d failed requests: %s", d.DevName, a.failures, err)
+			a.status = deviceStatusOffline
+		}
+		return err
+	}
+
+	if a.status == deviceStatusOffline {
+		wbgo.Info.Printf("device %s is online", d.DevName)
+	}
+	a.failures = 0
+	a.status = deviceStatusOnline
+	a.lastSeen = now
+
+	return nil
+}
+
+// Get current availability state of device
+func (d *SnmpDevice) availabilityState() (status deviceStatus, lastSeen time.Time) {
+	d.availability.mutex.Lock()
+	defer d.availability.mutex.Unlock()
+	return d.availability.status, d.availability.lastSeen
+}
+
+// Suspend or resume polling of device according to its state
+// Called by poll timer worker before poll table is processed
+func (m *SnmpModel) scheduleAvailability(dev *SnmpDevice, t time.Time) {
+	status, _ := dev.availabilityState()
+	suspended := m.pollTable.IsSuspended(dev.Config)
+
+	if status == deviceStatusOffline && !suspended {
+		a := dev.availability
+		wbgo.Debug.Printf("[POLLTIMEREVENT] Suspend polling of %s", dev.DevName)
+		m.pollTable.Suspend(a.probeChannel, t, a.probeInterval, a.maxProbeInterval)
+	} else if status != deviceStatusOffline && suspended {
+		wbgo.Debug.Printf("[POLLTIMEREVENT] Resume polling of %s", dev.DevName)
+		m.pollTable.Resume(dev.Config)
+	}
+}
+
+// Publish availability state of device if it has been changed
+// Called by publisher worker after each result of device
+func (m *SnmpModel) publishAvailability(dev *SnmpDevice) {
+	a := dev.availability
+	status, lastSeen := dev.availabilityState()
+
+	if status != a.publishedStatus && status != deviceStatusUnknown {
+		a.publishedStatus = status
+
+		online, errorValue := "1", ""
+		if status == deviceStatusOffline {
+			online, errorValue = "0", "r"
+		}
+
+		if a.onlineChannel != nil {
+			m.publishData(dev, a.onlineChannel, online)
+		}
+		if m.DeviceErrors != nil {
+			m.DeviceErrors.OnDeviceError(dev, errorValue)
+		}
+	}
+
+	if a.lastSeenChannel != nil && !lastSeen.IsZero() {
+		if seen := lastSeen.Format(LastSeenFormat); seen != a.publishedLastSeen {
+			a.publishedLastSeen = seen
+			m.publishData(dev, a.lastSeenChannel, seen)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package mqtt_snmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+type AvailabilitySuite struct {
+	testutils.Suite
+
+	model    *SnmpModel
+	channel  *ChannelConfig
+	observer *MockDeviceObserver
+	now      time.Time
+
+	queryChannel  chan PollQuery
+	writeChannel  chan WriteQuery
+	resultChannel chan PollResult
+	errorChannel  chan PollError
+	quitChannel   chan struct{}
+	pollDone      chan struct{}
+	pubDone       chan struct{}
+}
+
+func (s *AvailabilitySuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	fakeSNMPMessages = make(map[string]*gosnmp.SnmpPacket)
+	fakeSNMPRequests = nil
+
+	s.now = time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return s.now }
+
+	s.channel = &ChannelConfig{
+		Name:         "channel1",
+		Oid:          ".1.2.3.4",
+		ControlType:  "value",
+		Conv:         AsIs,
+		InvConv:      AsIs,
+		PollInterval: 1000,
+		Order:        1,
+	}
+
+	device := &DeviceConfig{
+		Name:                 "UPS",
+		Address:              "127.0.0.1",
+		Community:            "test",
+		Id:                   "snmp_ups",
+		SnmpVersion:          gosnmp.Version2c,
+		SnmpTimeout:          1,
+		PollInterval:         5000,
+		OfflineThreshold:     2,
+		AvailabilityControls: true,
+		Channels:             map[string]*ChannelConfig{"channel1": s.channel},
+	}
+	s.channel.Device = device
+
+	config := &DaemonConfig{
+		NumWorkers: 1,
+		Devices:    map[string]*DeviceConfig{"snmp_ups": device},
+	}
+
+	var err error
+	s.model, err = NewSnmpModel(NewFakeSNMP, config, s.now)
+	s.Ck("can't create model", err)
+
+	s.observer = NewMockDeviceObserver()
+	s.model.DeviceErrors = s.observer
+	s.model.devices[0].Observe(s.observer)
+
+	s.queryChannel = make(chan PollQuery, CHAN_BUFFER_SIZE)
+	s.writeChannel = make(chan WriteQuery, CHAN_BUFFER_SIZE)
+	s.resultChannel = make(chan PollResult, CHAN_BUFFER_SIZE)
+	s.errorChannel = make(chan PollError, CHAN_BUFFER_SIZE)
+	s.quitChannel = make(chan struct{}, 2)
+	s.pollDone = make(chan struct{}, CHAN_BUFFER_SIZE)
+	s.pubDone = make(chan struct{}, CHAN_BUFFER_SIZE)
+
+	go s.model.PollWorker(0, s.queryChannel, s.writeChannel, s.resultChannel, s.errorChannel, s.quitChannel, s.pollDone)
+	go s.model.PublisherWorker(s.resultChannel, s.errorChannel, s.quitChannel, s.pubDone)
+}
+
+func (s *AvailabilitySuite) TearDownTest() {
+	s.quitChannel <- struct{}{}
+	s.quitChannel <- struct{}{}
+	<-s.pollDone
+	<-s.pubDone
+
+	timeNow = time.Now
+
+	s.Suite.TearDownTest()
+}
+
+// Poll channel and wait for result to be published
+func (s *AvailabilitySuite) poll() {
+	s.queryChannel <- PollQuery{Channel: s.channel, Deadline: s.now}
+
+	for _, done := range []chan struct{}{s.pollDone, s.pubDone} {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			s.Fail("poll timeout")
+		}
+	}
+}
+
+func (s *AvailabilitySuite) TestProbeInterval() {
+	s.Equal(time.Second, s.model.devices[0].availability.probeInterval)
+}
+
+func (s *AvailabilitySuite) TestOnlineOffline() {
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.4", "foo")
+
+	s.poll()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_ups, name channel1, type value, value foo, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_ups, name online, type switch, value 1, order 2"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_ups, name last_seen, type text, value 2016-12-01T00:00:00Z, order 3"},
+		&MockDeviceEvent{OnDeviceErrorEvent, "device snmp_ups, error "},
+	}, EventTimeout))
+
+	// single failure doesn't make device offline
+	delete(fakeSNMPMessages, "127.0.0.1@test@.1.2.3.4")
+	s.now = s.now.Add(time.Second)
+
+	s.poll()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnErrorEvent, "device snmp_ups, name channel1, error r"},
+	}, EventTimeout))
+
+	s.now = s.now.Add(time.Second)
+
+	s.poll()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_ups, name online, value 0"},
+		&MockDeviceEvent{OnDeviceErrorEvent, "device snmp_ups, error r"},
+	}, EventTimeout))
+
+	// successful request makes device online
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.4", "bar")
+	s.now = s.now.Add(time.Second)
+
+	s.poll()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_ups, name channel1, value bar"},
+		&MockDeviceEvent{OnErrorEvent, "device snmp_ups, name channel1, error "},
+		&MockDeviceEvent{OnValueEvent, "device snmp_ups, name online, value 1"},
+		&MockDeviceEvent{OnValueEvent, "device snmp_ups, name last_seen, value 2016-12-01T00:00:03Z"},
+		&MockDeviceEvent{OnDeviceErrorEvent, "device snmp_ups, error "},
+	}, EventTimeout))
+
+	s.EnsureGotWarnings()
+	s.EnsureGotErrors()
+}
+
+func (s *AvailabilitySuite) TestProbe() {
+	dev := s.model.devices[0]
+	probe := dev.availability.probeChannel
+	s.Equal(DefaultProbeOid, probe.Oid)
+
+	// probe result is not published, only device state is changed
+	for i := 0; i < 2; i++ {
+		s.queryChannel <- PollQuery{Channel: probe, Deadline: s.now, Probe: true}
+		<-s.pollDone
+	}
+	status, _ := dev.availabilityState()
+	s.Equal(deviceStatusOffline, status)
+
+	InsertFakeSNMPMessage("127.0.0.1@test@"+DefaultProbeOid, "100")
+	s.queryChannel <- PollQuery{Channel: probe, Deadline: s.now, Probe: true}
+	<-s.pollDone
+
+	status, lastSeen := dev.availabilityState()
+	s.Equal(deviceStatusOnline, status)
+	s.Equal(s.now, lastSeen)
+	s.NoError(s.observer.WaitForNoMessages(WaitTimeout))
+
+	s.EnsureGotWarnings()
+}
+
+// Test polling of offline device is suspended until probe succeeds
+func (s *AvailabilitySuite) TestSchedule() {
+	dev := s.model.devices[0]
+
+	s.model.scheduleAvailability(dev, s.now)
+	s.False(s.model.pollTable.IsSuspended(dev.Config))
+
+	dev.availability.status = deviceStatusOffline
+	s.model.scheduleAvailability(dev, s.now)
+	if b, ok := s.model.pollTable.Suspended[dev.Config]; s.True(ok) {
+		s.Equal(dev.availability.probeChannel, b.Probe)
+		s.Equal(s.now.Add(time.Second), b.NextProbe)
+		s.Equal(5*time.Minute, b.Max)
+	}
+
+	dev.availability.status = deviceStatusOnline
+	s.model.scheduleAvailability(dev, s.now)
+	s.False(s.model.pollTable.IsSuspended(dev.Config))
+}
+
+func TestAvailability(t *testing.T) {
+	testutils.RunSuites(t, new(AvailabilitySuite))
+}
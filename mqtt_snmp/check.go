@@ -0,0 +1,344 @@
+package mqtt_snmp
+
+// Config check module
+// Validates config and templates without running the daemon:
+// each device, template and channel is parsed on its own and all
+// OIDs are resolved, so all problems are reported at once
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Problem found by config check
+type CheckProblem struct {
+	// Where problem is found: "config", "device <id>" or "template <type>"
+	Source string
+
+	// Channel name, empty for problems of whole source
+	Channel string
+
+	Message string
+
+	// Warnings don't prevent daemon from serving device or channel
+	Warning bool
+}
+
+func (p CheckProblem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	if p.Channel != "" {
+		return fmt.Sprintf("channel %s: %s: %s", p.Channel, level, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", level, p.Message)
+}
+
+// Config check report
+type CheckReport struct {
+	Problems []CheckProblem
+
+	// Map from device IDs to sources of problems
+	sources map[string]string
+}
+
+func (r *CheckReport) add(source, channel string, warning bool, format string, args ...any) {
+	r.Problems = append(r.Problems, CheckProblem{
+		Source:  source,
+		Channel: channel,
+		Message: fmt.Sprintf(format, args...),
+		Warning: warning,
+	})
+}
+
+// Check if there are errors in report
+func (r *CheckReport) HasErrors() bool {
+	for _, p := range r.Problems {
+		if !p.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// Write report grouped by sources
+func (r *CheckReport) Write(w io.Writer) {
+	sources := make([]string, 0)
+	problems := make(map[string][]CheckProblem)
+	errors := 0
+	for _, p := range r.Problems {
+		if _, ok := problems[p.Source]; !ok {
+			sources = append(sources, p.Source)
+		}
+		problems[p.Source] = append(problems[p.Source], p)
+		if !p.Warning {
+			errors++
+		}
+	}
+
+	for _, source := range sources {
+		fmt.Fprintf(w, "%s:\n", source)
+		for _, p := range problems[source] {
+			fmt.Fprintf(w, "    %s\n", p)
+		}
+	}
+	fmt.Fprintf(w, "%d errors, %d warnings\n", errors, len(r.Problems)-errors)
+}
+
+// Get device ID from raw device entry the way parser does
+func entryDeviceId(entry map[string]any) string {
+	d := NewEmptyDeviceConfig()
+	copyString(&entry, "address", &d.Address, false)
+	copyString(&entry, "community", &d.Community, false)
+	if d.Address != "" {
+		d.Id = "snmp_" + d.GenerateId()
+	}
+	copyString(&entry, "id", &d.Id, false)
+	return d.Id
+}
+
+// Get names of channels in raw device entry
+func entryChannelNames(entry map[string]any) []string {
+	names := make([]string, 0)
+	switch channels := entry["channels"].(type) {
+	case []map[string]any:
+		for _, ch := range channels {
+			if name, ok := ch["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+	case []any:
+		for _, ch := range channels {
+			if m, ok := ch.(map[string]any); ok {
+				if name, ok := m["name"].(string); ok {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check device entry: merge it with template, parse channels one by one
+// and then the whole device
+// Source of problems is made of device ID, which is known after merge only
+// Returns merged entry, nil if device is disabled or can't be merged
+func (r *CheckReport) checkDevice(c *DaemonConfig, sourceOf func(id string) string, devConfig map[string]any) map[string]any {
+	merged, err := c.mergeDeviceEntry(devConfig)
+	if err != nil {
+		r.add(sourceOf(entryDeviceId(devConfig)), "", false, "%s", err)
+		return nil
+	}
+	if merged == nil {
+		return nil
+	}
+	id := entryDeviceId(merged)
+	source := sourceOf(id)
+	r.sources[id] = source
+
+	// parser would overwrite first device, so device is checked by channels only
+	duplicate := id != "" && c.Devices[id] != nil
+	if duplicate {
+		r.add(source, "", false, "duplicate device id %s", id)
+	}
+
+	if unknown := unknownKeys(merged, deviceKeys); len(unknown) > 0 {
+		r.add(source, "", true, "unknown keys are ignored: %s", strings.Join(unknown, ", "))
+	}
+
+	// channels are parsed by scratch device to find all broken ones
+	d := NewEmptyDeviceConfig()
+	copyString(&merged, "oid_prefix", &d.OidPrefix, false)
+	d.Channels = make(map[string]*ChannelConfig)
+
+	channels, _ := merged["channels"].([]map[string]any)
+	sort.Slice(channels, func(i, j int) bool {
+		a, _ := channels[i]["name"].(string)
+		b, _ := channels[j]["name"].(string)
+		return a < b
+	})
+
+	valid := make([]map[string]any, 0, len(channels))
+	for _, ch := range channels {
+		name, _ := ch["name"].(string)
+		if unknown := unknownKeys(ch, channelKeys); len(unknown) > 0 {
+			r.add(source, name, true, "unknown keys are ignored: %s", strings.Join(unknown, ", "))
+		}
+		if err := d.parseChannelEntry(ch); err != nil {
+			r.add(source, name, false, "%s", err)
+			continue
+		}
+		valid = append(valid, ch)
+	}
+
+	// device errors which are not caused by single channel,
+	// broken channels are left out not to be reported twice
+	entry := merged
+	if len(valid) < len(channels) {
+		entry = make(map[string]any, len(merged))
+		for k, v := range merged {
+			entry[k] = v
+		}
+		entry["channels"] = valid
+	}
+	if !duplicate && (len(valid) > 0 || len(channels) == 0) {
+		if err := c.parseMergedDeviceEntry(entry); err != nil {
+			r.add(source, "", false, "%s", err)
+		}
+	}
+
+	// OIDs of broken device are checked by parsed channels
+	if id != "" && c.Devices[id] == nil {
+		d.Id = id
+		c.Devices[id] = d
+	}
+
+	return merged
+}
+
+// Resolve OIDs of parsed devices
+func (r *CheckReport) checkOids(config *DaemonConfig) {
+	ids := make([]string, 0, len(config.Devices))
+	for id := range config.Devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	mib := NewMib(config.MibDirs)
+	for _, id := range ids {
+		dev := config.Devices[id]
+		if _, err := mib.Translate(dev.ProbeOid); dev.ProbeOid != "" && err != nil {
+			r.add(r.sources[id], "", false, "can't resolve probe OID %s: %s", dev.ProbeOid, err)
+		}
+	}
+
+	TranslateOidsInDaemonConfig(config)
+
+	for _, id := range ids {
+		for _, ch := range config.Devices[id].Channels {
+			if ch.Error != "" {
+				r.add(r.sources[id], ch.Name, false, "%s", ch.Error)
+			}
+		}
+	}
+}
+
+// Sort problems of each source by channels
+// Problems of sources go first, sources are kept in order of appearance
+func (r *CheckReport) sortProblems() {
+	order := make(map[string]int)
+	for _, p := range r.Problems {
+		if _, ok := order[p.Source]; !ok {
+			order[p.Source] = len(order)
+		}
+	}
+	sort.SliceStable(r.Problems, func(i, j int) bool {
+		a, b := r.Problems[i], r.Problems[j]
+		if a.Source != b.Source {
+			return order[a.Source] < order[b.Source]
+		}
+		return a.Channel < b.Channel
+	})
+}
+
+// Check config and templates
+// Templates are checked as devices on their own, then config devices
+// are checked; template channels disabled in all devices of template
+// are reported as unused
+func CheckConfig(input io.Reader, templatesDir string) *CheckReport {
+	r := &CheckReport{sources: make(map[string]string)}
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		r.add("config", "", false, "can't read config: %s", err)
+		return r
+	}
+
+	config, err := NewDaemonConfig(bytes.NewReader(data), templatesDir)
+	if err != nil {
+		// errors of single devices are found by device checks
+		if _, ok := err.(DeviceConfigErrors); !ok {
+			r.add("config", "", false, "%s", err)
+			return r
+		}
+	}
+
+	var root struct {
+		Devices []map[string]any
+	}
+	if err := json.Unmarshal(data, &root); err != nil {
+		r.add("config", "", false, "can't parse config JSON file: %s", err)
+		return r
+	}
+
+	// templates
+	types := make([]string, 0, len(config.templates.templates))
+	for t := range config.templates.templates {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	templates := &DaemonConfig{MibDirs: config.MibDirs, templates: config.templates, Devices: make(map[string]*DeviceConfig)}
+	for _, t := range types {
+		entry := map[string]any{"device_type": t}
+		if _, ok := config.templates.templates[t]["address"]; !ok {
+			entry["address"] = "template"
+		}
+		if _, ok := config.templates.templates[t]["id"]; !ok {
+			entry["id"] = "template_" + t
+		}
+		source := "template " + t
+		r.checkDevice(templates, func(string) string { return source }, entry)
+	}
+
+	// config devices
+	devices := &DaemonConfig{MibDirs: config.MibDirs, templates: config.templates, Devices: make(map[string]*DeviceConfig)}
+	used := make(map[string]map[string]bool)
+	for i, entry := range root.Devices {
+		n := i + 1
+		sourceOf := func(id string) string {
+			if id == "" {
+				return fmt.Sprintf("device #%d", n)
+			}
+			return "device " + id
+		}
+
+		merged := r.checkDevice(devices, sourceOf, entry)
+		if merged == nil {
+			continue
+		}
+
+		if t, ok := entry["device_type"].(string); ok {
+			if used[t] == nil {
+				used[t] = make(map[string]bool)
+			}
+			for _, name := range entryChannelNames(merged) {
+				used[t][name] = true
+			}
+		}
+	}
+
+	// unused template channels
+	for _, t := range types {
+		if used[t] == nil {
+			continue
+		}
+		for _, name := range entryChannelNames(config.templates.templates[t]) {
+			if !used[t][name] {
+				r.add("template "+t, name, true, "channel is disabled in all devices of template")
+			}
+		}
+	}
+
+	r.checkOids(templates)
+	r.checkOids(devices)
+	r.sortProblems()
+
+	return r
+}
@@ -0,0 +1,203 @@
+package mqtt_snmp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/contactless/wbgo/testutils"
+)
+
+type CheckSuite struct {
+	testutils.Suite
+
+	dir string
+}
+
+func (s *CheckSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	var err error
+	s.dir, err = os.MkdirTemp("", "wb-mqtt-snmp-check")
+	s.Ck("can't create temp dir", err)
+
+	tpl := `{
+		"device_type": "ups",
+		"channels": [
+			{"name": "voltage", "oid": ".1.2.3.1"},
+			{"name": "current", "oid": ".1.2.3.2"},
+			{"name": "serial", "oid": ".1.2.3.3", "control_type": "text"}
+		]
+	}`
+	s.Ck("can't write template", os.WriteFile(filepath.Join(s.dir, "config-ups.json"), []byte(tpl), 0644))
+}
+
+func (s *CheckSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+	s.Suite.TearDownTest()
+}
+
+func (s *CheckSuite) check(config string) *CheckReport {
+	config = strings.ReplaceAll(config, "MIBS", filepath.Join(s.dir, "mibs"))
+	return CheckConfig(strings.NewReader(config), s.dir)
+}
+
+func (s *CheckSuite) TestCleanConfig() {
+	r := s.check(`{
+		"mib_dirs": ["MIBS"],
+		"devices": [
+			{"address": "10.0.0.1", "device_type": "ups"},
+			{"address": "10.0.0.2", "channels": [{"name": "uptime", "oid": ".1.3.6.1.2.1.1.3.0"}]}
+		]
+	}`)
+	s.Empty(r.Problems)
+	s.False(r.HasErrors())
+}
+
+func (s *CheckSuite) TestDeviceErrors() {
+	r := s.check(`{
+		"mib_dirs": ["MIBS"],
+		"devices": [
+			{"address": "10.0.0.1", "device_type": "nope"},
+			{"address": "10.0.0.2", "channels": [
+				{"name": "a", "oid": ".1.2", "control_type": "bogus"},
+				{"name": "b", "oid": "NO-SUCH-MIB::x.0"},
+				{"name": "c", "oid": ".1.4", "unit": "V"}
+			]},
+			{"address": "10.0.0.3", "channels": [{"name": "d", "oid": ".1.2"}], "poll": 1}
+		]
+	}`)
+	s.True(r.HasErrors())
+
+	s.Equal([]CheckProblem{
+		{Source: "device snmp_10.0.0.1", Message: "no such template: nope"},
+		{Source: "device snmp_10.0.0.2", Channel: "a", Message: "channel a: unsupported control type bogus"},
+		{Source: "device snmp_10.0.0.2", Channel: "b", Message: r.Problems[2].Message},
+		{Source: "device snmp_10.0.0.2", Channel: "c", Message: "unknown keys are ignored: unit", Warning: true},
+		{Source: "device snmp_10.0.0.3", Message: "unknown keys are ignored: poll", Warning: true},
+	}, r.Problems)
+	s.Contains(r.Problems[2].Message, "can't resolve OID NO-SUCH-MIB::x.0")
+	s.EnsureGotWarnings()
+}
+
+// Device ID depends on community from template
+func (s *CheckSuite) TestTemplateCommunity() {
+	tpl := `{
+		"device_type": "snmpd",
+		"community": "public",
+		"channels": [{"name": "uptime", "oid": ".1.3.6.1.2.1.1.3.0"}]
+	}`
+	s.Ck("can't write template", os.WriteFile(filepath.Join(s.dir, "config-snmpd.json"), []byte(tpl), 0644))
+
+	r := s.check(`{
+		"devices": [
+			{"address": "127.0.0.1", "device_type": "snmpd", "channels": [{"name": "uptime", "unit": "s"}]}
+		]
+	}`)
+	s.Equal([]CheckProblem{
+		{Source: "device snmp_127.0.0.1_public", Channel: "uptime", Message: "unknown keys are ignored: unit", Warning: true},
+	}, r.Problems)
+	s.EnsureGotWarnings()
+}
+
+func (s *CheckSuite) TestDuplicateChannels() {
+	r := s.check(`{
+		"devices": [
+			{"address": "10.0.0.1", "channels": [
+				{"name": "a", "oid": ".1.2"},
+				{"name": "a", "oid": ".1.3"}
+			]}
+		]
+	}`)
+	s.True(r.HasErrors())
+	s.Len(r.Problems, 1)
+	s.Equal("device snmp_10.0.0.1", r.Problems[0].Source)
+	s.Contains(r.Problems[0].Message, "a")
+}
+
+func (s *CheckSuite) TestDuplicateIds() {
+	r := s.check(`{
+		"devices": [
+			{"address": "10.0.0.1", "id": "ups", "channels": [{"name": "a", "oid": ".1.2"}]},
+			{"address": "10.0.0.2", "id": "ups", "channels": [{"name": "b", "oid": ".1.3", "control_type": "bogus"}]}
+		]
+	}`)
+	s.True(r.HasErrors())
+	s.Equal([]CheckProblem{
+		{Source: "device ups", Message: "duplicate device id ups"},
+		{Source: "device ups", Channel: "b", Message: "channel b: unsupported control type bogus"},
+	}, r.Problems)
+}
+
+// Device errors are reported along with errors of channels
+func (s *CheckSuite) TestDeviceErrorsWithBrokenChannels() {
+	r := s.check(`{
+		"devices": [
+			{"address": "10.0.0.1", "last_trap_control": true, "channels": [
+				{"name": "a", "oid": ".1.2", "control_type": "bogus"},
+				{"name": "last_trap", "oid": ".1.3"}
+			]}
+		]
+	}`)
+	s.True(r.HasErrors())
+	s.Equal([]CheckProblem{
+		{Source: "device snmp_10.0.0.1", Message: "channel name last_trap in snmp_10.0.0.1 is reserved for last trap control"},
+		{Source: "device snmp_10.0.0.1", Channel: "a", Message: "channel a: unsupported control type bogus"},
+	}, r.Problems)
+}
+
+func (s *CheckSuite) TestUnusedTemplateChannels() {
+	r := s.check(`{
+		"devices": [
+			{"address": "10.0.0.1", "device_type": "ups", "channels": [{"name": "serial", "enabled": false}]},
+			{"address": "10.0.0.2", "device_type": "ups", "channels": [{"name": "serial", "enabled": false}, {"name": "current", "enabled": false}]}
+		]
+	}`)
+	s.False(r.HasErrors())
+	s.Equal([]CheckProblem{
+		{Source: "template ups", Channel: "serial", Message: "channel is disabled in all devices of template", Warning: true},
+	}, r.Problems)
+}
+
+func (s *CheckSuite) TestTemplateErrors() {
+	tpl := `{
+		"device_type": "broken",
+		"channels": [{"name": "a", "oid": ".1.2", "control_type": "bogus"}]
+	}`
+	s.Ck("can't write template", os.WriteFile(filepath.Join(s.dir, "config-broken.json"), []byte(tpl), 0644))
+
+	r := s.check(`{"devices": [{"address": "10.0.0.1", "channels": [{"name": "a", "oid": ".1.2"}]}]}`)
+	s.Equal([]CheckProblem{
+		{Source: "template broken", Channel: "a", Message: "channel a: unsupported control type bogus"},
+	}, r.Problems)
+}
+
+func (s *CheckSuite) TestConfigError() {
+	r := s.check(`{"devices": [`)
+	s.True(r.HasErrors())
+	s.Len(r.Problems, 1)
+	s.Equal("config", r.Problems[0].Source)
+}
+
+func (s *CheckSuite) TestWrite() {
+	r := &CheckReport{Problems: []CheckProblem{
+		{Source: "device a", Message: "bad device"},
+		{Source: "device a", Channel: "x", Message: "bad key", Warning: true},
+		{Source: "template t", Channel: "y", Message: "bad channel"},
+	}}
+
+	var b bytes.Buffer
+	r.Write(&b)
+	s.Equal("device a:\n"+
+		"    error: bad device\n"+
+		"    channel x: warning: bad key\n"+
+		"template t:\n"+
+		"    channel y: error: bad channel\n"+
+		"2 errors, 1 warnings\n", b.String())
+}
+
+func TestCheck(t *testing.T) {
+	testutils.RunSuites(t, new(CheckSuite))
+}
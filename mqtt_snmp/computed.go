@@ -0,0 +1,96 @@
+package mqtt_snmp
+
+// Computed channels module
+// Computed channel value is an expression of other channels of device,
+// it's recomputed by publisher worker whenever any input is received
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/contactless/wbgo"
+)
+
+// Get computed channels of device by their inputs
+func computedChannels(config *DeviceConfig) map[*ChannelConfig][]*ChannelConfig {
+	res := make(map[*ChannelConfig][]*ChannelConfig)
+	for _, ch := range config.Channels {
+		for _, name := range ch.Inputs {
+			if in, ok := config.Channels[name]; ok {
+				res[in] = append(res[in], ch)
+			}
+		}
+	}
+	return res
+}
+
+// Recompute channels depending on given input
+// Computed channel is in read error if any of its inputs is,
+// it's not published until all inputs have values
+func (m *SnmpModel) publishComputed(dev *SnmpDevice, input *ChannelConfig) {
+	for _, ch := range dev.computed[input] {
+		env := make(exprEnv, len(ch.Inputs))
+		failed, ready := false, true
+
+		for v, name := range ch.Inputs {
+			in := dev.Config.Channels[name]
+			if dev.Error[in] == "r" {
+				failed = true
+				break
+			}
+
+			// published value may lag behind because of deadband
+			data, ok := dev.Latest[in]
+			if !ok || data == "" {
+				ready = false
+				continue
+			}
+
+			x, err := strconv.ParseFloat(data, 64)
+			if err != nil {
+				wbgo.Warn.Printf("input %s of %s:%s is not a number: %s", name, dev.DevName, ch.Name, data)
+				failed = true
+				break
+			}
+			env[v] = x
+		}
+
+		if failed {
+			m.publishError(dev, ch, "r")
+			continue
+		}
+		if !ready {
+			continue
+		}
+
+		res, err := ch.Computed.EvalVars(env)
+		if err != nil {
+			wbgo.Warn.Printf("can't compute %s:%s: %s", dev.DevName, ch.Name, err)
+			m.publishError(dev, ch, "r")
+			continue
+		}
+
+		m.publishData(dev, ch, formatComputed(ch, res))
+	}
+}
+
+// Format computed value without float noise like 0.30000000000000004,
+// numeric result is rounded to channel precision if it's set
+func formatComputed(ch *ChannelConfig, res float64) string {
+	data := ch.Conv(trimFloat(res))
+	if ch.Precision <= 0 {
+		return data
+	}
+	f, err := strconv.ParseFloat(data, 64)
+	if err != nil {
+		return data
+	}
+	return trimFloat(math.Round(f/ch.Precision) * ch.Precision)
+}
+
+// Format float with 12 significant digits at most,
+// that cuts off errors accumulated by arithmetic
+func trimFloat(f float64) string {
+	f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'g', 12, 64), 64)
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,39 @@
+package mqtt_snmp
+
+import (
+	"testing"
+
+	"github.com/contactless/wbgo/testutils"
+)
+
+type ComputedSuite struct {
+	testutils.Suite
+}
+
+func (s *ComputedSuite) TestFormat() {
+	cases := []struct {
+		res       float64
+		precision float64
+		conv      ValueConverter
+		expected  string
+	}{
+		{0.1 + 0.2, 0, AsIs, "0.3"},
+		{3.5, 0, AsIs, "3.5"},
+		{-2, 0, AsIs, "-2"},
+		{1e15, 0, AsIs, "1000000000000000"},
+		{3.14159, 0.1, AsIs, "3.1"},
+		{3.14159, 0.01, AsIs, "3.14"},
+		{1234, 5, AsIs, "1235"},
+		{7.8, 1, AsIs, "8"},
+		{12.34, 0.5, Scale(10), "123.5"},
+	}
+
+	for _, c := range cases {
+		ch := &ChannelConfig{Conv: c.conv, Precision: c.precision}
+		s.Equal(c.expected, formatComputed(ch, c.res), "%v with precision %v", c.res, c.precision)
+	}
+}
+
+func TestComputed(t *testing.T) {
+	testutils.RunSuites(t, new(ComputedSuite))
+}
@@ -1,17 +1,20 @@
 package mqtt_snmp
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/contactless/wbgo"
-	"github.com/wirenboard/gosnmp"
+	"github.com/gosnmp/gosnmp"
 )
 
 const (
@@ -37,6 +40,19 @@ const (
 	// Default number of workers
 	DefaultNumWorkers = 4
 
+	// Default max number of variables in single GET request
+	DefaultMaxVarbinds = 10
+
+	// Default number of consecutive failed requests
+	// after which device is considered offline
+	DefaultOfflineThreshold = 3
+
+	// Default OID requested to check if offline device is back (sysUpTime.0)
+	DefaultProbeOid = ".1.3.6.1.2.1.1.3.0"
+
+	// Default max interval between probes of offline device (ms)
+	DefaultMaxProbeInterval = 300000
+
 	floatEps = 0.00001 // epsilon to compare floats
 )
 
@@ -101,10 +117,11 @@ func (tpl *deviceTemplatesStorage) Load(dir string) error {
 }
 
 // Initialize raw device entry using template
+// Entry gets copy of template, so merging doesn't change template itself
 func (tpl *deviceTemplatesStorage) InitEntry(devType string, entry map[string]any) error {
 	if data, ok := tpl.templates[devType]; ok {
 		for key, value := range data {
-			entry[key] = value
+			entry[key] = copyEntry(value)
 		}
 	} else {
 		return fmt.Errorf("no such template: %s", devType)
@@ -113,6 +130,26 @@ func (tpl *deviceTemplatesStorage) InitEntry(devType string, entry map[string]an
 	return nil
 }
 
+// Make deep copy of raw JSON value
+func copyEntry(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		res := make(map[string]any, len(v))
+		for key, item := range v {
+			res[key] = copyEntry(item)
+		}
+		return res
+	case []any:
+		res := make([]any, len(v))
+		for i, item := range v {
+			res[i] = copyEntry(item)
+		}
+		return res
+	default:
+		return value
+	}
+}
+
 // Channel value converter type
 type ValueConverter func(string) string
 
@@ -135,9 +172,145 @@ func Scale(factor float64) ValueConverter {
 	}
 }
 
+// Inverse of Scale converter, used to write values to device
+func InverseScale(factor float64) ValueConverter {
+	return func(s string) string {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			wbgo.Warn.Printf("can't convert numeric value: %s", s)
+			return s
+		}
+
+		// skip conversion if scale is 1
+		if math.Abs(factor-1.0) < floatEps {
+			return s
+		}
+
+		return strconv.FormatFloat(f/factor, 'f', -1, 64)
+	}
+}
+
+// Enumeration converter, replaces numbers by their labels
+// Unknown numbers are kept as is
+func Enum(labels map[int64]string) ValueConverter {
+	return func(s string) string {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			wbgo.Warn.Printf("can't convert enumeration value: %s", s)
+			return s
+		}
+		if label, ok := labels[n]; ok {
+			return label
+		}
+		return s
+	}
+}
+
+// Inverse of Enum converter, used to write values to device
+// Numbers are accepted as well as labels, label used for several
+// values (possible in MIB only) is written as the lowest one
+func InverseEnum(labels map[int64]string) ValueConverter {
+	values := make(map[string]int64, len(labels))
+	for n, label := range labels {
+		if v, ok := values[label]; !ok || n < v {
+			values[label] = n
+		}
+	}
+
+	return func(s string) string {
+		if n, ok := values[s]; ok {
+			return strconv.FormatInt(n, 10)
+		}
+		return s
+	}
+}
+
+// SNMP types allowed in SET requests
+var snmpSetTypes = map[string]gosnmp.Asn1BER{
+	"Integer":          gosnmp.Integer,
+	"OctetString":      gosnmp.OctetString,
+	"ObjectIdentifier": gosnmp.ObjectIdentifier,
+	"IpAddress":        gosnmp.IPAddress,
+	"Counter32":        gosnmp.Counter32,
+	"Gauge32":          gosnmp.Gauge32,
+	"TimeTicks":        gosnmp.TimeTicks,
+	"Counter64":        gosnmp.Counter64,
+	"Unsigned32":       gosnmp.Uinteger32,
+}
+
+// Control types of wb-mqtt-conventions
+var controlTypes = map[string]bool{
+	"switch":               true,
+	"alarm":                true,
+	"pushbutton":           true,
+	"range":                true,
+	"rgb":                  true,
+	"text":                 true,
+	"value":                true,
+	"temperature":          true,
+	"rel_humidity":         true,
+	"atmospheric_pressure": true,
+	"rainfall":             true,
+	"wind_speed":           true,
+	"power":                true,
+	"power_consumption":    true,
+	"voltage":              true,
+	"water_flow":           true,
+	"water_consumption":    true,
+	"resistance":           true,
+	"concentration":        true,
+	"heat_power":           true,
+	"heat_energy":          true,
+	"current":              true,
+	"pressure":             true,
+	"lux":                  true,
+	"sound_level":          true,
+}
+
+// Known keys of device entries
+var deviceKeys = map[string]bool{
+	"enabled": true, "address": true, "community": true, "name": true, "id": true,
+	"device_type": true, "snmp_version": true, "snmp_timeout": true, "oid_prefix": true,
+	"poll_interval": true, "max_varbinds": true, "last_trap_control": true,
+	"offline_threshold": true, "availability_controls": true, "probe_oid": true,
+	"max_probe_interval": true, "channels": true,
+	"security_name": true, "security_level": true, "auth_protocol": true,
+	"auth_passphrase": true, "priv_protocol": true, "priv_passphrase": true,
+	"context_name": true, "context_engine_id": true,
+}
+
+// Known keys of channel entries, order is set by template merging
+var channelKeys = map[string]bool{
+	"enabled": true, "name": true, "oid": true, "control_type": true, "type": true,
+	"units": true, "scale": true, "expr": true, "poll_interval": true, "order": true,
+	"writable": true, "set_type": true, "table": true, "row_name": true, "label_oid": true,
+	"format": true, "enum": true, "rate": true, "computed": true, "inputs": true,
+	"alarm": true, "deadband": true, "min_publish_interval": true, "max_publish_interval": true,
+	"readonly": true, "min": true, "max": true, "precision": true, "title": true,
+}
+
+// Get sorted unknown keys of config entry
+func unknownKeys(entry map[string]any, known map[string]bool) []string {
+	unknown := make([]string, 0)
+	for key := range entry {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// Warn about unknown keys of config entry, they are likely typos
+func warnUnknownKeys(entry map[string]any, known map[string]bool, where string) {
+	if unknown := unknownKeys(entry, known); len(unknown) > 0 {
+		wbgo.Warn.Printf("unknown keys in %s are ignored: %s", where, strings.Join(unknown, ", "))
+	}
+}
+
 // Check if control type is numeric
 func isNumericControlType(ctype string) bool {
-	return ctype != "text"
+	return ctype != "text" && ctype != "rgb"
 }
 
 // Final structures
@@ -147,6 +320,77 @@ type ChannelConfig struct {
 	PollInterval                  int
 	Order                         int
 	Device                        *DeviceConfig
+
+	// Write support: SNMP type for SET requests and
+	// inverse of Conv to get raw value from MQTT one
+	Writable bool
+	SetType  gosnmp.Asn1BER
+	InvConv  ValueConverter
+
+	// Table walking: Oid is a table column, each row
+	// gets its own control named by RowName pattern,
+	// LabelOid is an optional column to get row labels from
+	Table    bool
+	RowName  string
+	LabelOid string
+
+	// Expression to transform polled value before Conv and its compiled form
+	Expr string
+	expr *Expression
+
+	// Computed channel is not polled, it's an expression of other
+	// channels of device given by map from variable to channel name
+	Computed *Expression
+	Inputs   map[string]string
+
+	// Rate unit of counter channel, counter value is replaced
+	// by its change per unit, zero to publish raw value
+	Rate time.Duration
+
+	// Labels of INTEGER values, text controls show labels
+	// and numeric ones show numbers with labels in control meta
+	Enum map[int64]string
+
+	// Control meta: readonly flag, value range and precision
+	// of numeric controls, titles by language
+	Readonly  bool
+	Min, Max  *float64
+	Precision float64
+	Title     map[string]string
+
+	// Changes of numeric value less than deadband (absolute or percent
+	// of published value) are not published
+	Deadband        float64
+	DeadbandPercent bool
+
+	// Changed values are published not more often than min interval,
+	// unchanged ones are repeated after max interval if it's not zero
+	MinPublishInterval, MaxPublishInterval time.Duration
+
+	// Alarm thresholds, nil if channel has no alarm control
+	Alarm *AlarmConfig
+
+	// Format of binary OCTET STRING values (hex, mac, dateandtime,
+	// ascii, base64), DISPLAY-HINT of MIB object is used if empty
+	Format string
+
+	// MIB object of Oid with type information,
+	// nil if object is not found in loaded MIBs
+	Object *MibObject
+
+	// Error of channel setup, i.e. unresolved OID;
+	// failed channel is not polled and its control shows error
+	Error string
+}
+
+// SNMPv3 User-based Security Model parameters
+type SnmpV3Config struct {
+	SecurityName                   string
+	SecurityLevel                  gosnmp.SnmpV3MsgFlags
+	AuthProtocol                   gosnmp.SnmpV3AuthProtocol
+	PrivProtocol                   gosnmp.SnmpV3PrivProtocol
+	AuthPassphrase, PrivPassphrase string
+	ContextName, ContextEngineId   string
 }
 
 type DeviceConfig struct {
@@ -156,8 +400,34 @@ type DeviceConfig struct {
 	SnmpTimeout                              int
 	PollInterval                             int
 
+	// Max number of channels polled in single GET request,
+	// 1 disables batching
+	MaxVarbinds int
+
+	// SNMPv3 security parameters, nil for v1 and v2c
+	V3 *SnmpV3Config
+
+	// Publish traps not matched to any channel to "last_trap" control
+	LastTrapControl bool
+
+	// Number of consecutive failed requests after which device
+	// is considered offline, 0 disables offline detection
+	OfflineThreshold int
+
+	// Publish device state to "online" and "last_seen" controls
+	AvailabilityControls bool
+
+	// OID requested to check if offline device is back
+	// and max interval between such requests (ms)
+	ProbeOid         string
+	MaxProbeInterval int
+
 	// Channels is map from channel names
 	Channels map[string]*ChannelConfig
+
+	// Device entry merged with template, used to detect
+	// changed devices on config reload
+	source map[string]any
 }
 
 // Get device ID from community string and address
@@ -175,6 +445,17 @@ type DaemonConfig struct {
 	NumWorkers int
 	templates  deviceTemplatesStorage
 
+	// UDP address to receive traps and informs on, i.e. ":162"
+	// Trap receiver is disabled if empty
+	TrapListen string
+
+	// Directories to load MIB modules from
+	MibDirs []string
+
+	// Directory to record device sessions to
+	// Recording is disabled if empty
+	RecordDir string
+
 	// Devices storage is map from device IDs
 	Devices map[string]*DeviceConfig
 }
@@ -200,23 +481,27 @@ func NewDaemonConfig(input io.Reader, templatesDir string) (config *DaemonConfig
 // Make empty device config, fill it with
 // default configuration values such as SnmpVersion and SnmpTimeout
 func NewEmptyDeviceConfig() *DeviceConfig {
-	return &DeviceConfig{DeviceType: "", Community: "", SnmpVersion: DefaultSnmpVersion, SnmpTimeout: DefaultSnmpTimeout, OidPrefix: "", PollInterval: DefaultChannelPollInterval}
+	return &DeviceConfig{DeviceType: "", Community: "", SnmpVersion: DefaultSnmpVersion, SnmpTimeout: DefaultSnmpTimeout, OidPrefix: "", PollInterval: DefaultChannelPollInterval, MaxVarbinds: DefaultMaxVarbinds, OfflineThreshold: DefaultOfflineThreshold, ProbeOid: DefaultProbeOid, MaxProbeInterval: DefaultMaxProbeInterval}
 }
 
 // Make empty channel config
 func NewEmptyChannelConfig() *ChannelConfig {
-	return &ChannelConfig{ControlType: DefaultChannelControlType, Conv: AsIs, PollInterval: DefaultChannelPollInterval, Units: "", Order: 0}
+	return &ChannelConfig{ControlType: DefaultChannelControlType, Conv: AsIs, InvConv: AsIs, PollInterval: DefaultChannelPollInterval, Units: "", Order: 0}
 }
 
 // JSON unmarshaller for DaemonConfig
 func (c *DaemonConfig) UnmarshalJSON(raw []byte) error {
 	var root struct {
 		Debug      bool
-		NumWorkers int `json:"num_workers"`
+		NumWorkers int      `json:"num_workers"`
+		TrapListen string   `json:"trap_listen"`
+		MibDirs    []string `json:"mib_dirs"`
+		RecordDir  string   `json:"record_dir"`
 		Devices    []map[string]any
 	}
 
 	root.NumWorkers = DefaultNumWorkers
+	root.MibDirs = append([]string{}, DefaultMibDirs...)
 
 	if err := json.Unmarshal(raw, &root); err != nil {
 		return fmt.Errorf("can't parse config JSON file: %s", err.Error())
@@ -224,6 +509,9 @@ func (c *DaemonConfig) UnmarshalJSON(raw []byte) error {
 
 	c.Debug = root.Debug
 	c.NumWorkers = root.NumWorkers
+	c.TrapListen = root.TrapListen
+	c.MibDirs = root.MibDirs
+	c.RecordDir = root.RecordDir
 	c.Devices = make(map[string]*DeviceConfig)
 
 	// parse devices config
@@ -264,6 +552,23 @@ func copyInt(fromMap *map[string]any, key string, to *int, required bool) error
 	return nil
 }
 
+// Copy raw any data from map to bool
+func copyBool(fromMap *map[string]any, key string, to *bool, required bool) error {
+	if entry, ok := (*fromMap)[key]; ok {
+		if val, valid := entry.(bool); valid {
+			*to = val
+		} else {
+			return fmt.Errorf("%s must be bool, but %T given", key, entry)
+		}
+	} else {
+		if required {
+			return fmt.Errorf("%s is not present", key)
+		}
+	}
+
+	return nil
+}
+
 // Copy raw any data from map to SnmpVersion
 func copySnmpVersion(fromMap *map[string]any, key string, to *gosnmp.SnmpVersion, required bool) error {
 	if entry, ok := (*fromMap)[key]; ok {
@@ -273,8 +578,10 @@ func copySnmpVersion(fromMap *map[string]any, key string, to *gosnmp.SnmpVersion
 				*to = gosnmp.Version1
 			case "2c":
 				*to = gosnmp.Version2c
+			case "3":
+				*to = gosnmp.Version3
 			default:
-				return fmt.Errorf("SNMP version must be either 1 or 2c, %s given", val)
+				return fmt.Errorf("SNMP version must be one of 1, 2c or 3, %s given", val)
 			}
 		} else {
 			return fmt.Errorf("%s must be int, but %T given", key, entry)
@@ -288,6 +595,110 @@ func copySnmpVersion(fromMap *map[string]any, key string, to *gosnmp.SnmpVersion
 	return nil
 }
 
+// SNMPv3 security levels
+var snmpV3SecurityLevels = map[string]gosnmp.SnmpV3MsgFlags{
+	"noAuthNoPriv": gosnmp.NoAuthNoPriv,
+	"authNoPriv":   gosnmp.AuthNoPriv,
+	"authPriv":     gosnmp.AuthPriv,
+}
+
+// SNMPv3 authentication protocols
+var snmpV3AuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"MD5":     gosnmp.MD5,
+	"SHA":     gosnmp.SHA,
+	"SHA-224": gosnmp.SHA224,
+	"SHA-256": gosnmp.SHA256,
+	"SHA-384": gosnmp.SHA384,
+	"SHA-512": gosnmp.SHA512,
+}
+
+// SNMPv3 privacy protocols
+var snmpV3PrivProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"DES":     gosnmp.DES,
+	"AES":     gosnmp.AES,
+	"AES-192": gosnmp.AES192,
+	"AES-256": gosnmp.AES256,
+}
+
+// Minimal passphrase length according to RFC 3414
+const minV3PassphraseLength = 8
+
+// Parse SNMPv3 security parameters from raw device entry
+func parseSnmpV3Config(devEntry map[string]any) (*SnmpV3Config, error) {
+	v3 := &SnmpV3Config{SecurityLevel: gosnmp.NoAuthNoPriv, AuthProtocol: gosnmp.NoAuth, PrivProtocol: gosnmp.NoPriv}
+
+	// security name is required
+	if err := copyString(&devEntry, "security_name", &(v3.SecurityName), true); err != nil {
+		return nil, err
+	}
+
+	var level, authProto, privProto string
+	if err := copyString(&devEntry, "security_level", &level, false); err != nil {
+		return nil, err
+	}
+	if err := copyString(&devEntry, "auth_protocol", &authProto, false); err != nil {
+		return nil, err
+	}
+	if err := copyString(&devEntry, "priv_protocol", &privProto, false); err != nil {
+		return nil, err
+	}
+	if err := copyString(&devEntry, "auth_passphrase", &(v3.AuthPassphrase), false); err != nil {
+		return nil, err
+	}
+	if err := copyString(&devEntry, "priv_passphrase", &(v3.PrivPassphrase), false); err != nil {
+		return nil, err
+	}
+	if err := copyString(&devEntry, "context_name", &(v3.ContextName), false); err != nil {
+		return nil, err
+	}
+
+	var engineId string
+	if err := copyString(&devEntry, "context_engine_id", &engineId, false); err != nil {
+		return nil, err
+	}
+	if engineId != "" {
+		// engine ID is given as hex string, optionally with 0x prefix
+		raw, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(engineId), "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("context_engine_id must be hex string: %s", err)
+		}
+		v3.ContextEngineId = string(raw)
+	}
+
+	if level != "" {
+		var ok bool
+		if v3.SecurityLevel, ok = snmpV3SecurityLevels[level]; !ok {
+			return nil, fmt.Errorf("unknown security_level %s", level)
+		}
+	}
+
+	if v3.SecurityLevel == gosnmp.NoAuthNoPriv {
+		return v3, nil
+	}
+
+	// authNoPriv and authPriv require authentication
+	var ok bool
+	if v3.AuthProtocol, ok = snmpV3AuthProtocols[authProto]; !ok {
+		return nil, fmt.Errorf("auth_protocol must be one of MD5, SHA, SHA-224, SHA-256, SHA-384, SHA-512 for %s, %q given", level, authProto)
+	}
+	if len(v3.AuthPassphrase) < minV3PassphraseLength {
+		return nil, fmt.Errorf("auth_passphrase must be at least %d characters long", minV3PassphraseLength)
+	}
+
+	if v3.SecurityLevel == gosnmp.AuthNoPriv {
+		return v3, nil
+	}
+
+	if v3.PrivProtocol, ok = snmpV3PrivProtocols[privProto]; !ok {
+		return nil, fmt.Errorf("priv_protocol must be one of DES, AES, AES-192, AES-256 for %s, %q given", level, privProto)
+	}
+	if len(v3.PrivPassphrase) < minV3PassphraseLength {
+		return nil, fmt.Errorf("priv_passphrase must be at least %d characters long", minV3PassphraseLength)
+	}
+
+	return v3, nil
+}
+
 // Copy raw any data from map to float64
 func copyFloat64(fromMap *map[string]any, key string, to *float64, required bool) error {
 	if entry, ok := (*fromMap)[key]; ok {
@@ -322,19 +733,38 @@ func (c *DaemonConfig) checkEnabled(channel map[string]any) (bool, error) {
 	return enabled, nil
 }
 
+// Errors of invalid devices skipped by config parser
+// Config is still usable with the rest of devices
+type DeviceConfigErrors []error
+
+func (e DeviceConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
 // Parse devices list
+// Invalid devices are skipped, errors of all of them are returned
 func (c *DaemonConfig) parseDevices(devs []map[string]any) error {
 	if len(devs) == 0 {
 		return fmt.Errorf("devices list is empty")
 	}
 
+	var errs DeviceConfigErrors
+
 	// for each element in input slice - create DeviceConfig structure
-	for _, value := range devs {
+	for i, value := range devs {
 		if err := c.parseDeviceEntry(value); err != nil {
-			return err
+			errs = append(errs, fmt.Errorf("device #%d: %s", i+1, err))
 		}
 	}
 
+	if len(errs) > 0 {
+		return errs
+	}
+
 	return nil
 }
 
@@ -429,6 +859,13 @@ func (c *DaemonConfig) layConfigDataOverTemplate(tplEntry, devEntry map[string]a
 	for name, channel := range devChannelsMap {
 		// check if this name is present in channel map
 		if _, present := tplChannelsMap[name]; present {
+			// control type of config overrides template one
+			// whatever key is used for it
+			if hasControlType(channel) {
+				delete(tplChannelsMap[name], "control_type")
+				delete(tplChannelsMap[name], "type")
+			}
+
 			// merge entries
 			for n, v := range channel {
 				tplChannelsMap[name][n] = v
@@ -488,17 +925,18 @@ func (c *DaemonConfig) layConfigDataOverTemplate(tplEntry, devEntry map[string]a
 	return nil
 }
 
-// Parse single device entry
-func (c *DaemonConfig) parseDeviceEntry(devConfig map[string]any) error {
+// Merge device entry with its template
+// Entry is nil if device is disabled
+func (c *DaemonConfig) mergeDeviceEntry(devConfig map[string]any) (map[string]any, error) {
 
 	// Check if device is enabled and skip if not
 	if enableEntry, ok := devConfig["enabled"]; ok {
 		if enableValue, valid := enableEntry.(bool); valid {
 			if !enableValue {
-				return nil // device is disabled, nothing to do here
+				return nil, nil // device is disabled, nothing to do here
 			}
 		} else {
-			return fmt.Errorf("'enable' must be bool, %T given", enableEntry)
+			return nil, fmt.Errorf("'enable' must be bool, %T given", enableEntry)
 		}
 	} // if 'enable' is not presented, think that device is enabled by default
 
@@ -511,21 +949,41 @@ func (c *DaemonConfig) parseDeviceEntry(devConfig map[string]any) error {
 	if devTypeEntry, ok := devConfig["device_type"]; ok {
 		if devType, valid = devTypeEntry.(string); valid {
 			if err := c.templates.InitEntry(devType, devEntry); err != nil {
-				return err
+				return nil, err
 			}
 		} else {
-			return fmt.Errorf("device_type must be string, but %T given", devTypeEntry)
+			return nil, fmt.Errorf("device_type must be string, but %T given", devTypeEntry)
 		}
 	}
 
 	// Lay config data over template
 	if err := c.layConfigDataOverTemplate(devEntry, devConfig); err != nil {
+		return nil, err
+	}
+
+	return devEntry, nil
+}
+
+// Parse single device entry
+func (c *DaemonConfig) parseDeviceEntry(devConfig map[string]any) error {
+	devEntry, err := c.mergeDeviceEntry(devConfig)
+	if err != nil || devEntry == nil {
 		return err
 	}
+	return c.parseMergedDeviceEntry(devEntry)
+}
 
+// Parse device entry merged with its template
+func (c *DaemonConfig) parseMergedDeviceEntry(devEntry map[string]any) error {
 	// Parse whole tree
 	d := NewEmptyDeviceConfig()
 
+	where := "device"
+	if address, ok := devEntry["address"].(string); ok {
+		where = "device " + address
+	}
+	warnUnknownKeys(devEntry, deviceKeys, where)
+
 	// insert entries in a hard way
 	// address field is required
 	if err := copyString(&devEntry, "address", &(d.Address), true); err != nil {
@@ -551,6 +1009,11 @@ func (c *DaemonConfig) parseDeviceEntry(devConfig map[string]any) error {
 	if err := copyString(&devEntry, "id", &(d.Id), false); err != nil {
 		return err
 	}
+
+	// check collision of explicit ID
+	if _, ok := c.Devices[d.Id]; ok {
+		return fmt.Errorf("device id collision on %s", d.Id)
+	}
 	if err := copyString(&devEntry, "device_type", &(d.DeviceType), false); err != nil {
 		return err
 	}
@@ -566,6 +1029,42 @@ func (c *DaemonConfig) parseDeviceEntry(devConfig map[string]any) error {
 	if err := copyInt(&devEntry, "poll_interval", &(d.PollInterval), false); err != nil {
 		return err
 	}
+	if err := copyInt(&devEntry, "max_varbinds", &(d.MaxVarbinds), false); err != nil {
+		return err
+	}
+	if d.MaxVarbinds < 1 || d.MaxVarbinds > gosnmp.MaxOids {
+		return fmt.Errorf("max_varbinds in %s must be in range 1..%d, %d given", d.Id, gosnmp.MaxOids, d.MaxVarbinds)
+	}
+
+	// SNMPv3 security parameters
+	if d.SnmpVersion == gosnmp.Version3 {
+		var err error
+		if d.V3, err = parseSnmpV3Config(devEntry); err != nil {
+			return fmt.Errorf("SNMPv3 config error in %s: %s", d.Id, err)
+		}
+	}
+
+	if err := copyBool(&devEntry, "last_trap_control", &(d.LastTrapControl), false); err != nil {
+		return err
+	}
+	if err := copyInt(&devEntry, "offline_threshold", &(d.OfflineThreshold), false); err != nil {
+		return err
+	}
+	if d.OfflineThreshold < 0 {
+		return fmt.Errorf("offline_threshold in %s must not be negative, %d given", d.Id, d.OfflineThreshold)
+	}
+	if err := copyBool(&devEntry, "availability_controls", &(d.AvailabilityControls), false); err != nil {
+		return err
+	}
+	if err := copyString(&devEntry, "probe_oid", &(d.ProbeOid), false); err != nil {
+		return err
+	}
+	if err := copyInt(&devEntry, "max_probe_interval", &(d.MaxProbeInterval), false); err != nil {
+		return err
+	}
+	if d.MaxProbeInterval < 1 {
+		return fmt.Errorf("max_probe_interval in %s must be positive, %d given", d.Id, d.MaxProbeInterval)
+	}
 
 	d.Channels = make(map[string]*ChannelConfig)
 
@@ -582,12 +1081,68 @@ func (c *DaemonConfig) parseDeviceEntry(devConfig map[string]any) error {
 		return fmt.Errorf("channels list is not present for %s", d.Id)
 	}
 
+	// check name collision with trap control
+	if _, ok := d.Channels[LastTrapChannelName]; ok && d.LastTrapControl {
+		return fmt.Errorf("channel name %s in %s is reserved for last trap control", LastTrapChannelName, d.Id)
+	}
+
+	// check name collision with availability controls
+	if d.AvailabilityControls {
+		for _, name := range []string{OnlineChannelName, LastSeenChannelName} {
+			if _, ok := d.Channels[name]; ok {
+				return fmt.Errorf("channel name %s in %s is reserved for availability control", name, d.Id)
+			}
+		}
+	}
+
 	// append device to storage
+	d.source = devEntry
 	c.Devices[d.Id] = d
 
 	return nil
 }
 
+// Process OID prefix
+// only if it is defined, name is from MIB and there's no prefix in name
+func (d *DeviceConfig) prefixedOid(oid string) string {
+	if d.OidPrefix != "" && oid != "" && oid[0] != '.' && !strings.Contains(oid, "::") {
+		return d.OidPrefix + "::" + oid
+	}
+	return oid
+}
+
+// Parse table walking parameters of channel
+func (c *ChannelConfig) parseTableEntry(d *DeviceConfig, channel map[string]any) error {
+	if err := copyBool(&channel, "table", &(c.Table), false); err != nil {
+		return err
+	}
+
+	if !c.Table {
+		return nil
+	}
+
+	c.RowName = c.Name + " " + TableIndexPlaceholder
+	if err := copyString(&channel, "row_name", &(c.RowName), false); err != nil {
+		return fmt.Errorf("table channel %s: %s", c.Name, err)
+	}
+	if err := copyString(&channel, "label_oid", &(c.LabelOid), false); err != nil {
+		return fmt.Errorf("table channel %s: %s", c.Name, err)
+	}
+	c.LabelOid = d.prefixedOid(c.LabelOid)
+
+	// row names must differ
+	hasIndex := strings.Contains(c.RowName, TableIndexPlaceholder)
+	hasLabel := strings.Contains(c.RowName, TableLabelPlaceholder)
+	if hasLabel && c.LabelOid == "" {
+		return fmt.Errorf("table channel %s: label_oid is required for %s in row_name", c.Name, TableLabelPlaceholder)
+	}
+	if !hasIndex && !hasLabel {
+		return fmt.Errorf("table channel %s: row_name must contain %s or %s", c.Name, TableIndexPlaceholder, TableLabelPlaceholder)
+	}
+
+	return nil
+}
+
 // Parse channels list
 func (d *DeviceConfig) parseChannels(chans []map[string]any) error {
 	// for each element in input slice - create ChannelConfig structure and append to DeviceConfig
@@ -601,9 +1156,279 @@ func (d *DeviceConfig) parseChannels(chans []map[string]any) error {
 		}
 	}
 
+	// inputs of computed channels are polled channels of the same device
+	// alarm controls names must not clash with channels
+	alarms := make(map[string]bool)
+	for _, c := range d.Channels {
+		if c.Alarm != nil {
+			if _, ok := d.Channels[c.Alarm.Name]; ok || alarms[c.Alarm.Name] {
+				return fmt.Errorf("channel %s: duplicate alarm control name %s", c.Name, c.Alarm.Name)
+			}
+			alarms[c.Alarm.Name] = true
+		}
+
+		for v, name := range c.Inputs {
+			in, ok := d.Channels[name]
+			if !ok {
+				return fmt.Errorf("computed channel %s: input %s refers to unknown channel %s", c.Name, v, name)
+			}
+			if in.Computed != nil || in.Table {
+				return fmt.Errorf("computed channel %s: input %s refers to computed or table channel %s", c.Name, v, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Parse computed channel expression and its inputs, i.e.
+// "computed": "p1 + p2", "inputs": {"p1": "Power L1", "p2": "Power L2"}
+func (c *ChannelConfig) parseComputedEntry(channel map[string]any) error {
+	var src string
+	if err := copyString(&channel, "computed", &src, false); err != nil {
+		return err
+	}
+
+	entry, hasInputs := channel["inputs"]
+	if src == "" {
+		if hasInputs {
+			return fmt.Errorf("channel %s: inputs are given for non-computed channel", c.Name)
+		}
+		return nil
+	}
+
+	raw, ok := entry.(map[string]any)
+	if !ok || len(raw) == 0 {
+		return fmt.Errorf("computed channel %s: inputs object is required", c.Name)
+	}
+
+	c.Inputs = make(map[string]string, len(raw))
+	vars := make([]string, 0, len(raw))
+	for v, value := range raw {
+		name, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("computed channel %s: input %s must be channel name, but %T given", c.Name, v, value)
+		}
+		if !isExprVarName(v) {
+			return fmt.Errorf("computed channel %s: wrong input name %s", c.Name, v)
+		}
+		c.Inputs[v] = name
+		vars = append(vars, v)
+	}
+
+	sort.Strings(vars)
+	e, err := ParseExpressionVars(src, vars)
+	if err != nil {
+		return fmt.Errorf("computed channel %s: %s", c.Name, err)
+	}
+	c.Computed = e
+
+	return nil
+}
+
+// Parse enumeration object: {"1": "label1", "2": "label2"}
+func parseEnum(entry any) (map[int64]string, error) {
+	raw, ok := entry.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("enum must be object, but %T given", entry)
+	}
+
+	enum := make(map[int64]string, len(raw))
+	values := make(map[string]int64, len(raw))
+	for key, value := range raw {
+		n, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("enum key must be integer, but %q given", key)
+		}
+		label, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("enum label of %d must be string, but %T given", n, value)
+		}
+		// label must be written back as single value
+		if v, ok := values[label]; ok {
+			return nil, fmt.Errorf("enum label %q is used for both %d and %d", label, v, n)
+		}
+		values[label] = n
+		enum[n] = label
+	}
+
+	return enum, nil
+}
+
+// Parse control meta of channel: readonly flag, min, max,
+// precision and title which is string or object of titles by language
+// Channels are read-only unless they are writable
+func (c *ChannelConfig) parseMetaEntry(channel map[string]any) error {
+	c.Readonly = !c.Writable
+	if err := copyBool(&channel, "readonly", &(c.Readonly), false); err != nil {
+		return err
+	}
+	if c.Readonly && c.Writable {
+		return fmt.Errorf("channel %s: writable channel can't be readonly", c.Name)
+	}
+
+	for _, key := range []string{"min", "max"} {
+		if _, ok := channel[key]; !ok {
+			continue
+		}
+		var v float64
+		if err := copyFloat64(&channel, key, &v, true); err != nil {
+			return fmt.Errorf("channel %s: %s", c.Name, err)
+		}
+		if key == "min" {
+			c.Min = &v
+		} else {
+			c.Max = &v
+		}
+	}
+	if c.Min != nil && c.Max != nil && *c.Min >= *c.Max {
+		return fmt.Errorf("channel %s: min must be less than max", c.Name)
+	}
+
+	if err := copyFloat64(&channel, "precision", &(c.Precision), false); err != nil {
+		return fmt.Errorf("channel %s: %s", c.Name, err)
+	}
+	if c.Precision < 0 {
+		return fmt.Errorf("channel %s: precision can't be negative", c.Name)
+	}
+
+	if (c.Min != nil || c.Max != nil || c.Precision != 0) && !isNumericControlType(c.ControlType) {
+		return fmt.Errorf("channel %s: min, max and precision could be applied only to numeric control type", c.Name)
+	}
+
+	switch title := channel["title"].(type) {
+	case nil:
+	case string:
+		c.Title = map[string]string{DefaultTitleLang: title}
+	case map[string]any:
+		c.Title = make(map[string]string, len(title))
+		for lang, value := range title {
+			if c.Title[lang], _ = value.(string); c.Title[lang] == "" {
+				return fmt.Errorf("channel %s: title in %s must be non-empty string", c.Name, lang)
+			}
+		}
+	default:
+		return fmt.Errorf("channel %s: title must be string or object, but %T given", c.Name, title)
+	}
+
 	return nil
 }
 
+// Check if control type is given in channel entry
+func hasControlType(channel map[string]any) bool {
+	_, hasControlType := channel["control_type"]
+	_, hasType := channel["type"]
+	return hasControlType || hasType
+}
+
+// Parse control type of channel
+// Templates use "type" key, "control_type" is used in config,
+// type must be supported by wb-mqtt-conventions
+func (c *ChannelConfig) parseControlType(channel map[string]any) error {
+	var controlType, typ string
+	if err := copyString(&channel, "control_type", &controlType, false); err != nil {
+		return err
+	}
+	if err := copyString(&channel, "type", &typ, false); err != nil {
+		return err
+	}
+
+	if controlType != "" && typ != "" && controlType != typ {
+		return fmt.Errorf("channel %s: control_type %s and type %s differ", c.Name, controlType, typ)
+	}
+	if controlType == "" {
+		controlType = typ
+	}
+	if controlType == "" {
+		return nil
+	}
+
+	if !controlTypes[controlType] {
+		return fmt.Errorf("channel %s: unsupported control type %s", c.Name, controlType)
+	}
+	c.ControlType = controlType
+
+	return nil
+}
+
+// Parse alarm thresholds, i.e.
+// {"high": 45, "low": 5, "hysteresis": 1, "delay": 10000}
+func parseAlarm(entry any) (*AlarmConfig, error) {
+	raw, ok := entry.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("alarm must be object, but %T given", entry)
+	}
+
+	alarm := &AlarmConfig{}
+	if err := copyString(&raw, "name", &alarm.Name, false); err != nil {
+		return nil, fmt.Errorf("alarm %s", err)
+	}
+
+	threshold := func(key string) (*float64, error) {
+		if _, ok := raw[key]; !ok {
+			return nil, nil
+		}
+		var v float64
+		err := copyFloat64(&raw, key, &v, true)
+		return &v, err
+	}
+
+	var err error
+	if alarm.High, err = threshold("high"); err != nil {
+		return nil, fmt.Errorf("alarm %s", err)
+	}
+	if alarm.Low, err = threshold("low"); err != nil {
+		return nil, fmt.Errorf("alarm %s", err)
+	}
+	if alarm.High == nil && alarm.Low == nil {
+		return nil, fmt.Errorf("alarm must have high or low threshold")
+	}
+	if alarm.High != nil && alarm.Low != nil && *alarm.High <= *alarm.Low {
+		return nil, fmt.Errorf("alarm high threshold must be greater than low one")
+	}
+
+	if err := copyFloat64(&raw, "hysteresis", &alarm.Hysteresis, false); err != nil {
+		return nil, fmt.Errorf("alarm %s", err)
+	}
+	delay := 0
+	if err := copyInt(&raw, "delay", &delay, false); err != nil {
+		return nil, fmt.Errorf("alarm %s", err)
+	}
+	if alarm.Hysteresis < 0 || delay < 0 {
+		return nil, fmt.Errorf("alarm hysteresis and delay can't be negative")
+	}
+	alarm.Delay = time.Duration(delay) * time.Millisecond
+
+	return alarm, nil
+}
+
+// Convert polled value to published one by expression and Conv
+// Error is returned if expression can't be evaluated
+func (c *ChannelConfig) Convert(data string) (string, error) {
+	if c.expr != nil {
+		var err error
+		if data, err = c.expr.Convert(data); err != nil {
+			return "", err
+		}
+	}
+	return c.Conv(data), nil
+}
+
+// Set enumeration of channel
+// Labels are converted to and from numbers for text controls only,
+// after value is converted by expression if any
+func (c *ChannelConfig) SetEnum(enum map[int64]string) {
+	c.Enum = enum
+	if !isNumericControlType(c.ControlType) {
+		conv, labels := c.Conv, Enum(enum)
+		if conv == nil {
+			conv = AsIs
+		}
+		c.Conv = func(s string) string { return labels(conv(s)) }
+		c.InvConv = InverseEnum(enum)
+	}
+}
+
 // Parse single channel entry
 func (d *DeviceConfig) parseChannelEntry(channel map[string]any) error {
 
@@ -617,19 +1442,28 @@ func (d *DeviceConfig) parseChannelEntry(channel map[string]any) error {
 		return err
 	}
 
-	// oid is required
-	if err := copyString(&channel, "oid", &(c.Oid), true); err != nil {
+	warnUnknownKeys(channel, channelKeys, "channel "+c.Name)
+
+	// computed channel is optional, it has no OID
+	if err := c.parseComputedEntry(channel); err != nil {
 		return err
 	}
 
-	// process OID prefix
-	// only if it is defined, name is from MIB and there's no prefix in name
-	if d.OidPrefix != "" && c.Oid[0] != '.' && !strings.Contains(c.Oid, "::") {
-		c.Oid = d.OidPrefix + "::" + c.Oid
+	if c.Computed != nil {
+		if _, ok := channel["oid"]; ok {
+			return fmt.Errorf("computed channel %s can't have oid", c.Name)
+		}
+	} else {
+		// oid is required
+		if err := copyString(&channel, "oid", &(c.Oid), true); err != nil {
+			return err
+		}
+
+		c.Oid = d.prefixedOid(c.Oid)
 	}
 
 	// control type is optional
-	if err := copyString(&channel, "control_type", &(c.ControlType), false); err != nil {
+	if err := c.parseControlType(channel); err != nil {
 		return err
 	}
 
@@ -642,11 +1476,60 @@ func (d *DeviceConfig) parseChannelEntry(channel map[string]any) error {
 				return err
 			}
 			c.Conv = Scale(scale)
+			c.InvConv = InverseScale(scale)
 		} else {
 			wbgo.Warn.Println("scale could be applied only to numeric control type")
 		}
 	}
 
+	// expression is optional and replaces scale
+	if err := copyString(&channel, "expr", &(c.Expr), false); err != nil {
+		return err
+	}
+
+	if c.Expr != "" {
+		if _, ok := channel["scale"]; ok {
+			return fmt.Errorf("channel %s: scale and expr can't be used together", c.Name)
+		}
+		e, err := ParseExpression(c.Expr)
+		if err != nil {
+			return fmt.Errorf("channel %s: %s", c.Name, err)
+		}
+		c.expr = e
+	}
+
+	// write support is optional
+	if err := copyBool(&channel, "writable", &(c.Writable), false); err != nil {
+		return err
+	}
+
+	if c.Writable {
+		var setType string
+		if err := copyString(&channel, "set_type", &setType, true); err != nil {
+			return fmt.Errorf("writable channel %s: %s", c.Name, err)
+		}
+
+		var ok bool
+		if c.SetType, ok = snmpSetTypes[setType]; !ok {
+			return fmt.Errorf("writable channel %s: unsupported set_type %s", c.Name, setType)
+		}
+
+		// scale must be invertible
+		if scale, ok := channel["scale"].(float64); ok && scale == 0 {
+			return fmt.Errorf("writable channel %s: scale can't be zero", c.Name)
+		}
+
+		// expression can't be inverted
+		if c.Expr != "" {
+			return fmt.Errorf("writable channel %s: expr can't be used", c.Name)
+		}
+	}
+
+	// table walking is optional
+	if err := c.parseTableEntry(d, channel); err != nil {
+		return err
+	}
+
 	// poll interval is optional
 	c.PollInterval = d.PollInterval
 	if err := copyInt(&channel, "poll_interval", &(c.PollInterval), false); err != nil {
@@ -663,6 +1546,104 @@ func (d *DeviceConfig) parseChannelEntry(channel map[string]any) error {
 		c.Units = ""
 	}
 
+	// format is optional and works only for text controls,
+	// control type is text by default for formatted channels
+	if err := copyString(&channel, "format", &(c.Format), false); err != nil {
+		return err
+	}
+
+	if c.Format != "" {
+		if !isValidFormat(c.Format) {
+			return fmt.Errorf("channel %s: unsupported format %s", c.Name, c.Format)
+		}
+		if !hasControlType(channel) {
+			c.ControlType = "text"
+		} else if isNumericControlType(c.ControlType) {
+			wbgo.Warn.Println("format given for numeric channel ", c.Name, ", skipping it")
+			c.Format = ""
+		}
+	}
+
+	// counter rate is optional and works only for numeric controls
+	var rate string
+	if err := copyString(&channel, "rate", &rate, false); err != nil {
+		return err
+	}
+
+	if rate != "" {
+		var ok bool
+		if c.Rate, ok = rateUnits[rate]; !ok {
+			return fmt.Errorf("channel %s: unsupported rate %s", c.Name, rate)
+		}
+		if !isNumericControlType(c.ControlType) {
+			return fmt.Errorf("channel %s: rate could be applied only to numeric control type", c.Name)
+		}
+		if c.Writable {
+			return fmt.Errorf("channel %s: rate channel can't be writable", c.Name)
+		}
+	}
+
+	// enumeration is optional
+	if entry, ok := channel["enum"]; ok {
+		enum, err := parseEnum(entry)
+		if err != nil {
+			return fmt.Errorf("channel %s: %s", c.Name, err)
+		}
+		c.SetEnum(enum)
+	}
+
+	// alarm thresholds are optional and work only for numeric controls
+	if entry, ok := channel["alarm"]; ok {
+		alarm, err := parseAlarm(entry)
+		if err != nil {
+			return fmt.Errorf("channel %s: %s", c.Name, err)
+		}
+		if !isNumericControlType(c.ControlType) || c.Table {
+			return fmt.Errorf("channel %s: alarm could be applied only to numeric non-table channel", c.Name)
+		}
+		if alarm.Name == "" {
+			alarm.Name = c.Name + " alarm"
+		}
+		c.Alarm = alarm
+	}
+
+	// control meta is optional
+	if err := c.parseMetaEntry(channel); err != nil {
+		return err
+	}
+
+	// deadband is optional and works only for numeric controls
+	if entry, ok := channel["deadband"]; ok {
+		var err error
+		if c.Deadband, c.DeadbandPercent, err = parseDeadband(entry); err != nil {
+			return fmt.Errorf("channel %s: %s", c.Name, err)
+		}
+		if !isNumericControlType(c.ControlType) {
+			return fmt.Errorf("channel %s: deadband could be applied only to numeric control type", c.Name)
+		}
+	}
+
+	// publish intervals are optional
+	minInterval, maxInterval := 0, 0
+	if err := copyInt(&channel, "min_publish_interval", &minInterval, false); err != nil {
+		return err
+	}
+	if err := copyInt(&channel, "max_publish_interval", &maxInterval, false); err != nil {
+		return err
+	}
+	if minInterval < 0 || maxInterval < 0 {
+		return fmt.Errorf("channel %s: publish intervals can't be negative", c.Name)
+	}
+	if maxInterval > 0 && maxInterval < minInterval {
+		return fmt.Errorf("channel %s: max_publish_interval must not be less than min_publish_interval", c.Name)
+	}
+	c.MinPublishInterval = time.Duration(minInterval) * time.Millisecond
+	c.MaxPublishInterval = time.Duration(maxInterval) * time.Millisecond
+
+	if c.Computed != nil && (c.Writable || c.Table || c.Rate != 0 || c.Expr != "") {
+		return fmt.Errorf("computed channel %s can't be writable, table, rate or expr one", c.Name)
+	}
+
 	// add order
 	if err := copyInt(&channel, "order", &(c.Order), true); err != nil {
 		return err
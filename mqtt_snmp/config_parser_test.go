@@ -5,10 +5,11 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/contactless/wbgo"
 	"github.com/contactless/wbgo/testutils"
-	"github.com/wirenboard/gosnmp"
+	"github.com/gosnmp/gosnmp"
 )
 
 type ConfigParserSuite struct {
@@ -92,7 +93,9 @@ func DaemonConfigsEqualVerbose(a, b *DaemonConfig, verbose bool) bool {
 				cvalue.Oid != b_cvalue.Oid ||
 				cvalue.ControlType != b_cvalue.ControlType ||
 				cvalue.PollInterval != b_cvalue.PollInterval ||
-				cvalue.Order != b_cvalue.Order {
+				cvalue.Order != b_cvalue.Order ||
+				cvalue.Writable != b_cvalue.Writable ||
+				cvalue.SetType != b_cvalue.SetType {
 				if verbose {
 					wbgo.Debug.Printf("device %s channel %s configuration mismatch", dkey, ckey)
 					wbgo.Debug.Printf("%+v", cvalue)
@@ -418,6 +421,844 @@ func (s *ConfigParserSuite) TestOidPrefix() {
 	s.True(DaemonConfigsEqualVerbose(res, &expect, true))
 }
 
+// Test SNMPv3 security parameters
+func (s *ConfigParserSuite) TestSnmpV3() {
+	testConfig := `{
+		"devices": [
+			{
+				"address": "127.0.0.1",
+				"id": "noauth",
+				"snmp_version": "3",
+				"security_name": "user1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			},
+			{
+				"address": "127.0.0.2",
+				"id": "auth",
+				"snmp_version": "3",
+				"security_name": "user2",
+				"security_level": "authNoPriv",
+				"auth_protocol": "SHA-256",
+				"auth_passphrase": "authpassword",
+				"context_name": "bridge1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			},
+			{
+				"address": "127.0.0.3",
+				"id": "priv",
+				"snmp_version": "3",
+				"security_name": "user3",
+				"security_level": "authPriv",
+				"auth_protocol": "MD5",
+				"auth_passphrase": "authpassword",
+				"priv_protocol": "AES",
+				"priv_passphrase": "privpassword",
+				"context_engine_id": "0x80001f8880",
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			}
+		]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	s.Equal(&SnmpV3Config{
+		SecurityName:  "user1",
+		SecurityLevel: gosnmp.NoAuthNoPriv,
+		AuthProtocol:  gosnmp.NoAuth,
+		PrivProtocol:  gosnmp.NoPriv,
+	}, res.Devices["noauth"].V3)
+
+	s.Equal(&SnmpV3Config{
+		SecurityName:   "user2",
+		SecurityLevel:  gosnmp.AuthNoPriv,
+		AuthProtocol:   gosnmp.SHA256,
+		AuthPassphrase: "authpassword",
+		PrivProtocol:   gosnmp.NoPriv,
+		ContextName:    "bridge1",
+	}, res.Devices["auth"].V3)
+
+	s.Equal(&SnmpV3Config{
+		SecurityName:    "user3",
+		SecurityLevel:   gosnmp.AuthPriv,
+		AuthProtocol:    gosnmp.MD5,
+		AuthPassphrase:  "authpassword",
+		PrivProtocol:    gosnmp.AES,
+		PrivPassphrase:  "privpassword",
+		ContextEngineId: "\x80\x00\x1f\x88\x80",
+	}, res.Devices["priv"].V3)
+
+	// SNMPv3 parameters are ignored for v2c
+	res, err = NewDaemonConfig(strings.NewReader(`{
+		"devices": [{
+			"address": "127.0.0.1",
+			"security_name": "user1",
+			"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+		}]
+	}`), ".")
+	s.Ck("failed to parse config", err)
+	s.Nil(res.Devices["snmp_127.0.0.1"].V3)
+}
+
+// Fail on incomplete SNMPv3 security parameters
+func (s *ConfigParserSuite) TestSnmpV3Errors() {
+	wrongEntries := []string{
+		// no security name
+		`"security_level": "noAuthNoPriv"`,
+		// unknown security level
+		`"security_name": "user", "security_level": "auth"`,
+		// no auth protocol
+		`"security_name": "user", "security_level": "authNoPriv", "auth_passphrase": "authpassword"`,
+		// unknown auth protocol
+		`"security_name": "user", "security_level": "authNoPriv", "auth_protocol": "SHA1", "auth_passphrase": "authpassword"`,
+		// short auth passphrase
+		`"security_name": "user", "security_level": "authNoPriv", "auth_protocol": "SHA", "auth_passphrase": "short"`,
+		// no priv protocol
+		`"security_name": "user", "security_level": "authPriv", "auth_protocol": "SHA", "auth_passphrase": "authpassword", "priv_passphrase": "privpassword"`,
+		// no priv passphrase
+		`"security_name": "user", "security_level": "authPriv", "auth_protocol": "SHA", "auth_passphrase": "authpassword", "priv_protocol": "AES"`,
+		// wrong context engine ID
+		`"security_name": "user", "context_engine_id": "engine"`,
+	}
+
+	for _, entry := range wrongEntries {
+		testConfig := `{
+			"devices": [{
+				"address": "127.0.0.1",
+				"snmp_version": "3",
+				` + entry + `,
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			}]
+		}`
+
+		_, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+		s.Error(err, "config parser doesn't fail on %s", entry)
+	}
+}
+
+// Test writable channels
+func (s *ConfigParserSuite) TestWritableChannels() {
+	testConfig := `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"channels": [
+				{
+					"name": "channel1",
+					"oid": ".1.2.3.4",
+					"writable": true,
+					"set_type": "Integer",
+					"scale": 0.1
+				},
+				{
+					"name": "channel2",
+					"oid": ".1.2.3.5",
+					"writable": true,
+					"set_type": "OctetString"
+				},
+				{
+					"name": "channel3",
+					"oid": ".1.2.3.6",
+					"writable": false,
+					"set_type": "Integer"
+				}
+			]
+		}]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	channels := res.Devices["snmp_127.0.0.1"].Channels
+
+	s.True(channels["channel1"].Writable)
+	s.Equal(gosnmp.Integer, channels["channel1"].SetType)
+	s.Equal("125", channels["channel1"].InvConv("12.5"))
+
+	s.True(channels["channel2"].Writable)
+	s.Equal(gosnmp.OctetString, channels["channel2"].SetType)
+	s.Equal("foo", channels["channel2"].InvConv("foo"))
+
+	s.False(channels["channel3"].Writable)
+}
+
+// Fail on wrong writable channels settings
+func (s *ConfigParserSuite) TestWritableErrors() {
+	wrongEntries := []string{
+		// no set type
+		`"writable": true`,
+		// unknown set type
+		`"writable": true, "set_type": "Float"`,
+		// zero scale
+		`"writable": true, "set_type": "Integer", "scale": 0`,
+	}
+
+	for _, entry := range wrongEntries {
+		testConfig := `{
+			"devices": [{
+				"address": "127.0.0.1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3", ` + entry + `}]
+			}]
+		}`
+
+		_, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+		s.Error(err, "config parser doesn't fail on %s", entry)
+	}
+}
+
+// Test value formats of channels
+func (s *ConfigParserSuite) TestFormat() {
+	testConfig := `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"channels": [
+				{"name": "mac", "oid": ".1.2.3.4", "format": "mac"},
+				{"name": "value", "oid": ".1.2.3.5", "control_type": "value", "format": "hex"},
+				{"name": "text", "oid": ".1.2.3.6"}
+			]
+		}]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	channels := res.Devices["snmp_127.0.0.1"].Channels
+	s.Equal(FormatMac, channels["mac"].Format)
+	s.Equal("text", channels["mac"].ControlType)
+	s.Equal("", channels["value"].Format)
+	s.Equal("", channels["text"].Format)
+
+	// format of numeric channel is skipped with warning
+	s.EnsureGotWarnings()
+
+	testConfig = `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"channels": [{"name": "mac", "oid": ".1.2.3.4", "format": "bcd"}]
+		}]
+	}`
+
+	_, err = NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Error(err, "config parser doesn't fail on unknown format")
+}
+
+// Test enumerations of channels
+func (s *ConfigParserSuite) TestEnum() {
+	testConfig := `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"channels": [
+				{"name": "status", "oid": ".1.2.3.4", "control_type": "text", "enum": {"1": "unknown", "2": "onLine"}},
+				{"name": "code", "oid": ".1.2.3.5", "enum": {"1": "unknown", "2": "onLine"}}
+			]
+		}]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	channels := res.Devices["snmp_127.0.0.1"].Channels
+	enum := map[int64]string{1: "unknown", 2: "onLine"}
+
+	s.Equal(enum, channels["status"].Enum)
+	s.Equal("onLine", channels["status"].Conv("2"))
+	s.Equal("7", channels["status"].Conv("7"))
+	s.Equal("1", channels["status"].InvConv("unknown"))
+
+	// label used for several values is written as the lowest one
+	inv := InverseEnum(map[int64]string{3: "ok", 1: "ok", 2: "ok", 4: "fail"})
+	s.Equal("1", inv("ok"))
+	s.Equal("4", inv("fail"))
+	s.Equal("bad", inv("bad"))
+
+	// numeric channel publishes numbers
+	s.Equal(enum, channels["code"].Enum)
+	s.Equal("2", channels["code"].Conv("2"))
+
+	wrongEntries := []string{
+		`"enum": ["unknown"]`,
+		`"enum": {"one": "unknown"}`,
+		`"enum": {"1": 1}`,
+		`"enum": {"1": "ok", "2": "ok"}`,
+	}
+
+	for _, entry := range wrongEntries {
+		testConfig := `{
+			"devices": [{
+				"address": "127.0.0.1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3", ` + entry + `}]
+			}]
+		}`
+
+		_, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+		s.Error(err, "config parser doesn't fail on %s", entry)
+	}
+}
+
+// Test counter rate channels
+func (s *ConfigParserSuite) TestRate() {
+	testConfig := `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"channels": [
+				{"name": "in", "oid": ".1.2.3.4", "rate": "second", "scale": 8},
+				{"name": "errors", "oid": ".1.2.3.5", "rate": "minute"},
+				{"name": "raw", "oid": ".1.2.3.6"}
+			]
+		}]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	channels := res.Devices["snmp_127.0.0.1"].Channels
+	s.Equal(time.Second, channels["in"].Rate)
+	s.Equal(time.Minute, channels["errors"].Rate)
+	s.Equal(time.Duration(0), channels["raw"].Rate)
+
+	wrongEntries := []string{
+		`"rate": "hour"`,
+		`"rate": "second", "control_type": "text"`,
+		`"rate": "second", "writable": true, "set_type": "Counter32"`,
+	}
+
+	for _, entry := range wrongEntries {
+		testConfig := `{
+			"devices": [{
+				"address": "127.0.0.1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3", ` + entry + `}]
+			}]
+		}`
+
+		_, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+		s.Error(err, "config parser doesn't fail on %s", entry)
+	}
+}
+
+// Test expressions of channels
+func (s *ConfigParserSuite) TestExpr() {
+	testConfig := `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"channels": [
+				{"name": "temperature", "oid": ".1.2.3.4", "expr": "round(x * 0.1 - 40, 1)"},
+				{"name": "status", "oid": ".1.2.3.5", "control_type": "text", "expr": "bits(x, 2, 2)", "enum": {"1": "ok", "2": "fail"}}
+			]
+		}]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	channels := res.Devices["snmp_127.0.0.1"].Channels
+	for _, c := range []struct{ name, value, expected string }{
+		{"temperature", "654", "25.4"},
+		{"status", "11", "fail"},
+	} {
+		res, err := channels[c.name].Convert(c.value)
+		if s.NoError(err, "convert %s", c.name) {
+			s.Equal(c.expected, res)
+		}
+	}
+	_, err = channels["temperature"].Convert("foo")
+	s.Error(err)
+
+	wrongEntries := []string{
+		`"expr": "x *"`,
+		`"expr": "x", "scale": 0.1`,
+		`"expr": "x", "writable": true, "set_type": "Integer"`,
+	}
+
+	for _, entry := range wrongEntries {
+		testConfig := `{
+			"devices": [{
+				"address": "127.0.0.1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3", ` + entry + `}]
+			}]
+		}`
+
+		_, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+		if s.Error(err, "config parser doesn't fail on %s", entry) {
+			s.Contains(err.Error(), "channel1")
+		}
+	}
+}
+
+// Test computed channels
+func (s *ConfigParserSuite) TestComputed() {
+	testConfig := `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"channels": [
+				{"name": "Power L1", "oid": ".1.2.3.1"},
+				{"name": "Power L2", "oid": ".1.2.3.2"},
+				{"name": "Total power", "computed": "p1 + p2", "inputs": {"p1": "Power L1", "p2": "Power L2"}}
+			]
+		}]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	total := res.Devices["snmp_127.0.0.1"].Channels["Total power"]
+	s.Equal("", total.Oid)
+	s.Equal(map[string]string{"p1": "Power L1", "p2": "Power L2"}, total.Inputs)
+	if s.NotNil(total.Computed) {
+		s.Equal([]string{"p1", "p2"}, total.Computed.Vars)
+	}
+
+	wrongEntries := []string{
+		`"computed": "a +", "inputs": {"a": "input"}`,
+		`"computed": "a + b", "inputs": {"a": "input"}`,
+		`"computed": "a"`,
+		`"computed": "a", "inputs": {"a": "unknown"}`,
+		`"computed": "a", "inputs": {"a": 1}`,
+		`"computed": "abs", "inputs": {"abs": "input"}`,
+		`"computed": "a", "inputs": {"a": "input"}, "oid": ".1.2.3.5"`,
+		`"computed": "a", "inputs": {"a": "input"}, "rate": "second"`,
+		`"oid": ".1.2.3.5", "inputs": {"a": "input"}`,
+	}
+
+	for _, entry := range wrongEntries {
+		testConfig := `{
+			"devices": [{
+				"address": "127.0.0.1",
+				"channels": [
+					{"name": "input", "oid": ".1.2.3.4"},
+					{"name": "channel1", ` + entry + `}
+				]
+			}]
+		}`
+
+		_, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+		if s.Error(err, "config parser doesn't fail on %s", entry) {
+			s.Contains(err.Error(), "channel1")
+		}
+	}
+}
+
+// Test alarm thresholds
+func (s *ConfigParserSuite) TestAlarm() {
+	testConfig := `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"channels": [
+				{"name": "Battery temperature", "oid": ".1.2.3.1", "alarm": {"high": 45, "low": 5, "hysteresis": 1, "delay": 10000}},
+				{"name": "Load", "oid": ".1.2.3.2", "alarm": {"high": 90, "name": "Overload"}}
+			]
+		}]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	channels := res.Devices["snmp_127.0.0.1"].Channels
+	alarm := channels["Battery temperature"].Alarm
+	if s.NotNil(alarm) {
+		s.Equal("Battery temperature alarm", alarm.Name)
+		s.Equal(45.0, *alarm.High)
+		s.Equal(5.0, *alarm.Low)
+		s.Equal(1.0, alarm.Hysteresis)
+		s.Equal(10*time.Second, alarm.Delay)
+	}
+	alarm = channels["Load"].Alarm
+	if s.NotNil(alarm) {
+		s.Equal("Overload", alarm.Name)
+		s.Nil(alarm.Low)
+	}
+
+	wrongEntries := []string{
+		`"alarm": 45`,
+		`"alarm": {}`,
+		`"alarm": {"high": "45"}`,
+		`"alarm": {"high": 5, "low": 45}`,
+		`"alarm": {"high": 45, "delay": -1}`,
+		`"alarm": {"high": 45}, "control_type": "text"`,
+		`"alarm": {"high": 45}, "table": true`,
+		`"alarm": {"high": 45, "name": "input"}`,
+	}
+
+	for _, entry := range wrongEntries {
+		testConfig := `{
+			"devices": [{
+				"address": "127.0.0.1",
+				"channels": [
+					{"name": "input", "oid": ".1.2.3.4"},
+					{"name": "channel1", "oid": ".1.2.3.5", ` + entry + `}
+				]
+			}]
+		}`
+
+		_, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+		if s.Error(err, "config parser doesn't fail on %s", entry) {
+			s.Contains(err.Error(), "channel1")
+		}
+	}
+}
+
+// Test deadband and publish intervals
+func (s *ConfigParserSuite) TestThrottle() {
+	testConfig := `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"channels": [
+				{"name": "Voltage", "oid": ".1.2.3.1", "deadband": 0.5, "min_publish_interval": 1000, "max_publish_interval": 60000},
+				{"name": "Load", "oid": ".1.2.3.2", "deadband": "5%"}
+			]
+		}]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	channels := res.Devices["snmp_127.0.0.1"].Channels
+	s.Equal(0.5, channels["Voltage"].Deadband)
+	s.False(channels["Voltage"].DeadbandPercent)
+	s.Equal(time.Second, channels["Voltage"].MinPublishInterval)
+	s.Equal(time.Minute, channels["Voltage"].MaxPublishInterval)
+	s.Equal(5.0, channels["Load"].Deadband)
+	s.True(channels["Load"].DeadbandPercent)
+	s.Equal(time.Duration(0), channels["Load"].MaxPublishInterval)
+
+	wrongEntries := []string{
+		`"deadband": "5"`,
+		`"deadband": -1`,
+		`"deadband": 1, "control_type": "text"`,
+		`"min_publish_interval": -1`,
+		`"min_publish_interval": 2000, "max_publish_interval": 1000`,
+	}
+
+	for _, entry := range wrongEntries {
+		testConfig := `{
+			"devices": [{
+				"address": "127.0.0.1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3", ` + entry + `}]
+			}]
+		}`
+
+		_, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+		if s.Error(err, "config parser doesn't fail on %s", entry) {
+			s.Contains(err.Error(), "channel1")
+		}
+	}
+}
+
+// Test control types of templates and config
+func (s *ConfigParserSuite) TestControlType() {
+	tpl := `{
+		"device_type": "ups",
+		"channels": [
+			{"name": "Input voltage", "oid": ".1.2.3.1", "type": "voltage"},
+			{"name": "Output voltage", "oid": ".1.2.3.2", "type": "voltage"},
+			{"name": "Status", "oid": ".1.2.3.3", "type": "text"}
+		]
+	}`
+	s.Ck("can't write template", os.WriteFile("config-ups.json", []byte(tpl), os.ModePerm))
+
+	testConfig := `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"device_type": "ups",
+			"channels": [
+				{"name": "Output voltage", "control_type": "value"},
+				{"name": "Humidity", "oid": ".1.2.3.4", "control_type": "rel_humidity"}
+			]
+		}]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	channels := res.Devices["snmp_127.0.0.1"].Channels
+	s.Equal("voltage", channels["Input voltage"].ControlType)
+	s.Equal("value", channels["Output voltage"].ControlType)
+	s.Equal("text", channels["Status"].ControlType)
+	s.Equal("rel_humidity", channels["Humidity"].ControlType)
+
+	wrongEntries := []string{
+		`"control_type": "voltge"`,
+		`"type": "voltage", "control_type": "value"`,
+		`"type": 1`,
+	}
+
+	for _, entry := range wrongEntries {
+		testConfig := `{
+			"devices": [{
+				"address": "127.0.0.1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3", ` + entry + `}]
+			}]
+		}`
+
+		_, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+		s.Error(err, "config parser doesn't fail on %s", entry)
+	}
+}
+
+// Test warnings about unknown keys
+func (s *ConfigParserSuite) TestUnknownKeys() {
+	testConfig := `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"comunity": "public",
+			"channels": [{"name": "channel1", "oid": ".1.2.3", "scael": 0.1}]
+		}]
+	}`
+
+	_, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+	s.EnsureGotWarnings()
+}
+
+// Test control meta
+func (s *ConfigParserSuite) TestMeta() {
+	testConfig := `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"channels": [
+				{"name": "Load", "oid": ".1.2.3.1", "min": 0, "max": 100, "precision": 0.1, "title": {"en": "Load", "ru": "Нагрузка"}},
+				{"name": "Setpoint", "oid": ".1.2.3.2", "writable": true, "set_type": "Integer", "title": "Setpoint"},
+				{"name": "Name", "oid": ".1.2.3.3", "control_type": "text", "readonly": false}
+			]
+		}]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	channels := res.Devices["snmp_127.0.0.1"].Channels
+	load := channels["Load"]
+	s.True(load.Readonly)
+	s.Equal(0.0, *load.Min)
+	s.Equal(100.0, *load.Max)
+	s.Equal(0.1, load.Precision)
+	s.Equal(map[string]string{"en": "Load", "ru": "Нагрузка"}, load.Title)
+	s.False(channels["Setpoint"].Readonly)
+	s.Equal(map[string]string{"en": "Setpoint"}, channels["Setpoint"].Title)
+	s.False(channels["Name"].Readonly)
+
+	wrongEntries := []string{
+		`"readonly": true, "writable": true, "set_type": "Integer"`,
+		`"min": 10, "max": 0`,
+		`"max": "100"`,
+		`"precision": -1`,
+		`"precision": 0.1, "control_type": "text"`,
+		`"title": 1`,
+		`"title": {"ru": 1}`,
+	}
+
+	for _, entry := range wrongEntries {
+		testConfig := `{
+			"devices": [{
+				"address": "127.0.0.1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3", ` + entry + `}]
+			}]
+		}`
+
+		_, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+		if s.Error(err, "config parser doesn't fail on %s", entry) {
+			s.Contains(err.Error(), "channel1")
+		}
+	}
+}
+
+// Test session recording setting
+func (s *ConfigParserSuite) TestRecordDir() {
+	res, err := NewDaemonConfig(strings.NewReader(`{"devices": [{"address": "127.0.0.1", "channels": [{"name": "channel1", "oid": ".1.2.3"}]}]}`), ".")
+	s.Ck("failed to parse config", err)
+	s.Equal("", res.RecordDir)
+
+	res, err = NewDaemonConfig(strings.NewReader(`{
+		"record_dir": "/var/log/wb-mqtt-snmp",
+		"devices": [{"address": "127.0.0.1", "channels": [{"name": "channel1", "oid": ".1.2.3"}]}]
+	}`), ".")
+	s.Ck("failed to parse config", err)
+	s.Equal("/var/log/wb-mqtt-snmp", res.RecordDir)
+}
+
+// Test trap receiver settings
+func (s *ConfigParserSuite) TestTraps() {
+	testConfig := `{
+		"trap_listen": ":1162",
+		"devices": [
+			{
+				"address": "127.0.0.1",
+				"last_trap_control": true,
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			},
+			{
+				"address": "127.0.0.2",
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			}
+		]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	s.Equal(":1162", res.TrapListen)
+	s.True(res.Devices["snmp_127.0.0.1"].LastTrapControl)
+	s.False(res.Devices["snmp_127.0.0.2"].LastTrapControl)
+
+	// last trap control name is reserved
+	testConfig = `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"last_trap_control": true,
+			"channels": [{"name": "last_trap", "oid": ".1.2.3"}]
+		}]
+	}`
+
+	_, err = NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Error(err, "config parser doesn't fail on last trap control name collision")
+}
+
+// Test device availability settings
+func (s *ConfigParserSuite) TestAvailability() {
+	testConfig := `{
+		"devices": [
+			{
+				"address": "127.0.0.1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			},
+			{
+				"address": "127.0.0.2",
+				"offline_threshold": 0,
+				"availability_controls": true,
+				"probe_oid": "SNMPv2-MIB::sysName.0",
+				"max_probe_interval": 60000,
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			}
+		]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	s.Equal(DefaultOfflineThreshold, res.Devices["snmp_127.0.0.1"].OfflineThreshold)
+	s.False(res.Devices["snmp_127.0.0.1"].AvailabilityControls)
+	s.Equal(0, res.Devices["snmp_127.0.0.2"].OfflineThreshold)
+	s.True(res.Devices["snmp_127.0.0.2"].AvailabilityControls)
+	s.Equal(DefaultProbeOid, res.Devices["snmp_127.0.0.1"].ProbeOid)
+	s.Equal(DefaultMaxProbeInterval, res.Devices["snmp_127.0.0.1"].MaxProbeInterval)
+	s.Equal("SNMPv2-MIB::sysName.0", res.Devices["snmp_127.0.0.2"].ProbeOid)
+	s.Equal(60000, res.Devices["snmp_127.0.0.2"].MaxProbeInterval)
+
+	for _, entry := range []string{
+		`"offline_threshold": -1, "channels": [{"name": "channel1", "oid": ".1.2.3"}]`,
+		`"max_probe_interval": 0, "channels": [{"name": "channel1", "oid": ".1.2.3"}]`,
+		`"availability_controls": true, "channels": [{"name": "online", "oid": ".1.2.3"}]`,
+		`"availability_controls": true, "channels": [{"name": "last_seen", "oid": ".1.2.3"}]`,
+	} {
+		testConfig = `{"devices": [{"address": "127.0.0.1", ` + entry + `}]}`
+
+		_, err = NewDaemonConfig(strings.NewReader(testConfig), ".")
+		s.Error(err, "config parser doesn't fail on %s", entry)
+	}
+}
+
+// Test max number of variables in GET request
+func (s *ConfigParserSuite) TestMaxVarbinds() {
+	testConfig := `{
+		"devices": [
+			{
+				"address": "127.0.0.1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			},
+			{
+				"address": "127.0.0.2",
+				"max_varbinds": 1,
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			}
+		]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	s.Equal(DefaultMaxVarbinds, res.Devices["snmp_127.0.0.1"].MaxVarbinds)
+	s.Equal(1, res.Devices["snmp_127.0.0.2"].MaxVarbinds)
+
+	for _, entry := range []string{"0", "-5", "61", `"10"`} {
+		testConfig = `{
+			"devices": [{
+				"address": "127.0.0.1",
+				"max_varbinds": ` + entry + `,
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			}]
+		}`
+
+		_, err = NewDaemonConfig(strings.NewReader(testConfig), ".")
+		s.Error(err, "config parser doesn't fail on max_varbinds %s", entry)
+	}
+}
+
+// Test table channels
+func (s *ConfigParserSuite) TestTableChannels() {
+	testConfig := `{
+		"devices": [{
+			"address": "127.0.0.1",
+			"oid_prefix": "IF-MIB",
+			"channels": [
+				{
+					"name": "status",
+					"oid": "ifOperStatus",
+					"table": true,
+					"row_name": "Port {label} status",
+					"label_oid": "ifName"
+				},
+				{
+					"name": "traffic",
+					"oid": "ifInOctets",
+					"table": true
+				},
+				{
+					"name": "uptime",
+					"oid": ".1.3.6.1.2.1.1.3.0"
+				}
+			]
+		}]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	s.Ck("failed to parse config", err)
+
+	channels := res.Devices["snmp_127.0.0.1"].Channels
+
+	s.True(channels["status"].Table)
+	s.Equal("IF-MIB::ifOperStatus", channels["status"].Oid)
+	s.Equal("Port {label} status", channels["status"].RowName)
+	s.Equal("IF-MIB::ifName", channels["status"].LabelOid)
+
+	s.True(channels["traffic"].Table)
+	s.Equal("traffic {index}", channels["traffic"].RowName)
+	s.Equal("", channels["traffic"].LabelOid)
+
+	s.False(channels["uptime"].Table)
+}
+
+// Fail on wrong table channels settings
+func (s *ConfigParserSuite) TestTableErrors() {
+	wrongEntries := []string{
+		// no placeholders in row name
+		`"table": true, "row_name": "Port"`,
+		// label without label column
+		`"table": true, "row_name": "Port {label}"`,
+		// wrong label column type
+		`"table": true, "label_oid": 1`,
+	}
+
+	for _, entry := range wrongEntries {
+		testConfig := `{
+			"devices": [{
+				"address": "127.0.0.1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3", ` + entry + `}]
+			}]
+		}`
+
+		_, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+		s.Error(err, "config parser doesn't fail on %s", entry)
+	}
+}
+
 //
 // Test skipped parameters
 
@@ -481,6 +1322,64 @@ func (s *ConfigParserSuite) TestAddressCollision() {
 
 	_, err = NewDaemonConfig(strings.NewReader(testConfig_2), ".")
 	s.NoError(err, "config parser fail on no device address collision")
+
+	// explicit IDs collide too
+	testConfig_3 := `{
+		"devices": [
+		{
+			"address": "127.0.0.1",
+			"id": "ups",
+			"device_type": "type2"
+		},
+		{
+			"address": "127.0.0.2",
+			"id": "ups",
+			"device_type": "type2"
+		}
+		]
+	}`
+
+	config, err := NewDaemonConfig(strings.NewReader(testConfig_3), ".")
+	s.Error(err, "config parser doesn't fail on device id collision")
+	s.Equal("127.0.0.1", config.Devices["ups"].Address)
+}
+
+// Test invalid devices are skipped with all errors collected
+func (s *ConfigParserSuite) TestInvalidDevices() {
+	testConfig := `{
+		"devices": [
+			{
+				"address": "127.0.0.1",
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			},
+			{
+				"address": "127.0.0.2",
+				"max_varbinds": 0,
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			},
+			{
+				"address": "127.0.0.3",
+				"channels": [{"name": "channel1", "oid": ".1.2.3"}]
+			},
+			{
+				"address": "127.0.0.4"
+			}
+		]
+	}`
+
+	res, err := NewDaemonConfig(strings.NewReader(testConfig), ".")
+	if s.Error(err, "config parser doesn't fail on invalid devices") {
+		errs, ok := err.(DeviceConfigErrors)
+		if s.True(ok, "error is not DeviceConfigErrors: %T", err) && s.Len(errs, 2) {
+			s.Contains(errs[0].Error(), "device #2: max_varbinds in snmp_127.0.0.2")
+			s.Contains(errs[1].Error(), "device #4: channel parse error in snmp_127.0.0.4")
+		}
+	}
+
+	// valid devices are kept
+	s.Len(res.Devices, 2)
+	s.Contains(res.Devices, "snmp_127.0.0.1")
+	s.Contains(res.Devices, "snmp_127.0.0.3")
 }
 
 // Test channel names collision
@@ -0,0 +1,102 @@
+package mqtt_snmp
+
+// Config watcher module
+// Watches config file and templates directory and requests config
+// reload on their changes; editors often replace files instead of
+// writing them, so parent directory of config file is watched
+
+import (
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/contactless/wbgo"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Delay between last change of watched files and reload,
+// so series of changes cause single reload
+var ConfigReloadDelay = time.Second
+
+// Watcher of config file and templates directory
+type ConfigWatcher struct {
+	watcher      *fsnotify.Watcher
+	configFile   string
+	templatesDir string
+	reload       func()
+	quit, done   chan struct{}
+}
+
+// Start watching config file and templates directory,
+// reload function is called from watcher goroutine
+func NewConfigWatcher(configFile, templatesDir string, reload func()) (*ConfigWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &ConfigWatcher{
+		watcher:      watcher,
+		configFile:   filepath.Clean(configFile),
+		templatesDir: filepath.Clean(templatesDir),
+		reload:       reload,
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+
+	for _, dir := range []string{filepath.Dir(w.configFile), w.templatesDir} {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// Check if file is config file or template
+func (w *ConfigWatcher) isWatched(name string) bool {
+	name = filepath.Clean(name)
+	if name == w.configFile {
+		return true
+	}
+	if filepath.Dir(name) != w.templatesDir {
+		return false
+	}
+	m, _ := regexp.MatchString(TemplatesFileMask, filepath.Base(name))
+	return m
+}
+
+func (w *ConfigWatcher) run() {
+	defer close(w.done)
+
+	timer := time.NewTimer(ConfigReloadDelay)
+	timer.Stop()
+
+	for {
+		select {
+		case ev := <-w.watcher.Events:
+			if ev.Op == fsnotify.Chmod || !w.isWatched(ev.Name) {
+				continue
+			}
+			wbgo.Debug.Printf("config change: %s", ev)
+			timer.Reset(ConfigReloadDelay)
+		case err := <-w.watcher.Errors:
+			wbgo.Error.Printf("config watcher error: %s", err)
+		case <-timer.C:
+			w.reload()
+		case <-w.quit:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Stop watching
+func (w *ConfigWatcher) Stop() {
+	close(w.quit)
+	<-w.done
+	w.watcher.Close()
+}
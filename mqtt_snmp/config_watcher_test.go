@@ -0,0 +1,95 @@
+package mqtt_snmp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+)
+
+type ConfigWatcherSuite struct {
+	testutils.Suite
+
+	dir, templatesDir string
+	oldDelay          time.Duration
+	reloads           chan struct{}
+	watcher           *ConfigWatcher
+}
+
+func (s *ConfigWatcherSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	var err error
+	s.dir, err = os.MkdirTemp("", "wb-mqtt-snmp-watcher")
+	s.Ck("can't create temp dir", err)
+	s.templatesDir = filepath.Join(s.dir, "templates")
+	s.Ck("can't create templates dir", os.Mkdir(s.templatesDir, 0755))
+
+	s.oldDelay = ConfigReloadDelay
+	ConfigReloadDelay = 50 * time.Millisecond
+
+	s.reloads = make(chan struct{}, 16)
+	s.watcher, err = NewConfigWatcher(filepath.Join(s.dir, "wb-mqtt-snmp.conf"), s.templatesDir, func() {
+		s.reloads <- struct{}{}
+	})
+	s.Ck("can't create watcher", err)
+}
+
+func (s *ConfigWatcherSuite) TearDownTest() {
+	s.watcher.Stop()
+	ConfigReloadDelay = s.oldDelay
+	os.RemoveAll(s.dir)
+	s.Suite.TearDownTest()
+}
+
+func (s *ConfigWatcherSuite) write(name string) {
+	s.Ck("can't write file", os.WriteFile(name, []byte("{}"), 0644))
+}
+
+func (s *ConfigWatcherSuite) expectReload(reload bool) {
+	select {
+	case <-s.reloads:
+		s.True(reload, "unexpected reload")
+	case <-time.After(300 * time.Millisecond):
+		s.False(reload, "reload timeout")
+	}
+}
+
+func (s *ConfigWatcherSuite) TestConfigFile() {
+	s.write(filepath.Join(s.dir, "wb-mqtt-snmp.conf"))
+	s.expectReload(true)
+
+	// config is replaced by editor
+	s.write(filepath.Join(s.dir, "wb-mqtt-snmp.conf.new"))
+	s.expectReload(false)
+	s.Ck("can't rename file", os.Rename(filepath.Join(s.dir, "wb-mqtt-snmp.conf.new"), filepath.Join(s.dir, "wb-mqtt-snmp.conf")))
+	s.expectReload(true)
+
+	s.write(filepath.Join(s.dir, "other.conf"))
+	s.expectReload(false)
+}
+
+func (s *ConfigWatcherSuite) TestTemplates() {
+	s.write(filepath.Join(s.templatesDir, "config-ups.json"))
+	s.expectReload(true)
+
+	s.Ck("can't remove file", os.Remove(filepath.Join(s.templatesDir, "config-ups.json")))
+	s.expectReload(true)
+
+	s.write(filepath.Join(s.templatesDir, "readme.txt"))
+	s.expectReload(false)
+}
+
+func (s *ConfigWatcherSuite) TestSeriesOfChanges() {
+	for i := 0; i < 5; i++ {
+		s.write(filepath.Join(s.dir, "wb-mqtt-snmp.conf"))
+	}
+	s.expectReload(true)
+	s.expectReload(false)
+}
+
+func TestConfigWatcher(t *testing.T) {
+	testutils.RunSuites(t, new(ConfigWatcherSuite))
+}
@@ -0,0 +1,134 @@
+package mqtt_snmp
+
+// Counter rate module
+// Rate channels publish change of counter per second (or minute)
+// between successive polls instead of raw counter value
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/contactless/wbgo"
+	"github.com/gosnmp/gosnmp"
+)
+
+// Rate units of channels
+var rateUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+}
+
+// Raw counter value with time of response
+// Agent uptime is requested with counters to detect its restarts,
+// it's valid if HasUptime is set
+type CounterSample struct {
+	Value     uint64
+	Type      gosnmp.Asn1BER
+	Time      time.Time
+	Uptime    uint32
+	HasUptime bool
+}
+
+// Create counter sample from SNMP variable
+func newCounterSample(v gosnmp.SnmpPDU, t time.Time) (sample CounterSample, err error) {
+	switch v.Type {
+	case gosnmp.Counter32, gosnmp.Counter64, gosnmp.Gauge32, gosnmp.Uinteger32, gosnmp.Integer:
+		if v.Value == nil {
+			break
+		}
+		n := gosnmp.ToBigInt(v.Value)
+		if n.Sign() < 0 {
+			return sample, fmt.Errorf("negative counter value %s", n)
+		}
+		return CounterSample{Value: n.Uint64(), Type: v.Type, Time: t}, nil
+	}
+
+	return sample, fmt.Errorf("instance of type %s is not a counter", v.Type)
+}
+
+// Set agent uptime of sample from sysUpTime variable
+func (s *CounterSample) setUptime(v gosnmp.SnmpPDU) {
+	if ticks, ok := v.Value.(uint32); ok && v.Type == gosnmp.TimeTicks {
+		s.Uptime = ticks
+		s.HasUptime = true
+	}
+}
+
+// Get agent uptime by separate request, it's used if uptime can't be
+// requested with counters, empty variable is returned on failure
+func (d *SnmpDevice) getUptime() gosnmp.SnmpPDU {
+	packet, err := d.Get([]string{sysUpTimeOid})
+	if err != nil || packet.Error != gosnmp.NoError || len(packet.Variables) == 0 {
+		wbgo.Debug.Printf("can't get uptime of %s", d.DevName)
+		return gosnmp.SnmpPDU{}
+	}
+	return packet.Variables[0]
+}
+
+// Allowed difference between uptime and local time passed between samples,
+// it covers request latency when uptime wraparound is detected
+const uptimeWrapTolerance = 10 * time.Second
+
+// Check if agent uptime has wrapped around 2^32 ticks (about 497 days)
+// between samples: uptime increase modulo 2^32 must match time passed,
+// otherwise agent is restarted
+func uptimeWrapped(prev, cur CounterSample) bool {
+	ticks := time.Duration(cur.Uptime-prev.Uptime) * 10 * time.Millisecond
+	return ticks <= cur.Time.Sub(prev.Time)+uptimeWrapTolerance
+}
+
+// Get rate of counter between samples per given unit
+// Counter wraparound is taken into account, rate is unknown
+// if agent is restarted or non-counter value is decreased
+// 64-bit counter can't wrap between polls in practice, so its
+// decrease is treated as wraparound only if agent uptime is known
+func counterRate(prev, cur CounterSample, per time.Duration) (float64, bool) {
+	if prev.HasUptime && cur.HasUptime && cur.Uptime < prev.Uptime && !uptimeWrapped(prev, cur) {
+		return 0, false
+	}
+
+	elapsed := cur.Time.Sub(prev.Time)
+	if elapsed <= 0 || prev.Type != cur.Type {
+		return 0, false
+	}
+
+	var delta uint64
+	switch {
+	case cur.Value >= prev.Value:
+		delta = cur.Value - prev.Value
+	case cur.Type == gosnmp.Counter32:
+		delta = uint64(uint32(cur.Value) - uint32(prev.Value))
+	case cur.Type == gosnmp.Counter64 && prev.HasUptime && cur.HasUptime:
+		delta = cur.Value - prev.Value
+	default:
+		return 0, false
+	}
+
+	return float64(delta) * float64(per) / float64(elapsed), true
+}
+
+// Publish rate of channel counter
+// First sample and samples after agent restart are only stored
+func (m *SnmpModel) publishRate(dev *SnmpDevice, channel *ChannelConfig, sample CounterSample) {
+	prev, ok := dev.Samples[channel]
+	dev.Samples[channel] = sample
+	if !ok {
+		wbgo.Debug.Printf("[publisher] First sample of %s:%s", dev.DevName, channel.Name)
+		return
+	}
+
+	rate, ok := counterRate(prev, sample, channel.Rate)
+	if !ok {
+		wbgo.Info.Printf("counter of %s:%s is reset, rate is skipped", dev.DevName, channel.Name)
+		return
+	}
+
+	data, err := channel.Convert(strconv.FormatFloat(rate, 'f', 2, 64))
+	if err != nil {
+		wbgo.Warn.Printf("rate of %s:%s: %s", dev.DevName, channel.Name, err)
+		m.publishError(dev, channel, "r")
+		return
+	}
+	m.publishData(dev, channel, data)
+}
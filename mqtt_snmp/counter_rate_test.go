@@ -0,0 +1,74 @@
+package mqtt_snmp
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+type CounterRateSuite struct {
+	testutils.Suite
+}
+
+func (s *CounterRateSuite) TestRate() {
+	t := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(typ gosnmp.Asn1BER, value uint64, seconds int, uptime uint32) CounterSample {
+		return CounterSample{Value: value, Type: typ, Time: t.Add(time.Duration(seconds) * time.Second), Uptime: uptime, HasUptime: uptime != 0}
+	}
+
+	cases := []struct {
+		prev, cur CounterSample
+		per       time.Duration
+		rate      float64
+		ok        bool
+	}{
+		// plain counter
+		{sample(gosnmp.Counter32, 100, 0, 0), sample(gosnmp.Counter32, 600, 10, 0), time.Second, 50, true},
+		{sample(gosnmp.Counter32, 100, 0, 0), sample(gosnmp.Counter32, 600, 10, 0), time.Minute, 3000, true},
+		// wraparound
+		{sample(gosnmp.Counter32, math.MaxUint32-99, 0, 0), sample(gosnmp.Counter32, 100, 2, 0), time.Second, 100, true},
+		{sample(gosnmp.Counter64, math.MaxUint64-99, 0, 5000), sample(gosnmp.Counter64, 100, 2, 5200), time.Second, 100, true},
+		// 64-bit counter is decreased without uptime, it's reset rather than wrapped
+		{sample(gosnmp.Counter64, math.MaxUint64-99, 0, 0), sample(gosnmp.Counter64, 100, 2, 0), time.Second, 0, false},
+		// agent restart
+		{sample(gosnmp.Counter32, math.MaxUint32-99, 0, 5000), sample(gosnmp.Counter32, 100, 2, 100), time.Second, 0, false},
+		{sample(gosnmp.Counter32, 100, 0, 5000), sample(gosnmp.Counter32, 600, 10, 6000), time.Second, 50, true},
+		// uptime wraps around 2^32 ticks, agent is not restarted
+		{sample(gosnmp.Counter32, 100, 0, math.MaxUint32-499), sample(gosnmp.Counter32, 600, 10, 500), time.Second, 50, true},
+		{sample(gosnmp.Counter32, 100, 0, math.MaxUint32-99), sample(gosnmp.Counter32, 600, 10, 100000), time.Second, 0, false},
+		// non-counter is decreased
+		{sample(gosnmp.Gauge32, 600, 0, 0), sample(gosnmp.Gauge32, 100, 10, 0), time.Second, 0, false},
+		// no time between samples
+		{sample(gosnmp.Counter32, 100, 0, 0), sample(gosnmp.Counter32, 600, 0, 0), time.Second, 0, false},
+	}
+
+	for i, c := range cases {
+		rate, ok := counterRate(c.prev, c.cur, c.per)
+		s.Equal(c.ok, ok, "case %d", i)
+		s.InDelta(c.rate, rate, 1e-9, "case %d", i)
+	}
+}
+
+func (s *CounterRateSuite) TestSample() {
+	t := time.Now()
+
+	sample, err := newCounterSample(gosnmp.SnmpPDU{Type: gosnmp.Counter64, Value: uint64(math.MaxUint64)}, t)
+	s.Ck("counter64", err)
+	s.Equal(CounterSample{Value: math.MaxUint64, Type: gosnmp.Counter64, Time: t}, sample)
+
+	sample.setUptime(gosnmp.SnmpPDU{Type: gosnmp.TimeTicks, Value: uint32(1234)})
+	s.True(sample.HasUptime)
+	s.Equal(uint32(1234), sample.Uptime)
+
+	_, err = newCounterSample(gosnmp.SnmpPDU{Type: gosnmp.Integer, Value: -1}, t)
+	s.Error(err)
+	_, err = newCounterSample(gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte("1")}, t)
+	s.Error(err)
+}
+
+func TestCounterRate(t *testing.T) {
+	testutils.RunSuites(t, new(CounterRateSuite))
+}
@@ -0,0 +1,326 @@
+package mqtt_snmp
+
+// Value rendering module
+// Converts binary OCTET STRING values (MAC addresses, DateAndTime,
+// serial numbers etc.) to text using channel format or
+// DISPLAY-HINT of textual convention from MIB (RFC 2579)
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// Channel value formats
+const (
+	FormatHex         = "hex"
+	FormatMac         = "mac"
+	FormatDateAndTime = "dateandtime"
+	FormatAscii       = "ascii"
+	FormatBase64      = "base64"
+)
+
+// Format function converts raw octets to text,
+// returns false if value doesn't match format
+type octetFormat func(d []byte) (string, bool)
+
+var octetFormats = map[string]octetFormat{
+	FormatHex:         formatHex,
+	FormatMac:         formatMac,
+	FormatDateAndTime: formatDateAndTime,
+	FormatAscii:       formatAscii,
+	FormatBase64:      formatBase64,
+}
+
+// Check if format name is supported
+func isValidFormat(format string) bool {
+	_, ok := octetFormats[format]
+	return ok
+}
+
+// Octets in hex separated by spaces: "00 1A 2B"
+func formatHex(d []byte) (string, bool) {
+	parts := make([]string, len(d))
+	for i, b := range d {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, " "), true
+}
+
+// Octets in hex separated by colons: "00:1a:2b:3c:4d:5e"
+func formatMac(d []byte) (string, bool) {
+	parts := make([]string, len(d))
+	for i, b := range d {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":"), true
+}
+
+// DateAndTime of SNMPv2-TC: 8 octets of local time
+// optionally followed by 3 octets of UTC offset
+func formatDateAndTime(d []byte) (string, bool) {
+	if len(d) != 8 && len(d) != 11 {
+		return "", false
+	}
+
+	year := int(d[0])<<8 | int(d[1])
+	t := fmt.Sprintf("%04d-%02d-%02dT%02d:%02d:%02d", year, d[2], d[3], d[4], d[5], d[6])
+	if len(d) == 11 {
+		if d[8] != '+' && d[8] != '-' {
+			return "", false
+		}
+		t += fmt.Sprintf("%c%02d:%02d", d[8], d[9], d[10])
+	}
+
+	// check ranges with time parser
+	layout := "2006-01-02T15:04:05"
+	if len(d) == 11 {
+		layout = time.RFC3339
+	}
+	if _, err := time.Parse(layout, t); err != nil {
+		return "", false
+	}
+
+	return t, true
+}
+
+// Printable ASCII characters, others are replaced by dots
+func formatAscii(d []byte) (string, bool) {
+	res := make([]byte, len(d))
+	for i, b := range d {
+		if b >= 0x20 && b < 0x7f {
+			res[i] = b
+		} else {
+			res[i] = '.'
+		}
+	}
+	return string(res), true
+}
+
+// Raw octets encoded in base64
+func formatBase64(d []byte) (string, bool) {
+	return base64.StdEncoding.EncodeToString(d), true
+}
+
+// Single part of octet string DISPLAY-HINT
+type octetHintSpec struct {
+	repeat     bool
+	length     int
+	format     byte
+	separator  byte
+	terminator byte
+}
+
+// Parse octet string DISPLAY-HINT, i.e. "1x:" or "2d-1d-1d,1d:1d:1d.1d,1a1d:1d"
+func parseOctetHint(hint string) ([]octetHintSpec, error) {
+	specs := make([]octetHintSpec, 0)
+
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+
+	for i := 0; i < len(hint); {
+		var spec octetHintSpec
+
+		if hint[i] == '*' {
+			spec.repeat = true
+			i++
+		}
+
+		start := i
+		for i < len(hint) && isDigit(hint[i]) {
+			i++
+		}
+		if start == i {
+			return nil, fmt.Errorf("no octet length in display hint %q", hint)
+		}
+		spec.length, _ = strconv.Atoi(hint[start:i])
+		if spec.length == 0 {
+			// zero length part consumes nothing, so rendering would never end
+			return nil, fmt.Errorf("zero octet length in display hint %q", hint)
+		}
+
+		if i == len(hint) || !strings.ContainsRune("xdoat", rune(hint[i])) {
+			return nil, fmt.Errorf("no display format in display hint %q", hint)
+		}
+		spec.format = hint[i]
+		i++
+
+		if i < len(hint) && !isDigit(hint[i]) && hint[i] != '*' {
+			spec.separator = hint[i]
+			i++
+
+			if spec.repeat && i < len(hint) && !isDigit(hint[i]) && hint[i] != '*' {
+				spec.terminator = hint[i]
+				i++
+			}
+		}
+
+		specs = append(specs, spec)
+	}
+
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("empty display hint")
+	}
+
+	return specs, nil
+}
+
+// Render octets according to DISPLAY-HINT
+// Last part of hint is repeated until data is exhausted
+func applyOctetHint(hint string, d []byte) (string, error) {
+	specs, err := parseOctetHint(hint)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+
+	for i := 0; len(d) > 0; i++ {
+		spec := specs[len(specs)-1]
+		if i < len(specs) {
+			spec = specs[i]
+		}
+
+		count := 1
+		if spec.repeat {
+			count = int(d[0])
+			d = d[1:]
+		}
+
+		for n := 0; n < count && len(d) > 0; n++ {
+			size := spec.length
+			if size > len(d) {
+				size = len(d)
+			}
+			value := d[:size]
+			d = d[size:]
+
+			switch spec.format {
+			case 'a', 't':
+				b.Write(value)
+			case 'x':
+				b.WriteString(fmt.Sprintf("%0*x", 2*size, new(big.Int).SetBytes(value)))
+			case 'd':
+				b.WriteString(new(big.Int).SetBytes(value).Text(10))
+			case 'o':
+				b.WriteString(new(big.Int).SetBytes(value).Text(8))
+			}
+
+			if len(d) == 0 {
+				break
+			}
+			if spec.terminator != 0 && n == count-1 {
+				b.WriteByte(spec.terminator)
+			} else if spec.separator != 0 {
+				b.WriteByte(spec.separator)
+			}
+		}
+	}
+
+	return b.String(), nil
+}
+
+// Render integer according to DISPLAY-HINT: "d", "d-2", "x", "o" or "b"
+func applyIntegerHint(hint string, v *big.Int) (string, error) {
+	if hint == "" {
+		return "", fmt.Errorf("empty display hint")
+	}
+
+	switch hint[0] {
+	case 'x':
+		return v.Text(16), nil
+	case 'o':
+		return v.Text(8), nil
+	case 'b':
+		return v.Text(2), nil
+	case 'd':
+	default:
+		return "", fmt.Errorf("unsupported display hint %q", hint)
+	}
+
+	if len(hint) == 1 {
+		return v.Text(10), nil
+	}
+
+	if hint[1] != '-' {
+		return "", fmt.Errorf("unsupported display hint %q", hint)
+	}
+	point, err := strconv.Atoi(hint[2:])
+	if err != nil || point < 0 {
+		return "", fmt.Errorf("unsupported display hint %q", hint)
+	}
+
+	sign := ""
+	digits := new(big.Int).Abs(v).Text(10)
+	if v.Sign() < 0 {
+		sign = "-"
+	}
+	if point == 0 {
+		return sign + digits, nil
+	}
+	if len(digits) <= point {
+		digits = strings.Repeat("0", point-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-point] + "." + digits[len(digits)-point:], nil
+}
+
+// Check if octets are UTF-8 text without control characters
+func isText(d []byte) bool {
+	if !utf8.Valid(d) {
+		return false
+	}
+	for _, r := range string(d) {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// Convert variable value into string using channel format
+// or DISPLAY-HINT of channel MIB object
+// Binary octet strings without format and hint are shown in hex
+func (c *ChannelConfig) ConvertValue(v gosnmp.SnmpPDU) (data string, valid bool) {
+	hint := ""
+	if c.Object != nil {
+		hint = c.Object.DisplayHint
+	}
+
+	switch v.Type {
+	case gosnmp.OctetString:
+		d, ok := v.Value.([]byte)
+		if !ok {
+			return "", false
+		}
+
+		if c.Format != "" {
+			return octetFormats[c.Format](d)
+		}
+
+		if hint != "" {
+			if data, err := applyOctetHint(hint, d); err == nil && utf8.ValidString(data) {
+				return data, true
+			}
+		}
+
+		if !isText(d) {
+			return formatHex(d)
+		}
+
+	case gosnmp.Integer, gosnmp.Gauge32, gosnmp.Uinteger32:
+		if hint != "" && v.Value != nil {
+			if data, err := applyIntegerHint(hint, gosnmp.ToBigInt(v.Value)); err == nil {
+				return data, true
+			}
+		}
+	}
+
+	return ConvertSnmpValue(v)
+}
@@ -0,0 +1,145 @@
+package mqtt_snmp
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+type DisplayHintSuite struct {
+	testutils.Suite
+}
+
+func (s *DisplayHintSuite) TestFormats() {
+	mac := []byte{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0xff}
+
+	cases := []struct {
+		format string
+		data   []byte
+		result string
+	}{
+		{FormatHex, mac, "00 1A 2B 3C 4D FF"},
+		{FormatMac, mac, "00:1a:2b:3c:4d:ff"},
+		{FormatAscii, []byte("SN\x00\x01-42\xff"), "SN..-42."},
+		{FormatBase64, mac, "ABorPE3/"},
+		{FormatDateAndTime, []byte{0x07, 0xe0, 0x0c, 0x01, 0x0d, 0x1e, 0x0f, 0x00}, "2016-12-01T13:30:15"},
+		{FormatDateAndTime, []byte{0x07, 0xe0, 0x0c, 0x01, 0x0d, 0x1e, 0x0f, 0x00, '+', 0x03, 0x00}, "2016-12-01T13:30:15+03:00"},
+	}
+
+	for _, c := range cases {
+		res, ok := octetFormats[c.format](c.data)
+		s.True(ok, "format %s of %v", c.format, c.data)
+		s.Equal(c.result, res, "format %s of %v", c.format, c.data)
+	}
+
+	// wrong length and month
+	_, ok := formatDateAndTime([]byte{0x07, 0xe0, 0x0c})
+	s.False(ok)
+	_, ok = formatDateAndTime([]byte{0x07, 0xe0, 0x0d, 0x01, 0x0d, 0x1e, 0x0f, 0x00})
+	s.False(ok)
+}
+
+func (s *DisplayHintSuite) TestOctetHint() {
+	cases := []struct {
+		hint   string
+		data   []byte
+		result string
+	}{
+		// MacAddress
+		{"1x:", []byte{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}, "00:1a:2b:3c:4d:5e"},
+		// DisplayString
+		{"255a", []byte("sysName"), "sysName"},
+		// DateAndTime
+		{"2d-1d-1d,1d:1d:1d.1d,1a1d:1d", []byte{0x07, 0xe0, 0x0c, 0x01, 0x0d, 0x1e, 0x0f, 0x00, '+', 0x03, 0x00}, "2016-12-1,13:30:15.0,+3:0"},
+		// InetAddressIPv4
+		{"1d.1d.1d.1d", []byte{192, 168, 1, 10}, "192.168.1.10"},
+		// InetAddressIPv6
+		{"2x:2x:2x:2x:2x:2x:2x:2x", []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01}, "2001:0db8:0000:0000:0000:0000:0000:0001"},
+		// repeat indicator and terminator
+		{"*1d./1d-", []byte{3, 1, 2, 3, 4, 5}, "1.2.3/4-5"},
+	}
+
+	for _, c := range cases {
+		res, err := applyOctetHint(c.hint, c.data)
+		s.Ck("hint "+c.hint, err)
+		s.Equal(c.result, res, "hint %s", c.hint)
+	}
+
+	for _, hint := range []string{"", "x", "1q", "*:", "0a", "0x:", "1x:0d"} {
+		_, err := applyOctetHint(hint, []byte("abc"))
+		s.Error(err, "hint %q is accepted", hint)
+	}
+}
+
+func (s *DisplayHintSuite) TestIntegerHint() {
+	cases := []struct {
+		hint   string
+		value  int64
+		result string
+	}{
+		{"d", 1234, "1234"},
+		{"d-2", 1234, "12.34"},
+		{"d-2", 5, "0.05"},
+		{"d-1", -5, "-0.5"},
+		{"d-0", 7, "7"},
+		{"x", 255, "ff"},
+		{"o", 8, "10"},
+		{"b", 5, "101"},
+	}
+
+	for _, c := range cases {
+		res, err := applyIntegerHint(c.hint, big.NewInt(c.value))
+		s.Ck("hint "+c.hint, err)
+		s.Equal(c.result, res, "hint %s of %d", c.hint, c.value)
+	}
+
+	for _, hint := range []string{"", "a", "d2", "d-x"} {
+		_, err := applyIntegerHint(hint, big.NewInt(1))
+		s.Error(err, "hint %q is accepted", hint)
+	}
+}
+
+func (s *DisplayHintSuite) TestConvertValue() {
+	mac := gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0xfe}}
+
+	// binary value without format and hint
+	data, valid := ConvertSnmpValue(mac)
+	s.False(valid)
+	data, valid = (&ChannelConfig{}).ConvertValue(mac)
+	s.True(valid)
+	s.Equal("00 1A 2B 3C 4D FE", data)
+
+	// hint of MIB object
+	ch := &ChannelConfig{Object: &MibObject{Type: "MacAddress", BaseType: "OCTET STRING", DisplayHint: "1x:"}}
+	data, valid = ch.ConvertValue(mac)
+	s.True(valid)
+	s.Equal("00:1a:2b:3c:4d:fe", data)
+
+	// format takes precedence over hint
+	ch.Format = FormatBase64
+	data, valid = ch.ConvertValue(mac)
+	s.True(valid)
+	s.Equal("ABorPE3+", data)
+
+	// text values are kept
+	data, valid = (&ChannelConfig{}).ConvertValue(gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte("текст")})
+	s.True(valid)
+	s.Equal("текст", data)
+
+	// integer hint
+	ch = &ChannelConfig{Object: &MibObject{BaseType: "Integer32", DisplayHint: "d-1"}}
+	data, valid = ch.ConvertValue(gosnmp.SnmpPDU{Type: gosnmp.Integer, Value: 235})
+	s.True(valid)
+	s.Equal("23.5", data)
+
+	// format doesn't match value
+	ch = &ChannelConfig{Format: FormatDateAndTime}
+	_, valid = ch.ConvertValue(mac)
+	s.False(valid)
+}
+
+func TestDisplayHint(t *testing.T) {
+	testutils.RunSuites(t, new(DisplayHintSuite))
+}
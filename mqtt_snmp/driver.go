@@ -2,6 +2,7 @@ package mqtt_snmp
 
 import (
 	"github.com/contactless/wbgo"
+	"strings"
 	"time"
 )
 
@@ -9,12 +10,80 @@ const (
 	DRIVER_CLIENT_ID = "snmp"
 )
 
-func NewSnmpDriver(config *DaemonConfig, broker string) (*wbgo.Driver, error) {
-	model, err := NewSnmpModel(NewGoSNMP, config, time.Now())
+// Meta topics published for controls
+var controlMetaTopics = []string{"type", "name", "units", "readonly", "writable", "order", "max", "error", "enum"}
+
+// Meta topics published for devices
+var deviceMetaTopics = []string{"name", "error"}
+
+// Publisher of MQTT topics which are not supported by wbgo driver:
+// device error and meta, control meta, and removal of controls and devices
+// with their retained values and meta topics
+type mqttMetaPublisher struct {
+	client wbgo.MQTTClient
+}
+
+func (p *mqttMetaPublisher) OnDeviceError(dev wbgo.DeviceModel, value string) {
+	topic := strings.Join([]string{"/devices", dev.Name(), "meta", "error"}, "/")
+	p.client.Publish(wbgo.MQTTMessage{Topic: topic, Payload: value, QoS: 1, Retained: true})
+}
+
+func (p *mqttMetaPublisher) OnDeviceMeta(dev wbgo.DeviceModel, value string) {
+	topic := strings.Join([]string{"/devices", dev.Name(), "meta"}, "/")
+	p.client.Publish(wbgo.MQTTMessage{Topic: topic, Payload: value, QoS: 1, Retained: true})
+}
+
+func (p *mqttMetaPublisher) OnControlMeta(dev wbgo.DeviceModel, control, meta, value string) {
+	topic := strings.Join([]string{"/devices", dev.Name(), "controls", control, "meta"}, "/")
+	if meta != "" {
+		topic += "/" + meta
+	}
+	p.client.Publish(wbgo.MQTTMessage{Topic: topic, Payload: value, QoS: 1, Retained: true})
+}
+
+func (p *mqttMetaPublisher) RemoveControl(dev wbgo.DeviceModel, name string) {
+	topic := strings.Join([]string{"/devices", dev.Name(), "controls", name}, "/")
+	for _, meta := range controlMetaTopics {
+		p.client.Publish(wbgo.MQTTMessage{Topic: topic + "/meta/" + meta, Payload: "", QoS: 1, Retained: true})
+	}
+	p.client.Publish(wbgo.MQTTMessage{Topic: topic + "/meta", Payload: "", QoS: 1, Retained: true})
+	p.client.Publish(wbgo.MQTTMessage{Topic: topic, Payload: "", QoS: 1, Retained: true})
+}
+
+// Clear retained meta of removed device
+func (p *mqttMetaPublisher) RemoveDevice(dev wbgo.DeviceModel) {
+	topic := strings.Join([]string{"/devices", dev.Name(), "meta"}, "/")
+	for _, meta := range deviceMetaTopics {
+		p.client.Publish(wbgo.MQTTMessage{Topic: topic + "/" + meta, Payload: "", QoS: 1, Retained: true})
+	}
+	p.client.Publish(wbgo.MQTTMessage{Topic: topic, Payload: "", QoS: 1, Retained: true})
+}
+
+// Create driver with SNMP model, model is returned to reload its config
+func NewSnmpDriver(config *DaemonConfig, broker string) (*wbgo.Driver, *SnmpModel, error) {
+	snmpFactory := SnmpFactory(NewGoSNMP)
+	if config.RecordDir != "" {
+		recorder, err := NewSessionRecorder(config.RecordDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		wbgo.Info.Printf("recording device sessions to %s", config.RecordDir)
+		snmpFactory = recorder.Wrap(snmpFactory)
+	}
+
+	model, err := NewSnmpModel(snmpFactory, config, time.Now())
 	if err != nil {
-		wbgo.Error.Fatal(err)
+		return nil, nil, err
 	}
 
-	driver := wbgo.NewDriver(model, wbgo.NewPahoMQTTClient(broker, DRIVER_CLIENT_ID, false))
-	return driver, nil
+	client := wbgo.NewPahoMQTTClient(broker, DRIVER_CLIENT_ID, false)
+	publisher := &mqttMetaPublisher{client}
+	model.Remover = publisher
+	model.DeviceErrors = publisher
+	model.ControlMeta = publisher
+	model.DeviceMeta = publisher
+	model.DeviceRemover = publisher
+
+	driver := wbgo.NewDriver(model, client)
+	return driver, model, nil
 }
@@ -0,0 +1,511 @@
+package mqtt_snmp
+
+// Value expression module
+// Small arithmetic language to transform polled values,
+// expressions are compiled once on config loading
+//
+// Value is referred as x (or by input names of computed channels),
+// supported are numbers (including hex), arithmetic (+ - * / %),
+// comparison (< <= > >= == !=), logical (&& || !), bitwise
+// (& | ^ ~ << >>) and conditional (c ? a : b) operators, and
+// functions listed in exprFunctions
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Values of expression variables
+type exprEnv map[string]float64
+
+// Compiled expression node
+type exprNode func(env exprEnv) (float64, error)
+
+// Compiled value expression
+type Expression struct {
+	Source string
+	Vars   []string
+	eval   exprNode
+}
+
+// Expression function with allowed number of arguments,
+// max is -1 for variadic functions
+type exprFunction struct {
+	min, max int
+	call     func(args []float64) (float64, error)
+}
+
+var exprFunctions = map[string]exprFunction{
+	"abs":   {1, 1, func(a []float64) (float64, error) { return math.Abs(a[0]), nil }},
+	"floor": {1, 1, func(a []float64) (float64, error) { return math.Floor(a[0]), nil }},
+	"ceil":  {1, 1, func(a []float64) (float64, error) { return math.Ceil(a[0]), nil }},
+	"min": {1, -1, func(a []float64) (float64, error) {
+		res := a[0]
+		for _, v := range a[1:] {
+			res = math.Min(res, v)
+		}
+		return res, nil
+	}},
+	"max": {1, -1, func(a []float64) (float64, error) {
+		res := a[0]
+		for _, v := range a[1:] {
+			res = math.Max(res, v)
+		}
+		return res, nil
+	}},
+	// clamp(v, lo, hi)
+	"clamp": {3, 3, func(a []float64) (float64, error) {
+		return math.Max(a[1], math.Min(a[2], a[0])), nil
+	}},
+	// round(v) or round(v, digits)
+	"round": {1, 2, func(a []float64) (float64, error) {
+		if len(a) == 1 {
+			return math.Round(a[0]), nil
+		}
+		p := math.Pow(10, math.Trunc(a[1]))
+		return math.Round(a[0]*p) / p, nil
+	}},
+	// bit(v, n) is n-th bit of v
+	"bit": {2, 2, func(a []float64) (float64, error) {
+		v, n, err := exprInts(a[0], a[1])
+		if err != nil || n < 0 || n > 63 {
+			return 0, fmt.Errorf("wrong bit(%v, %v)", a[0], a[1])
+		}
+		return float64((v >> n) & 1), nil
+	}},
+	// bits(v, from, count) is count bits of v starting from bit from
+	"bits": {3, 3, func(a []float64) (float64, error) {
+		v, from, err := exprInts(a[0], a[1])
+		count := int64(a[2])
+		if err != nil || from < 0 || count < 1 || from+count > 64 {
+			return 0, fmt.Errorf("wrong bits(%v, %v, %v)", a[0], a[1], a[2])
+		}
+		return float64((v >> from) & (1<<count - 1)), nil
+	}},
+}
+
+// Convert operands of bitwise operation to integers
+func exprInts(a, b float64) (int64, int64, error) {
+	if a != math.Trunc(a) || b != math.Trunc(b) {
+		return 0, 0, fmt.Errorf("bitwise operation on fractional value")
+	}
+	return int64(a), int64(b), nil
+}
+
+// Convert boolean to number
+func exprBool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Binary operators by precedence, from lowest to highest
+var exprBinaryOperators = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// Operators which start with other operator
+var exprTwoCharOperators = []string{"||", "&&", "<<", ">>", "==", "!=", "<=", ">="}
+
+// Apply binary operator
+func exprBinary(op string, a, b float64) (float64, error) {
+	switch op {
+	case "||":
+		return exprBool(a != 0 || b != 0), nil
+	case "&&":
+		return exprBool(a != 0 && b != 0), nil
+	case "==":
+		return exprBool(a == b), nil
+	case "!=":
+		return exprBool(a != b), nil
+	case "<":
+		return exprBool(a < b), nil
+	case "<=":
+		return exprBool(a <= b), nil
+	case ">":
+		return exprBool(a > b), nil
+	case ">=":
+		return exprBool(a >= b), nil
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if op == "/" {
+			return a / b, nil
+		}
+		return math.Mod(a, b), nil
+	}
+
+	x, y, err := exprInts(a, b)
+	if err != nil {
+		return 0, err
+	}
+	switch op {
+	case "|":
+		return float64(x | y), nil
+	case "^":
+		return float64(x ^ y), nil
+	case "&":
+		return float64(x & y), nil
+	case "<<", ">>":
+		if y < 0 || y > 63 {
+			return 0, fmt.Errorf("wrong shift %d", y)
+		}
+		if op == "<<" {
+			return float64(x << y), nil
+		}
+		return float64(x >> y), nil
+	}
+
+	return 0, fmt.Errorf("unknown operator %s", op)
+}
+
+// Expression parser, recursive descent over source string
+type exprParser struct {
+	src  string
+	pos  int
+	vars []string
+}
+
+// Parse and compile expression of value x
+func ParseExpression(src string) (*Expression, error) {
+	return ParseExpressionVars(src, []string{"x"})
+}
+
+// Parse and compile expression of given variables
+func ParseExpressionVars(src string, vars []string) (*Expression, error) {
+	p := &exprParser{src: src, vars: vars}
+
+	node, err := p.parseTernary()
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %s", src, err)
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("expression %q: unexpected %q at %d", src, p.src[p.pos:], p.pos)
+	}
+
+	return &Expression{Source: src, Vars: vars, eval: node}, nil
+}
+
+// Evaluate expression for value x
+func (e *Expression) Eval(x float64) (float64, error) {
+	return e.EvalVars(exprEnv{"x": x})
+}
+
+// Evaluate expression for values of variables
+func (e *Expression) EvalVars(env exprEnv) (float64, error) {
+	res, err := e.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(res) || math.IsInf(res, 0) {
+		return 0, fmt.Errorf("result is not a number")
+	}
+	return res, nil
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// Consume token if it's next in source
+func (p *exprParser) accept(token string) bool {
+	p.skipSpaces()
+	if !strings.HasPrefix(p.src[p.pos:], token) {
+		return false
+	}
+
+	// don't take first char of two-char operator
+	rest := p.src[p.pos+len(token):]
+	if len(token) == 1 && rest != "" {
+		for _, op := range exprTwoCharOperators {
+			if op == token+rest[:1] {
+				return false
+			}
+		}
+	}
+
+	p.pos += len(token)
+	return true
+}
+
+// c ? a : b
+func (p *exprParser) parseTernary() (exprNode, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+
+	a, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept(":") {
+		return nil, fmt.Errorf("':' expected at %d", p.pos)
+	}
+	b, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(env exprEnv) (float64, error) {
+		c, err := cond(env)
+		if err != nil {
+			return 0, err
+		}
+		if c != 0 {
+			return a(env)
+		}
+		return b(env)
+	}, nil
+}
+
+// Left-associative binary operators of given precedence level
+func (p *exprParser) parseBinary(level int) (exprNode, error) {
+	if level == len(exprBinaryOperators) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := ""
+		for _, o := range exprBinaryOperators[level] {
+			if p.accept(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(env exprEnv) (float64, error) {
+			a, err := l(env)
+			if err != nil {
+				return 0, err
+			}
+
+			// logical operators are short-circuit
+			if op == "||" && a != 0 || op == "&&" && a == 0 {
+				return exprBool(a != 0), nil
+			}
+
+			b, err := right(env)
+			if err != nil {
+				return 0, err
+			}
+			return exprBinary(op, a, b)
+		}
+	}
+}
+
+// Unary minus, logical and bitwise not
+func (p *exprParser) parseUnary() (exprNode, error) {
+	for _, op := range []string{"-", "!", "~"} {
+		if !p.accept(op) {
+			continue
+		}
+
+		arg, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(env exprEnv) (float64, error) {
+			v, err := arg(env)
+			if err != nil {
+				return 0, err
+			}
+			switch op {
+			case "-":
+				return -v, nil
+			case "!":
+				return exprBool(v == 0), nil
+			}
+			n, _, err := exprInts(v, 0)
+			return float64(^n), err
+		}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func isExprDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isExprNameChar(c byte) bool {
+	return isExprDigit(c) || c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// Check if name can be used as expression variable
+func isExprVarName(name string) bool {
+	if name == "" || isExprDigit(name[0]) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isExprNameChar(name[i]) {
+			return false
+		}
+	}
+	_, isFunction := exprFunctions[name]
+	return !isFunction
+}
+
+func isExprHex(text string) bool {
+	return strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X")
+}
+
+// Number, x, function call or expression in parens
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	p.skipSpaces()
+	if p.pos == len(p.src) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+
+	if p.accept("(") {
+		node, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("')' expected at %d", p.pos)
+		}
+		return node, nil
+	}
+
+	start := p.pos
+	c := p.src[p.pos]
+
+	if isExprDigit(c) || c == '.' {
+		// hex, decimal or exponent number, exponent sign is the only non-alphanumeric char
+		for p.pos < len(p.src) && (isExprNameChar(p.src[p.pos]) || p.src[p.pos] == '.' ||
+			strings.ContainsRune("+-", rune(p.src[p.pos])) && strings.ContainsRune("eE", rune(p.src[p.pos-1])) && !isExprHex(p.src[start:])) {
+			p.pos++
+		}
+		text := p.src[start:p.pos]
+
+		var v float64
+		if isExprHex(text) {
+			n, err := strconv.ParseUint(text[2:], 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("wrong number %s", text)
+			}
+			v = float64(n)
+		} else {
+			var err error
+			if v, err = strconv.ParseFloat(text, 64); err != nil {
+				return nil, fmt.Errorf("wrong number %s", text)
+			}
+		}
+		return func(exprEnv) (float64, error) { return v, nil }, nil
+	}
+
+	for p.pos < len(p.src) && isExprNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+
+	if name == "" {
+		return nil, fmt.Errorf("unexpected %q at %d", p.src[start:], start)
+	}
+
+	for _, v := range p.vars {
+		if name == v {
+			return func(env exprEnv) (float64, error) { return env[name], nil }, nil
+		}
+	}
+
+	f, ok := exprFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown name %s", name)
+	}
+	if !p.accept("(") {
+		return nil, fmt.Errorf("'(' expected after %s", name)
+	}
+
+	args := make([]exprNode, 0)
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, fmt.Errorf("',' or ')' expected at %d", p.pos)
+			}
+		}
+	}
+
+	if len(args) < f.min || f.max >= 0 && len(args) > f.max {
+		return nil, fmt.Errorf("wrong number of arguments of %s: %d", name, len(args))
+	}
+
+	return func(env exprEnv) (float64, error) {
+		values := make([]float64, len(args))
+		for i, arg := range args {
+			v, err := arg(env)
+			if err != nil {
+				return 0, err
+			}
+			values[i] = v
+		}
+		return f.call(values)
+	}, nil
+}
+
+// Convert numeric value by expression, error is returned if value
+// isn't a number or expression can't be evaluated
+func (e *Expression) Convert(s string) (string, error) {
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", fmt.Errorf("value %q is not a number", s)
+	}
+
+	res, err := e.Eval(x)
+	if err != nil {
+		return "", fmt.Errorf("can't evaluate %s for %s: %s", e.Source, s, err)
+	}
+
+	return strconv.FormatFloat(res, 'f', -1, 64), nil
+}
@@ -0,0 +1,114 @@
+package mqtt_snmp
+
+import (
+	"testing"
+
+	"github.com/contactless/wbgo/testutils"
+)
+
+type ExpressionSuite struct {
+	testutils.Suite
+}
+
+func (s *ExpressionSuite) TestEval() {
+	cases := []struct {
+		expr   string
+		x      float64
+		result float64
+	}{
+		{"x", 5, 5},
+		{"x * 0.1 - 40", 650, 25},
+		{"(x + 2) * 3", 1, 9},
+		{"-x + 1", 3, -2},
+		{"x / 4 % 2", 12, 1},
+		{"1.5e2 + x", 0, 150},
+		{"0x10 + x", 1, 17},
+		{"clamp(x, 0, 100)", 120, 100},
+		{"clamp(x, 0, 100)", -5, 0},
+		{"min(x, 3, 7)", 5, 3},
+		{"max(x, 3, 7)", 5, 7},
+		{"round(x / 3, 2)", 10, 3.33},
+		{"round(x)", 2.5, 3},
+		{"abs(x) + floor(1.7) + ceil(0.2)", -3, 5},
+		{"bit(x, 2)", 4, 1},
+		{"bit(x, 1)", 4, 0},
+		{"bits(x, 4, 4)", 0xab, 0xa},
+		{"x & 0x0f | 0x30", 0xab, 0x3b},
+		{"x >> 4 ^ 1", 0x20, 3},
+		{"1 << x", 3, 8},
+		{"~x & 0xff", 0x0f, 0xf0},
+		{"x > 10 ? 1 : 0", 11, 1},
+		{"x > 10 ? 1 : x < 0 ? -1 : 0", -5, -1},
+		{"x >= 1 && x <= 3 || x == 10", 10, 1},
+		{"x != 0 && 10 / x > 1", 0, 0},
+		{"!x", 0, 1},
+	}
+
+	for _, c := range cases {
+		e, err := ParseExpression(c.expr)
+		if !s.NoError(err, "parse %s", c.expr) {
+			continue
+		}
+		res, err := e.Eval(c.x)
+		s.NoError(err, "eval %s", c.expr)
+		s.InDelta(c.result, res, 1e-9, "%s for %v", c.expr, c.x)
+	}
+}
+
+func (s *ExpressionSuite) TestVars() {
+	e, err := ParseExpressionVars("max(p1, p2) - min(p1, p2)", []string{"p1", "p2"})
+	s.Ck("parse", err)
+
+	res, err := e.EvalVars(exprEnv{"p1": 3, "p2": 10})
+	s.Ck("eval", err)
+	s.Equal(7.0, res)
+
+	// x isn't known if not listed
+	_, err = ParseExpressionVars("p1 + x", []string{"p1"})
+	s.Error(err)
+
+	s.True(isExprVarName("in_1"))
+	s.False(isExprVarName("1in"))
+	s.False(isExprVarName("in-1"))
+	s.False(isExprVarName("round"))
+}
+
+func (s *ExpressionSuite) TestErrors() {
+	for _, expr := range []string{"", "x +", "(x", "y", "foo(x)", "round", "round(x, 1, 2)", "clamp(x)", "x ? 1", "1 2", "0xzz", "x = 1"} {
+		_, err := ParseExpression(expr)
+		s.Error(err, "expression %q is accepted", expr)
+	}
+
+	for _, expr := range []string{"1 / x", "x % 0", "bit(x, 64)", "x & 1.5", "x / 0.0 * 0"} {
+		e, err := ParseExpression(expr)
+		if s.NoError(err, "parse %s", expr) {
+			_, err = e.Eval(0)
+			s.Error(err, "expression %q is evaluated", expr)
+		}
+	}
+}
+
+func (s *ExpressionSuite) TestConverter() {
+	e, err := ParseExpression("round(x * 0.1, 2)")
+	s.Ck("parse", err)
+
+	for value, expected := range map[string]string{"123.46": "12.35", "-40": "-4"} {
+		res, err := e.Convert(value)
+		if s.NoError(err, "convert %s", value) {
+			s.Equal(expected, res)
+		}
+	}
+
+	// non-numeric values and evaluation errors are reported
+	_, err = e.Convert("foo")
+	s.Error(err)
+
+	e, err = ParseExpression("1 / x")
+	s.Ck("parse", err)
+	_, err = e.Convert("0")
+	s.Error(err)
+}
+
+func TestExpression(t *testing.T) {
+	testutils.RunSuites(t, new(ExpressionSuite))
+}
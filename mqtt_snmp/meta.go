@@ -0,0 +1,156 @@
+package mqtt_snmp
+
+// Control and device meta module
+// Besides legacy meta subtopics published by wbgo driver,
+// controls and devices get JSON meta of current Wiren Board conventions
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/contactless/wbgo"
+)
+
+const (
+	// Driver name in device meta
+	DriverName = "wb-mqtt-snmp"
+
+	// Default language of titles
+	DefaultTitleLang = "en"
+)
+
+// Optional model observer extension to publish device JSON meta
+type DeviceMetaPublisher interface {
+	OnDeviceMeta(dev wbgo.DeviceModel, value string)
+}
+
+// Device JSON meta
+type deviceMeta struct {
+	Driver string            `json:"driver"`
+	Title  map[string]string `json:"title,omitempty"`
+}
+
+// Control JSON meta
+type controlMeta struct {
+	Type      string                       `json:"type"`
+	Units     string                       `json:"units,omitempty"`
+	Readonly  bool                         `json:"readonly,omitempty"`
+	Order     int                          `json:"order"`
+	Min       *float64                     `json:"min,omitempty"`
+	Max       *float64                     `json:"max,omitempty"`
+	Precision float64                      `json:"precision,omitempty"`
+	Title     map[string]string            `json:"title,omitempty"`
+	Enum      map[string]map[string]string `json:"enum,omitempty"`
+}
+
+// Get MQTT control writability from channel config
+func channelWritability(channel *ChannelConfig) wbgo.Writability {
+	if channel.Writable {
+		return wbgo.ForceWritable
+	}
+	if channel.Readonly {
+		return wbgo.ForceReadOnly
+	}
+	return wbgo.DefaultWritability
+}
+
+// Get title of channel for legacy meta, default language is preferred
+func (c *ChannelConfig) legacyTitle() string {
+	if title, ok := c.Title[DefaultTitleLang]; ok {
+		return title
+	}
+	langs := make([]string, 0, len(c.Title))
+	for lang := range c.Title {
+		langs = append(langs, lang)
+	}
+	if len(langs) == 0 {
+		return ""
+	}
+	sort.Strings(langs)
+	return c.Title[langs[0]]
+}
+
+// Create wbgo control for channel
+func newChannelControl(channel *ChannelConfig, value string) wbgo.Control {
+	control := wbgo.Control{
+		Name:        channel.Name,
+		Title:       channel.legacyTitle(),
+		Type:        channel.ControlType,
+		Value:       value,
+		Order:       channel.Order,
+		Writability: channelWritability(channel),
+	}
+	if channel.Units != "" {
+		control.Type = control.Type + ":" + channel.Units
+	}
+	if channel.Max != nil {
+		control.HasMax = true
+		control.Max = *channel.Max
+	}
+	return control
+}
+
+// Get JSON meta of channel control
+func (c *ChannelConfig) meta() controlMeta {
+	meta := controlMeta{
+		Type:      c.ControlType,
+		Units:     c.Units,
+		Readonly:  c.Readonly,
+		Order:     c.Order,
+		Min:       c.Min,
+		Max:       c.Max,
+		Precision: c.Precision,
+		Title:     c.Title,
+	}
+
+	if len(c.Enum) > 0 && isNumericControlType(c.ControlType) {
+		meta.Enum = make(map[string]map[string]string, len(c.Enum))
+		for value, label := range c.Enum {
+			meta.Enum[strconv.FormatInt(value, 10)] = map[string]string{DefaultTitleLang: label}
+		}
+	}
+
+	return meta
+}
+
+// Publish meta of new control which is not supported by wbgo driver:
+// JSON meta and enumeration labels of numeric control as JSON object
+func (m *SnmpModel) publishControlMeta(dev *SnmpDevice, channel *ChannelConfig) {
+	if m.ControlMeta == nil {
+		return
+	}
+
+	data, err := json.Marshal(channel.meta())
+	if err != nil {
+		wbgo.Error.Printf("can't encode meta of %s:%s: %s", dev.DevName, channel.Name, err)
+		return
+	}
+	m.ControlMeta.OnControlMeta(dev, channel.Name, "", string(data))
+
+	if len(channel.Enum) == 0 || !isNumericControlType(channel.ControlType) {
+		return
+	}
+
+	data, err = json.Marshal(channel.Enum)
+	if err != nil {
+		wbgo.Error.Printf("can't encode enumeration of %s:%s: %s", dev.DevName, channel.Name, err)
+		return
+	}
+	m.ControlMeta.OnControlMeta(dev, channel.Name, "enum", string(data))
+}
+
+// Publish JSON meta of device with driver name and title
+func (m *SnmpModel) publishDeviceMeta(dev *SnmpDevice) {
+	if m.DeviceMeta == nil {
+		return
+	}
+
+	meta := deviceMeta{Driver: DriverName, Title: map[string]string{DefaultTitleLang: dev.DevTitle}}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		wbgo.Error.Printf("can't encode meta of %s: %s", dev.DevName, err)
+		return
+	}
+	m.DeviceMeta.OnDeviceMeta(dev, string(data))
+}
@@ -0,0 +1,500 @@
+package mqtt_snmp
+
+// MIB loader module
+// Loads MIB modules from directories on demand, resolves
+// symbolic names to numeric OIDs and provides type information
+// of MIB objects
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/contactless/wbgo"
+	"github.com/wirenboard/wb-mqtt-snmp/snmp_oid"
+)
+
+var (
+	// Default MIB directories, daemon MIBs override system ones
+	DefaultMibDirs = []string{"/usr/share/wb-mqtt-snmp/mibs", "/usr/share/snmp/mibs"}
+
+	// Module header to index MIB files without parsing
+	mibModuleHeader = regexp.MustCompile(`([A-Za-z][A-Za-z0-9_-]*)\s+DEFINITIONS\s*(?:[A-Z]+\s+TAGS\s*)?::=\s*BEGIN`)
+
+	// Base types where textual conventions end
+	mibBaseTypes = map[string]bool{
+		"INTEGER": true, "Integer32": true, "Unsigned32": true, "Gauge32": true, "Gauge": true,
+		"Counter32": true, "Counter": true, "Counter64": true, "TimeTicks": true,
+		"IpAddress": true, "NetworkAddress": true, "Opaque": true,
+		"OCTET STRING": true, "OBJECT IDENTIFIER": true, "BITS": true,
+		"SEQUENCE": true, "CHOICE": true,
+	}
+)
+
+// Root nodes of OID tree, they are known without any module
+var mibRootNodes = map[string]string{
+	"ccitt":           ".0",
+	"iso":             ".1",
+	"joint-iso-ccitt": ".2",
+}
+
+// Core SMI modules nodes used if modules files are not installed
+var mibCoreNodes = map[string]map[string]string{
+	"SNMPv2-SMI": {
+		"org": ".1.3", "dod": ".1.3.6", "internet": ".1.3.6.1",
+		"directory": ".1.3.6.1.1", "mgmt": ".1.3.6.1.2", "mib-2": ".1.3.6.1.2.1",
+		"transmission": ".1.3.6.1.2.1.10", "experimental": ".1.3.6.1.3",
+		"private": ".1.3.6.1.4", "enterprises": ".1.3.6.1.4.1",
+		"security": ".1.3.6.1.5", "snmpV2": ".1.3.6.1.6", "snmpDomains": ".1.3.6.1.6.1",
+		"snmpProxys": ".1.3.6.1.6.2", "snmpModules": ".1.3.6.1.6.3", "zeroDotZero": ".0.0",
+	},
+	"RFC1155-SMI": {
+		"org": ".1.3", "dod": ".1.3.6", "internet": ".1.3.6.1",
+		"directory": ".1.3.6.1.1", "mgmt": ".1.3.6.1.2", "experimental": ".1.3.6.1.3",
+		"private": ".1.3.6.1.4", "enterprises": ".1.3.6.1.4.1",
+	},
+	"RFC1213-MIB": {
+		"mib-2": ".1.3.6.1.2.1",
+	},
+
+	// macro definitions only
+	"RFC-1212":    {},
+	"RFC-1215":    {},
+	"SNMPv2-CONF": {},
+}
+
+// MIB object with type information
+type MibObject struct {
+	Name, Module string
+
+	// Numeric OID with leading dot
+	Oid string
+
+	// Assignment macro, i.e. "OBJECT-TYPE" or "OBJECT IDENTIFIER"
+	Kind string
+
+	// Type as it's written in SYNTAX clause, i.e. "DisplayString",
+	// and base type it is derived from, i.e. "OCTET STRING"
+	Type, BaseType string
+
+	// Display hint of textual convention
+	DisplayHint string
+
+	// Named numbers of INTEGER and BITS types
+	Enums map[int64]string
+
+	Units, Access string
+}
+
+// Set of loaded MIB modules
+type Mib struct {
+	dirs []string
+
+	// Map from module names to files
+	files map[string]string
+
+	// Loaded modules
+	modules map[string]*mibModule
+
+	// Resolved objects by module and name and by OID
+	objects map[string]map[string]*MibObject
+	byOid   map[string]*MibObject
+
+	// Modules are loaded from all files
+	allLoaded bool
+}
+
+// Create MIB set and index module files of directories
+// Missing directories are skipped
+func NewMib(dirs []string) *Mib {
+	m := &Mib{
+		dirs:    dirs,
+		files:   make(map[string]string),
+		modules: make(map[string]*mibModule),
+		objects: make(map[string]map[string]*MibObject),
+		byOid:   make(map[string]*MibObject),
+	}
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			wbgo.Debug.Printf("skip MIB directory %s: %s", dir, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			data, err := os.ReadFile(path)
+			if err != nil {
+				wbgo.Warn.Printf("can't read MIB file %s: %s", path, err)
+				continue
+			}
+
+			for _, match := range mibModuleHeader.FindAllStringSubmatch(string(data), -1) {
+				// modules of first directories take precedence
+				if _, ok := m.files[match[1]]; !ok {
+					m.files[match[1]] = path
+				}
+			}
+		}
+	}
+
+	return m
+}
+
+// Load module with all its imports
+func (m *Mib) LoadModule(name string) error {
+	return m.loadModule(name, make(map[string]bool))
+}
+
+func (m *Mib) loadModule(name string, loading map[string]bool) error {
+	if _, ok := m.modules[name]; ok || loading[name] {
+		return nil
+	}
+	loading[name] = true
+
+	path, ok := m.files[name]
+	if !ok {
+		if _, core := mibCoreNodes[name]; core {
+			m.addCoreModule(name)
+			return nil
+		}
+		return fmt.Errorf("MIB module %s is not found in %s", name, strings.Join(m.dirs, ", "))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	modules, err := parseMib(string(data))
+	if err != nil {
+		return fmt.Errorf("can't parse %s: %s", path, err)
+	}
+
+	// file may contain several modules, they are loaded together
+	for _, mod := range modules {
+		if _, ok := m.modules[mod.Name]; !ok && m.files[mod.Name] == path {
+			m.modules[mod.Name] = mod
+		}
+	}
+
+	for _, mod := range modules {
+		if m.modules[mod.Name] != mod {
+			continue
+		}
+		for _, imp := range sortedImportModules(mod) {
+			if err := m.loadModule(imp, loading); err != nil {
+				// netsnmp is tolerant to missing imports, so be it
+				wbgo.Warn.Printf("module %s: %s", mod.Name, err)
+			}
+		}
+	}
+
+	for _, mod := range modules {
+		if m.modules[mod.Name] == mod {
+			m.resolveModule(mod)
+		}
+	}
+
+	return nil
+}
+
+// Load all modules of MIB directories
+// Broken modules are skipped with warning
+func (m *Mib) LoadAll() {
+	if m.allLoaded {
+		return
+	}
+	m.allLoaded = true
+
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := m.LoadModule(name); err != nil {
+			wbgo.Warn.Printf("can't load MIB module %s: %s", name, err)
+		}
+	}
+}
+
+func sortedImportModules(mod *mibModule) []string {
+	set := make(map[string]bool)
+	for _, imp := range mod.Imports {
+		set[imp] = true
+	}
+
+	res := make([]string, 0, len(set))
+	for imp := range set {
+		res = append(res, imp)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// Register built-in core module
+func (m *Mib) addCoreModule(name string) {
+	m.modules[name] = &mibModule{Name: name, Imports: make(map[string]string), Types: make(map[string]*mibType)}
+	m.objects[name] = make(map[string]*MibObject)
+
+	for node, oid := range mibCoreNodes[name] {
+		obj := &MibObject{Name: node, Module: name, Oid: oid, Kind: "OBJECT IDENTIFIER"}
+		m.objects[name][node] = obj
+		if _, ok := m.byOid[oid]; !ok {
+			m.byOid[oid] = obj
+		}
+	}
+}
+
+func (m *Mib) addObject(obj *MibObject) {
+	if _, ok := m.objects[obj.Module]; !ok {
+		m.objects[obj.Module] = make(map[string]*MibObject)
+	}
+	m.objects[obj.Module][obj.Name] = obj
+
+	if prev, ok := m.byOid[obj.Oid]; !ok || prev.Kind == "OBJECT IDENTIFIER" {
+		m.byOid[obj.Oid] = obj
+	}
+}
+
+// Find object visible in module: defined or imported
+// Other loaded modules are searched too if symbol is imported
+// from wrong module, like netsnmp does
+func (m *Mib) findSymbol(mod *mibModule, name string) *MibObject {
+	if obj, ok := m.objects[mod.Name][name]; ok {
+		return obj
+	}
+
+	if imp, ok := mod.Imports[name]; ok {
+		if obj, ok := m.objects[imp][name]; ok {
+			return obj
+		}
+		return m.findObject(name)
+	}
+
+	if oid, ok := mibRootNodes[name]; ok {
+		return &MibObject{Name: name, Oid: oid, Kind: "OBJECT IDENTIFIER"}
+	}
+
+	return nil
+}
+
+// Find object by name in all loaded modules
+func (m *Mib) findObject(name string) *MibObject {
+	modules := make([]string, 0, len(m.objects))
+	for mod := range m.objects {
+		modules = append(modules, mod)
+	}
+	sort.Strings(modules)
+
+	for _, mod := range modules {
+		if obj, ok := m.objects[mod][name]; ok {
+			return obj
+		}
+	}
+
+	return nil
+}
+
+// Resolve OID values of module nodes
+// Nodes may refer to nodes defined later, so repeat until
+// nothing is resolved
+func (m *Mib) resolveModule(mod *mibModule) {
+	pending := mod.Nodes
+
+	for len(pending) > 0 {
+		rest := make([]*mibNode, 0)
+
+		for _, node := range pending {
+			if !m.resolveNode(mod, node) {
+				rest = append(rest, node)
+			}
+		}
+
+		if len(rest) == len(pending) {
+			for _, node := range rest {
+				wbgo.Warn.Printf("module %s: can't resolve parent %s of %s", mod.Name, node.Value[0].Name, node.Name)
+			}
+			return
+		}
+		pending = rest
+	}
+}
+
+func (m *Mib) resolveNode(mod *mibModule, node *mibNode) bool {
+	var oid string
+	first := node.Value[0]
+
+	if first.Name == "" {
+		oid = fmt.Sprintf(".%d", first.Number)
+	} else if parent := m.findSymbol(mod, first.Name); parent != nil {
+		oid = parent.Oid
+	} else if first.HasNum {
+		oid = fmt.Sprintf(".%d", first.Number)
+	} else {
+		return false
+	}
+
+	for _, c := range node.Value[1:] {
+		oid = fmt.Sprintf("%s.%d", oid, c.Number)
+
+		// named components define intermediate nodes
+		if c.Name != "" && m.findSymbol(mod, c.Name) == nil {
+			m.addObject(&MibObject{Name: c.Name, Module: mod.Name, Oid: oid, Kind: "OBJECT IDENTIFIER"})
+		}
+	}
+
+	obj := &MibObject{
+		Name:   node.Name,
+		Module: mod.Name,
+		Oid:    oid,
+		Kind:   node.Kind,
+		Units:  node.Units,
+		Access: node.Access,
+	}
+	if node.Syntax != nil {
+		obj.Type = node.Syntax.Type
+		obj.Enums = node.Syntax.Enums
+		m.resolveType(mod, obj, node.Syntax.Type)
+	}
+
+	m.addObject(obj)
+	return true
+}
+
+// Follow textual conventions chain to base type
+// Display hint and named numbers of nearest convention are used
+func (m *Mib) resolveType(mod *mibModule, obj *MibObject, name string) {
+	for depth := 0; depth < 16; depth++ {
+		if mibBaseTypes[name] {
+			obj.BaseType = name
+			return
+		}
+
+		typ, typMod := m.findType(mod, name)
+		if typ == nil || typ.Syntax == nil {
+			wbgo.Debug.Printf("module %s: unknown type %s of %s", mod.Name, name, obj.Name)
+			return
+		}
+
+		if obj.DisplayHint == "" {
+			obj.DisplayHint = typ.DisplayHint
+		}
+		if obj.Enums == nil {
+			obj.Enums = typ.Syntax.Enums
+		}
+
+		mod, name = typMod, typ.Syntax.Type
+	}
+}
+
+func (m *Mib) findType(mod *mibModule, name string) (*mibType, *mibModule) {
+	if typ, ok := mod.Types[name]; ok {
+		return typ, mod
+	}
+
+	if imp, ok := m.modules[mod.Imports[name]]; ok {
+		if typ, ok := imp.Types[name]; ok {
+			return typ, imp
+		}
+	}
+
+	for _, other := range m.modules {
+		if typ, ok := other.Types[name]; ok {
+			return typ, other
+		}
+	}
+
+	return nil, nil
+}
+
+// Check if OID is numeric, with or without leading dot
+func isNumericOid(oid string) bool {
+	for _, part := range strings.Split(strings.TrimPrefix(oid, "."), ".") {
+		if _, err := strconv.ParseUint(part, 10, 32); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Translate name like "MODULE::name.index" or "name.index"
+// to numeric OID with leading dot
+// Numeric OIDs are returned as is
+func (m *Mib) Translate(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("empty OID")
+	}
+	if isNumericOid(name) {
+		return snmp_oid.Normalize(name), nil
+	}
+
+	module, symbol := "", name
+	if i := strings.Index(name, "::"); i >= 0 {
+		module, symbol = name[:i], name[i+2:]
+	}
+
+	index := ""
+	if i := strings.Index(symbol, "."); i >= 0 {
+		symbol, index = symbol[:i], symbol[i:]
+		if !isNumericOid(index) {
+			return "", fmt.Errorf("wrong index %s of %s", index[1:], symbol)
+		}
+	}
+
+	var obj *MibObject
+	if module != "" {
+		if err := m.LoadModule(module); err != nil {
+			return "", err
+		}
+		if obj = m.findSymbol(m.modules[module], symbol); obj == nil {
+			return "", fmt.Errorf("object %s is not found in MIB module %s", symbol, module)
+		}
+	} else {
+		if oid, ok := mibRootNodes[symbol]; ok {
+			return oid + index, nil
+		}
+		if obj = m.findObject(symbol); obj == nil {
+			m.LoadAll()
+			obj = m.findObject(symbol)
+		}
+		if obj == nil {
+			return "", fmt.Errorf("object %s is not found in MIB modules", symbol)
+		}
+	}
+
+	return obj.Oid + index, nil
+}
+
+// Find object by OID of its instance
+// Returns nearest object and rest of OID as index
+func (m *Mib) Object(oid string) (obj *MibObject, index string) {
+	oid = snmp_oid.Normalize(oid)
+
+	for prefix := oid; prefix != ""; {
+		if obj, ok := m.byOid[prefix]; ok {
+			return obj, strings.TrimPrefix(oid[len(prefix):], ".")
+		}
+
+		i := strings.LastIndex(prefix, ".")
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+
+	return nil, ""
+}
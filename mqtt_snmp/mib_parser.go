@@ -0,0 +1,569 @@
+package mqtt_snmp
+
+// MIB parser module
+// Parses SMIv1/SMIv2 modules into OID assignments and types;
+// only parts required to resolve names and convert values are kept,
+// all other clauses are skipped
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type mibTokenKind int
+
+const (
+	mibIdent mibTokenKind = iota
+	mibNumber
+	mibString
+	mibSymbol
+	mibEOF
+)
+
+type mibToken struct {
+	kind mibTokenKind
+	text string
+	line int
+}
+
+// Split MIB text into tokens
+// Comments start with "--" and end with line or with next "--"
+func tokenizeMib(text string) ([]mibToken, error) {
+	res := make([]mibToken, 0, len(text)/8)
+	line := 1
+	i := 0
+
+	for i < len(text) {
+		c := text[i]
+
+		switch {
+		case c == '\n':
+			line++
+			i++
+
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+
+		case strings.HasPrefix(text[i:], "--"):
+			i += 2
+			for i < len(text) && text[i] != '\n' {
+				if strings.HasPrefix(text[i:], "--") {
+					i += 2
+					break
+				}
+				i++
+			}
+
+		case c == '"':
+			start, startLine := i+1, line
+			i++
+			for i < len(text) && text[i] != '"' {
+				if text[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			if i == len(text) {
+				return nil, fmt.Errorf("line %d: unterminated string", startLine)
+			}
+			res = append(res, mibToken{mibString, text[start:i], startLine})
+			i++
+
+		case c == '\'':
+			// binary or hex string, i.e. '00'H
+			start := i
+			i++
+			for i < len(text) && text[i] != '\'' {
+				i++
+			}
+			if i < len(text)-1 {
+				i += 2
+			}
+			res = append(res, mibToken{mibString, text[start:i], line})
+
+		case unicode.IsDigit(rune(c)) || (c == '-' && i+1 < len(text) && unicode.IsDigit(rune(text[i+1]))):
+			start := i
+			i++
+			for i < len(text) && unicode.IsDigit(rune(text[i])) {
+				i++
+			}
+			res = append(res, mibToken{mibNumber, text[start:i], line})
+
+		case unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(text) {
+				r := text[i]
+				if unicode.IsLetter(rune(r)) || unicode.IsDigit(rune(r)) || r == '_' ||
+					(r == '-' && !strings.HasPrefix(text[i:], "--")) {
+					i++
+					continue
+				}
+				break
+			}
+			res = append(res, mibToken{mibIdent, text[start:i], line})
+
+		case strings.HasPrefix(text[i:], "::="):
+			res = append(res, mibToken{mibSymbol, "::=", line})
+			i += 3
+
+		case strings.HasPrefix(text[i:], ".."):
+			res = append(res, mibToken{mibSymbol, "..", line})
+			i += 2
+
+		case strings.ContainsRune("{}()[],;|.:<>-=!@&*+/", rune(c)):
+			res = append(res, mibToken{mibSymbol, string(c), line})
+			i++
+
+		default:
+			return nil, fmt.Errorf("line %d: unexpected symbol %q", line, c)
+		}
+	}
+
+	return append(res, mibToken{mibEOF, "", line}), nil
+}
+
+// Type syntax of object or type assignment
+// Constraints are dropped, named numbers are kept
+type mibSyntax struct {
+	// Referenced type name, i.e. "INTEGER", "OCTET STRING", "DisplayString"
+	Type string
+
+	// Named numbers of INTEGER and BITS
+	Enums map[int64]string
+}
+
+// Component of OID value, i.e. "iso", "org(3)" or "6"
+type mibOidComponent struct {
+	Name   string
+	Number uint32
+	HasNum bool
+}
+
+// Assignment of OID to name
+type mibNode struct {
+	Name string
+
+	// Macro used for assignment, i.e. "OBJECT-TYPE"
+	Kind string
+
+	// OID value components
+	Value []mibOidComponent
+
+	Syntax *mibSyntax
+	Units  string
+	Access string
+	Line   int
+}
+
+// Type assignment or textual convention
+type mibType struct {
+	Name        string
+	Syntax      *mibSyntax
+	DisplayHint string
+}
+
+// Parsed MIB module
+type mibModule struct {
+	Name string
+
+	// Map from imported symbols to their modules
+	Imports map[string]string
+
+	Nodes []*mibNode
+	Types map[string]*mibType
+}
+
+type mibParser struct {
+	tokens []mibToken
+	pos    int
+}
+
+func (p *mibParser) peek() mibToken {
+	return p.tokens[p.pos]
+}
+
+func (p *mibParser) peekAt(offset int) mibToken {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *mibParser) next() mibToken {
+	t := p.tokens[p.pos]
+	if t.kind != mibEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *mibParser) is(text string) bool {
+	t := p.peek()
+	return t.kind != mibString && t.text == text
+}
+
+func (p *mibParser) expect(text string) error {
+	if t := p.next(); t.kind == mibString || t.text != text {
+		return fmt.Errorf("line %d: %q expected, %q found", t.line, text, t.text)
+	}
+	return nil
+}
+
+// Skip balanced block starting with opening bracket
+func (p *mibParser) skipBlock() error {
+	open := p.next()
+	closing := map[string]string{"{": "}", "(": ")", "[": "]"}[open.text]
+	depth := 1
+
+	for depth > 0 {
+		t := p.next()
+		switch {
+		case t.kind == mibEOF:
+			return fmt.Errorf("line %d: unbalanced %q", open.line, open.text)
+		case t.kind != mibSymbol:
+		case t.text == open.text:
+			depth++
+		case t.text == closing:
+			depth--
+		}
+	}
+
+	return nil
+}
+
+// Parse all modules of MIB file
+func parseMib(text string) ([]*mibModule, error) {
+	tokens, err := tokenizeMib(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &mibParser{tokens: tokens}
+	res := make([]*mibModule, 0, 1)
+
+	for p.peek().kind != mibEOF {
+		m, err := p.parseModule()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no MIB modules found")
+	}
+
+	return res, nil
+}
+
+// Module is "Name DEFINITIONS ::= BEGIN ... END"
+func (p *mibParser) parseModule() (*mibModule, error) {
+	name := p.next()
+	if name.kind != mibIdent {
+		return nil, fmt.Errorf("line %d: module name expected, %q found", name.line, name.text)
+	}
+
+	if err := p.expect("DEFINITIONS"); err != nil {
+		return nil, err
+	}
+	// tagging mode, i.e. "IMPLICIT TAGS"
+	for !p.is("::=") && p.peek().kind == mibIdent {
+		p.next()
+	}
+	if err := p.expect("::="); err != nil {
+		return nil, err
+	}
+	if err := p.expect("BEGIN"); err != nil {
+		return nil, err
+	}
+
+	m := &mibModule{
+		Name:    name.text,
+		Imports: make(map[string]string),
+		Types:   make(map[string]*mibType),
+	}
+
+	for !p.is("END") {
+		if p.peek().kind == mibEOF {
+			return nil, fmt.Errorf("module %s is not terminated with END", m.Name)
+		}
+		if err := p.parseStatement(m); err != nil {
+			return nil, fmt.Errorf("module %s: %s", m.Name, err)
+		}
+	}
+	p.next()
+
+	return m, nil
+}
+
+func (p *mibParser) parseStatement(m *mibModule) error {
+	t := p.next()
+
+	switch {
+	case t.text == "IMPORTS":
+		return p.parseImports(m)
+
+	case t.text == "EXPORTS":
+		for !p.is(";") && p.peek().kind != mibEOF {
+			p.next()
+		}
+		p.next()
+		return nil
+
+	case t.kind == mibSymbol && t.text == ";":
+		return nil
+
+	case t.kind != mibIdent:
+		return fmt.Errorf("line %d: unexpected %q", t.line, t.text)
+
+	case p.is("MACRO"):
+		// macro definitions of SMI modules are not interesting
+		for !p.is("END") {
+			if p.next().kind == mibEOF {
+				return fmt.Errorf("line %d: macro %s is not terminated", t.line, t.text)
+			}
+		}
+		p.next()
+		return nil
+
+	case p.is("::="):
+		p.next()
+		return p.parseTypeAssignment(m, t.text)
+
+	case p.is("OBJECT") && p.peekAt(1).text == "IDENTIFIER":
+		p.next()
+		p.next()
+		node := &mibNode{Name: t.text, Kind: "OBJECT IDENTIFIER", Line: t.line}
+		if err := p.expect("::="); err != nil {
+			return err
+		}
+		return p.parseOidValue(m, node)
+	}
+
+	// macro invocation, i.e. "name OBJECT-TYPE <clauses> ::= value"
+	node := &mibNode{Name: t.text, Kind: p.next().text, Line: t.line}
+	for !p.is("::=") {
+		if err := p.parseClause(node); err != nil {
+			return err
+		}
+	}
+	p.next()
+
+	if !p.is("{") {
+		// SMIv1 TRAP-TYPE value is a number, not OID
+		p.next()
+		return nil
+	}
+
+	return p.parseOidValue(m, node)
+}
+
+// Imports are "symbol, symbol FROM Module ... ;"
+func (p *mibParser) parseImports(m *mibModule) error {
+	symbols := make([]string, 0)
+
+	for {
+		t := p.next()
+		switch {
+		case t.kind == mibEOF:
+			return fmt.Errorf("IMPORTS are not terminated")
+		case t.text == ";":
+			return nil
+		case t.text == ",":
+		case t.text == "FROM":
+			module := p.next()
+			for _, s := range symbols {
+				m.Imports[s] = module.text
+			}
+			symbols = symbols[:0]
+		default:
+			symbols = append(symbols, t.text)
+		}
+	}
+}
+
+// Clause of macro invocation
+// Values of unknown clauses are skipped token by token
+func (p *mibParser) parseClause(node *mibNode) error {
+	t := p.next()
+
+	switch {
+	case t.kind == mibEOF:
+		return fmt.Errorf("line %d: %s of %s is not terminated", node.Line, node.Kind, node.Name)
+	case t.text == "SYNTAX" && node.Syntax == nil:
+		var err error
+		node.Syntax, err = p.parseSyntax()
+		return err
+	case t.text == "UNITS" && p.peek().kind == mibString:
+		node.Units = p.next().text
+	case (t.text == "MAX-ACCESS" || t.text == "ACCESS") && p.peek().kind == mibIdent:
+		node.Access = p.next().text
+	case p.is("{") || p.is("("):
+		return p.skipBlock()
+	}
+
+	return nil
+}
+
+// Type assignment is either textual convention or plain type
+func (p *mibParser) parseTypeAssignment(m *mibModule, name string) error {
+	typ := &mibType{Name: name}
+	m.Types[name] = typ
+
+	if !p.is("TEXTUAL-CONVENTION") {
+		var err error
+		typ.Syntax, err = p.parseSyntax()
+		return err
+	}
+	p.next()
+
+	for !p.is("SYNTAX") {
+		t := p.next()
+		switch {
+		case t.kind == mibEOF:
+			return fmt.Errorf("line %d: textual convention %s has no SYNTAX", t.line, name)
+		case t.text == "DISPLAY-HINT" && p.peek().kind == mibString:
+			typ.DisplayHint = p.next().text
+		}
+	}
+	p.next()
+
+	var err error
+	typ.Syntax, err = p.parseSyntax()
+	return err
+}
+
+// Parse type syntax with optional tag, named numbers and constraints
+func (p *mibParser) parseSyntax() (*mibSyntax, error) {
+	if p.is("[") {
+		if err := p.skipBlock(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is("IMPLICIT") || p.is("EXPLICIT") {
+		p.next()
+	}
+
+	t := p.next()
+	if t.kind != mibIdent {
+		return nil, fmt.Errorf("line %d: type expected, %q found", t.line, t.text)
+	}
+	s := &mibSyntax{Type: t.text}
+
+	switch {
+	case t.text == "OCTET" && p.is("STRING"), t.text == "OBJECT" && p.is("IDENTIFIER"):
+		s.Type += " " + p.next().text
+	case t.text == "SEQUENCE" && p.is("OF"):
+		p.next()
+		p.next()
+		return s, nil
+	case t.text == "SEQUENCE", t.text == "CHOICE":
+		return s, p.skipBlock()
+	}
+
+	if p.is("{") {
+		var err error
+		if s.Enums, err = p.parseNamedNumbers(); err != nil {
+			return nil, err
+		}
+	}
+
+	for p.is("(") {
+		if err := p.skipBlock(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Named numbers are "{ name(1), name(2) }"
+func (p *mibParser) parseNamedNumbers() (map[int64]string, error) {
+	res := make(map[int64]string)
+	p.next()
+
+	for !p.is("}") {
+		name := p.next()
+		if name.kind != mibIdent {
+			return nil, fmt.Errorf("line %d: named number expected, %q found", name.line, name.text)
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		num := p.next()
+		value, err := strconv.ParseInt(num.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: wrong value of %s: %q", num.line, name.text, num.text)
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		res[value] = name.text
+
+		if p.is(",") {
+			p.next()
+		}
+	}
+	p.next()
+
+	return res, nil
+}
+
+// OID value is "{ parent 1 }" or "{ iso org(3) dod(6) 1 }"
+func (p *mibParser) parseOidValue(m *mibModule, node *mibNode) error {
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	for !p.is("}") {
+		t := p.next()
+		c := mibOidComponent{}
+
+		switch t.kind {
+		case mibIdent:
+			c.Name = t.text
+			// module reference, i.e. SNMPv2-SMI.enterprises
+			if p.is(".") && p.peekAt(1).kind == mibIdent {
+				p.next()
+				c.Name = p.next().text
+			}
+			if p.is("(") {
+				p.next()
+				num := p.next()
+				v, err := strconv.ParseUint(num.text, 10, 32)
+				if err != nil {
+					return fmt.Errorf("line %d: wrong OID component %s(%s)", num.line, c.Name, num.text)
+				}
+				c.Number, c.HasNum = uint32(v), true
+				if err := p.expect(")"); err != nil {
+					return err
+				}
+			}
+		case mibNumber:
+			v, err := strconv.ParseUint(t.text, 10, 32)
+			if err != nil {
+				return fmt.Errorf("line %d: wrong OID component %s", t.line, t.text)
+			}
+			c.Number, c.HasNum = uint32(v), true
+		default:
+			return fmt.Errorf("line %d: unexpected %q in OID value of %s", t.line, t.text, node.Name)
+		}
+
+		node.Value = append(node.Value, c)
+	}
+	p.next()
+
+	if len(node.Value) == 0 {
+		return fmt.Errorf("line %d: empty OID value of %s", node.Line, node.Name)
+	}
+	for _, c := range node.Value[1:] {
+		if !c.HasNum {
+			return fmt.Errorf("line %d: OID value of %s has no number for %s", node.Line, node.Name, c.Name)
+		}
+	}
+
+	m.Nodes = append(m.Nodes, node)
+	return nil
+}
@@ -0,0 +1,425 @@
+package mqtt_snmp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/contactless/wbgo/testutils"
+)
+
+// Cut-down versions of standard modules
+const testSnmpV2Smi = `
+SNMPv2-SMI DEFINITIONS ::= BEGIN
+
+-- the path to the root
+
+org            OBJECT IDENTIFIER ::= { iso 3 }  --  "iso" = 1
+dod            OBJECT IDENTIFIER ::= { org 6 }
+internet       OBJECT IDENTIFIER ::= { dod 1 }
+mgmt           OBJECT IDENTIFIER ::= { internet 2 }
+mib-2          OBJECT IDENTIFIER ::= { mgmt 1 }
+private        OBJECT IDENTIFIER ::= { internet 4 }
+enterprises    OBJECT IDENTIFIER ::= { private 1 }
+
+MODULE-IDENTITY MACRO ::=
+BEGIN
+    TYPE NOTATION ::=
+                  "LAST-UPDATED" value(Update ExtUTCTime)
+                  "ORGANIZATION" Text
+    VALUE NOTATION ::=
+                  value(VALUE OBJECT IDENTIFIER)
+    Text ::= value(IA5String)
+END
+
+ObjectName ::= OBJECT IDENTIFIER
+
+Integer32 ::= INTEGER (-2147483648..2147483647)
+Counter32 ::= [APPLICATION 1] IMPLICIT INTEGER (0..4294967295)
+TimeTicks ::= [APPLICATION 3] IMPLICIT INTEGER (0..4294967295)
+
+ObjectSyntax ::= CHOICE {
+    simple SimpleSyntax,
+    application-wide ApplicationSyntax
+}
+
+zeroDotZero OBJECT-IDENTITY
+    STATUS     current
+    DESCRIPTION
+            "A value used for null identifiers."
+    ::= { 0 0 }
+
+END
+`
+
+const testSnmpV2Tc = `
+SNMPv2-TC DEFINITIONS ::= BEGIN
+
+IMPORTS
+    TimeTicks FROM SNMPv2-SMI;
+
+DisplayString ::= TEXTUAL-CONVENTION
+    DISPLAY-HINT "255a"
+    STATUS       current
+    DESCRIPTION
+            "Represents textual information taken from the NVT ASCII
+            character set, as defined in pages 4, 10-11 of RFC 854."
+    SYNTAX       OCTET STRING (SIZE (0..255))
+
+TruthValue ::= TEXTUAL-CONVENTION
+    STATUS       current
+    DESCRIPTION  "Represents a boolean value."
+    SYNTAX       INTEGER { true(1), false(2) }
+
+END
+`
+
+const testSnmpV2Mib = `
+SNMPv2-MIB DEFINITIONS ::= BEGIN
+
+IMPORTS
+    OBJECT-TYPE, TimeTicks, mib-2
+        FROM SNMPv2-SMI
+    DisplayString, TruthValue
+        FROM SNMPv2-TC;
+
+system   OBJECT IDENTIFIER ::= { mib-2 1 }
+
+sysDescr OBJECT-TYPE
+    SYNTAX      DisplayString (SIZE (0..255))
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION
+            "A textual description of the entity -- including version."
+    ::= { system 1 }
+
+sysUpTime OBJECT-TYPE
+    SYNTAX      TimeTicks
+    UNITS       "centiseconds"
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "The time since the network management portion of
+                the system was last re-initialized."
+    ::= { system 3 }
+
+sysName OBJECT-TYPE
+    SYNTAX      DisplayString (SIZE (0..255))
+    MAX-ACCESS  read-write
+    STATUS      current
+    DESCRIPTION "An administratively-assigned name."
+    ::= { system 5 }
+
+snmpEnableAuthenTraps OBJECT-TYPE
+    SYNTAX      INTEGER { enabled(1), disabled(2) }
+    MAX-ACCESS  read-write
+    STATUS      current
+    DESCRIPTION "Authentication failure traps."
+    DEFVAL      { disabled }
+    ::= { snmp 30 }
+
+snmpTrueValue OBJECT-TYPE
+    SYNTAX      TruthValue
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "Test of named numbers of textual convention."
+    ::= { snmp 31 }
+
+snmp     OBJECT IDENTIFIER ::= { mib-2 11 }
+
+END
+`
+
+// SMIv1 vendor module
+const testUpsMib = `
+TEST-UPS-MIB DEFINITIONS ::= BEGIN
+
+IMPORTS
+   enterprises, Gauge                 FROM RFC1155-SMI
+   DisplayString                      FROM RFC1213-MIB
+   OBJECT-TYPE                        FROM RFC-1212
+   TRAP-TYPE                          FROM RFC-1215;
+
+testvendor     OBJECT IDENTIFIER ::=  { enterprises 99999 }
+products       OBJECT IDENTIFIER ::=  { testvendor 1 }
+ups            OBJECT IDENTIFIER ::=  { products hardware(1) 1 }
+upsBattery     OBJECT IDENTIFIER ::=  { ups 2 }
+
+upsBatteryStatus OBJECT-TYPE
+   SYNTAX INTEGER  {
+      unknown(1),
+      batteryNormal(2),
+      batteryLow(3)
+   }
+   ACCESS read-only
+   STATUS mandatory
+   DESCRIPTION
+      "The status of the UPS batteries."
+   ::= { upsBattery 1 }
+
+upsBatteryTemperature OBJECT-TYPE
+   SYNTAX Gauge
+   ACCESS read-only
+   STATUS mandatory
+   DESCRIPTION "The current internal UPS temperature."
+   ::= { upsBattery 2 }
+
+upsPhaseTable OBJECT-TYPE
+   SYNTAX SEQUENCE OF UpsPhaseEntry
+   ACCESS not-accessible
+   STATUS mandatory
+   DESCRIPTION "Phases."
+   ::= { upsBattery 3 }
+
+upsPhaseEntry OBJECT-TYPE
+   SYNTAX UpsPhaseEntry
+   ACCESS not-accessible
+   STATUS mandatory
+   DESCRIPTION "Phase."
+   INDEX { upsPhaseIndex }
+   ::= { upsPhaseTable 1 }
+
+UpsPhaseEntry ::= SEQUENCE {
+   upsPhaseIndex    INTEGER,
+   upsPhaseVoltage  INTEGER
+}
+
+upsPhaseIndex OBJECT-TYPE
+   SYNTAX INTEGER
+   ACCESS read-only
+   STATUS mandatory
+   DESCRIPTION "Phase index."
+   ::= { upsPhaseEntry 1 }
+
+upsPhaseVoltage OBJECT-TYPE
+   SYNTAX INTEGER
+   ACCESS read-only
+   STATUS mandatory
+   DESCRIPTION "Phase voltage."
+   DEFVAL { 0 }
+   ::= { upsPhaseEntry 2 }
+
+upsOnBattery TRAP-TYPE
+   ENTERPRISE testvendor
+   VARIABLES { upsBatteryStatus }
+   DESCRIPTION "The UPS has switched to battery backup power."
+   ::= 5
+
+END
+
+-- second module in the same file with absolute OID
+TEST-OTHER-MIB DEFINITIONS ::= BEGIN
+
+other OBJECT IDENTIFIER ::= { iso org(3) dod(6) internet(1) private(4) enterprises(1) 88888 }
+
+otherValue OBJECT-TYPE
+   SYNTAX INTEGER
+   ACCESS read-only
+   STATUS mandatory
+   DESCRIPTION "Value."
+   ::= { other 1 }
+
+END
+`
+
+type MibSuite struct {
+	testutils.Suite
+
+	tempDir  string
+	oldDirRm func()
+	dirs     []string
+}
+
+func (s *MibSuite) writeMib(dir, name, text string) {
+	s.Ck("can't create MIB dir", os.MkdirAll(dir, 0755))
+	s.Ck("can't write MIB", os.WriteFile(filepath.Join(dir, name), []byte(text), 0644))
+}
+
+func (s *MibSuite) SetupTestFixture(t *testing.T) {
+	s.tempDir, s.oldDirRm = testutils.SetupTempDir(t)
+
+	// vendor directory goes first to override standard modules
+	s.dirs = []string{filepath.Join(s.tempDir, "vendor"), filepath.Join(s.tempDir, "mibs"), filepath.Join(s.tempDir, "missing")}
+
+	s.writeMib(s.dirs[1], "SNMPv2-SMI.txt", testSnmpV2Smi)
+	s.writeMib(s.dirs[1], "SNMPv2-TC.txt", testSnmpV2Tc)
+	s.writeMib(s.dirs[1], "SNMPv2-MIB", testSnmpV2Mib)
+	s.writeMib(s.dirs[1], "TEST-UPS-MIB.my", strings.Replace(testUpsMib, "99999", "1", 1))
+	s.writeMib(s.dirs[0], "TEST-UPS-MIB.mib", testUpsMib)
+	s.writeMib(s.dirs[0], "BROKEN-MIB.txt", "BROKEN-MIB DEFINITIONS ::= BEGIN\nfoo OBJECT IDENTIFIER ::= { bar baz }\nEND\n")
+}
+
+func (s *MibSuite) TearDownTestFixture(t *testing.T) {
+	s.oldDirRm()
+}
+
+func (s *MibSuite) TestTokenize() {
+	tokens, err := tokenizeMib("a-b OBJECT IDENTIFIER -- comment -- ::= { c 1 } -- till end\n-- ::= \"x y\"\n\"multi\nline\"")
+	s.Ck("can't tokenize", err)
+
+	texts := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		texts = append(texts, t.text)
+	}
+	s.Equal([]string{"a-b", "OBJECT", "IDENTIFIER", "::=", "{", "c", "1", "}", "multi\nline", ""}, texts)
+
+	_, err = tokenizeMib("foo \"unterminated")
+	s.Error(err)
+}
+
+func (s *MibSuite) TestTranslate() {
+	mib := NewMib(s.dirs)
+
+	for name, oid := range map[string]string{
+		"SNMPv2-MIB::sysName.0":               ".1.3.6.1.2.1.1.5.0",
+		"SNMPv2-MIB::system":                  ".1.3.6.1.2.1.1",
+		"SNMPv2-MIB::snmpEnableAuthenTraps.0": ".1.3.6.1.2.1.11.30.0",
+		"SNMPv2-SMI::zeroDotZero":             ".0.0",
+		"TEST-UPS-MIB::upsPhaseVoltage.1.2":   ".1.3.6.1.4.1.99999.1.1.1.2.3.1.2.1.2",
+		"TEST-UPS-MIB::hardware":              ".1.3.6.1.4.1.99999.1.1",
+		"TEST-OTHER-MIB::otherValue.0":        ".1.3.6.1.4.1.88888.1.0",
+		"upsBatteryStatus.0":                  ".1.3.6.1.4.1.99999.1.1.1.2.1.0",
+		"sysDescr.0":                          ".1.3.6.1.2.1.1.1.0",
+		".1.3.6.1.2.1.1.5.0":                  ".1.3.6.1.2.1.1.5.0",
+		"1.3.6.1":                             ".1.3.6.1",
+	} {
+		res, err := mib.Translate(name)
+		if s.NoError(err, "can't translate %s", name) {
+			s.Equal(oid, res, "wrong translation of %s", name)
+		}
+	}
+
+	for name, message := range map[string]string{
+		"NO-SUCH-MIB::sysName.0":  "MIB module NO-SUCH-MIB is not found",
+		"SNMPv2-MIB::ifName.1":    "object ifName is not found in MIB module SNMPv2-MIB",
+		"noSuchObject.0":          "object noSuchObject is not found in MIB modules",
+		"SNMPv2-MIB::sysName.foo": "wrong index foo of sysName",
+		"BROKEN-MIB::foo":         "BROKEN-MIB.txt: module BROKEN-MIB: line 2",
+	} {
+		_, err := mib.Translate(name)
+		if s.Error(err, "%s is translated", name) {
+			s.Contains(err.Error(), message)
+		}
+	}
+
+	// broken module is reported on loading all modules
+	s.EnsureGotWarnings()
+}
+
+func (s *MibSuite) TestObject() {
+	mib := NewMib(s.dirs)
+	s.Ck("can't load SNMPv2-MIB", mib.LoadModule("SNMPv2-MIB"))
+	s.Ck("can't load TEST-UPS-MIB", mib.LoadModule("TEST-UPS-MIB"))
+
+	obj, index := mib.Object(".1.3.6.1.2.1.1.5.0")
+	if s.NotNil(obj) {
+		s.Equal("0", index)
+		s.Equal(MibObject{
+			Name:        "sysName",
+			Module:      "SNMPv2-MIB",
+			Oid:         ".1.3.6.1.2.1.1.5",
+			Kind:        "OBJECT-TYPE",
+			Type:        "DisplayString",
+			BaseType:    "OCTET STRING",
+			DisplayHint: "255a",
+			Access:      "read-write",
+		}, *obj)
+	}
+
+	obj, _ = mib.Object(".1.3.6.1.2.1.1.3.0")
+	if s.NotNil(obj) {
+		s.Equal("TimeTicks", obj.BaseType)
+		s.Equal("centiseconds", obj.Units)
+	}
+
+	// named numbers of object itself and of textual convention
+	obj, _ = mib.Object(".1.3.6.1.2.1.11.30.0")
+	if s.NotNil(obj) {
+		s.Equal(map[int64]string{1: "enabled", 2: "disabled"}, obj.Enums)
+	}
+	obj, _ = mib.Object(".1.3.6.1.2.1.11.31.0")
+	if s.NotNil(obj) {
+		s.Equal("INTEGER", obj.BaseType)
+		s.Equal(map[int64]string{1: "true", 2: "false"}, obj.Enums)
+	}
+
+	obj, _ = mib.Object(".1.3.6.1.4.1.99999.1.1.1.2.1.0")
+	if s.NotNil(obj) {
+		s.Equal("upsBatteryStatus", obj.Name)
+		s.Equal("read-only", obj.Access)
+		s.Equal(map[int64]string{1: "unknown", 2: "batteryNormal", 3: "batteryLow"}, obj.Enums)
+	}
+
+	obj, index = mib.Object(".1.3.6.1.4.1.99999.1.1.1.2.3.1.2.3")
+	if s.NotNil(obj) {
+		s.Equal("upsPhaseVoltage", obj.Name)
+		s.Equal("3", index)
+	}
+
+	obj, _ = mib.Object(".1.3.6.1.4.1.99999.1.1.1.2.2.0")
+	if s.NotNil(obj) {
+		s.Equal("Gauge", obj.BaseType)
+	}
+
+	obj, _ = mib.Object(".2.5")
+	s.Nil(obj)
+}
+
+func (s *MibSuite) TestTranslateConfig() {
+	device := &DeviceConfig{
+		Id:       "snmp_ups",
+		ProbeOid: "SNMPv2-MIB::sysUpTime.0",
+		Channels: map[string]*ChannelConfig{
+			"name":   &ChannelConfig{Name: "name", Oid: "SNMPv2-MIB::sysName.0"},
+			"status": &ChannelConfig{Name: "status", Oid: "TEST-UPS-MIB::upsBatteryStatus.0"},
+			"raw":    &ChannelConfig{Name: "raw", Oid: ".1.3.6.1.4.1.99999.5.0"},
+			"value":  &ChannelConfig{Name: "value", Oid: ".1.3.6.1.4.1.99999.1.1.1.2.1.0"},
+			"labels": &ChannelConfig{Name: "labels", Oid: "TEST-UPS-MIB::upsBatteryStatus.0", ControlType: "text", Enum: map[int64]string{2: "ok"}},
+			"phases": &ChannelConfig{Name: "phases", Oid: "TEST-UPS-MIB::upsPhaseVoltage", Table: true, LabelOid: "TEST-UPS-MIB::upsPhaseIndex"},
+		},
+	}
+	config := &DaemonConfig{MibDirs: s.dirs, Devices: map[string]*DeviceConfig{"snmp_ups": device}}
+
+	s.Ck("can't translate config", TranslateOidsInDaemonConfig(config))
+
+	s.Equal(".1.3.6.1.2.1.1.3.0", device.ProbeOid)
+	s.Equal(".1.3.6.1.2.1.1.5.0", device.Channels["name"].Oid)
+	s.Equal("sysName", device.Channels["name"].Object.Name)
+	s.Equal("upsBatteryStatus", device.Channels["status"].Object.Name)
+
+	// enumeration is taken from MIB for channels given by name only,
+	// numeric OID is translated after module is loaded by other channel
+	s.Equal(map[int64]string{1: "unknown", 2: "batteryNormal", 3: "batteryLow"}, device.Channels["status"].Enum)
+	s.Equal("upsBatteryStatus", device.Channels["value"].Object.Name)
+	s.Nil(device.Channels["value"].Enum)
+	s.Equal(map[int64]string{2: "ok"}, device.Channels["labels"].Enum)
+	s.Equal(".1.3.6.1.4.1.99999.5.0", device.Channels["raw"].Oid)
+	s.Nil(device.Channels["raw"].Object)
+	s.Equal(".1.3.6.1.4.1.99999.1.1.1.2.3.1.2", device.Channels["phases"].Oid)
+	s.Equal(".1.3.6.1.4.1.99999.1.1.1.2.3.1.1", device.Channels["phases"].LabelOid)
+
+	// all failed channels are reported
+	device.Channels["bad1"] = &ChannelConfig{Name: "bad1", Oid: "SNMPv2-MIB::sysNmae.0"}
+	device.Channels["bad2"] = &ChannelConfig{Name: "bad2", Oid: "UPS-MIB::upsBatteryStatus.0"}
+
+	err := TranslateOidsInDaemonConfig(config)
+	if s.Error(err) {
+		s.Contains(err.Error(), "can't resolve OID SNMPv2-MIB::sysNmae.0 of channel bad1 in snmp_ups: object sysNmae is not found in MIB module SNMPv2-MIB")
+		s.Contains(err.Error(), "can't resolve OID UPS-MIB::upsBatteryStatus.0 of channel bad2 in snmp_ups: MIB module UPS-MIB is not found")
+	}
+
+	// failed channels are marked, the rest are still translated
+	s.Contains(device.Channels["bad1"].Error, "object sysNmae is not found")
+	s.Contains(device.Channels["bad2"].Error, "MIB module UPS-MIB is not found")
+	s.Empty(device.Channels["name"].Error)
+	s.Equal(".1.3.6.1.2.1.1.5.0", device.Channels["name"].Oid)
+}
+
+func TestMib(t *testing.T) {
+	s := new(MibSuite)
+
+	s.SetupTestFixture(t)
+	defer s.TearDownTestFixture(t)
+
+	testutils.RunSuites(t, s)
+}
@@ -1,77 +1,98 @@
 package mqtt_snmp
 
 // OID translate module
-// Using `snmptranslate` utility from NetSNMP
+// Using MIB modules loaded by native MIB parser
 
 import (
 	"fmt"
-	"log"
-	"os/exec"
+	"sort"
 	"strings"
 )
 
-// Translates mixed OIDs/names to OIDs
-// using local `snmptranslate` utility and, so,
-// local installed MIBs
-func TranslateOids(oids []string) (out map[string]string, err error) {
-	var raw_out []byte
-
-	// call snmptranslate
-	log.Printf("command to run: snmptranslate %s -On", strings.Join(oids, " "))
-	cmd := exec.Command("snmptranslate", append(oids, "-On")...)
-	raw_out, err = cmd.Output()
-
+// Translate channel OID to numeric one
+func translateChannelOid(mib *Mib, d *DeviceConfig, c *ChannelConfig, name, oid string) (string, error) {
+	res, err := mib.Translate(oid)
 	if err != nil {
-		eout, _ := cmd.CombinedOutput()
-		err = fmt.Errorf("error translating OIDs: %s", string(eout))
-		return
+		return "", fmt.Errorf("can't resolve %s %s of channel %s in %s: %s", name, oid, c.Name, d.Id, err)
 	}
+	return res, nil
+}
 
-	// parse output of snmptranslate
-	out = make(map[string]string)
-	split := strings.Split(string(raw_out), "\n\n")
+// Translate all OIDs in given configuration
+// MIB objects of channels are set for further value conversion,
+// enumerations of objects are set to channels given by name,
+// channels which can't be translated are marked as failed and
+// reported at once
+func TranslateOidsInDaemonConfig(config *DaemonConfig) error {
+	mib := NewMib(config.MibDirs)
+	errs := make([]string, 0)
 
-	for i, value := range oids {
-		out[value] = strings.Trim(split[i], " \n")
+	// go in order to get stable error messages
+	devices := make([]string, 0, len(config.Devices))
+	for id := range config.Devices {
+		devices = append(devices, id)
 	}
+	sort.Strings(devices)
 
-	return
-}
+	for _, id := range devices {
+		device := config.Devices[id]
 
-// Translate all OIDs in given configuration
-func TranslateOidsInDaemonConfig(config *DaemonConfig) error {
-	// collect all unique OIDs into list
-	oids_set := make(map[string]bool)
+		// default probe is used if probe OID can't be translated
+		if device.ProbeOid != "" {
+			oid, err := mib.Translate(device.ProbeOid)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("can't resolve probe OID %s in %s: %s", device.ProbeOid, device.Id, err))
+				oid = DefaultProbeOid
+			}
+			device.ProbeOid = oid
+		}
 
-	for _, device := range config.Devices {
-		for _, channel := range device.Channels {
-			oids_set[channel.Oid] = true
+		channels := make([]string, 0, len(device.Channels))
+		for name := range device.Channels {
+			channels = append(channels, name)
 		}
-	}
+		sort.Strings(channels)
 
-	oids_list := make([]string, len(oids_set), len(oids_set)+1) // +1 for TranslateOids (for not to waste time on reallocations)
+		for _, name := range channels {
+			ch := device.Channels[name]
+			if ch.Computed != nil {
+				continue
+			}
 
-	i := 0
-	for key := range oids_set {
-		oids_list[i] = key
-		i += 1
-	}
+			oid, err := translateChannelOid(mib, device, ch, "OID", ch.Oid)
+			if err != nil {
+				ch.Error = err.Error()
+				errs = append(errs, ch.Error)
+				continue
+			}
 
-	// parse list
-	tmap, err := TranslateOids(oids_list)
-	if err != nil {
-		return err
-	}
+			if ch.LabelOid != "" {
+				labelOid, err := translateChannelOid(mib, device, ch, "label OID", ch.LabelOid)
+				if err != nil {
+					ch.Error = err.Error()
+					errs = append(errs, ch.Error)
+					continue
+				}
+				ch.LabelOid = labelOid
+			}
+
+			// enumeration of object is used for channels given by name
+			// unless it's set in config
+			symbolic := !isNumericOid(ch.Oid)
 
-	// translate OIDs in config
-	for dev_key, device := range config.Devices {
-		for ch_key := range device.Channels {
-			// TODO: it's a Go bullshit' workaround
-			tmp := config.Devices[dev_key].Channels[ch_key]
-			tmp.Oid = tmap[tmp.Oid]
-			config.Devices[dev_key].Channels[ch_key] = tmp
+			ch.Oid = oid
+			if obj, _ := mib.Object(oid); obj != nil && obj.Kind == "OBJECT-TYPE" {
+				ch.Object = obj
+				if symbolic && ch.Enum == nil && len(obj.Enums) > 0 {
+					ch.SetEnum(obj.Enums)
+				}
+			}
 		}
 	}
 
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
 	return nil
 }
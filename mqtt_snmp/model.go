@@ -2,13 +2,16 @@ package mqtt_snmp
 
 import (
 	"fmt"
+	"math"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 	"unicode/utf8"
 
 	"github.com/contactless/wbgo"
-	"github.com/wirenboard/gosnmp"
+	"github.com/gosnmp/gosnmp"
+	"github.com/wirenboard/wb-mqtt-snmp/snmp_oid"
 )
 
 const (
@@ -16,6 +19,10 @@ const (
 	CHAN_BUFFER_SIZE = 128
 )
 
+// Minimal interval between attempts to create SNMP session
+// of failed device
+var DeviceReconnectInterval = 30 * time.Second
+
 // SNMP device object
 type SnmpDevice struct {
 	wbgo.DeviceBase
@@ -29,9 +36,53 @@ type SnmpDevice struct {
 	// Device errors
 	Error map[*ChannelConfig]string
 
-	// SNMP connection
+	// Time of last published value of channels
+	Published map[*ChannelConfig]time.Time
+
+	// Last received values of channels, published ones in Cache
+	// may be older because of deadband and publish interval
+	Latest map[*ChannelConfig]string
+
+	// Alarm states of channels with thresholds
+	Alarms map[*ChannelConfig]*alarmState
+
+	// Previous counter samples of rate channels
+	Samples map[*ChannelConfig]CounterSample
+
+	// Computed channels depending on each input channel
+	computed map[*ChannelConfig][]*ChannelConfig
+
+	// SNMP connection, nil if device has failed to create it
 	snmp SnmpInterface
 
+	// Error of last attempt to create SNMP connection and its time
+	snmpError     error
+	snmpErrorTime time.Time
+
+	// Factory to create SNMP connection again
+	snmpFactory SnmpFactory
+	debug       bool
+
+	// Channel to send write queries to workers
+	writeChannel chan<- WriteQuery
+
+	// Channel to report write errors to publisher
+	errorChannel chan<- PollError
+
+	// Pseudo-channel for traps not matched to any channel,
+	// nil if last trap control is disabled
+	trapChannel *ChannelConfig
+
+	// Online/offline state of device
+	availability *deviceAvailability
+
+	// Table rows channels: map from table channel to
+	// map from row index to row channel
+	rows map[*ChannelConfig]map[string]*ChannelConfig
+
+	// Mutex to protect rows and channels of config changed on reload
+	rowsMutex sync.RWMutex
+
 	// Mutex to protect SNMP connection
 	mutex sync.Mutex
 }
@@ -46,97 +97,278 @@ func ConvertSnmpValue(v gosnmp.SnmpPDU) (data string, valid bool) {
 	case gosnmp.Counter32:
 		fallthrough
 	case gosnmp.Counter64:
-		var d uint64
-		d, valid = v.Value.(uint64)
-		if !valid {
-			return
-		}
-		data = fmt.Sprintf("%d", d)
-		valid = true
-
+		fallthrough
 	case gosnmp.Integer:
 		fallthrough
 	case gosnmp.Uinteger32:
-		var d int
-		d, valid = v.Value.(int)
-		if !valid {
+		if v.Value == nil {
 			return
 		}
-		data = fmt.Sprintf("%d", d)
+		data = gosnmp.ToBigInt(v.Value).String()
 		valid = true
 
 	case gosnmp.OctetString:
-		data, valid = v.Value.(string)
-
-		// check also if value is a text string
-		// TODO: implement DISPLAY-HINT to convert compound values
-		valid = utf8.Valid([]byte(data))
-	case gosnmp.IpAddress:
-		var d net.IP
-		d, valid = v.Value.(net.IP)
+		var d []byte
+		d, valid = v.Value.([]byte)
 		if !valid {
 			return
 		}
-		data = d.String()
-		valid = true
+
+		// check also if value is a text string,
+		// binary values are converted by channel format
+		data = string(d)
+		valid = utf8.Valid(d)
+	case gosnmp.IPAddress:
+		data, valid = v.Value.(string)
 	case gosnmp.TimeTicks:
-		var d uint64
-		d, valid = v.Value.(uint64)
+		var d uint32
+		d, valid = v.Value.(uint32)
 		if !valid {
 			return
 		}
-		data = fmt.Sprintf("%s", time.Duration(d*10)*time.Millisecond)
+		data = fmt.Sprintf("%s", time.Duration(d)*10*time.Millisecond)
 		valid = true
 	}
 
 	return
 }
 
+// MakeSnmpPDU converts string value into variable of given SNMP type
+func MakeSnmpPDU(oid string, t gosnmp.Asn1BER, value string) (pdu gosnmp.SnmpPDU, err error) {
+	pdu = gosnmp.SnmpPDU{Name: oid, Type: t}
+
+	switch t {
+	case gosnmp.Integer:
+		var f float64
+		if f, err = strconv.ParseFloat(value, 64); err != nil {
+			return
+		}
+		if f < math.MinInt32 || f > math.MaxInt32 {
+			err = fmt.Errorf("value %s is out of Integer range", value)
+			return
+		}
+		pdu.Value = int(math.Round(f))
+
+	case gosnmp.Counter32:
+		fallthrough
+	case gosnmp.Gauge32:
+		fallthrough
+	case gosnmp.TimeTicks:
+		fallthrough
+	case gosnmp.Uinteger32:
+		var f float64
+		if f, err = strconv.ParseFloat(value, 64); err != nil {
+			return
+		}
+		if f < 0 || f > math.MaxUint32 {
+			err = fmt.Errorf("value %s is out of %s range", value, t)
+			return
+		}
+		pdu.Value = uint32(math.Round(f))
+
+	case gosnmp.Counter64:
+		// integers are parsed exactly, float loses precision above 2^53
+		if d, e := strconv.ParseUint(value, 10, 64); e == nil {
+			pdu.Value = d
+			return
+		}
+		var f float64
+		if f, err = strconv.ParseFloat(value, 64); err != nil {
+			return
+		}
+		if f < 0 || f >= math.Exp2(64) {
+			err = fmt.Errorf("value %s is out of %s range", value, t)
+			return
+		}
+		pdu.Value = uint64(math.Round(f))
+
+	case gosnmp.OctetString:
+		pdu.Value = value
+
+	case gosnmp.IPAddress:
+		if net.ParseIP(value) == nil {
+			err = fmt.Errorf("%s is not an IP address", value)
+			return
+		}
+		pdu.Value = value
+
+	case gosnmp.ObjectIdentifier:
+		pdu.Value = value
+
+	default:
+		err = fmt.Errorf("can't set value of type %s", t)
+	}
+
+	return
+}
+
 // Create new SNMP device instance from config tree
-func newSnmpDevice(snmpFactory SnmpFactory, config *DeviceConfig, debug bool) (device *SnmpDevice, err error) {
-	snmp, err := snmpFactory(config.Address, config.Community, config.SnmpVersion, int64(config.SnmpTimeout), debug)
+// Device is created even if SNMP session can't be created,
+// so it's marked as failed and session is created again later
+func newSnmpDevice(snmpFactory SnmpFactory, config *DeviceConfig, debug bool) *SnmpDevice {
+	device := &SnmpDevice{
+		DeviceBase:   wbgo.DeviceBase{DevName: config.Id, DevTitle: config.Name},
+		Config:       config,
+		Cache:        make(map[*ChannelConfig]string),
+		Error:        make(map[*ChannelConfig]string),
+		Published:    make(map[*ChannelConfig]time.Time),
+		Latest:       make(map[*ChannelConfig]string),
+		Samples:      make(map[*ChannelConfig]CounterSample),
+		rows:         make(map[*ChannelConfig]map[string]*ChannelConfig),
+		snmpFactory:  snmpFactory,
+		debug:        debug,
+		availability: newDeviceAvailability(config),
+	}
+
+	if config.LastTrapControl {
+		device.trapChannel = newTrapChannel(config)
+	}
+
+	device.computed = computedChannels(config)
+	device.Alarms = newAlarmStates(config)
+
+	if _, err := device.session(); err != nil {
+		wbgo.Error.Printf("device %s is failed: %s", config.Id, err)
+	}
+
+	return device
+}
+
+// Get SNMP session of device, create it if it's not created yet
+// Attempts are made not more often than DeviceReconnectInterval
+// Must be called with mutex locked
+func (d *SnmpDevice) session() (SnmpInterface, error) {
+	if d.snmp != nil {
+		return d.snmp, nil
+	}
+
+	if d.snmpError != nil && time.Since(d.snmpErrorTime) < DeviceReconnectInterval {
+		return nil, d.snmpError
+	}
+
+	snmp, err := d.snmpFactory(d.Config, d.debug)
 	if err != nil {
-		return
+		d.snmpError = fmt.Errorf("can't create SNMP session: %s", err)
+		d.snmpErrorTime = time.Now()
+		return nil, d.snmpError
 	}
 
-	device = &SnmpDevice{
-		DeviceBase: wbgo.DeviceBase{DevName: config.Id, DevTitle: config.Name},
-		snmp:       snmp,
-		Config:     config,
-		Cache:      make(map[*ChannelConfig]string),
-		Error:      make(map[*ChannelConfig]string),
+	if d.snmpError != nil {
+		wbgo.Info.Printf("device %s is recovered", d.DevName)
 	}
+	d.snmp, d.snmpError = snmp, nil
 
+	return snmp, nil
+}
+
+func (d *SnmpDevice) Get(oids []string) (packet *gosnmp.SnmpPacket, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	err = d.request(func(snmp SnmpInterface) (err error) {
+		packet, err = snmp.Get(oids)
+		return
+	})
 	return
 }
 
-func (d *SnmpDevice) Get(oid string) (*gosnmp.SnmpPacket, error) {
+func (d *SnmpDevice) Walk(oid string) (pdus []gosnmp.SnmpPDU, err error) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
-	return d.snmp.Get(oid)
+
+	err = d.request(func(snmp SnmpInterface) (err error) {
+		pdus, err = snmp.Walk(oid)
+		return
+	})
+	return
 }
 
-// TODO: receive values from MQTT and send it to SNMP?
-func (d *SnmpDevice) AcceptValue(name, value string)        {}
-func (d *SnmpDevice) AcceptOnValue(name, value string) bool { return false }
-func (d *SnmpDevice) IsVirtual() bool                       { return false }
+// Write value of channel to device via SNMP SET
+// Value is converted back to raw form by channel InvConv
+func (d *SnmpDevice) Write(channel *ChannelConfig, value string) error {
+	pdu, err := MakeSnmpPDU(channel.Oid, channel.SetType, channel.InvConv(value))
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var packet *gosnmp.SnmpPacket
+	err = d.request(func(snmp SnmpInterface) (err error) {
+		packet, err = snmp.Set([]gosnmp.SnmpPDU{pdu})
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	if packet.Error != gosnmp.NoError {
+		return fmt.Errorf("SET failed: %s", packet.Error)
+	}
+
+	return nil
+}
+
+func (d *SnmpDevice) AcceptValue(name, value string) {}
+
+// Receive value from MQTT and send it to write worker
+// Value is not echoed back: it is published after read-back
+func (d *SnmpDevice) AcceptOnValue(name, value string) bool {
+	channel := d.channelByName(name)
+	if channel == nil || !channel.Writable {
+		wbgo.Warn.Printf("%s: channel %s is not writable", d.DevName, name)
+		return false
+	}
+
+	if d.writeChannel == nil {
+		wbgo.Warn.Printf("%s: can't write channel %s: model is not started", d.DevName, name)
+		return false
+	}
+
+	// driver goroutine must not wait for busy workers
+	select {
+	case d.writeChannel <- WriteQuery{Channel: channel, Value: value}:
+	default:
+		wbgo.Error.Printf("%s: can't write channel %s: write queue is full", d.DevName, name)
+		select {
+		case d.errorChannel <- PollError{Channel: channel, Error: "write queue is full", Write: true}:
+		default:
+		}
+	}
+
+	return false
+}
+
+func (d *SnmpDevice) IsVirtual() bool { return false }
 
 // SNMP device model
 type SnmpModel struct {
 	wbgo.ModelBase
 	config *DaemonConfig
 
+	// Factory to create SNMP connections of devices
+	snmpFactory SnmpFactory
+
 	// devices list
 	devices []*SnmpDevice
 
 	// devices associated with their channels
 	DeviceChannelMap map[*ChannelConfig]*SnmpDevice
 
+	// devices associated with their configs,
+	// used for table rows created in runtime
+	deviceConfigMap map[*DeviceConfig]*SnmpDevice
+
+	// Mutex to protect devices maps which are changed on config reload
+	devicesMutex sync.RWMutex
+
 	// Poll schedule table
 	pollTable *PollTable
 
 	// Channels to exchange data between workers and replier
 	queryChannel         chan PollQuery
+	writeChannel         chan WriteQuery
 	resultChannel        chan PollResult
 	errorChannel         chan PollError
 	quitChannels         []chan struct{}
@@ -144,8 +376,53 @@ type SnmpModel struct {
 	pubDoneChannel       chan struct{}
 	pollTimerDoneChannel chan struct{}
 
-	// Poll timer to sync poll procedures
+	// Functions to run in publisher worker, i.e. config reload
+	taskChannel chan func()
+
+	// Poll timer to sync poll procedures and time it is set to,
+	// zero if timer is stopped
 	pollTimer wbgo.RTimer
+	nextPoll  time.Time
+
+	// Channels of queries which are being processed by poll workers,
+	// they are not sent again until previous poll is completed
+	inFlight      map[*ChannelConfig]bool
+	inFlightMutex sync.Mutex
+
+	// Scheduler statistics
+	stats      PollStats
+	statsMutex sync.Mutex
+
+	// Running poll workers and publisher
+	workers sync.WaitGroup
+
+	// Trap receiver, nil if disabled
+	trapListener *gosnmp.TrapListener
+
+	// Map from trap source IP to devices
+	trapDevices map[string][]*SnmpDevice
+
+	// Remover of table rows controls, optional
+	Remover ControlRemover
+
+	// Publisher of device error meta, optional
+	DeviceErrors DeviceErrorPublisher
+
+	// Publisher of control meta not supported by wbgo driver, optional
+	ControlMeta ControlMetaPublisher
+
+	// Publisher of device JSON meta, optional
+	DeviceMeta DeviceMetaPublisher
+
+	// Remover of device meta on config reload, optional
+	DeviceRemover DeviceRemover
+}
+
+// Optional model observer extension to publish control meta
+// which is not supported by wbgo driver, empty meta name
+// stands for JSON meta topic of control
+type ControlMetaPublisher interface {
+	OnControlMeta(dev wbgo.DeviceModel, control, meta, value string)
 }
 
 // SNMP model constructor
@@ -153,27 +430,16 @@ func NewSnmpModel(snmpFactory SnmpFactory, config *DaemonConfig, start time.Time
 	err = nil
 
 	model = &SnmpModel{
-		config: config,
+		config:      config,
+		snmpFactory: snmpFactory,
 	}
 
 	// init all devices from configuration
-	model.devices = make([]*SnmpDevice, len(model.config.Devices))
+	model.devices = make([]*SnmpDevice, 0, len(model.config.Devices))
 	model.DeviceChannelMap = make(map[*ChannelConfig]*SnmpDevice)
-	i := 0
-	for dev := range model.config.Devices {
-		if model.devices[i], err = newSnmpDevice(snmpFactory, model.config.Devices[dev], config.Debug); err != nil {
-			wbgo.Error.Fatalf("can't create SNMP device: %s", err)
-		}
-
-		for ch := range model.config.Devices[dev].Channels {
-			model.DeviceChannelMap[model.config.Devices[dev].Channels[ch]] = model.devices[i]
-		}
-
-		i += 1
-	}
-
-	if err != nil {
-		return
+	model.deviceConfigMap = make(map[*DeviceConfig]*SnmpDevice)
+	for _, dev := range model.config.Devices {
+		model.addDevice(newSnmpDevice(snmpFactory, dev, config.Debug))
 	}
 
 	// fill poll table
@@ -185,63 +451,298 @@ func NewSnmpModel(snmpFactory SnmpFactory, config *DaemonConfig, start time.Time
 	return
 }
 
+// Add device to devices list and maps
+// Must be called with devices mutex locked if model is started
+func (m *SnmpModel) addDevice(dev *SnmpDevice) {
+	m.devices = append(m.devices, dev)
+
+	for _, ch := range dev.Config.Channels {
+		m.DeviceChannelMap[ch] = dev
+	}
+
+	m.deviceConfigMap[dev.Config] = dev
+
+	if dev.trapChannel != nil {
+		m.DeviceChannelMap[dev.trapChannel] = dev
+	}
+}
+
 // Form queries from config and fill poll table
 func (m *SnmpModel) formQueries(deadline time.Time) {
+	// push that queues into poll table
+	for interval, lst := range deviceQueries(m.config.Devices, deadline) {
+		m.pollTable.AddQueue(NewPollQueue(lst), interval)
+	}
+}
+
+// Form queries of devices channels with given deadline
+// grouped by poll interval
+func deviceQueries(devices map[string]*DeviceConfig, deadline time.Time) map[int][]PollQuery {
+	channels := make([]*ChannelConfig, 0)
+	for _, dev := range devices {
+		for _, ch := range dev.Channels {
+			channels = append(channels, ch)
+		}
+	}
+
+	return channelQueries(channels, deadline)
+}
+
+// Form queries of channels with given deadline grouped by poll interval
+func channelQueries(channels []*ChannelConfig, deadline time.Time) map[int][]PollQuery {
 	// create map from intervals to queries
 	queries := make(map[int][]PollQuery)
 
-	// go through config file and fill queries map
-	for _, dev := range m.config.Devices {
-		for _, ch := range dev.Channels {
-			if _, ok := queries[ch.PollInterval]; !ok {
-				queries[ch.PollInterval] = make([]PollQuery, 0, 5)
+	for _, ch := range channels {
+		// failed and computed channels are never polled
+		if ch.Error != "" || ch.Computed != nil {
+			continue
+		}
+
+		if _, ok := queries[ch.PollInterval]; !ok {
+			queries[ch.PollInterval] = make([]PollQuery, 0, 5)
+		}
+
+		// form query
+		q := PollQuery{
+			Channel:  ch,
+			Deadline: deadline,
+		}
+
+		queries[ch.PollInterval] = append(queries[ch.PollInterval], q)
+	}
+
+	return queries
+}
+
+// Get device of channel, nil if device or channel is removed on config reload
+// Table rows and probes are not in DeviceChannelMap, so they are found by device config
+func (m *SnmpModel) channelDevice(channel *ChannelConfig) *SnmpDevice {
+	m.devicesMutex.RLock()
+	defer m.devicesMutex.RUnlock()
+
+	if dev, ok := m.DeviceChannelMap[channel]; ok {
+		return dev
+	}
+	if dev, ok := m.deviceConfigMap[channel.Device]; ok && (channel == dev.availability.probeChannel || dev.isRow(channel)) {
+		return dev
+	}
+	return nil
+}
+
+// Group due queries of the same device and poll interval
+// into batches to be requested in single GET PDU
+// Table channels are walked and probes are sent alone, so they are never batched
+func (m *SnmpModel) batchQueries(queries []PollQuery) []PollQuery {
+	type batchKey struct {
+		dev      *SnmpDevice
+		interval int
+	}
+
+	// index of last batch of each key in result
+	open := make(map[batchKey]int)
+	res := make([]PollQuery, 0, len(queries))
+
+	for _, q := range queries {
+		dev := m.channelDevice(q.Channel)
+		if q.Channel.Table || q.Probe || dev.Config.MaxVarbinds <= 1 {
+			res = append(res, q)
+			continue
+		}
+
+		key := batchKey{dev, q.Channel.PollInterval}
+		if i, ok := open[key]; ok && requestVarbinds(append(res[i].Channels(), q.Channel)) <= dev.Config.MaxVarbinds {
+			res[i].Batch = append(res[i].Batch, q.Channel)
+			continue
+		}
+
+		open[key] = len(res)
+		res = append(res, q)
+	}
+
+	return res
+}
+
+// Check if there are rate channels in list
+func hasRateChannels(channels []*ChannelConfig) bool {
+	for _, ch := range channels {
+		if ch.Rate != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Get number of variables in GET request of channels,
+// agent uptime is requested with rate channels
+func requestVarbinds(channels []*ChannelConfig) int {
+	if hasRateChannels(channels) {
+		return len(channels) + 1
+	}
+	return len(channels)
+}
+
+// Find variable of OID in response
+// Agent must keep order of variables, but check names anyway
+func findVariable(vars []gosnmp.SnmpPDU, i int, oid string) (v gosnmp.SnmpPDU, ok bool) {
+	oid = snmp_oid.Normalize(oid)
+	if i < len(vars) && snmp_oid.Normalize(vars[i].Name) == oid {
+		return vars[i], true
+	}
+
+	for _, v := range vars {
+		if snmp_oid.Normalize(v.Name) == oid {
+			return v, true
+		}
+	}
+
+	return
+}
+
+// Send poll error of channel to publisher worker
+func (m *SnmpModel) sendPollError(dev *SnmpDevice, channel *ChannelConfig, message string, write bool, err chan PollError) {
+	wbgo.Error.Printf("failed to poll %s:%s: %s", dev.DevName, channel.Name, message)
+	err <- PollError{Channel: channel, Error: message, Write: write}
+}
+
+// Poll channels of the same device in single GET request and
+// send result (or error) of each channel to publisher worker
+// Request is split and retried if agent can't process it as a whole
+func (m *SnmpModel) pollChannels(id int, channels []*ChannelConfig, write bool, res chan PollResult, err chan PollError) {
+	dev := m.channelDevice(channels[0])
+	if dev == nil {
+		wbgo.Debug.Printf("[poller %d] Drop query of %s: device is removed", id, channels[0].Name)
+		return
+	}
+
+	oids := make([]string, len(channels))
+	for i, ch := range channels {
+		oids[i] = ch.Oid
+	}
+
+	// agent uptime is requested with counters to detect agent restarts,
+	// by separate request if agent accepts single variable only
+	withUptime := hasRateChannels(channels)
+	batchUptime := withUptime && dev.Config.MaxVarbinds > 1
+	if batchUptime {
+		oids = append(oids, sysUpTimeOid)
+	}
+
+	packet, e := dev.Get(oids)
+	now := timeNow()
+	if e != nil {
+		for _, ch := range channels {
+			m.sendPollError(dev, ch, e.Error(), write, err)
+		}
+		return
+	}
+
+	if packet.Error != gosnmp.NoError {
+		bad := int(packet.ErrorIndex) - 1
+
+		switch {
+		case packet.Error == gosnmp.TooBig && len(channels) > 1:
+			// response doesn't fit in agent buffer, so try halves
+			wbgo.Debug.Printf("[poller %d] Response of %d variables from %s is too big, split request", id, len(channels), dev.DevName)
+			half := len(channels) / 2
+			m.pollChannels(id, channels[:half], write, res, err)
+			m.pollChannels(id, channels[half:], write, res, err)
+
+		case bad >= 0 && bad < len(channels) && len(channels) > 1:
+			// SNMPv1 agent fails whole request because of single variable
+			m.sendPollError(dev, channels[bad], packet.Error.String(), write, err)
+			rest := make([]*ChannelConfig, 0, len(channels)-1)
+			rest = append(rest, channels[:bad]...)
+			rest = append(rest, channels[bad+1:]...)
+			m.pollChannels(id, rest, write, res, err)
+
+		default:
+			for _, ch := range channels {
+				m.sendPollError(dev, ch, packet.Error.String(), write, err)
 			}
+		}
+		return
+	}
 
-			// form query
-			q := PollQuery{
-				Channel:  ch,
-				Deadline: deadline,
+	var uptime gosnmp.SnmpPDU
+	if batchUptime {
+		uptime, _ = findVariable(packet.Variables, len(channels), sysUpTimeOid)
+	} else if withUptime {
+		uptime = dev.getUptime()
+	}
+
+	for i, ch := range channels {
+		v, ok := findVariable(packet.Variables, i, ch.Oid)
+		if !ok {
+			m.sendPollError(dev, ch, "no instance in response", write, err)
+			continue
+		}
+
+		if ch.Rate != 0 {
+			sample, e := newCounterSample(v, now)
+			if e != nil {
+				m.sendPollError(dev, ch, e.Error(), write, err)
+				continue
 			}
+			sample.setUptime(uptime)
+			res <- PollResult{Channel: ch, Sample: &sample, Write: write}
+			continue
+		}
 
-			queries[ch.PollInterval] = append(queries[ch.PollInterval], q)
+		data, valid := ch.ConvertValue(v)
+		if !valid {
+			errorMessage := fmt.Sprintf("failed to poll %s:%s: instance can't be converted to string", dev.DevName, ch.Name)
+			wbgo.Error.Printf(errorMessage)
+			err <- PollError{Channel: ch, Error: errorMessage, Write: write}
+			continue
 		}
-	}
 
-	// push that queues into poll table
-	for interval, lst := range queries {
-		m.pollTable.AddQueue(NewPollQueue(lst), interval)
+		data, e := ch.Convert(data)
+		if e != nil {
+			m.sendPollError(dev, ch, e.Error(), write, err)
+			continue
+		}
+		wbgo.Debug.Printf("[poller %d] Send result for %s: %v", id, ch.Name, data)
+		res <- PollResult{Channel: ch, Data: data, Write: write}
 	}
 }
 
 // Reader worker
 // Receives poll query, perform SNMP transaction and
 // send result (or error) to publisher worker
-func (m *SnmpModel) PollWorker(id int, req <-chan PollQuery, res chan PollResult, err chan PollError, quit <-chan struct{}, done chan struct{}) {
+// Also performs write queries: SNMP SET followed by read-back
+func (m *SnmpModel) PollWorker(id int, req <-chan PollQuery, wr <-chan WriteQuery, res chan PollResult, err chan PollError, quit <-chan struct{}, done chan struct{}) {
 LPollWorker:
 	for {
 		select {
 		case r := <-req:
-			wbgo.Debug.Printf("[poller %d] Receive request %v\n", id, r.Channel.Oid)
-			// process query
-			dev := m.DeviceChannelMap[r.Channel]
-			packet, e := dev.Get(r.Channel.Oid)
-			if e != nil {
-				wbgo.Error.Printf("failed to poll %s:%s: %s", dev.DevName, r.Channel.Name, e)
-				err <- PollError{Channel: r.Channel, Error: e.Error()}
-			} else {
-				for i := range packet.Variables {
-					data, valid := ConvertSnmpValue(packet.Variables[i])
-					if !valid {
-						errorMessage := fmt.Sprintf("failed to poll %s:%s: instance can't be converted to string", dev.DevName, r.Channel.Name)
-						wbgo.Error.Printf(errorMessage)
-						err <- PollError{Channel: r.Channel, Error: errorMessage}
-					} else {
-						wbgo.Debug.Printf("[poller %d] Send result for request %v: %v", id, r, data)
-						res <- PollResult{Channel: r.Channel, Data: r.Channel.Conv(data)}
-					}
+			wbgo.Debug.Printf("[poller %d] Receive request %v (+%d batched)\n", id, r.Channel.Oid, len(r.Batch))
+			if r.Probe {
+				// availability of device is updated by request itself
+				if dev := m.channelDevice(r.Channel); dev == nil {
+					wbgo.Debug.Printf("[poller %d] Drop probe of %s: device is removed", id, r.Channel.Device.Id)
+				} else if _, e := dev.Get([]string{r.Channel.Oid}); e != nil {
+					wbgo.Debug.Printf("[poller %d] Probe of %s failed: %s", id, r.Channel.Device.Id, e)
 				}
+			} else if r.Channel.Table {
+				m.walkTable(id, r.Channel, res, err)
+			} else {
+				m.pollChannels(id, r.Channels(), false, res, err)
 			}
+			m.completeQuery(r)
 			done <- struct{}{}
+		case w := <-wr:
+			wbgo.Debug.Printf("[poller %d] Receive write request %v: %s\n", id, w.Channel.Oid, w.Value)
+			dev := m.channelDevice(w.Channel)
+			if dev == nil {
+				wbgo.Warn.Printf("can't write %s:%s: device is removed", w.Channel.Device.Id, w.Channel.Name)
+			} else if e := dev.Write(w.Channel, w.Value); e != nil {
+				wbgo.Error.Printf("failed to write %s:%s: %s", dev.DevName, w.Channel.Name, e)
+				err <- PollError{Channel: w.Channel, Error: e.Error(), Write: true}
+			} else {
+				// read value back to publish real device state
+				m.pollChannels(id, []*ChannelConfig{w.Channel}, true, res, err)
+			}
 		case <-quit:
 			done <- struct{}{}
 			break LPollWorker
@@ -249,6 +750,58 @@ LPollWorker:
 	}
 }
 
+// Publish value of channel, create control if it's a new one
+func (m *SnmpModel) publishData(dev *SnmpDevice, channel *ChannelConfig, data string) {
+	dev.Latest[channel] = data
+
+	// try to get value from cache
+	val, ok := dev.Cache[channel]
+	if !ok {
+		// create value in cache and create new control in MQTT
+		dev.Cache[channel] = data
+		dev.Error[channel] = ""
+		dev.Published[channel] = timeNow()
+		wbgo.Debug.Printf("[publisher] Create new control for channel %+v\n", *channel)
+		dev.Observer.OnNewControl(dev, newChannelControl(channel, data))
+		m.publishControlMeta(dev, channel)
+	} else {
+		// send new value only if it has been changed enough
+		// or if it's time to repeat it
+		if now := timeNow(); dev.needPublish(channel, val, data, now) {
+			dev.Cache[channel] = data
+			dev.Published[channel] = now
+			dev.Observer.OnValue(dev, channel.Name, data)
+		}
+		err, ok := dev.Error[channel]
+		if ok && err != "" {
+			dev.Error[channel] = ""
+			dev.Observer.OnError(dev, channel.Name, "")
+		}
+	}
+
+	m.publishAlarm(dev, channel, data)
+}
+
+// Publish error of channel, create control if it's a new one
+func (m *SnmpModel) publishError(dev *SnmpDevice, channel *ChannelConfig, errorValue string) {
+	_, ok := dev.Cache[channel]
+	if !ok {
+		wbgo.Debug.Printf("[publisher] Create new control for channel %+v\n", *channel)
+		dev.Observer.OnNewControl(dev, newChannelControl(channel, ""))
+		m.publishControlMeta(dev, channel)
+		dev.Cache[channel] = ""
+		dev.Error[channel] = ""
+	}
+
+	err, ok := dev.Error[channel]
+	if ok && err != errorValue {
+		dev.Error[channel] = errorValue
+		dev.Observer.OnError(dev, channel.Name, errorValue)
+	}
+
+	m.publishAlarmError(dev, channel, errorValue)
+}
+
 // Publisher worker
 // Receives new values from Reader workers
 func (m *SnmpModel) PublisherWorker(data <-chan PollResult, err <-chan PollError, quit, done chan struct{}) {
@@ -260,61 +813,44 @@ LPublisherWorker:
 
 			// process received data
 			// get device of given channel
-			dev := m.DeviceChannelMap[d.Channel]
+			dev := m.channelDevice(d.Channel)
 
 			if dev == nil {
-				panic(fmt.Sprintf("device is not found for channel: %+v", d.Channel))
+				// device is removed on config reload while channel was polled
+				wbgo.Debug.Printf("[publisher] Drop data of %s: device is not found", d.Channel.Name)
+			} else if d.Channel.Table {
+				m.publishTable(dev, d.Channel, d.Rows)
+			} else if d.Sample != nil {
+				m.publishRate(dev, d.Channel, *d.Sample)
+			} else {
+				m.publishData(dev, d.Channel, d.Data)
+			}
+			if dev != nil {
+				m.publishComputed(dev, d.Channel)
+				m.publishAvailability(dev)
 			}
 
-			// try to get value from cache
-			val, ok := dev.Cache[d.Channel]
-			if !ok {
-				// create value in cache and create new control in MQTT
-				dev.Cache[d.Channel] = d.Data
-				dev.Error[d.Channel] = ""
-				// TODO: read-only, max value and retain flags
-				controlType := d.Channel.ControlType
-				if d.Channel.Units != "" {
-					controlType = controlType + ":" + d.Channel.Units
-				}
-				wbgo.Debug.Printf("[publisher] Create new control for channel %+v\n", *(d.Channel))
-				dev.Observer.OnNewControl(dev, wbgo.Control{Name: d.Channel.Name, Type: controlType, Value: d.Data, Order: d.Channel.Order})
-			} else {
-				if val != d.Data {
-					dev.Cache[d.Channel] = d.Data
-					// send new value only if it has been changed
-					dev.Observer.OnValue(dev, d.Channel.Name, d.Data)
-				}
-				err, ok := dev.Error[d.Channel]
-				if ok && err != "" {
-					dev.Error[d.Channel] = ""
-					dev.Observer.OnError(dev, d.Channel.Name, "")
-				}
+			// write queries and traps are not counted by poll timer
+			if !d.Write && !d.Trap {
+				done <- struct{}{}
 			}
-			done <- struct{}{}
 		case e := <-err:
 			// error handling
 			// get device of given channel
-			dev := m.DeviceChannelMap[e.Channel]
+			dev := m.channelDevice(e.Channel)
 
 			if dev == nil {
-				panic(fmt.Sprintf("device is not found for channel: %+v", e.Channel))
-			}
-			_, ok := dev.Cache[e.Channel]
-			if !ok {
-				wbgo.Debug.Printf("[publisher] Create new control for channel %+v\n", *(e.Channel))
-				dev.Observer.OnNewControl(dev, wbgo.Control{Name: e.Channel.Name, Type: e.Channel.ControlType, Order: e.Channel.Order})
-				dev.Cache[e.Channel] = ""
-				dev.Error[e.Channel] = "r"
+				// device is removed on config reload while channel was polled
+				wbgo.Debug.Printf("[publisher] Drop error of %s: device is not found", e.Channel.Name)
+			} else {
+				m.publishPollError(dev, e)
 			}
 
-			err, ok := dev.Error[e.Channel]
-			if ok && err != "r" {
-				dev.Error[e.Channel] = "r"
-				dev.Observer.OnError(dev, e.Channel.Name, "r")
+			if !e.Write && !e.Trap {
+				done <- struct{}{}
 			}
-
-			done <- struct{}{}
+		case f := <-m.taskChannel:
+			f()
 		case <-quit:
 			done <- struct{}{}
 			break LPublisherWorker
@@ -322,35 +858,119 @@ LPublisherWorker:
 	}
 }
 
+// Publish poll error of channel
+func (m *SnmpModel) publishPollError(dev *SnmpDevice, e PollError) {
+	// "r" for read errors, "w" for write errors
+	errorValue := "r"
+	if e.Write {
+		errorValue = "w"
+	}
+
+	// table has no control itself, so errors go to its rows
+	if e.Channel.Table {
+		for _, row := range dev.tableRows(e.Channel) {
+			m.publishError(dev, row, errorValue)
+		}
+	} else {
+		m.publishError(dev, e.Channel, errorValue)
+	}
+	m.publishComputed(dev, e.Channel)
+	m.publishAvailability(dev)
+}
+
+// Send pending queries to poll workers
+// Query is skipped if its previous poll is not completed yet
+// or if poll workers are too busy to accept it
+func (m *SnmpModel) pollPending(t time.Time) {
+	for _, dev := range m.devices {
+		m.scheduleAvailability(dev, t)
+	}
+
+	pending := m.pollTable.Pending(t)
+	queries := make([]PollQuery, 0, len(pending))
+
+	m.inFlightMutex.Lock()
+	for _, q := range pending {
+		if m.inFlight[q.Channel] {
+			wbgo.Debug.Printf("[POLLTIMEREVENT] Skip %s: previous poll is not completed", q.Channel.Name)
+			m.countStats(PollStats{Skipped: 1})
+			continue
+		}
+		m.inFlight[q.Channel] = true
+		queries = append(queries, q)
+	}
+	m.inFlightMutex.Unlock()
+
+	sent := 0
+	for _, q := range m.batchQueries(queries) {
+		select {
+		case m.queryChannel <- q:
+			sent += 1 + len(q.Batch)
+		default:
+			wbgo.Debug.Printf("[POLLTIMEREVENT] Skip %s: poll workers are busy", q.Channel.Name)
+			m.completeQuery(q)
+			m.countStats(PollStats{Skipped: 1 + len(q.Batch)})
+		}
+	}
+
+	m.countStats(PollStats{Polls: sent, Overdue: m.pollTable.TakeOverdue()})
+}
+
+// Mark query as completed, so its channels may be polled again
+func (m *SnmpModel) completeQuery(q PollQuery) {
+	m.inFlightMutex.Lock()
+	defer m.inFlightMutex.Unlock()
+
+	for _, ch := range q.Channels() {
+		delete(m.inFlight, ch)
+	}
+}
+
+// Add scheduler statistics
+func (m *SnmpModel) countStats(d PollStats) {
+	m.statsMutex.Lock()
+	defer m.statsMutex.Unlock()
+
+	m.stats.Polls += d.Polls
+	m.stats.Skipped += d.Skipped
+	m.stats.Overdue += d.Overdue
+}
+
+// Get scheduler statistics
+func (m *SnmpModel) Stats() PollStats {
+	m.statsMutex.Lock()
+	defer m.statsMutex.Unlock()
+	return m.stats
+}
+
 // Timer triggers pollTable to send queries
+// Queries are sent without waiting for previous ones to complete,
+// so slow device doesn't delay polls of other devices
 func (m *SnmpModel) PollTimerWorker(quit <-chan struct{}, done chan struct{}) {
-	var t time.Time
-
 	for {
-		// wait for timer event
 		select {
 		case <-quit:
 			done <- struct{}{}
 			return
-		case t = <-m.pollTimer.GetChannel():
-		}
-		wbgo.Debug.Printf("[POLLTIMEREVENT] Run at %v\n", t)
-
-		// start poll and wait until it's done
-		numQueries := m.pollTable.Poll(m.queryChannel, t)
-		for i := 0; i < 2*numQueries; i++ {
-			select {
-			case <-m.pollDoneChannel:
-			case <-m.pubDoneChannel:
+		case <-m.pollDoneChannel:
+			// completion is tracked by in-flight queries set
+		case <-m.pubDoneChannel:
+		case t := <-m.pollTimer.GetChannel():
+			wbgo.Debug.Printf("[POLLTIMEREVENT] Run at %v\n", t)
+			m.pollPending(t)
+
+			// setup timer to next poll time
+			// poll table may be left empty by config reload
+			nextPoll, err := m.pollTable.NextPollTime()
+			if err != nil {
+				wbgo.Warn.Printf("no channels to poll: %s", err)
+				m.pollTimer.Stop()
+				m.nextPoll = time.Time{}
+				continue
 			}
+			m.pollTimer.Reset(nextPoll.Sub(t))
+			m.nextPoll = nextPoll
 		}
-
-		// setup timer to next poll time
-		nextPoll, err := m.pollTable.NextPollTime()
-		if err != nil {
-			panic("Error getting next poll time from table")
-		}
-		m.pollTimer.Reset(nextPoll.Sub(t))
 	}
 }
 
@@ -364,20 +984,24 @@ func (m *SnmpModel) SetPollTimer(t wbgo.RTimer) {
 func (m *SnmpModel) Start() error {
 	// create all channels
 	m.queryChannel = make(chan PollQuery, CHAN_BUFFER_SIZE)
+	m.writeChannel = make(chan WriteQuery, CHAN_BUFFER_SIZE)
 	m.resultChannel = make(chan PollResult, CHAN_BUFFER_SIZE)
 	m.errorChannel = make(chan PollError, CHAN_BUFFER_SIZE)
 	m.quitChannels = make([]chan struct{}, m.config.NumWorkers+2) // +2 for publisher and poll timer
 	m.pollDoneChannel = make(chan struct{}, CHAN_BUFFER_SIZE)
 	m.pubDoneChannel = make(chan struct{}, CHAN_BUFFER_SIZE)
 	m.pollTimerDoneChannel = make(chan struct{})
+	m.taskChannel = make(chan func())
+	m.inFlight = make(map[*ChannelConfig]bool)
 
 	for i := range m.quitChannels {
 		m.quitChannels[i] = make(chan struct{})
 	}
 
 	// observe local devices
-	for i := range m.devices {
-		m.Observer.OnNewDevice(m.devices[i])
+	for _, dev := range m.devices {
+		m.observeDevice(dev)
+		m.startDevice(dev)
 	}
 
 	// start poll timer
@@ -385,24 +1009,67 @@ func (m *SnmpModel) Start() error {
 	if m.pollTimer == nil {
 		nextPoll, err := m.pollTable.NextPollTime()
 		if err != nil {
-			wbgo.Error.Fatalf("unable to get next poll time: %s", err)
-			m.Stop()
-			return err
+			// nothing to poll, so timer is never fired
+			wbgo.Warn.Printf("no channels to poll: %s", err)
+			timer := wbgo.NewRealRTimer(time.Hour)
+			timer.Stop()
+			m.SetPollTimer(timer)
+		} else {
+			m.SetPollTimer(wbgo.NewRealRTimer(nextPoll.Sub(time.Now())))
 		}
-		m.SetPollTimer(wbgo.NewRealRTimer(nextPoll.Sub(time.Now())))
+	}
+	if nextPoll, err := m.pollTable.NextPollTime(); err == nil {
+		m.nextPoll = nextPoll
 	}
 
 	// start workers and publisher
+	m.workers.Add(m.config.NumWorkers + 1)
 	for i := 0; i < m.config.NumWorkers; i++ {
-		go m.PollWorker(i, m.queryChannel, m.resultChannel, m.errorChannel, m.quitChannels[i], m.pollDoneChannel)
+		go func(id int) {
+			defer m.workers.Done()
+			m.PollWorker(id, m.queryChannel, m.writeChannel, m.resultChannel, m.errorChannel, m.quitChannels[id], m.pollDoneChannel)
+		}(i)
 	}
-	go m.PublisherWorker(m.resultChannel, m.errorChannel, m.quitChannels[m.config.NumWorkers], m.pubDoneChannel)
+	go func() {
+		defer m.workers.Done()
+		m.PublisherWorker(m.resultChannel, m.errorChannel, m.quitChannels[m.config.NumWorkers], m.pubDoneChannel)
+	}()
 
 	go m.PollTimerWorker(m.quitChannels[m.config.NumWorkers+1], m.pollTimerDoneChannel)
 
+	// start trap receiver
+	if m.config.TrapListen != "" {
+		if err := m.startTrapListener(m.config.TrapListen); err != nil {
+			wbgo.Error.Printf("can't start trap receiver on %s: %s", m.config.TrapListen, err)
+			m.Stop()
+			return err
+		}
+	}
+
 	return nil
 }
 
+// Connect device to write workers and add it to observer
+// Observer may accept writes right away, so it's done in this order
+func (m *SnmpModel) observeDevice(dev *SnmpDevice) {
+	dev.writeChannel = m.writeChannel
+	dev.errorChannel = m.errorChannel
+	m.Observer.OnNewDevice(dev)
+}
+
+// Publish new device added to observer
+func (m *SnmpModel) startDevice(dev *SnmpDevice) {
+	m.publishDeviceMeta(dev)
+
+	// failed channels are shown right away as they're never polled
+	for _, ch := range dev.Config.Channels {
+		if ch.Error != "" && !ch.Table {
+			m.publishError(dev, ch, "r")
+			m.publishComputed(dev, ch)
+		}
+	}
+}
+
 // Built-in poll function - leave this empty, we have our own autopoll already
 func (m *SnmpModel) Poll() {}
 
@@ -414,30 +1081,34 @@ func (m *SnmpModel) Stop() {
 		m.pollTimer.Stop()
 	}
 
-	// close all data channels
-	// close(m.queryChannel)
-	// close(m.resultChannel)
-	// close(m.errorChannel)
+	// stop trap receiver before publisher
+	m.stopTrapListener()
 
-	// send signals to quit to all workers
-	for i := range m.quitChannels {
-		m.quitChannels[i] <- struct{}{}
+	// stop poll timer worker first, so no more queries are sent
+	m.quitChannels[m.config.NumWorkers+1] <- struct{}{}
+	<-m.pollTimerDoneChannel
+
+	// signal workers to quit
+	for i := range m.quitChannels[:m.config.NumWorkers+1] {
+		close(m.quitChannels[i])
 	}
 
-	// wait for workers to shut down
-	pollDone := 0
-	pubDone := 0
-	pollTimerDone := 0
-	for range m.quitChannels {
+	// wait for workers to shut down, poll workers may still
+	// complete queries, so their signals and results are dropped
+	finished := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(finished)
+	}()
+
+	for {
 		select {
 		case <-m.pollDoneChannel:
-			pollDone++
 		case <-m.pubDoneChannel:
-			pubDone++
-		case <-m.pollTimerDoneChannel:
-			pollTimerDone++
+		case <-m.resultChannel:
+		case <-m.errorChannel:
+		case <-finished:
+			return
 		}
 	}
-
-	// fmt.Printf("Done: poll %d, pub %d, timer %d\n", pollDone, pubDone, pollTimerDone)
 }
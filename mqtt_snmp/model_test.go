@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"github.com/contactless/wbgo"
 	"github.com/contactless/wbgo/testutils"
-	"github.com/wirenboard/gosnmp"
+	"github.com/gosnmp/gosnmp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -30,6 +32,11 @@ const (
 	OnValueEvent MockDeviceEventType = iota
 	OnNewControlEvent
 	OnErrorEvent
+	OnRemoveControlEvent
+	OnDeviceErrorEvent
+	OnControlMetaEvent
+	OnDeviceMetaEvent
+	OnRemoveDeviceEvent
 )
 
 type MockDeviceEvent struct {
@@ -56,8 +63,45 @@ func (o *MockDeviceObserver) OnNewControl(dev wbgo.LocalDeviceModel, control wbg
 	return control.Value
 }
 
-// The OnError stub
 func (o *MockDeviceObserver) OnError(dev wbgo.DeviceModel, name, value string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.Log <- MockDeviceEvent{OnErrorEvent, fmt.Sprintf("device %s, name %s, error %s", dev.Name(), name, value)}
+}
+
+// RemoveControl implements ControlRemover
+func (o *MockDeviceObserver) RemoveControl(dev wbgo.DeviceModel, name string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.Log <- MockDeviceEvent{OnRemoveControlEvent, fmt.Sprintf("device %s, name %s", dev.Name(), name)}
+}
+
+// OnDeviceError implements DeviceErrorPublisher
+func (o *MockDeviceObserver) OnDeviceError(dev wbgo.DeviceModel, value string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.Log <- MockDeviceEvent{OnDeviceErrorEvent, fmt.Sprintf("device %s, error %s", dev.Name(), value)}
+}
+
+// OnControlMeta implements ControlMetaPublisher
+func (o *MockDeviceObserver) OnControlMeta(dev wbgo.DeviceModel, control, meta, value string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.Log <- MockDeviceEvent{OnControlMetaEvent, fmt.Sprintf("device %s, name %s, meta %s, value %s", dev.Name(), control, meta, value)}
+}
+
+// OnDeviceMeta implements DeviceMetaPublisher
+func (o *MockDeviceObserver) OnDeviceMeta(dev wbgo.DeviceModel, value string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.Log <- MockDeviceEvent{OnDeviceMetaEvent, fmt.Sprintf("device %s, meta %s", dev.Name(), value)}
+}
+
+// RemoveDevice implements DeviceRemover
+func (o *MockDeviceObserver) RemoveDevice(dev wbgo.DeviceModel) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.Log <- MockDeviceEvent{OnRemoveDeviceEvent, fmt.Sprintf("device %s", dev.Name())}
 }
 
 // CheckEvents checks if all events from list were pushed into log (maybe in another order)
@@ -113,6 +157,12 @@ var (
 	// Map of fake SNMP objects to be read from FakeSNMPs
 	// Keys are "address@community@oid"
 	fakeSNMPMessages map[string]*gosnmp.SnmpPacket
+
+	// Max number of variables in fake GET request, 0 for unlimited
+	fakeSNMPMaxVarbinds int
+
+	// Number of variables in each fake GET request
+	fakeSNMPRequests []int
 )
 
 // Fake SNMP connection
@@ -122,23 +172,101 @@ type FakeSNMP struct {
 	Timeout            int64
 }
 
-func (snmp *FakeSNMP) Get(oid string) (packet *gosnmp.SnmpPacket, err error) {
-	if pkg, ok := fakeSNMPMessages[snmp.Address+"@"+snmp.Community+"@"+oid]; ok {
-		packet = pkg
-		err = nil
-		return
-	} else {
-		packet = nil
-		err = fmt.Errorf("No such instance")
+// Get collects fake SNMP messages into single response
+// Missing OIDs are returned as noSuchInstance, request fails
+// if none of OIDs is found, tooBig is returned for requests
+// with more than fakeSNMPMaxVarbinds variables
+func (snmp *FakeSNMP) Get(oids []string) (packet *gosnmp.SnmpPacket, err error) {
+	fakeSNMPRequests = append(fakeSNMPRequests, len(oids))
+
+	packet = &gosnmp.SnmpPacket{
+		Version: snmp.Version,
+		PDUType: gosnmp.GetResponse,
+	}
+
+	if fakeSNMPMaxVarbinds > 0 && len(oids) > fakeSNMPMaxVarbinds {
+		packet.Error = gosnmp.TooBig
 		return
 	}
+
+	found := false
+	for _, oid := range oids {
+		if pkg, ok := fakeSNMPMessages[snmp.Address+"@"+snmp.Community+"@"+oid]; ok {
+			packet.Variables = append(packet.Variables, pkg.Variables[0])
+			found = true
+		} else {
+			packet.Variables = append(packet.Variables, gosnmp.SnmpPDU{Name: oid, Type: gosnmp.NoSuchInstance})
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("No such instance")
+	}
+
+	return
+}
+
+// Set stores values in fake SNMP messages map
+// Writes to OIDs with "ro" prefix in value fail with notWritable error
+func (snmp *FakeSNMP) Set(pdus []gosnmp.SnmpPDU) (packet *gosnmp.SnmpPacket, err error) {
+	packet = &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		PDUType:   gosnmp.GetResponse,
+		Variables: pdus,
+	}
+
+	for i := range pdus {
+		key := snmp.Address + "@" + snmp.Community + "@" + pdus[i].Name
+		if pkg, ok := fakeSNMPMessages[key]; ok {
+			if v, ok := pkg.Variables[0].Value.([]byte); ok && strings.HasPrefix(string(v), "ro") {
+				packet.Error = gosnmp.NotWritable
+				packet.ErrorIndex = uint8(i + 1)
+				return
+			}
+		}
+	}
+
+	for i := range pdus {
+		fakeSNMPMessages[snmp.Address+"@"+snmp.Community+"@"+pdus[i].Name] = &gosnmp.SnmpPacket{
+			Version:   gosnmp.Version2c,
+			PDUType:   gosnmp.GetResponse,
+			Variables: []gosnmp.SnmpPDU{pdus[i]},
+		}
+	}
+
+	return
+}
+
+// Walk returns all fake SNMP messages in subtree sorted by OID
+// Walk fails if there are no messages for device at all
+func (snmp *FakeSNMP) Walk(rootOid string) (pdus []gosnmp.SnmpPDU, err error) {
+	device := snmp.Address + "@" + snmp.Community + "@"
+	found := false
+
+	for key, pkg := range fakeSNMPMessages {
+		if !strings.HasPrefix(key, device) {
+			continue
+		}
+		found = true
+		if strings.HasPrefix(key, device+rootOid+".") {
+			pdus = append(pdus, pkg.Variables[0])
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("Request timeout")
+	}
+
+	sort.Slice(pdus, func(i, j int) bool { return pdus[i].Name < pdus[j].Name })
+
+	return
 }
 
 func InsertFakeSNMPMessage(key, value string) {
 	fakeSNMPMessages[key] = &gosnmp.SnmpPacket{
 		Version:        gosnmp.Version2c,
 		Community:      "",
-		RequestType:    gosnmp.GetResponse,
+		PDUType:        gosnmp.GetResponse,
 		RequestID:      0,
 		Error:          0,
 		ErrorIndex:     0,
@@ -148,19 +276,19 @@ func InsertFakeSNMPMessage(key, value string) {
 			gosnmp.SnmpPDU{
 				Name:  strings.Split(key, "@")[2],
 				Type:  gosnmp.OctetString,
-				Value: value,
+				Value: []byte(value),
 			},
 		},
 	}
 }
 
-func NewFakeSNMP(address, community string, version gosnmp.SnmpVersion, timeout int64, debug bool) (snmp SnmpInterface, err error) {
+func NewFakeSNMP(config *DeviceConfig, debug bool) (snmp SnmpInterface, err error) {
 	err = nil
 	s := &FakeSNMP{
-		Address:   address,
-		Community: community,
-		Version:   version,
-		Timeout:   timeout,
+		Address:   config.Address,
+		Community: config.Community,
+		Version:   config.SnmpVersion,
+		Timeout:   int64(config.SnmpTimeout),
 	}
 	snmp = s
 
@@ -218,7 +346,7 @@ type FakeModelObserver struct {
 	DevObserver *MockDeviceObserver
 }
 
-func (f *FakeModelObserver) CallSync(thunk func())             {}
+func (f *FakeModelObserver) CallSync(thunk func())             { thunk() }
 func (f *FakeModelObserver) WhenReady(thunk func())            {}
 func (f *FakeModelObserver) RemoveDevice(dev wbgo.DeviceModel) {}
 func (f *FakeModelObserver) OnNewDevice(dev wbgo.DeviceModel) {
@@ -241,6 +369,7 @@ type ModelWorkersTest struct {
 
 	// Workers channels
 	queryChannel  chan PollQuery
+	writeChannel  chan WriteQuery
 	resultChannel chan PollResult
 	errorChannel  chan PollError
 	quitChannel   chan struct{}
@@ -263,6 +392,8 @@ func (m *ModelWorkersTest) TearDownTestFixture(t *testing.T) {
 
 func (m *ModelWorkersTest) SetupTest() {
 	fakeSNMPMessages = make(map[string]*gosnmp.SnmpPacket)
+	fakeSNMPMaxVarbinds = 0
+	fakeSNMPRequests = nil
 
 	m.Suite.SetupTest()
 
@@ -270,6 +401,7 @@ func (m *ModelWorkersTest) SetupTest() {
 
 	// create channels
 	m.queryChannel = make(chan PollQuery, 128)
+	m.writeChannel = make(chan WriteQuery, 128)
 	m.resultChannel = make(chan PollResult, 128)
 	m.errorChannel = make(chan PollError, 128)
 	m.quitChannel = make(chan struct{}, 128)
@@ -309,8 +441,11 @@ func (m *ModelWorkersTest) SetupTest() {
 						Oid:          ".1.2.3.6",
 						ControlType:  "value",
 						Conv:         Scale(0.1),
+						InvConv:      InverseScale(0.1),
 						PollInterval: 2000,
 						Order:        3,
+						Writable:     true,
+						SetType:      gosnmp.Integer,
 					},
 				},
 			},
@@ -381,6 +516,234 @@ func (m *ModelWorkersTest) TestPublisherWorker() {
 	m.Equal(<-obs.Log, MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel1, value baz"})
 }
 
+// Test enumeration labels of numeric and text channels
+func (m *ModelWorkersTest) TestPublisherWorkerEnum() {
+	obs := NewMockDeviceObserver()
+	m.model.ControlMeta = obs
+
+	enum := map[int64]string{1: "unknown", 2: "onLine"}
+	dev := m.config.Devices["snmp_device1"]
+	ch := dev.Channels["channel2"]
+	ch.SetEnum(enum)
+	text := &ChannelConfig{Name: "status", Oid: ".1.2.3.7", ControlType: "text", Conv: AsIs, Order: 4, Device: dev}
+	text.SetEnum(enum)
+	m.model.DeviceChannelMap[text] = m.model.DeviceChannelMap[ch]
+
+	m.model.DeviceChannelMap[ch].Observe(obs)
+
+	done := make(chan struct{}, 128)
+	go m.model.PublisherWorker(m.resultChannel, m.errorChannel, m.quitChannel, done)
+
+	m.resultChannel <- PollResult{Channel: ch, Data: ch.Conv("2")}
+	m.resultChannel <- PollResult{Channel: text, Data: text.Conv("2")}
+	m.resultChannel <- PollResult{Channel: text, Data: text.Conv("3")}
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+
+	m.quitChannel <- struct{}{}
+	<-done
+
+	m.Ck("enum events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel2, type value, value 2, order 2"},
+		&MockDeviceEvent{OnControlMetaEvent, `device snmp_device1, name channel2, meta , value {"type":"value","order":2,"enum":{"1":{"en":"unknown"},"2":{"en":"onLine"}}}`},
+		&MockDeviceEvent{OnControlMetaEvent, `device snmp_device1, name channel2, meta enum, value {"1":"unknown","2":"onLine"}`},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name status, type text, value onLine, order 4"},
+		&MockDeviceEvent{OnControlMetaEvent, `device snmp_device1, name status, meta , value {"type":"text","order":4}`},
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name status, value 3"},
+	}, EventTimeout))
+	m.Ck("no more events", obs.WaitForNoMessages(WaitTimeout))
+
+	// label is written as number
+	m.Equal("2", text.InvConv("onLine"))
+	m.Equal("5", text.InvConv("5"))
+}
+
+func (m *ModelWorkersTest) TestPublisherWorkerComputed() {
+	obs := NewMockDeviceObserver()
+
+	dev := m.config.Devices["snmp_device1"]
+	ch1 := dev.Channels["channel1"]
+	ch2 := dev.Channels["channel2"]
+	e, err := ParseExpressionVars("a + b", []string{"a", "b"})
+	m.Ck("parse expression", err)
+	sum := &ChannelConfig{Name: "sum", ControlType: "value", Conv: AsIs, Order: 4, Device: dev,
+		Computed: e, Inputs: map[string]string{"a": "channel1", "b": "channel2"}}
+	dev.Channels["sum"] = sum
+
+	d := m.model.DeviceChannelMap[ch1]
+	d.computed = computedChannels(dev)
+	m.model.DeviceChannelMap[sum] = d
+	d.Observe(obs)
+
+	done := make(chan struct{}, 128)
+	go m.model.PublisherWorker(m.resultChannel, m.errorChannel, m.quitChannel, done)
+
+	// value is computed when all inputs are known
+	m.resultChannel <- PollResult{Channel: ch1, Data: "1.5"}
+	<-done
+	m.Equal(<-obs.Log, MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel1, type value, value 1.5, order 1"})
+	m.resultChannel <- PollResult{Channel: ch2, Data: "2"}
+	<-done
+	m.Ck("sum events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel2, type value, value 2, order 2"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name sum, type value, value 3.5, order 4"},
+	}, EventTimeout))
+
+	// error of input is propagated
+	m.errorChannel <- PollError{Channel: ch2, Error: "r"}
+	<-done
+	m.Ck("error events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnErrorEvent, "device snmp_device1, name channel2, error r"},
+		&MockDeviceEvent{OnErrorEvent, "device snmp_device1, name sum, error r"},
+	}, EventTimeout))
+
+	// and cleared with new value
+	m.resultChannel <- PollResult{Channel: ch2, Data: "3"}
+	<-done
+	m.Ck("recovery events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel2, value 3"},
+		&MockDeviceEvent{OnErrorEvent, "device snmp_device1, name channel2, error "},
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name sum, value 4.5"},
+		&MockDeviceEvent{OnErrorEvent, "device snmp_device1, name sum, error "},
+	}, EventTimeout))
+
+	m.quitChannel <- struct{}{}
+	<-done
+	m.Ck("no more events", obs.WaitForNoMessages(WaitTimeout))
+}
+
+func (m *ModelWorkersTest) TestPublisherWorkerComputedDeadband() {
+	obs := NewMockDeviceObserver()
+
+	dev := m.config.Devices["snmp_device1"]
+	ch1 := dev.Channels["channel1"]
+	ch1.Deadband = 1
+	e, err := ParseExpressionVars("a * 2", []string{"a"})
+	m.Ck("parse expression", err)
+	double := &ChannelConfig{Name: "double", ControlType: "value", Conv: AsIs, Order: 4, Device: dev,
+		Computed: e, Inputs: map[string]string{"a": "channel1"}}
+	dev.Channels["double"] = double
+
+	d := m.model.DeviceChannelMap[ch1]
+	d.computed = computedChannels(dev)
+	m.model.DeviceChannelMap[double] = d
+	d.Observe(obs)
+
+	done := make(chan struct{}, 128)
+	go m.model.PublisherWorker(m.resultChannel, m.errorChannel, m.quitChannel, done)
+
+	m.resultChannel <- PollResult{Channel: ch1, Data: "10"}
+	<-done
+	m.Ck("new control events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel1, type value, value 10, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name double, type value, value 20, order 4"},
+	}, EventTimeout))
+
+	// input change within deadband isn't published,
+	// but computed channel uses it anyway
+	for _, v := range []string{"10.5", "10.75"} {
+		m.resultChannel <- PollResult{Channel: ch1, Data: v}
+		<-done
+	}
+	m.Ck("computed events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name double, value 21"},
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name double, value 21.5"},
+	}, EventTimeout))
+
+	m.quitChannel <- struct{}{}
+	<-done
+	m.Ck("no more events", obs.WaitForNoMessages(WaitTimeout))
+}
+
+func (m *ModelWorkersTest) TestPublisherWorkerAlarm() {
+	obs := NewMockDeviceObserver()
+
+	dev := m.config.Devices["snmp_device1"]
+	ch := dev.Channels["channel1"]
+	high := 45.0
+	ch.Alarm = &AlarmConfig{Name: "channel1 alarm", High: &high, Hysteresis: 1}
+
+	d := m.model.DeviceChannelMap[ch]
+	d.Alarms = newAlarmStates(dev)
+	d.Observe(obs)
+
+	done := make(chan struct{}, 128)
+	go m.model.PublisherWorker(m.resultChannel, m.errorChannel, m.quitChannel, done)
+
+	m.resultChannel <- PollResult{Channel: ch, Data: "40"}
+	<-done
+	m.Ck("new control events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel1, type value, value 40, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel1 alarm, type switch, value 0, order 4"},
+	}, EventTimeout))
+
+	for _, v := range []string{"46", "44.5", "44"} {
+		m.resultChannel <- PollResult{Channel: ch, Data: v}
+		<-done
+	}
+	m.Ck("alarm events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel1, value 46"},
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel1 alarm, value 1"},
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel1, value 44.5"},
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel1, value 44"},
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel1 alarm, value 0"},
+	}, EventTimeout))
+
+	// read error is shown by alarm control too
+	m.errorChannel <- PollError{Channel: ch, Error: "r"}
+	<-done
+	m.Ck("error events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnErrorEvent, "device snmp_device1, name channel1, error r"},
+		&MockDeviceEvent{OnErrorEvent, "device snmp_device1, name channel1 alarm, error r"},
+	}, EventTimeout))
+
+	m.quitChannel <- struct{}{}
+	<-done
+	m.Ck("no more events", obs.WaitForNoMessages(WaitTimeout))
+}
+
+func (m *ModelWorkersTest) TestPublisherWorkerMeta() {
+	obs := NewMockDeviceObserver()
+	m.model.ControlMeta = obs
+
+	dev := m.config.Devices["snmp_device1"]
+	ch := dev.Channels["channel1"]
+	min, max := 0.0, 100.0
+	ch.Readonly = true
+	ch.Min, ch.Max = &min, &max
+	ch.Precision = 0.1
+	ch.Title = map[string]string{"en": "Load", "ru": "Нагрузка"}
+
+	m.model.DeviceChannelMap[ch].Observe(obs)
+
+	done := make(chan struct{}, 128)
+	go m.model.PublisherWorker(m.resultChannel, m.errorChannel, m.quitChannel, done)
+
+	m.resultChannel <- PollResult{Channel: ch, Data: "42"}
+	<-done
+
+	m.quitChannel <- struct{}{}
+	<-done
+
+	m.Ck("meta events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel1, type value, value 42, order 1"},
+		&MockDeviceEvent{OnControlMetaEvent, `device snmp_device1, name channel1, meta , value {"type":"value","units":"U","readonly":true,"order":1,"min":0,"max":100,"precision":0.1,"title":{"en":"Load","ru":"Нагрузка"}}`},
+	}, EventTimeout))
+	m.Ck("no more events", obs.WaitForNoMessages(WaitTimeout))
+
+	m.model.DeviceMeta = obs
+	m.model.publishDeviceMeta(m.model.DeviceChannelMap[ch])
+	m.Equal(MockDeviceEvent{OnDeviceMetaEvent, `device snmp_device1, meta {"driver":"wb-mqtt-snmp","title":{"en":"Device 1"}}`}, <-obs.Log)
+
+	control := newChannelControl(ch, "42")
+	m.Equal("Load", control.Title)
+	m.Equal("U", control.GetUnits())
+	m.Equal(wbgo.ForceReadOnly, control.Writability)
+	m.True(control.HasMax)
+	m.Equal(100.0, control.Max)
+}
+
 // Test poll worker itself (outside the model)
 func (m *ModelWorkersTest) TestPollWorker() {
 	// Insert some fake SNMP messages for channel1 (channel2 left unreachable)
@@ -394,10 +757,10 @@ func (m *ModelWorkersTest) TestPollWorker() {
 	ch3 := m.config.Devices["snmp_device1"].Channels["channel3"]
 
 	// Run poll worker
-	go m.model.PollWorker(0, m.queryChannel, m.resultChannel, m.errorChannel, m.quitChannel, done)
+	go m.model.PollWorker(0, m.queryChannel, m.writeChannel, m.resultChannel, m.errorChannel, m.quitChannel, done)
 
 	// Push some requests to model
-	m.queryChannel <- PollQuery{ch1, t}
+	m.queryChannel <- PollQuery{Channel: ch1, Deadline: t}
 	// wait for this to be done
 	timeout1 := make(chan struct{})
 	go Timeout(500, timeout1)
@@ -420,7 +783,7 @@ func (m *ModelWorkersTest) TestPollWorker() {
 	//
 	// Poll new value
 	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.4", "GoAway")
-	m.queryChannel <- PollQuery{ch1, t}
+	m.queryChannel <- PollQuery{Channel: ch1, Deadline: t}
 	// wait
 	timeout2 := make(chan struct{})
 	go Timeout(500, timeout2)
@@ -437,11 +800,11 @@ func (m *ModelWorkersTest) TestPollWorker() {
 	default:
 		m.Fail("no result from poller")
 	}
-	m.Equal(res, PollResult{ch1, "GoAway"})
+	m.Equal(res, PollResult{Channel: ch1, Data: "GoAway"})
 
 	//
 	// Poll no value and so get error
-	m.queryChannel <- PollQuery{ch2, t}
+	m.queryChannel <- PollQuery{Channel: ch2, Deadline: t}
 	// wait
 	timeout3 := make(chan struct{})
 	go Timeout(500, timeout3)
@@ -463,7 +826,7 @@ func (m *ModelWorkersTest) TestPollWorker() {
 	//
 	// Poll new value with scale
 	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.6", "100")
-	m.queryChannel <- PollQuery{ch3, t}
+	m.queryChannel <- PollQuery{Channel: ch3, Deadline: t}
 	// wait
 	timeout5 := make(chan struct{})
 	go Timeout(500, timeout5)
@@ -480,7 +843,7 @@ func (m *ModelWorkersTest) TestPollWorker() {
 	default:
 		m.Fail("no result from poller")
 	}
-	m.Equal(res, PollResult{ch3, "10.0"})
+	m.Equal(res, PollResult{Channel: ch3, Data: "10.0"})
 
 	// close worker
 	m.quitChannel <- struct{}{}
@@ -496,6 +859,410 @@ func (m *ModelWorkersTest) TestPollWorker() {
 	m.EnsureGotErrors()
 }
 
+// Test batched GET requests in poll worker
+func (m *ModelWorkersTest) TestPollWorkerBatch() {
+	// channel2 is left unreachable
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.4", "foo")
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.6", "100")
+
+	done := make(chan struct{}, 128)
+	t := time.Now()
+	ch1 := m.config.Devices["snmp_device1"].Channels["channel1"]
+	ch2 := m.config.Devices["snmp_device1"].Channels["channel2"]
+	ch3 := m.config.Devices["snmp_device1"].Channels["channel3"]
+
+	go m.model.PollWorker(0, m.queryChannel, m.writeChannel, m.resultChannel, m.errorChannel, m.quitChannel, done)
+
+	// results are fanned out to channels, missing instance is an error of its channel only
+	check := func() {
+		m.waitDone(done, "poll worker timeout")
+
+		m.Equal(PollResult{Channel: ch1, Data: "foo"}, <-m.resultChannel)
+		m.Equal(PollResult{Channel: ch3, Data: "10.0"}, <-m.resultChannel)
+		er := <-m.errorChannel
+		m.Equal(ch2, er.Channel)
+		m.False(er.Write)
+	}
+
+	m.queryChannel <- PollQuery{Channel: ch1, Deadline: t, Batch: []*ChannelConfig{ch2, ch3}}
+	check()
+	m.Equal([]int{3}, fakeSNMPRequests)
+
+	// agent responds with tooBig, request is split until it fits
+	fakeSNMPMaxVarbinds = 1
+	fakeSNMPRequests = nil
+
+	m.queryChannel <- PollQuery{Channel: ch1, Deadline: t, Batch: []*ChannelConfig{ch2, ch3}}
+	check()
+	m.Equal([]int{3, 1, 2, 1, 1}, fakeSNMPRequests)
+
+	m.quitChannel <- struct{}{}
+	m.waitDone(done, "poll worker timeout on quit")
+
+	m.EnsureGotErrors()
+}
+
+// Test grouping of due queries into batches
+func (m *ModelWorkersTest) TestBatchQueries() {
+	dev := m.config.Devices["snmp_device1"]
+	ch1, ch2, ch3 := dev.Channels["channel1"], dev.Channels["channel2"], dev.Channels["channel3"]
+	ch4 := &ChannelConfig{Name: "channel4", Oid: ".1.2.3.7", PollInterval: 2000, Device: dev}
+	m.model.DeviceChannelMap[ch4] = m.model.DeviceChannelMap[ch1]
+
+	t := time.Now()
+	queries := []PollQuery{
+		{Channel: ch1, Deadline: t},
+		{Channel: ch2, Deadline: t},
+		{Channel: ch3, Deadline: t},
+		{Channel: ch4, Deadline: t},
+	}
+
+	// batching is disabled
+	dev.MaxVarbinds = 1
+	m.Equal(queries, m.model.batchQueries(queries))
+
+	// queries are grouped by interval and split by max varbinds
+	dev.MaxVarbinds = 2
+	m.Equal([]PollQuery{
+		{Channel: ch1, Deadline: t},
+		{Channel: ch2, Deadline: t, Batch: []*ChannelConfig{ch3}},
+		{Channel: ch4, Deadline: t},
+	}, m.model.batchQueries(queries))
+
+	dev.MaxVarbinds = DefaultMaxVarbinds
+	m.Equal([]PollQuery{
+		{Channel: ch1, Deadline: t},
+		{Channel: ch2, Deadline: t, Batch: []*ChannelConfig{ch3, ch4}},
+	}, m.model.batchQueries(queries))
+
+	// agent uptime is requested with rate channels
+	ch4.Rate = time.Second
+	dev.MaxVarbinds = 3
+	m.Equal([]PollQuery{
+		{Channel: ch1, Deadline: t},
+		{Channel: ch2, Deadline: t, Batch: []*ChannelConfig{ch3}},
+		{Channel: ch4, Deadline: t},
+	}, m.model.batchQueries(queries))
+}
+
+// Insert fake SNMP variable of given type
+func InsertFakeSNMPVariable(key string, t gosnmp.Asn1BER, value any) {
+	fakeSNMPMessages[key] = &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		PDUType:   gosnmp.GetResponse,
+		Variables: []gosnmp.SnmpPDU{{Name: strings.Split(key, "@")[2], Type: t, Value: value}},
+	}
+}
+
+// Test rate of counter channel with agent uptime
+func (m *ModelWorkersTest) TestPollRate() {
+	dev := m.config.Devices["snmp_device1"]
+	dev.MaxVarbinds = DefaultMaxVarbinds
+	ch := dev.Channels["channel2"]
+	ch.Rate = time.Minute
+	ch.Conv = Scale(8)
+
+	obs := NewMockDeviceObserver()
+	m.model.DeviceChannelMap[ch].Observe(obs)
+
+	now := m.StartTime
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	res := make(chan PollResult, 16)
+	errs := make(chan PollError, 16)
+
+	poll := func(counter uint32, uptime uint32) {
+		InsertFakeSNMPVariable("127.0.0.1@test@.1.2.3.5", gosnmp.Counter32, uint(counter))
+		InsertFakeSNMPVariable("127.0.0.1@test@"+sysUpTimeOid, gosnmp.TimeTicks, uptime)
+		m.model.pollChannels(1, []*ChannelConfig{ch}, false, res, errs)
+		r := <-res
+		if m.NotNil(r.Sample) {
+			m.model.publishRate(m.model.DeviceChannelMap[ch], ch, *r.Sample)
+		}
+	}
+
+	// first sample is not published
+	poll(4294967000, 100)
+	m.Equal([]int{2}, fakeSNMPRequests)
+	m.Ck("no first sample", obs.WaitForNoMessages(WaitTimeout))
+
+	// counter wraps around
+	now = now.Add(30 * time.Second)
+	poll(1000, 3100)
+	m.Ck("rate", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel2, type value, value 20736.0, order 2"},
+	}, EventTimeout))
+
+	// agent restart
+	now = now.Add(30 * time.Second)
+	poll(5000, 50)
+	m.Ck("no sample after restart", obs.WaitForNoMessages(WaitTimeout))
+
+	now = now.Add(60 * time.Second)
+	poll(5600, 6050)
+	m.Ck("rate after restart", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel2, value 4800.0"},
+	}, EventTimeout))
+
+	// counter of wrong type
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.5", "foo")
+	m.model.pollChannels(1, []*ChannelConfig{ch}, false, res, errs)
+	e := <-errs
+	m.Equal("instance of type OctetString is not a counter", e.Error)
+	m.EnsureGotErrors()
+}
+
+// Agent uptime is requested separately if device accepts single variable
+func (m *ModelWorkersTest) TestPollRateUnbatched() {
+	dev := m.config.Devices["snmp_device1"]
+	dev.MaxVarbinds = 1
+	ch := dev.Channels["channel2"]
+	ch.Rate = time.Second
+
+	obs := NewMockDeviceObserver()
+	m.model.DeviceChannelMap[ch].Observe(obs)
+
+	now := m.StartTime
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	res := make(chan PollResult, 16)
+	errs := make(chan PollError, 16)
+
+	poll := func(counter uint32, uptime uint32) {
+		InsertFakeSNMPVariable("127.0.0.1@test@.1.2.3.5", gosnmp.Counter32, uint(counter))
+		InsertFakeSNMPVariable("127.0.0.1@test@"+sysUpTimeOid, gosnmp.TimeTicks, uptime)
+		m.model.pollChannels(1, []*ChannelConfig{ch}, false, res, errs)
+		r := <-res
+		if m.NotNil(r.Sample) {
+			m.True(r.Sample.HasUptime)
+			m.model.publishRate(m.model.DeviceChannelMap[ch], ch, *r.Sample)
+		}
+	}
+
+	poll(1000, 5000)
+	m.Equal([]int{1, 1}, fakeSNMPRequests)
+	m.Ck("no first sample", obs.WaitForNoMessages(WaitTimeout))
+
+	now = now.Add(10 * time.Second)
+	poll(1500, 6000)
+	m.Ck("rate", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel2, type value, value 50.00, order 2"},
+	}, EventTimeout))
+
+	// agent restart isn't taken for counter wraparound
+	now = now.Add(10 * time.Second)
+	poll(200, 100)
+	m.Ck("no sample after restart", obs.WaitForNoMessages(WaitTimeout))
+
+	now = now.Add(10 * time.Second)
+	poll(800, 1100)
+	m.Ck("rate after restart", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel2, value 60.00"},
+	}, EventTimeout))
+}
+
+// Value which can't be evaluated by expression is reported as read error
+func (m *ModelWorkersTest) TestPollExpressionError() {
+	ch := m.config.Devices["snmp_device1"].Channels["channel1"]
+	var err error
+	ch.expr, err = ParseExpression("1000 / x")
+	m.Ck("parse", err)
+	defer func() { ch.expr = nil }()
+
+	res := make(chan PollResult, 16)
+	errs := make(chan PollError, 16)
+
+	InsertFakeSNMPVariable("127.0.0.1@test@.1.2.3.4", gosnmp.Integer, 8)
+	m.model.pollChannels(1, []*ChannelConfig{ch}, false, res, errs)
+	r := <-res
+	m.Equal("125", r.Data)
+
+	InsertFakeSNMPVariable("127.0.0.1@test@.1.2.3.4", gosnmp.Integer, 0)
+	m.model.pollChannels(1, []*ChannelConfig{ch}, false, res, errs)
+	e := <-errs
+	m.Equal(ch, e.Channel)
+	m.Contains(e.Error, "1000 / x")
+	m.Len(res, 0)
+	m.EnsureGotErrors()
+}
+
+// Test conversion of MQTT values to SNMP PDUs
+func (m *ModelWorkersTest) TestMakeSnmpPDU() {
+	valid := []struct {
+		t     gosnmp.Asn1BER
+		value string
+		res   interface{}
+	}{
+		{gosnmp.Integer, "-12", -12},
+		{gosnmp.Integer, "12.6", 13},
+		{gosnmp.Gauge32, "42", uint32(42)},
+		{gosnmp.Counter32, "42", uint32(42)},
+		{gosnmp.TimeTicks, "100", uint32(100)},
+		{gosnmp.Uinteger32, "7", uint32(7)},
+		{gosnmp.Counter64, "18446744073709551615", uint64(18446744073709551615)},
+		{gosnmp.Counter64, "80.0", uint64(80)},
+		{gosnmp.Counter64, "1.5", uint64(2)},
+		{gosnmp.OctetString, "foo bar", "foo bar"},
+		{gosnmp.IPAddress, "192.168.0.1", "192.168.0.1"},
+		{gosnmp.ObjectIdentifier, ".1.3.6.1", ".1.3.6.1"},
+	}
+
+	for _, v := range valid {
+		pdu, err := MakeSnmpPDU(".1.2.3", v.t, v.value)
+		m.NoError(err, "failed to make %s from %s", v.t, v.value)
+		m.Equal(gosnmp.SnmpPDU{Name: ".1.2.3", Type: v.t, Value: v.res}, pdu)
+	}
+
+	invalid := []struct {
+		t     gosnmp.Asn1BER
+		value string
+	}{
+		{gosnmp.Integer, "foo"},
+		{gosnmp.Integer, "3000000000"},
+		{gosnmp.Gauge32, "-1"},
+		{gosnmp.Counter64, "-1"},
+		{gosnmp.Counter64, "1e20"},
+		{gosnmp.IPAddress, "192.168.0"},
+		{gosnmp.Null, ""},
+	}
+
+	for _, v := range invalid {
+		_, err := MakeSnmpPDU(".1.2.3", v.t, v.value)
+		m.Error(err, "%s is converted to %s", v.value, v.t)
+	}
+}
+
+// Wait for signal on done channel or fail on timeout
+func (m *ModelWorkersTest) waitDone(done chan struct{}, message string) {
+	timeout := make(chan struct{})
+	go Timeout(500, timeout)
+
+	select {
+	case <-done:
+	case <-timeout:
+		m.Fail(message)
+	}
+}
+
+// Test write queries processing in poll worker
+func (m *ModelWorkersTest) TestPollWorkerWrite() {
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.6", "100")
+
+	done := make(chan struct{}, 128)
+	ch3 := m.config.Devices["snmp_device1"].Channels["channel3"]
+
+	go m.model.PollWorker(0, m.queryChannel, m.writeChannel, m.resultChannel, m.errorChannel, m.quitChannel, done)
+
+	// write new value, it must be read back
+	m.writeChannel <- WriteQuery{Channel: ch3, Value: "12.5"}
+
+	timeout := make(chan struct{})
+	go Timeout(500, timeout)
+
+	select {
+	case res := <-m.resultChannel:
+		m.Equal(PollResult{Channel: ch3, Data: "12.5", Write: true}, res)
+	case <-timeout:
+		m.Fail("no read-back result from poller")
+	}
+
+	m.Equal(125, fakeSNMPMessages["127.0.0.1@test@.1.2.3.6"].Variables[0].Value)
+
+	// wrong value for Integer type
+	m.writeChannel <- WriteQuery{Channel: ch3, Value: "foo"}
+
+	timeout = make(chan struct{})
+	go Timeout(500, timeout)
+
+	select {
+	case er := <-m.errorChannel:
+		m.Equal(ch3, er.Channel)
+		m.True(er.Write)
+	case <-timeout:
+		m.Fail("no error from poller")
+	}
+
+	// device rejects write
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.6", "ro")
+	m.writeChannel <- WriteQuery{Channel: ch3, Value: "1"}
+
+	timeout = make(chan struct{})
+	go Timeout(500, timeout)
+
+	select {
+	case er := <-m.errorChannel:
+		m.Equal(PollError{Channel: ch3, Error: "SET failed: NotWritable", Write: true}, er)
+	case <-timeout:
+		m.Fail("no error from poller")
+	}
+
+	m.quitChannel <- struct{}{}
+	m.waitDone(done, "poll worker timeout on quit")
+
+	m.EnsureGotWarnings()
+	m.EnsureGotErrors()
+}
+
+// Test MQTT /on values processing in whole model
+func (m *ModelWorkersTest) TestModelWrite() {
+	timer := NewFakeRTimer(m.StartTime, 1*time.Millisecond)
+	m.model.SetPollTimer(timer)
+	obs := m.ModelObserver.DevObserver
+
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.4", "foo")
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.5", "bar")
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.6", "200")
+
+	m.model.Start()
+	defer m.model.Stop()
+
+	timer.Tick()
+
+	m.NoError(obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel1, type value, value foo, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel2, type value, value bar, order 2"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel3, type value, value 20.0, order 3"},
+	}, EventTimeout))
+
+	dev := m.model.DeviceChannelMap[m.config.Devices["snmp_device1"].Channels["channel3"]]
+
+	// non-writable channel is ignored
+	m.False(dev.AcceptOnValue("channel1", "baz"))
+	m.NoError(obs.WaitForNoMessages(WaitTimeout))
+
+	// writable channel value is published after read-back
+	m.False(dev.AcceptOnValue("channel3", "30"))
+	m.NoError(obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel3, value 30.0"},
+	}, EventTimeout))
+
+	// write error is published on error topic
+	m.False(dev.AcceptOnValue("channel3", "bad"))
+	m.NoError(obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnErrorEvent, "device snmp_device1, name channel3, error w"},
+	}, EventTimeout))
+
+	m.EnsureGotWarnings()
+	m.EnsureGotErrors()
+}
+
+// Write to full queue fails without blocking
+func (m *ModelWorkersTest) TestWriteQueueFull() {
+	dev := m.model.DeviceChannelMap[m.config.Devices["snmp_device1"].Channels["channel3"]]
+	errs := make(chan PollError, 1)
+	dev.writeChannel = make(chan WriteQuery)
+	dev.errorChannel = errs
+
+	m.False(dev.AcceptOnValue("channel3", "30"))
+	e := <-errs
+	m.Equal("channel3", e.Channel.Name)
+	m.True(e.Write)
+
+	m.EnsureGotErrors()
+}
+
 // Test whole model
 func (m *ModelWorkersTest) TestModel() {
 	// Create a fake timer to make poll shots
@@ -552,6 +1319,238 @@ func (m *ModelWorkersTest) TestModel() {
 	m.NoError(obs.WaitForNoMessages(WaitTimeout))
 }
 
+// Test whole model with batched requests
+func (m *ModelWorkersTest) TestModelBatch() {
+	timer := NewFakeRTimer(m.StartTime, 1*time.Millisecond)
+	m.model.SetPollTimer(timer)
+	obs := m.ModelObserver.DevObserver
+
+	m.config.Devices["snmp_device1"].MaxVarbinds = DefaultMaxVarbinds
+
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.4", "foo")
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.5", "bar")
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.6", "200")
+
+	m.model.Start()
+	defer m.model.Stop()
+
+	timer.Tick()
+
+	m.NoError(obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel1, type value, value foo, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel2, type value, value bar, order 2"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel3, type value, value 20.0, order 3"},
+	}, EventTimeout))
+
+	// channel2 and channel3 have the same interval
+	m.ElementsMatch([]int{1, 2}, fakeSNMPRequests)
+
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.5", "moo")
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.6", "300")
+
+	timer.Tick()
+	timer.Tick()
+
+	m.NoError(obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel2, value moo"},
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel3, value 30.0"},
+	}, EventTimeout))
+
+	m.NoError(obs.WaitForNoMessages(WaitTimeout))
+}
+
+// Fake SNMP connection which answers only when gate is open
+type SlowFakeSNMP struct {
+	FakeSNMP
+	gate chan struct{}
+}
+
+func (snmp *SlowFakeSNMP) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+	<-snmp.gate
+	return snmp.FakeSNMP.Get(oids)
+}
+
+// Test slow device doesn't delay polls of other devices
+func (m *ModelWorkersTest) TestModelSlowDevice() {
+	gate := make(chan struct{})
+	factory := func(config *DeviceConfig, debug bool) (SnmpInterface, error) {
+		snmp, err := NewFakeSNMP(config, debug)
+		if config.Address == "127.0.0.2" {
+			return &SlowFakeSNMP{*(snmp.(*FakeSNMP)), gate}, err
+		}
+		return snmp, err
+	}
+
+	device2 := &DeviceConfig{
+		Name:        "Device 2",
+		Address:     "127.0.0.2",
+		Community:   "test",
+		Id:          "snmp_device2",
+		SnmpVersion: gosnmp.Version2c,
+		SnmpTimeout: 1,
+	}
+	device2.Channels = map[string]*ChannelConfig{
+		"status": &ChannelConfig{
+			Name:         "status",
+			Oid:          ".1.2.3.7",
+			ControlType:  "text",
+			Conv:         AsIs,
+			PollInterval: 1000,
+			Order:        1,
+			Device:       device2,
+		},
+	}
+	m.config.Devices["snmp_device2"] = device2
+
+	var err error
+	m.model, err = NewSnmpModel(factory, m.config, m.StartTime)
+	m.Ck("can't create model", err)
+	m.model.Observe(m.ModelObserver)
+
+	timer := NewFakeRTimer(m.StartTime, 1*time.Millisecond)
+	m.model.SetPollTimer(timer)
+	obs := m.ModelObserver.DevObserver
+
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.4", "foo")
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.5", "bar")
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.6", "200")
+	InsertFakeSNMPMessage("127.0.0.2@test@.1.2.3.7", "ok")
+
+	m.model.Start()
+
+	timer.Tick()
+
+	m.NoError(obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel1, type value, value foo, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel2, type value, value bar, order 2"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel3, type value, value 20.0, order 3"},
+	}, EventTimeout))
+
+	// device 2 is still polled, so its next poll is skipped,
+	// but device 1 is polled on time
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.4", "baz")
+	timer.Tick()
+
+	m.NoError(obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_device1, name channel1, value baz"},
+	}, EventTimeout))
+	m.Equal(1, m.model.Stats().Skipped)
+
+	close(gate)
+
+	m.NoError(obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device2, name status, type text, value ok, order 1"},
+	}, EventTimeout))
+
+	// device 2 is polled again as soon as its poll is completed
+	InsertFakeSNMPMessage("127.0.0.2@test@.1.2.3.7", "fine")
+	timer.Tick()
+
+	m.NoError(obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_device2, name status, value fine"},
+	}, EventTimeout))
+
+	m.model.Stop()
+
+	// all channels on first and third polls, channel1 on second one
+	m.Equal(PollStats{Polls: 9, Skipped: 1, Overdue: 0}, m.model.Stats())
+	m.NoError(obs.WaitForNoMessages(WaitTimeout))
+}
+
+// Test failed device and channel don't stop polling of the rest
+func (m *ModelWorkersTest) TestModelFailedDevice() {
+	defer func(d time.Duration) { DeviceReconnectInterval = d }(DeviceReconnectInterval)
+	DeviceReconnectInterval = 0
+
+	var failing atomic.Bool
+	failing.Store(true)
+	factory := func(config *DeviceConfig, debug bool) (SnmpInterface, error) {
+		if config.Address == "127.0.0.2" && failing.Load() {
+			return nil, fmt.Errorf("unknown host")
+		}
+		return NewFakeSNMP(config, debug)
+	}
+
+	device2 := &DeviceConfig{
+		Name:        "Device 2",
+		Address:     "127.0.0.2",
+		Community:   "test",
+		Id:          "snmp_device2",
+		SnmpVersion: gosnmp.Version2c,
+		SnmpTimeout: 1,
+	}
+	device2.Channels = map[string]*ChannelConfig{
+		"status": &ChannelConfig{
+			Name:         "status",
+			Oid:          ".1.2.3.7",
+			ControlType:  "text",
+			Conv:         AsIs,
+			PollInterval: 1000,
+			Order:        1,
+			Device:       device2,
+		},
+	}
+	m.config.Devices["snmp_device2"] = device2
+
+	device1 := m.config.Devices["snmp_device1"]
+	device1.Channels["broken"] = &ChannelConfig{
+		Name:         "broken",
+		Oid:          "BAD-MIB::broken.0",
+		ControlType:  "value",
+		Conv:         AsIs,
+		PollInterval: 1000,
+		Order:        4,
+		Device:       device1,
+		Error:        "can't resolve OID BAD-MIB::broken.0",
+	}
+
+	var err error
+	m.model, err = NewSnmpModel(factory, m.config, m.StartTime)
+	m.Ck("failed device must not fail model", err)
+	m.model.Observe(m.ModelObserver)
+
+	timer := NewFakeRTimer(m.StartTime, 1*time.Millisecond)
+	m.model.SetPollTimer(timer)
+	obs := m.ModelObserver.DevObserver
+
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.4", "foo")
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.5", "bar")
+	InsertFakeSNMPMessage("127.0.0.1@test@.1.2.3.6", "200")
+	InsertFakeSNMPMessage("127.0.0.2@test@.1.2.3.7", "ok")
+
+	m.model.Start()
+	defer m.model.Stop()
+
+	// failed channel is shown at start
+	m.NoError(obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name broken, type value, value , order 4"},
+		&MockDeviceEvent{OnErrorEvent, "device snmp_device1, name broken, error r"},
+	}, EventTimeout))
+
+	timer.Tick()
+
+	m.NoError(obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel1, type value, value foo, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel2, type value, value bar, order 2"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device1, name channel3, type value, value 20.0, order 3"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_device2, name status, type text, value , order 1"},
+		&MockDeviceEvent{OnErrorEvent, "device snmp_device2, name status, error r"},
+	}, EventTimeout))
+
+	// device is recovered on next poll
+	failing.Store(false)
+	timer.Tick()
+
+	m.NoError(obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_device2, name status, value ok"},
+		&MockDeviceEvent{OnErrorEvent, "device snmp_device2, name status, error "},
+	}, EventTimeout))
+
+	m.NoError(obs.WaitForNoMessages(WaitTimeout))
+
+	m.EnsureGotErrors()
+}
+
 func TestModelWorkers(t *testing.T) {
 	s := new(ModelWorkersTest)
 
@@ -14,18 +14,58 @@ import (
 type PollQuery struct {
 	Channel  *ChannelConfig
 	Deadline time.Time
+
+	// Other channels of the same device and poll interval
+	// requested in the same GET PDU with Channel
+	Batch []*ChannelConfig
+
+	// Probe of suspended device, its result is not published
+	Probe bool
+}
+
+// Get all channels of query
+func (q *PollQuery) Channels() []*ChannelConfig {
+	return append([]*ChannelConfig{q.Channel}, q.Batch...)
+}
+
+// Write query unit
+// Sent from device on MQTT /on message to PollWorker
+// which performs SNMP SET and reads the value back
+type WriteQuery struct {
+	Channel *ChannelConfig
+	Value   string
 }
 
 // Poll result is data sent from PollWorker to PublishWorker
-// Data is processed by Conv function by PollWorker
+// Data is processed by Convert method of channel by PollWorker
+// Write flag is set for results of write queries,
+// Trap flag is set for values received in traps
+// Rows are set instead of Data for table channels,
+// Sample is set instead of Data for rate channels
 type PollResult struct {
 	Channel *ChannelConfig
 	Data    string
+	Rows    []TableRow
+	Sample  *CounterSample
+	Write   bool
+	Trap    bool
 }
 
+// Poll error is sent instead of result, flags are the same as of result
 type PollError struct {
 	Channel *ChannelConfig
 	Error   string
+	Write   bool
+	Trap    bool
+}
+
+// Scheduler statistics
+// Polls is a number of polled channels, Skipped is a number of
+// channels not polled because previous poll is still running,
+// Overdue is a number of channels polled later than a poll interval
+// after their deadline
+type PollStats struct {
+	Polls, Skipped, Overdue int
 }
 
 // Poll queue structure
@@ -125,6 +165,14 @@ func (p *PollQueue) GetHead() (q PollQuery, err error) {
 	return
 }
 
+// Backoff state of suspended device
+// Probe interval is doubled after each probe up to max one
+type PollBackoff struct {
+	Probe         *ChannelConfig
+	Interval, Max time.Duration
+	NextProbe     time.Time
+}
+
 // Poll table is a set of poll queues with different
 // poll_interval in each queue. This allows us to avoid
 // sorting and might work well with lots of channels with
@@ -137,15 +185,48 @@ type PollTable struct {
 	// Sorted in ascending order (to process
 	// more frequent polls first)
 	Intervals []int
+
+	// Suspended devices: their queries are requeued
+	// but not sent, only probes are sent instead
+	Suspended map[*DeviceConfig]*PollBackoff
+
+	// Number of overdue queries since last TakeOverdue
+	overdue int
 }
 
 func NewPollTable() *PollTable {
 	return &PollTable{
 		Queues:    make(map[int]*PollQueue),
 		Intervals: make([]int, 0),
+		Suspended: make(map[*DeviceConfig]*PollBackoff),
+	}
+}
+
+// Suspend polling of probe channel device
+// First probe is sent after given interval
+func (t *PollTable) Suspend(probe *ChannelConfig, now time.Time, interval, max time.Duration) {
+	if interval > max {
+		interval = max
+	}
+	t.Suspended[probe.Device] = &PollBackoff{
+		Probe:     probe,
+		Interval:  interval,
+		Max:       max,
+		NextProbe: now.Add(interval),
 	}
 }
 
+// Resume normal polling of device
+func (t *PollTable) Resume(dev *DeviceConfig) {
+	delete(t.Suspended, dev)
+}
+
+// Check if device polling is suspended
+func (t *PollTable) IsSuspended(dev *DeviceConfig) bool {
+	_, ok := t.Suspended[dev]
+	return ok
+}
+
 // Add queue to poll table
 func (t *PollTable) AddQueue(q *PollQueue, interval int) error {
 	// check if such queue is presented here
@@ -163,11 +244,42 @@ func (t *PollTable) AddQueue(q *PollQueue, interval int) error {
 	return nil
 }
 
-// Do "poll" action
-// Push pending polls into a given channel and requeue them
-// Returns number of polls sent into process
-func (t *PollTable) Poll(out chan PollQuery, deadline time.Time) int {
-	count := 0
+// Replace queries of removed devices and channels with added queries
+// Queues are rebuilt in deadline order, empty queues are dropped
+func (t *PollTable) UpdateDevices(removed map[*DeviceConfig]bool, removedChannels map[*ChannelConfig]bool, added map[int][]PollQuery) {
+	queries := make(map[int][]PollQuery)
+	for interval, q := range t.Queues {
+		for !q.IsEmpty() {
+			head, _ := q.Pop()
+			if !removed[head.Channel.Device] && !removedChannels[head.Channel] {
+				queries[interval] = append(queries[interval], head)
+			}
+		}
+	}
+	for interval, lst := range added {
+		queries[interval] = append(queries[interval], lst...)
+	}
+
+	for dev := range removed {
+		t.Resume(dev)
+	}
+
+	t.Queues = make(map[int]*PollQueue)
+	t.Intervals = make([]int, 0, len(queries))
+	for interval, lst := range queries {
+		if len(lst) == 0 {
+			continue
+		}
+		sort.SliceStable(lst, func(i, j int) bool { return lst[i].Deadline.Before(lst[j].Deadline) })
+		t.AddQueue(NewPollQueue(lst), interval)
+	}
+}
+
+// Pop pending polls and requeue them with new deadline
+// Returns queries as they were popped, ordered by poll interval,
+// followed by pending probes of suspended devices
+func (t *PollTable) Pending(deadline time.Time) []PollQuery {
+	res := make([]PollQuery, 0)
 
 	// process key by key
 	for _, poll_interval := range t.Intervals {
@@ -175,22 +287,64 @@ func (t *PollTable) Poll(out chan PollQuery, deadline time.Time) int {
 			head, err := t.Queues[poll_interval].Pop()
 			if err != nil {
 				// TODO: log error here
-				return count
+				return res
 			}
 
-			// fmt.Printf("[polltable] Send request from head: %v\n", head)
-			out <- head
+			if !t.IsSuspended(head.Channel.Device) {
+				res = append(res, head)
+				if deadline.Sub(head.Deadline) >= time.Duration(poll_interval)*time.Millisecond {
+					t.overdue++
+				}
+			}
 			head.Deadline = deadline.Add(time.Duration(poll_interval) * time.Millisecond)
 			t.Queues[poll_interval].Push(head)
-			count += 1
 		}
 	}
 
-	return count
+	for _, b := range t.Suspended {
+		if b.NextProbe.After(deadline) {
+			continue
+		}
+
+		res = append(res, PollQuery{Channel: b.Probe, Deadline: b.NextProbe, Probe: true})
+
+		b.Interval *= 2
+		if b.Interval > b.Max {
+			b.Interval = b.Max
+		}
+		b.NextProbe = deadline.Add(b.Interval)
+	}
+
+	return res
+}
+
+// Get number of overdue queries and reset it
+func (t *PollTable) TakeOverdue() int {
+	n := t.overdue
+	t.overdue = 0
+	return n
+}
+
+// Do "poll" action
+// Push pending polls into a given channel and requeue them
+// Returns number of polls sent into process
+func (t *PollTable) Poll(out chan PollQuery, deadline time.Time) int {
+	queries := t.Pending(deadline)
+	for i := range queries {
+		// fmt.Printf("[polltable] Send request from head: %v\n", queries[i])
+		out <- queries[i]
+	}
+
+	return len(queries)
 }
 
 // Get next poll time point
 func (t *PollTable) NextPollTime() (minTime time.Time, err error) {
+	if len(t.Intervals) == 0 {
+		err = fmt.Errorf("poll table is empty")
+		return
+	}
+
 	// Go through all queues heads and get minimal time
 	var head, h PollQuery
 	head, err = t.Queues[t.Intervals[0]].GetHead()
@@ -210,5 +364,11 @@ func (t *PollTable) NextPollTime() (minTime time.Time, err error) {
 		}
 	}
 
+	for _, b := range t.Suspended {
+		if b.NextProbe.Before(minTime) {
+			minTime = b.NextProbe
+		}
+	}
+
 	return
 }
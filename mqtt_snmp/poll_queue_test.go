@@ -141,6 +141,70 @@ func (p *PollQueueTest) TestPollTable() {
 	}
 }
 
+func (p *PollQueueTest) TestSuspend() {
+	dev1, dev2 := &DeviceConfig{Id: "dev1"}, &DeviceConfig{Id: "dev2"}
+	ch1, ch2 := NewEmptyChannelConfig(), NewEmptyChannelConfig()
+	ch1.Device, ch2.Device = dev1, dev2
+	probe := &ChannelConfig{Name: "probe", Oid: DefaultProbeOid, Device: dev1}
+
+	t := time.Date(2016, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	pt := NewPollTable()
+	pt.AddQueue(NewPollQueue([]PollQuery{{Channel: ch1, Deadline: t}, {Channel: ch2, Deadline: t}}), 1000)
+
+	pt.Suspend(probe, t, time.Second, 3*time.Second)
+	p.True(pt.IsSuspended(dev1))
+	p.False(pt.IsSuspended(dev2))
+
+	// queries of suspended device are requeued but not sent
+	res := pt.Pending(t)
+	if p.Len(res, 1) {
+		p.Equal(ch2, res[0].Channel)
+	}
+
+	// probe interval is doubled up to max one
+	start := t
+	probes := make([]int, 0)
+	for i := 1; i <= 10; i++ {
+		t = start.Add(time.Duration(i) * time.Second)
+		for _, q := range pt.Pending(t) {
+			if q.Probe {
+				probes = append(probes, i)
+				p.Equal(probe, q.Channel)
+			} else {
+				p.Equal(ch2, q.Channel)
+			}
+		}
+	}
+	p.Equal([]int{1, 3, 6, 9}, probes)
+
+	next, err := pt.NextPollTime()
+	p.NoError(err)
+	p.Equal(t.Add(time.Second), next)
+
+	pt.Resume(dev1)
+	p.False(pt.IsSuspended(dev1))
+	t = t.Add(time.Second)
+	p.Len(pt.Pending(t), 2)
+}
+
+func (p *PollQueueTest) TestOverdue() {
+	ch := []*ChannelConfig{NewEmptyChannelConfig(), NewEmptyChannelConfig()}
+	t := time.Date(2016, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	pt := NewPollTable()
+	pt.AddQueue(NewPollQueue([]PollQuery{{Channel: ch[0], Deadline: t}}), 1000)
+	pt.AddQueue(NewPollQueue([]PollQuery{{Channel: ch[1], Deadline: t}}), 5000)
+
+	// late polls are not overdue until whole interval is missed
+	p.Len(pt.Pending(t.Add(999*time.Millisecond)), 2)
+	p.Equal(0, pt.TakeOverdue())
+
+	p.Len(pt.Pending(t.Add(3*time.Second)), 1)
+	p.Equal(1, pt.TakeOverdue())
+	p.Equal(0, pt.TakeOverdue())
+}
+
 func TestPollQueue(t *testing.T) {
 	s := new(PollQueueTest)
 
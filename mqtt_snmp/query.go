@@ -0,0 +1,220 @@
+package mqtt_snmp
+
+// Device query module
+// Reads variables of configured device right away, values are
+// converted by matching channels the same way daemon publishes them,
+// so templates can be checked against real devices
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/wirenboard/wb-mqtt-snmp/snmp_oid"
+)
+
+// Value of variable converted by one of channels
+type QueryValue struct {
+	// Channel name, row name for table channels
+	Channel string
+
+	// Value as it's published by daemon
+	Value string
+
+	// Conversion error or note why value is not published as is
+	Error string
+}
+
+// Variable read by query
+type QueryResult struct {
+	// Numeric OID with leading dot
+	Oid string
+
+	// Name from MIB like "MODULE::name.index", empty if object is unknown
+	Name string
+
+	Type gosnmp.Asn1BER
+
+	// Value without channel conversions, binary strings are in hex
+	Raw string
+
+	// Values of channels matching variable
+	Values []QueryValue
+}
+
+// Query of configured device
+type DeviceQuery struct {
+	Device *DeviceConfig
+
+	mib  *Mib
+	snmp SnmpInterface
+
+	// Channels sorted by names
+	channels []*ChannelConfig
+}
+
+// Create query of device with given ID
+// OIDs of device channels are translated, other devices are not touched
+func NewDeviceQuery(snmpFactory SnmpFactory, config *DaemonConfig, id string, debug bool) (*DeviceQuery, error) {
+	dev, ok := config.Devices[id]
+	if !ok {
+		return nil, fmt.Errorf("no such device: %s", id)
+	}
+
+	// failed channels are not matched, so errors are ignored
+	TranslateOidsInDaemonConfig(&DaemonConfig{MibDirs: config.MibDirs, Devices: map[string]*DeviceConfig{id: dev}})
+
+	snmp, err := snmpFactory(dev, debug)
+	if err != nil {
+		return nil, fmt.Errorf("can't create SNMP session for %s: %s", id, err)
+	}
+
+	q := &DeviceQuery{Device: dev, mib: NewMib(config.MibDirs), snmp: snmp}
+	// variables are named by objects of any module
+	q.mib.LoadAll()
+
+	for _, ch := range dev.Channels {
+		if ch.Computed == nil && ch.Error == "" {
+			q.channels = append(q.channels, ch)
+		}
+	}
+	sort.Slice(q.channels, func(i, j int) bool { return q.channels[i].Name < q.channels[j].Name })
+
+	return q, nil
+}
+
+// Get MIB used to translate OIDs
+func (q *DeviceQuery) Mib() *Mib {
+	return q.mib
+}
+
+// Translate OID given by user, device OID prefix is applied to MIB names
+func (q *DeviceQuery) translate(oid string) (string, error) {
+	return q.mib.Translate(q.Device.prefixedOid(oid))
+}
+
+// Convert variable by channel as poll worker does
+func channelValue(ch *ChannelConfig, v gosnmp.SnmpPDU) QueryValue {
+	if ch.Rate != 0 {
+		return QueryValue{Channel: ch.Name, Error: "rate of counter is published"}
+	}
+
+	data, valid := ch.ConvertValue(v)
+	if !valid {
+		return QueryValue{Channel: ch.Name, Error: "instance can't be converted to string"}
+	}
+	data, err := ch.Convert(data)
+	if err != nil {
+		return QueryValue{Channel: ch.Name, Error: err.Error()}
+	}
+	return QueryValue{Channel: ch.Name, Value: data}
+}
+
+// Make result of variable with values of matching channels
+func (q *DeviceQuery) result(v gosnmp.SnmpPDU) QueryResult {
+	oid := snmp_oid.Normalize(v.Name)
+	res := QueryResult{Oid: oid, Type: v.Type}
+
+	obj, index := q.mib.Object(oid)
+	if obj != nil {
+		res.Name = obj.Module + "::" + obj.Name
+		if index != "" {
+			res.Name += "." + index
+		}
+	}
+
+	res.Raw, _ = (&ChannelConfig{Object: obj}).ConvertValue(v)
+
+	switch v.Type {
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView, gosnmp.Null:
+		return res
+	}
+
+	for _, ch := range q.channels {
+		if ch.Table {
+			if index, ok := tableIndex(ch.Oid, oid); ok {
+				val := channelValue(ch, v)
+				val.Channel = ch.rowName(index, "")
+				res.Values = append(res.Values, val)
+			}
+		} else if snmp_oid.Normalize(ch.Oid) == oid {
+			res.Values = append(res.Values, channelValue(ch, v))
+		}
+	}
+
+	return res
+}
+
+// Read variables by GET request
+func (q *DeviceQuery) Get(oids []string) ([]QueryResult, error) {
+	translated := make([]string, len(oids))
+	for i, oid := range oids {
+		var err error
+		if translated[i], err = q.translate(oid); err != nil {
+			return nil, fmt.Errorf("can't resolve OID %s: %s", oid, err)
+		}
+	}
+
+	packet, err := q.snmp.Get(translated)
+	if err != nil {
+		return nil, err
+	}
+	if packet.Error != gosnmp.NoError {
+		return nil, fmt.Errorf("%s (error index %d)", packet.Error, packet.ErrorIndex)
+	}
+
+	res := make([]QueryResult, len(packet.Variables))
+	for i, v := range packet.Variables {
+		res[i] = q.result(v)
+	}
+	return res, nil
+}
+
+// Read raw variables of subtree
+func (q *DeviceQuery) WalkVariables(subtree string) ([]gosnmp.SnmpPDU, error) {
+	oid, err := q.translate(subtree)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve OID %s: %s", subtree, err)
+	}
+	return q.snmp.Walk(oid)
+}
+
+// Read variables of subtree
+func (q *DeviceQuery) Walk(subtree string) ([]QueryResult, error) {
+	pdus, err := q.WalkVariables(subtree)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]QueryResult, len(pdus))
+	for i, v := range pdus {
+		res[i] = q.result(v)
+	}
+	return res, nil
+}
+
+// Write results one per line followed by values of channels
+func WriteQueryResults(w io.Writer, results []QueryResult) {
+	for _, r := range results {
+		name := r.Oid
+		if r.Name != "" {
+			name = r.Name + " (" + r.Oid + ")"
+		}
+
+		switch r.Type {
+		case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView, gosnmp.Null:
+			fmt.Fprintf(w, "%s = %s\n", name, r.Type)
+		default:
+			fmt.Fprintf(w, "%s = %s: %s\n", name, r.Type, r.Raw)
+		}
+
+		for _, v := range r.Values {
+			if v.Error != "" {
+				fmt.Fprintf(w, "    %s: (%s)\n", v.Channel, v.Error)
+			} else {
+				fmt.Fprintf(w, "    %s: %s\n", v.Channel, v.Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package mqtt_snmp
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+type QuerySuite struct {
+	testutils.Suite
+
+	dir    string
+	config *DaemonConfig
+	query  *DeviceQuery
+}
+
+func (s *QuerySuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	fakeSNMPMessages = make(map[string]*gosnmp.SnmpPacket)
+	fakeSNMPRequests = nil
+
+	var err error
+	s.dir, err = os.MkdirTemp("", "wb-mqtt-snmp-query")
+	s.Ck("can't create temp dir", err)
+
+	s.config, err = NewDaemonConfig(strings.NewReader(`{
+		"mib_dirs": [],
+		"devices": [{
+			"id": "ups",
+			"address": "127.0.0.1",
+			"community": "test",
+			"channels": [
+				{"name": "temperature", "oid": ".1.2.3.1", "control_type": "temperature", "scale": 0.1},
+				{"name": "status", "oid": ".1.2.3.2", "control_type": "text", "enum": {"1": "online", "2": "battery"}},
+				{"name": "status code", "oid": ".1.2.3.2", "control_type": "value"},
+				{"name": "traffic", "oid": ".1.2.3.3", "control_type": "value", "rate": "second"},
+				{"name": "ports", "oid": ".1.2.4", "control_type": "value", "table": true, "row_name": "Port {index}"}
+			]
+		}]
+	}`), s.dir)
+	s.Ck("can't parse config", err)
+
+	s.query, err = NewDeviceQuery(NewFakeSNMP, s.config, "ups", false)
+	s.Ck("can't create query", err)
+}
+
+func (s *QuerySuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+	s.Suite.TearDownTest()
+}
+
+func (s *QuerySuite) insert(oid string, t gosnmp.Asn1BER, value any) {
+	fakeSNMPMessages["127.0.0.1@test@"+oid] = &gosnmp.SnmpPacket{
+		Variables: []gosnmp.SnmpPDU{{Name: oid, Type: t, Value: value}},
+	}
+}
+
+func (s *QuerySuite) TestGet() {
+	s.insert(".1.2.3.1", gosnmp.Integer, 215)
+	s.insert(".1.2.3.2", gosnmp.Integer, 2)
+	s.insert(".1.2.3.3", gosnmp.Counter32, uint(1000))
+	s.insert(".1.2.3.9", gosnmp.OctetString, []byte{0x00, 0xff})
+
+	res, err := s.query.Get([]string{".1.2.3.1", ".1.2.3.2", "1.2.3.3", ".1.2.3.9", ".1.2.3.10"})
+	s.Ck("get failed", err)
+
+	s.Equal([]QueryResult{
+		{Oid: ".1.2.3.1", Type: gosnmp.Integer, Raw: "215", Values: []QueryValue{{Channel: "temperature", Value: "21.5"}}},
+		{Oid: ".1.2.3.2", Type: gosnmp.Integer, Raw: "2", Values: []QueryValue{
+			{Channel: "status", Value: "battery"},
+			{Channel: "status code", Value: "2"},
+		}},
+		{Oid: ".1.2.3.3", Type: gosnmp.Counter32, Raw: "1000", Values: []QueryValue{{Channel: "traffic", Error: "rate of counter is published"}}},
+		{Oid: ".1.2.3.9", Type: gosnmp.OctetString, Raw: "00 FF"},
+		{Oid: ".1.2.3.10", Type: gosnmp.NoSuchInstance},
+	}, res)
+}
+
+func (s *QuerySuite) TestGetErrors() {
+	_, err := s.query.Get([]string{"NO-SUCH-MIB::x.0"})
+	s.Error(err)
+
+	_, err = s.query.Get([]string{".1.2.3.1"})
+	s.Error(err, "request of device without variables doesn't fail")
+}
+
+func (s *QuerySuite) TestWalk() {
+	s.insert(".1.2.4.1", gosnmp.Integer, 1)
+	s.insert(".1.2.4.2", gosnmp.Integer, 2)
+	s.insert(".1.2.3.1", gosnmp.Integer, 215)
+
+	res, err := s.query.Walk(".1.2.4")
+	s.Ck("walk failed", err)
+
+	s.Equal([]QueryResult{
+		{Oid: ".1.2.4.1", Type: gosnmp.Integer, Raw: "1", Values: []QueryValue{{Channel: "Port 1", Value: "1"}}},
+		{Oid: ".1.2.4.2", Type: gosnmp.Integer, Raw: "2", Values: []QueryValue{{Channel: "Port 2", Value: "2"}}},
+	}, res)
+}
+
+func (s *QuerySuite) TestNoDevice() {
+	_, err := NewDeviceQuery(NewFakeSNMP, s.config, "nope", false)
+	s.Error(err)
+}
+
+func (s *QuerySuite) TestWriteResults() {
+	var b bytes.Buffer
+	WriteQueryResults(&b, []QueryResult{
+		{Oid: ".1.3.6.1.2.1.1.5.0", Name: "SNMPv2-MIB::sysName.0", Type: gosnmp.OctetString, Raw: "ups", Values: []QueryValue{{Channel: "name", Value: "ups"}}},
+		{Oid: ".1.2.3.3", Type: gosnmp.Counter32, Raw: "1000", Values: []QueryValue{{Channel: "traffic", Error: "rate of counter is published"}}},
+		{Oid: ".1.2.3.10", Type: gosnmp.NoSuchInstance},
+	})
+	s.Equal("SNMPv2-MIB::sysName.0 (.1.3.6.1.2.1.1.5.0) = OctetString: ups\n"+
+		"    name: ups\n"+
+		".1.2.3.3 = Counter32: 1000\n"+
+		"    traffic: (rate of counter is published)\n"+
+		".1.2.3.10 = NoSuchInstance\n", b.String())
+}
+
+func TestQuery(t *testing.T) {
+	testutils.RunSuites(t, new(QuerySuite))
+}
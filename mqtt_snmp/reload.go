@@ -0,0 +1,441 @@
+package mqtt_snmp
+
+// Config reload module
+// New config is compared with running one device by device:
+// devices with changed settings are removed and created again,
+// devices with changed channels only keep running configs and
+// replace changed channels, the rest keep their controls,
+// cached values and poll schedule
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/contactless/wbgo"
+)
+
+// Optional model observer extension to clear retained device meta
+// when device is removed on config reload
+type DeviceRemover interface {
+	RemoveDevice(dev wbgo.DeviceModel)
+}
+
+// Get copy of device entry with channels mapped by names,
+// as order of channels list in merged entry is random
+func normalizeSource(entry map[string]any) map[string]any {
+	res := make(map[string]any, len(entry))
+	for key, value := range entry {
+		res[key] = value
+	}
+
+	if channels, ok := entry["channels"].([]map[string]any); ok {
+		byName := make(map[string]any, len(channels))
+		for _, ch := range channels {
+			name, _ := ch["name"].(string)
+			byName[name] = ch
+		}
+		res["channels"] = byName
+	}
+
+	return res
+}
+
+// Check if device configs are parsed from the same merged entries
+// Configs which are not parsed from JSON are always different
+func (d *DeviceConfig) sameSource(other *DeviceConfig) bool {
+	if d.source == nil || other.source == nil {
+		return false
+	}
+	return reflect.DeepEqual(normalizeSource(d.source), normalizeSource(other.source))
+}
+
+// Channel changes of device which is kept on reload
+type channelChanges struct {
+	// Running and new configs of device
+	running, config *DeviceConfig
+
+	// Channels of running config to remove and channels of new config to add,
+	// changed channels are in both lists, lists are sorted by names
+	removed, added []*ChannelConfig
+}
+
+// Split normalized device entry into device settings and channels by names
+func splitSource(entry map[string]any) (settings, channels map[string]any) {
+	settings = normalizeSource(entry)
+	channels, _ = settings["channels"].(map[string]any)
+	delete(settings, "channels")
+	return
+}
+
+// Get channel changes of device with the same ID
+// False is returned if device must be created again: its settings are
+// changed or controls placed after channels would change their order
+func diffChannels(running, config *DeviceConfig) (*channelChanges, bool) {
+	if running.source == nil || config.source == nil {
+		return nil, false
+	}
+
+	runningSettings, runningChannels := splitSource(running.source)
+	settings, channels := splitSource(config.source)
+	if !reflect.DeepEqual(runningSettings, settings) {
+		return nil, false
+	}
+
+	// order of last trap and availability controls depends on number of channels
+	if (config.LastTrapControl || config.AvailabilityControls) && len(running.Channels) != len(config.Channels) {
+		return nil, false
+	}
+
+	c := &channelChanges{running: running, config: config}
+	for name, ch := range running.Channels {
+		if entry, ok := channels[name]; !ok || !reflect.DeepEqual(runningChannels[name], entry) {
+			c.removed = append(c.removed, ch)
+		}
+	}
+	for name, ch := range config.Channels {
+		if entry, ok := runningChannels[name]; !ok || !reflect.DeepEqual(channels[name], entry) {
+			c.added = append(c.added, ch)
+		}
+	}
+
+	sort.Slice(c.removed, func(i, j int) bool { return c.removed[i].Name < c.removed[j].Name })
+	sort.Slice(c.added, func(i, j int) bool { return c.added[i].Name < c.added[j].Name })
+
+	return c, true
+}
+
+// Get devices of running config to remove and devices of new config to add,
+// devices with changed settings are in both lists, lists are sorted by IDs
+// Devices with changed channels only are listed with their channel changes
+func diffDevices(running, config *DaemonConfig) (removed, added []*DeviceConfig, changed []*channelChanges) {
+	for id, dev := range running.Devices {
+		other, ok := config.Devices[id]
+		if ok && dev.sameSource(other) {
+			continue
+		}
+		if ok {
+			if c, ok := diffChannels(dev, other); ok {
+				changed = append(changed, c)
+				continue
+			}
+		}
+		removed = append(removed, dev)
+	}
+
+	for id, dev := range config.Devices {
+		other, ok := running.Devices[id]
+		if ok && dev.sameSource(other) {
+			continue
+		}
+		if ok {
+			if _, ok := diffChannels(other, dev); ok {
+				continue
+			}
+		}
+		added = append(added, dev)
+	}
+
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Id < removed[j].Id })
+	sort.Slice(added, func(i, j int) bool { return added[i].Id < added[j].Id })
+	sort.Slice(changed, func(i, j int) bool { return changed[i].running.Id < changed[j].running.Id })
+
+	return
+}
+
+// Warn about changed daemon settings, they are applied on restart only
+func warnDaemonChanges(running, config *DaemonConfig) {
+	if running.Debug != config.Debug {
+		wbgo.Warn.Printf("debug setting is changed, restart is required to apply it")
+	}
+	if running.NumWorkers != config.NumWorkers {
+		wbgo.Warn.Printf("num_workers setting is changed, restart is required to apply it")
+	}
+	if running.TrapListen != config.TrapListen {
+		wbgo.Warn.Printf("trap_listen setting is changed, restart is required to apply it")
+	}
+	if !reflect.DeepEqual(running.MibDirs, config.MibDirs) {
+		wbgo.Warn.Printf("mib_dirs setting is changed, restart is required to apply it")
+	}
+	if running.RecordDir != config.RecordDir {
+		wbgo.Warn.Printf("record_dir setting is changed, restart is required to apply it")
+	}
+}
+
+// Apply new config to running model
+// Config is taken right from parser: OIDs are translated for
+// new and changed devices and channels only, the rest keep running configs
+// Removed and changed devices are removed with their controls,
+// new and changed devices are created and polled right away
+// Devices with changed channels only replace those channels the same way
+func (m *SnmpModel) Reload(config *DaemonConfig, now time.Time) {
+	warnDaemonChanges(m.config, config)
+
+	removed, added, changed := diffDevices(m.config, config)
+	if len(removed) == 0 && len(added) == 0 && len(changed) == 0 {
+		wbgo.Info.Printf("config is reloaded, devices are not changed")
+		return
+	}
+
+	// channels which can't be translated are marked as failed,
+	// added channels are translated with settings of their new device config
+	addedConfig := &DaemonConfig{MibDirs: m.config.MibDirs, Devices: make(map[string]*DeviceConfig, len(added)+len(changed))}
+	for _, dev := range added {
+		addedConfig.Devices[dev.Id] = dev
+	}
+	for _, c := range changed {
+		dev := *c.config
+		dev.Channels = make(map[string]*ChannelConfig, len(c.added))
+		for _, ch := range c.added {
+			dev.Channels[ch.Name] = ch
+		}
+		addedConfig.Devices[dev.Id] = &dev
+	}
+	if err := TranslateOidsInDaemonConfig(addedConfig); err != nil {
+		wbgo.Error.Printf("error translating OIDs: %s", err)
+	}
+
+	// stop poll timer worker, so poll table is not used while it's updated
+	quit := m.quitChannels[m.config.NumWorkers+1]
+	quit <- struct{}{}
+	<-m.pollTimerDoneChannel
+
+	// devices are replaced by publisher worker, so no results are
+	// published to removed devices; poll signals are dropped meanwhile
+	// like poll timer worker does
+	finished := make(chan struct{})
+	apply := func() {
+		m.applyReload(removed, added, changed, now)
+		close(finished)
+	}
+	task := m.taskChannel
+	for waiting := true; waiting; {
+		select {
+		case task <- apply:
+			task = nil
+		case <-m.pollDoneChannel:
+		case <-m.pubDoneChannel:
+		case <-finished:
+			waiting = false
+		}
+	}
+
+	// new devices are polled right away
+	if nextPoll, err := m.pollTable.NextPollTime(); err == nil && (m.nextPoll.IsZero() || nextPoll.Before(m.nextPoll)) {
+		m.pollTimer.Reset(nextPoll.Sub(now))
+		m.nextPoll = nextPoll
+	}
+
+	go m.PollTimerWorker(quit, m.pollTimerDoneChannel)
+
+	wbgo.Info.Printf("config is reloaded: %d devices removed, %d devices added, %d devices changed", len(removed), len(added), len(changed))
+}
+
+// Replace devices and channels of running config
+// Called by publisher worker while poll timer worker is stopped,
+// devices are added to and removed from observer in its goroutine
+func (m *SnmpModel) applyReload(removed, added []*DeviceConfig, changed []*channelChanges, now time.Time) {
+	removedSet := make(map[*DeviceConfig]bool, len(removed))
+	for _, config := range removed {
+		removedSet[config] = true
+		m.removeDevice(m.deviceConfigMap[config])
+	}
+
+	newDevices := make([]*SnmpDevice, len(added))
+	addedMap := make(map[string]*DeviceConfig, len(added))
+	for i, config := range added {
+		wbgo.Info.Printf("adding device %s", config.Id)
+		newDevices[i] = newSnmpDevice(m.snmpFactory, config, m.config.Debug)
+		addedMap[config.Id] = config
+	}
+
+	m.devicesMutex.Lock()
+
+	devices := make([]*SnmpDevice, 0, len(m.devices)+len(added))
+	for _, dev := range m.devices {
+		if !removedSet[dev.Config] {
+			devices = append(devices, dev)
+		}
+	}
+	m.devices = devices
+
+	for ch, dev := range m.DeviceChannelMap {
+		if removedSet[dev.Config] {
+			delete(m.DeviceChannelMap, ch)
+		}
+	}
+
+	runningDevices := make(map[string]*DeviceConfig, len(m.config.Devices))
+	for id, config := range m.config.Devices {
+		if !removedSet[config] {
+			runningDevices[id] = config
+		}
+	}
+
+	for _, config := range removed {
+		delete(m.deviceConfigMap, config)
+	}
+
+	for _, dev := range newDevices {
+		m.addDevice(dev)
+		runningDevices[dev.Config.Id] = dev.Config
+	}
+	m.config.Devices = runningDevices
+
+	if m.trapListener != nil {
+		m.trapDevices = mapTrapDevices(m.devices)
+	}
+
+	m.devicesMutex.Unlock()
+
+	if m.trapListener != nil {
+		m.addTrapUsers(newDevices)
+	}
+
+	removedChannels := make(map[*ChannelConfig]bool)
+	addedChannels := make([]*ChannelConfig, 0)
+	for _, c := range changed {
+		m.replaceChannels(m.deviceConfigMap[c.running], c)
+		for _, ch := range c.removed {
+			removedChannels[ch] = true
+		}
+		addedChannels = append(addedChannels, c.added...)
+	}
+
+	queries := deviceQueries(addedMap, now)
+	for interval, lst := range channelQueries(addedChannels, now) {
+		queries[interval] = append(queries[interval], lst...)
+	}
+	m.pollTable.UpdateDevices(removedSet, removedChannels, queries)
+
+	for _, dev := range newDevices {
+		m.callSync(func() { m.observeDevice(dev) })
+		m.startDevice(dev)
+	}
+
+	// failed channels are shown right away as they're never polled
+	for _, ch := range addedChannels {
+		if ch.Error != "" && !ch.Table {
+			dev := m.deviceConfigMap[ch.Device]
+			m.publishError(dev, ch, "r")
+			m.publishComputed(dev, ch)
+		}
+	}
+}
+
+// Replace changed channels of running device
+// Running config is kept, added channels are moved to it from new one
+// Untouched channels keep their controls, cached values and samples
+func (m *SnmpModel) replaceChannels(dev *SnmpDevice, c *channelChanges) {
+	wbgo.Info.Printf("changing channels of device %s: %d removed, %d added", dev.DevName, len(c.removed), len(c.added))
+
+	for _, ch := range c.removed {
+		for index, row := range dev.tableRows(ch) {
+			m.removeRow(dev, ch, index, row)
+		}
+		m.removeControl(dev, ch)
+	}
+
+	dev.rowsMutex.Lock()
+	for _, ch := range c.removed {
+		delete(dev.rows, ch)
+		delete(dev.Config.Channels, ch.Name)
+	}
+	for _, ch := range c.added {
+		ch.Device = dev.Config
+		dev.Config.Channels[ch.Name] = ch
+	}
+	dev.rowsMutex.Unlock()
+
+	// new config source is compared on next reload
+	dev.Config.source = c.config.source
+
+	m.devicesMutex.Lock()
+	for _, ch := range c.removed {
+		delete(m.DeviceChannelMap, ch)
+	}
+	for _, ch := range c.added {
+		m.DeviceChannelMap[ch] = dev
+	}
+	m.devicesMutex.Unlock()
+
+	dev.computed = computedChannels(dev.Config)
+
+	// alarm controls keep their states unless their order is changed
+	alarms := newAlarmStates(dev.Config)
+	for ch, s := range alarms {
+		if old, ok := dev.Alarms[ch]; ok && old.channel.Order == s.channel.Order {
+			alarms[ch] = old
+		}
+	}
+	for ch, old := range dev.Alarms {
+		if alarms[ch] != old {
+			m.removeControl(dev, old.channel)
+		}
+	}
+	dev.Alarms = alarms
+}
+
+// Remove published control of channel and its cached values
+func (m *SnmpModel) removeControl(dev *SnmpDevice, ch *ChannelConfig) {
+	if _, ok := dev.Cache[ch]; ok && m.Remover != nil {
+		m.Remover.RemoveControl(dev, ch.Name)
+	}
+
+	delete(dev.Cache, ch)
+	delete(dev.Error, ch)
+	delete(dev.Published, ch)
+	delete(dev.Latest, ch)
+	delete(dev.Samples, ch)
+}
+
+// Run function in observer goroutine and wait for its completion
+// Driver handles MQTT messages of devices in its own goroutine,
+// so its devices are added and removed there
+func (m *SnmpModel) callSync(thunk func()) {
+	done := make(chan struct{})
+	m.Observer.CallSync(func() {
+		thunk()
+		close(done)
+	})
+	<-done
+}
+
+// Add SNMPv3 users of new devices to running trap listener
+func (m *SnmpModel) addTrapUsers(devices []*SnmpDevice) {
+	params := m.trapListener.Params
+	for _, dev := range devices {
+		if dev.Config.V3 == nil {
+			continue
+		}
+		if params.TrapSecurityParametersTable == nil {
+			wbgo.Warn.Printf("SNMPv3 traps of %s are received after restart only", dev.DevName)
+			continue
+		}
+		if err := addTrapUser(params, dev); err != nil {
+			wbgo.Warn.Printf("%s", err)
+		}
+	}
+}
+
+// Remove device and its controls
+func (m *SnmpModel) removeDevice(dev *SnmpDevice) {
+	wbgo.Info.Printf("removing device %s", dev.DevName)
+
+	if m.Remover != nil {
+		names := make([]string, 0, len(dev.Cache))
+		for ch := range dev.Cache {
+			names = append(names, ch.Name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			m.Remover.RemoveControl(dev, name)
+		}
+	}
+
+	if m.DeviceRemover != nil {
+		m.DeviceRemover.RemoveDevice(dev)
+	}
+
+	m.callSync(func() { m.Observer.RemoveDevice(dev) })
+}
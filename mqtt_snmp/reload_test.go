@@ -0,0 +1,395 @@
+package mqtt_snmp
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo"
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+const reloadConfig = `{
+	"num_workers": 2,
+	"devices": [
+		{
+			"address": "127.0.0.1",
+			"community": "one",
+			"id": "dev1",
+			"channels": [{"name": "a", "oid": ".1.2.3.1", "poll_interval": 1000}]
+		},
+		{
+			"address": "127.0.0.1",
+			"community": "two",
+			"id": "dev2",
+			"channels": [
+				{"name": "e", "oid": ".1.2.3.5", "poll_interval": 1000},
+				{"name": "b", "oid": ".1.2.3.2", "poll_interval": 1000}
+			]
+		},
+		{
+			"address": "127.0.0.1",
+			"community": "four",
+			"id": "dev4",
+			"channels": [{"name": "f", "oid": ".1.2.3.6", "poll_interval": 1000}]
+		}
+	]
+}`
+
+const reloadChangedConfig = `{
+	"num_workers": 2,
+	"devices": [
+		{
+			"address": "127.0.0.1",
+			"community": "one",
+			"id": "dev1",
+			"channels": [{"name": "a", "oid": ".1.2.3.1", "poll_interval": 1000}]
+		},
+		{
+			"address": "127.0.0.1",
+			"community": "two",
+			"id": "dev2",
+			"channels": [
+				{"name": "e", "oid": ".1.2.3.5", "poll_interval": 1000},
+				{"name": "b", "oid": ".1.2.3.3", "poll_interval": 1000},
+				{"name": "c", "oid": ".1.2.3.2", "poll_interval": 2000}
+			]
+		},
+		{
+			"address": "127.0.0.1",
+			"community": "three",
+			"id": "dev3",
+			"channels": [{"name": "d", "oid": ".1.2.3.4", "poll_interval": 1000}]
+		},
+		{
+			"address": "127.0.0.1",
+			"community": "four",
+			"name": "Device 4",
+			"id": "dev4",
+			"channels": [{"name": "f", "oid": ".1.2.3.6", "poll_interval": 1000}]
+		}
+	]
+}`
+
+// Model observer keeping devices in its own goroutine like wbgo driver,
+// so race detector catches devices changed by other goroutines
+type syncModelObserver struct {
+	*FakeModelObserver
+
+	devices map[string]wbgo.DeviceModel
+	events  chan func()
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+func newSyncModelObserver(devObserver *MockDeviceObserver) *syncModelObserver {
+	return &syncModelObserver{
+		FakeModelObserver: NewFakeModelObserver(devObserver),
+		devices:           make(map[string]wbgo.DeviceModel),
+		events:            make(chan func(), 16),
+		quit:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+}
+
+func (o *syncModelObserver) CallSync(thunk func()) {
+	o.events <- thunk
+}
+
+func (o *syncModelObserver) OnNewDevice(dev wbgo.DeviceModel) {
+	o.devices[dev.Name()] = dev
+	o.FakeModelObserver.OnNewDevice(dev)
+}
+
+func (o *syncModelObserver) RemoveDevice(dev wbgo.DeviceModel) {
+	delete(o.devices, dev.Name())
+}
+
+// Handle value written to control like driver handles /on message
+func (o *syncModelObserver) write(device, control, value string) {
+	o.events <- func() {
+		if dev, ok := o.devices[device]; ok {
+			dev.(wbgo.LocalDeviceModel).AcceptOnValue(control, value)
+		}
+	}
+}
+
+// Run observer goroutine, devices are added before it by model start
+func (o *syncModelObserver) start() {
+	go func() {
+		defer close(o.done)
+		for {
+			select {
+			case f := <-o.events:
+				f()
+			case <-o.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (o *syncModelObserver) stop() {
+	close(o.quit)
+	<-o.done
+}
+
+type ReloadSuite struct {
+	testutils.Suite
+
+	templatesDir string
+	start        time.Time
+}
+
+func (s *ReloadSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	var err error
+	s.templatesDir, err = os.MkdirTemp("", "wb-mqtt-snmp-templates")
+	s.Ck("can't create templates dir", err)
+
+	fakeSNMPMessages = make(map[string]*gosnmp.SnmpPacket)
+	fakeSNMPMaxVarbinds = 0
+	fakeSNMPRequests = nil
+
+	s.start = time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (s *ReloadSuite) TearDownTest() {
+	os.RemoveAll(s.templatesDir)
+	s.Suite.TearDownTest()
+}
+
+func (s *ReloadSuite) parse(data string) *DaemonConfig {
+	config, err := NewDaemonConfig(strings.NewReader(data), s.templatesDir)
+	s.Ck("can't parse config", err)
+	return config
+}
+
+func (s *ReloadSuite) TestDiffDevices() {
+	running := s.parse(reloadConfig)
+
+	removed, added, changed := diffDevices(running, s.parse(reloadConfig))
+	s.Empty(removed)
+	s.Empty(added)
+	s.Empty(changed)
+
+	// device with changed settings is replaced, changed channels only are replaced
+	config := s.parse(reloadChangedConfig)
+	removed, added, changed = diffDevices(running, config)
+	s.Equal([]*DeviceConfig{running.Devices["dev4"]}, removed)
+	s.Equal([]*DeviceConfig{config.Devices["dev3"], config.Devices["dev4"]}, added)
+	if s.Len(changed, 1) {
+		s.Equal(running.Devices["dev2"], changed[0].running)
+		s.Equal(config.Devices["dev2"], changed[0].config)
+		s.Equal([]*ChannelConfig{running.Devices["dev2"].Channels["b"]}, changed[0].removed)
+		s.Equal([]*ChannelConfig{config.Devices["dev2"].Channels["b"], config.Devices["dev2"].Channels["c"]}, changed[0].added)
+	}
+
+	// order of availability controls depends on number of channels
+	withControls := func(data string) *DaemonConfig {
+		return s.parse(strings.Replace(data, `"id": "dev2",`, `"id": "dev2", "availability_controls": true,`, 1))
+	}
+	running = withControls(reloadConfig)
+	config = withControls(reloadChangedConfig)
+	removed, added, changed = diffDevices(running, config)
+	s.Equal([]*DeviceConfig{running.Devices["dev2"], running.Devices["dev4"]}, removed)
+	s.Equal([]*DeviceConfig{config.Devices["dev2"], config.Devices["dev3"], config.Devices["dev4"]}, added)
+	s.Empty(changed)
+
+	// configs made without parser are never the same
+	s.False(running.Devices["dev1"].sameSource(NewEmptyDeviceConfig()))
+}
+
+func (s *ReloadSuite) TestUpdatePollTable() {
+	running := s.parse(reloadConfig)
+	config := s.parse(reloadChangedConfig)
+
+	table := NewPollTable()
+	for interval, lst := range deviceQueries(running.Devices, s.start) {
+		table.AddQueue(NewPollQueue(lst), interval)
+	}
+	table.Suspend(running.Devices["dev4"].Channels["f"], s.start, time.Second, time.Minute)
+
+	next := s.start.Add(500 * time.Millisecond)
+	added := deviceQueries(map[string]*DeviceConfig{"dev4": config.Devices["dev4"]}, next)
+	for interval, lst := range channelQueries([]*ChannelConfig{config.Devices["dev2"].Channels["b"], config.Devices["dev2"].Channels["c"]}, next) {
+		added[interval] = append(added[interval], lst...)
+	}
+	table.UpdateDevices(map[*DeviceConfig]bool{running.Devices["dev4"]: true}, map[*ChannelConfig]bool{running.Devices["dev2"].Channels["b"]: true}, added)
+
+	s.False(table.IsSuspended(running.Devices["dev4"]))
+	s.Equal([]int{1000, 2000}, table.Intervals)
+
+	pending := table.Pending(next)
+	channels := make(map[*ChannelConfig]bool)
+	for _, q := range pending {
+		channels[q.Channel] = true
+	}
+	s.Equal(map[*ChannelConfig]bool{
+		running.Devices["dev1"].Channels["a"]: true,
+		running.Devices["dev2"].Channels["e"]: true,
+		config.Devices["dev2"].Channels["b"]:  true,
+		config.Devices["dev2"].Channels["c"]:  true,
+		config.Devices["dev4"].Channels["f"]:  true,
+	}, channels)
+}
+
+func (s *ReloadSuite) TestReload() {
+	InsertFakeSNMPMessage("127.0.0.1@one@.1.2.3.1", "foo")
+	InsertFakeSNMPMessage("127.0.0.1@two@.1.2.3.2", "bar")
+	InsertFakeSNMPMessage("127.0.0.1@two@.1.2.3.3", "baz")
+	InsertFakeSNMPMessage("127.0.0.1@two@.1.2.3.5", "boo")
+	InsertFakeSNMPMessage("127.0.0.1@three@.1.2.3.4", "moo")
+	InsertFakeSNMPMessage("127.0.0.1@four@.1.2.3.6", "zoo")
+
+	running := s.parse(reloadConfig)
+	model, err := NewSnmpModel(NewFakeSNMP, running, s.start)
+	s.Ck("can't create model", err)
+
+	obs := NewMockDeviceObserver()
+	model.Observe(NewFakeModelObserver(obs))
+	model.Remover = obs
+	model.DeviceRemover = obs
+
+	timer := NewFakeRTimer(s.start, time.Millisecond)
+	model.SetPollTimer(timer)
+
+	model.Start()
+	defer model.Stop()
+
+	timer.Tick()
+	s.Ck("initial events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device dev1, name a, type value, value foo, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device dev2, name e, type value, value boo, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device dev2, name b, type value, value bar, order 2"},
+		&MockDeviceEvent{OnNewControlEvent, "device dev4, name f, type value, value zoo, order 1"},
+	}, EventTimeout))
+
+	oldChannel := running.Devices["dev2"].Channels["b"]
+	untouched := running.Devices["dev2"].Channels["e"]
+	unchanged := running.Devices["dev1"]
+	changed := running.Devices["dev2"]
+
+	// new devices and channels are polled on next scheduled poll
+	model.Reload(s.parse(reloadChangedConfig), s.start.Add(1001*time.Millisecond))
+	s.Ck("remove events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnRemoveControlEvent, "device dev2, name b"},
+		&MockDeviceEvent{OnRemoveControlEvent, "device dev4, name f"},
+		&MockDeviceEvent{OnRemoveDeviceEvent, "device dev4"},
+	}, EventTimeout))
+
+	// device with changed channels keeps its config and untouched channels
+	s.Equal(unchanged, model.config.Devices["dev1"])
+	s.Equal(changed, model.config.Devices["dev2"])
+	s.Nil(model.channelDevice(oldChannel))
+	dev := model.channelDevice(untouched)
+	if s.NotNil(dev) {
+		s.Equal("boo", dev.Cache[untouched])
+		s.Equal(dev, model.channelDevice(changed.Channels["c"]))
+	}
+
+	timer.Tick()
+	s.Ck("new devices events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device dev2, name b, type value, value baz, order 2"},
+		&MockDeviceEvent{OnNewControlEvent, "device dev2, name c, type value, value bar, order 3"},
+		&MockDeviceEvent{OnNewControlEvent, "device dev3, name d, type value, value moo, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device dev4, name f, type value, value zoo, order 1"},
+	}, EventTimeout))
+
+	// results of removed devices and channels are dropped
+	model.resultChannel <- PollResult{Channel: oldChannel, Data: "foo", Trap: true}
+	s.Ck("no events", obs.WaitForNoMessages(WaitTimeout))
+}
+
+// Rows of removed table channel are removed with it
+func (s *ReloadSuite) TestReloadTable() {
+	InsertFakeSNMPMessage("127.0.0.1@one@.1.2.3.1", "foo")
+	InsertFakeSNMPMessage("127.0.0.1@one@.1.2.4.1", "1")
+	InsertFakeSNMPMessage("127.0.0.1@one@.1.2.4.2", "2")
+	InsertFakeSNMPMessage("127.0.0.1@two@.1.2.3.2", "bar")
+	InsertFakeSNMPMessage("127.0.0.1@two@.1.2.3.5", "boo")
+	InsertFakeSNMPMessage("127.0.0.1@four@.1.2.3.6", "zoo")
+
+	tableConfig := strings.Replace(reloadConfig, `{"name": "a", "oid": ".1.2.3.1", "poll_interval": 1000}`,
+		`{"name": "a", "oid": ".1.2.3.1", "poll_interval": 1000}, {"name": "port", "oid": ".1.2.4", "table": true, "poll_interval": 1000}`, 1)
+	model, err := NewSnmpModel(NewFakeSNMP, s.parse(tableConfig), s.start)
+	s.Ck("can't create model", err)
+
+	obs := NewMockDeviceObserver()
+	model.Observe(NewFakeModelObserver(obs))
+	model.Remover = obs
+
+	timer := NewFakeRTimer(s.start, time.Millisecond)
+	model.SetPollTimer(timer)
+
+	model.Start()
+	defer model.Stop()
+
+	timer.Tick()
+	s.Ck("initial events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device dev1, name a, type value, value foo, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device dev1, name port 1, type value, value 1, order 2"},
+		&MockDeviceEvent{OnNewControlEvent, "device dev1, name port 2, type value, value 2, order 3"},
+		&MockDeviceEvent{OnNewControlEvent, "device dev2, name e, type value, value boo, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device dev2, name b, type value, value bar, order 2"},
+		&MockDeviceEvent{OnNewControlEvent, "device dev4, name f, type value, value zoo, order 1"},
+	}, EventTimeout))
+
+	model.Reload(s.parse(reloadConfig), s.start.Add(1001*time.Millisecond))
+	s.Ck("remove events", obs.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnRemoveControlEvent, "device dev1, name port 1"},
+		&MockDeviceEvent{OnRemoveControlEvent, "device dev1, name port 2"},
+	}, EventTimeout))
+	s.Ck("no events", obs.WaitForNoMessages(WaitTimeout))
+}
+
+// Devices are replaced in observer goroutine, so writes don't race with reload
+func (s *ReloadSuite) TestReloadWithWrites() {
+	InsertFakeSNMPMessage("127.0.0.1@one@.1.2.3.1", "foo")
+	InsertFakeSNMPMessage("127.0.0.1@two@.1.2.3.2", "bar")
+
+	model, err := NewSnmpModel(NewFakeSNMP, s.parse(reloadConfig), s.start)
+	s.Ck("can't create model", err)
+
+	obs := newSyncModelObserver(NewMockDeviceObserver())
+	model.Observe(obs)
+	model.SetPollTimer(NewFakeRTimer(s.start, time.Millisecond))
+	model.Start()
+	obs.start()
+
+	stop := make(chan struct{})
+	writing := make(chan struct{})
+	go func() {
+		defer close(writing)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				obs.write("dev2", "b", "1")
+				obs.write("dev3", "d", "1")
+			}
+		}
+	}()
+
+	model.Reload(s.parse(reloadChangedConfig), s.start.Add(time.Second))
+	model.Reload(s.parse(reloadConfig), s.start.Add(2*time.Second))
+
+	close(stop)
+	<-writing
+	model.Stop()
+	obs.stop()
+
+	s.Contains(obs.devices, "dev1")
+	s.Contains(obs.devices, "dev2")
+	s.NotContains(obs.devices, "dev3")
+	s.Contains(obs.devices, "dev4")
+	s.EnsureGotWarnings()
+}
+
+func TestReload(t *testing.T) {
+	testutils.RunSuites(t, new(ReloadSuite))
+}
@@ -0,0 +1,389 @@
+package mqtt_snmp
+
+// Session recorder module
+// Records requests to devices and their responses, so misbehaving
+// device can be investigated and replayed in tests later
+// Exchanges of each device are appended to "<device-id>.jsonl" file
+// in recording directory, one JSON object per line
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/contactless/wbgo"
+	"github.com/gosnmp/gosnmp"
+)
+
+// Kinds of recorded requests, as SnmpInterface methods
+const (
+	RecordedGet  = "get"
+	RecordedSet  = "set"
+	RecordedWalk = "walk"
+)
+
+// Variable of recorded request or response
+type RecordedVariable struct {
+	Oid string `json:"oid"`
+
+	// Type name like "Integer" or "OctetString"
+	Type string `json:"type"`
+
+	// Value in decimal for numbers, as is for strings
+	Value string `json:"value,omitempty"`
+
+	// Value of binary strings
+	Hex string `json:"hex,omitempty"`
+}
+
+// Recorded response of device
+type RecordedResponse struct {
+	// PDU type like "GetResponse", empty for walks
+	PduType string `json:"pdu_type,omitempty"`
+
+	// Error status like "GenErr", empty if there is no error
+	Error      string `json:"error_status,omitempty"`
+	ErrorIndex uint8  `json:"error_index,omitempty"`
+
+	Variables []RecordedVariable `json:"variables"`
+}
+
+// Request to device and its response
+type RecordedExchange struct {
+	Time time.Time `json:"time"`
+
+	// One of RecordedGet, RecordedSet, RecordedWalk
+	Request string `json:"request"`
+
+	// Requested OIDs of GET, root OID of walk
+	Oids []string `json:"oids,omitempty"`
+
+	// Variables of SET
+	Variables []RecordedVariable `json:"variables,omitempty"`
+
+	// Response, nil if request is failed
+	Response *RecordedResponse `json:"response,omitempty"`
+
+	// Request error like timeout
+	Error string `json:"error,omitempty"`
+
+	// Time from request to response or error
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+var (
+	// Types of recorded variables
+	recordedTypes = []gosnmp.Asn1BER{
+		gosnmp.Integer, gosnmp.BitString, gosnmp.OctetString, gosnmp.Null,
+		gosnmp.ObjectIdentifier, gosnmp.IPAddress, gosnmp.Counter32, gosnmp.Gauge32,
+		gosnmp.TimeTicks, gosnmp.Opaque, gosnmp.Counter64, gosnmp.Uinteger32,
+		gosnmp.OpaqueFloat, gosnmp.OpaqueDouble, gosnmp.NoSuchObject,
+		gosnmp.NoSuchInstance, gosnmp.EndOfMibView,
+	}
+
+	// Types of recorded responses
+	recordedPduTypes = []gosnmp.PDUType{gosnmp.GetResponse, gosnmp.Report}
+)
+
+// Check if string can be recorded as is
+func isPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Make recorded variable of SNMP variable
+func recordVariable(v gosnmp.SnmpPDU) RecordedVariable {
+	res := RecordedVariable{Oid: v.Name, Type: v.Type.String()}
+
+	switch value := v.Value.(type) {
+	case nil:
+	case []byte:
+		if isPrintable(value) {
+			res.Value = string(value)
+		} else {
+			res.Hex = hex.EncodeToString(value)
+		}
+	case string:
+		res.Value = value
+	case float32:
+		res.Value = strconv.FormatFloat(float64(value), 'g', -1, 32)
+	case float64:
+		res.Value = strconv.FormatFloat(value, 'g', -1, 64)
+	case int, int64, uint, uint32, uint64:
+		res.Value = gosnmp.ToBigInt(value).String()
+	default:
+		res.Value = fmt.Sprint(value)
+	}
+
+	return res
+}
+
+// Make SNMP variable of recorded one, value types are the same
+// as gosnmp decodes them
+func (v RecordedVariable) pdu() (pdu gosnmp.SnmpPDU, err error) {
+	pdu.Name = v.Oid
+
+	found := false
+	for _, t := range recordedTypes {
+		if t.String() == v.Type {
+			pdu.Type, found = t, true
+			break
+		}
+	}
+	if !found {
+		return pdu, fmt.Errorf("unsupported type %s of %s", v.Type, v.Oid)
+	}
+
+	switch pdu.Type {
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return pdu, nil
+	case gosnmp.OctetString, gosnmp.BitString, gosnmp.Opaque:
+		if v.Hex == "" {
+			pdu.Value = []byte(v.Value)
+			return pdu, nil
+		}
+		pdu.Value, err = hex.DecodeString(v.Hex)
+	case gosnmp.ObjectIdentifier, gosnmp.IPAddress:
+		pdu.Value = v.Value
+	case gosnmp.OpaqueFloat:
+		var f float64
+		f, err = strconv.ParseFloat(v.Value, 32)
+		pdu.Value = float32(f)
+	case gosnmp.OpaqueDouble:
+		pdu.Value, err = strconv.ParseFloat(v.Value, 64)
+	default:
+		n, ok := new(big.Int).SetString(v.Value, 10)
+		switch {
+		case !ok:
+			err = fmt.Errorf("not a number")
+		case pdu.Type == gosnmp.Integer:
+			pdu.Value = int(n.Int64())
+		case pdu.Type == gosnmp.Counter64:
+			pdu.Value = n.Uint64()
+		case pdu.Type == gosnmp.TimeTicks || pdu.Type == gosnmp.Uinteger32:
+			pdu.Value = uint32(n.Uint64())
+		default:
+			pdu.Value = uint(n.Uint64())
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("bad %s value %q of %s: %s", v.Type, v.Value+v.Hex, v.Oid, err)
+	}
+	return
+}
+
+// Make recorded variables of SNMP variables
+func recordVariables(vars []gosnmp.SnmpPDU) []RecordedVariable {
+	res := make([]RecordedVariable, len(vars))
+	for i, v := range vars {
+		res[i] = recordVariable(v)
+	}
+	return res
+}
+
+// Make recorded response of SNMP packet
+func recordPacket(packet *gosnmp.SnmpPacket) *RecordedResponse {
+	res := &RecordedResponse{
+		PduType:    packet.PDUType.String(),
+		ErrorIndex: packet.ErrorIndex,
+		Variables:  recordVariables(packet.Variables),
+	}
+	if packet.Error != gosnmp.NoError {
+		res.Error = packet.Error.String()
+	}
+	return res
+}
+
+// Make SNMP packet of recorded response
+func (r *RecordedResponse) packet() (*gosnmp.SnmpPacket, error) {
+	packet := &gosnmp.SnmpPacket{PDUType: gosnmp.GetResponse, ErrorIndex: r.ErrorIndex}
+
+	if r.PduType != "" {
+		found := false
+		for _, t := range recordedPduTypes {
+			if t.String() == r.PduType {
+				packet.PDUType, found = t, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unsupported PDU type %s", r.PduType)
+		}
+	}
+
+	if r.Error != "" {
+		found := false
+		for s := gosnmp.TooBig; s <= gosnmp.InconsistentName; s++ {
+			if s.String() == r.Error {
+				packet.Error, found = s, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown error status %s", r.Error)
+		}
+	}
+
+	vars, err := r.pdus()
+	packet.Variables = vars
+	return packet, err
+}
+
+// Make SNMP variables of recorded response
+func (r *RecordedResponse) pdus() ([]gosnmp.SnmpPDU, error) {
+	res := make([]gosnmp.SnmpPDU, len(r.Variables))
+	for i, v := range r.Variables {
+		var err error
+		if res[i], err = v.pdu(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Recorder of device sessions
+type SessionRecorder struct {
+	dir string
+
+	// Recording files by device IDs, nil if file can't be opened
+	files map[string]*os.File
+	mutex sync.Mutex
+}
+
+// Create recorder writing to directory, it's created if needed
+func NewSessionRecorder(dir string) (*SessionRecorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("can't create recording directory: %s", err)
+	}
+	return &SessionRecorder{dir: dir, files: make(map[string]*os.File)}, nil
+}
+
+// Get path of recording file of device
+func recordingFile(dir, id string) string {
+	return filepath.Join(dir, strings.ReplaceAll(id, string(filepath.Separator), "_")+".jsonl")
+}
+
+// Append exchange to recording of device
+func (r *SessionRecorder) record(id string, e RecordedExchange) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		wbgo.Error.Printf("can't encode recorded request of %s: %s", id, err)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f, ok := r.files[id]
+	if !ok {
+		name := recordingFile(r.dir, id)
+		if f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			wbgo.Error.Printf("can't record session of %s: %s", id, err)
+			f = nil
+		}
+		r.files[id] = f
+	}
+	if f == nil {
+		return
+	}
+
+	// line is written at once, so it's not torn on crash
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		wbgo.Error.Printf("can't record session of %s: %s", id, err)
+	}
+}
+
+// Close recording files
+func (r *SessionRecorder) Close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for id, f := range r.files {
+		if f != nil {
+			f.Close()
+		}
+		delete(r.files, id)
+	}
+}
+
+// Wrap SNMP factory, so sessions of devices are recorded
+func (r *SessionRecorder) Wrap(snmpFactory SnmpFactory) SnmpFactory {
+	return func(config *DeviceConfig, debug bool) (SnmpInterface, error) {
+		snmp, err := snmpFactory(config, debug)
+		if err != nil {
+			return nil, err
+		}
+		return &recordingSession{snmp: snmp, id: config.Id, recorder: r}, nil
+	}
+}
+
+// SNMP session recording exchanges of device
+type recordingSession struct {
+	snmp     SnmpInterface
+	id       string
+	recorder *SessionRecorder
+}
+
+// Record exchange started at given time
+func (s *recordingSession) record(start time.Time, e RecordedExchange, err error) {
+	e.Time = start
+	e.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		e.Error = err.Error()
+		e.Response = nil
+	}
+	s.recorder.record(s.id, e)
+}
+
+func (s *recordingSession) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+	start := time.Now()
+	packet, err := s.snmp.Get(oids)
+
+	e := RecordedExchange{Request: RecordedGet, Oids: oids}
+	if err == nil {
+		e.Response = recordPacket(packet)
+	}
+	s.record(start, e, err)
+
+	return packet, err
+}
+
+func (s *recordingSession) Set(pdus []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error) {
+	start := time.Now()
+	packet, err := s.snmp.Set(pdus)
+
+	e := RecordedExchange{Request: RecordedSet, Variables: recordVariables(pdus)}
+	if err == nil {
+		e.Response = recordPacket(packet)
+	}
+	s.record(start, e, err)
+
+	return packet, err
+}
+
+func (s *recordingSession) Walk(rootOid string) ([]gosnmp.SnmpPDU, error) {
+	start := time.Now()
+	pdus, err := s.snmp.Walk(rootOid)
+
+	e := RecordedExchange{Request: RecordedWalk, Oids: []string{rootOid}}
+	if err == nil {
+		e.Response = &RecordedResponse{Variables: recordVariables(pdus)}
+	}
+	s.record(start, e, err)
+
+	return pdus, err
+}
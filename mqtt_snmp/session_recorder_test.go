@@ -0,0 +1,170 @@
+package mqtt_snmp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+	sim "github.com/wirenboard/wb-mqtt-snmp/snmp_simulator"
+)
+
+type SessionRecorderSuite struct {
+	testutils.Suite
+
+	dir string
+}
+
+func (s *SessionRecorderSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	var err error
+	s.dir, err = os.MkdirTemp("", "wb-mqtt-snmp-recorder")
+	s.Ck("can't create temp dir", err)
+}
+
+func (s *SessionRecorderSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+	s.Suite.TearDownTest()
+}
+
+// Recorded variables are converted back to the same values gosnmp decodes
+func (s *SessionRecorderSuite) TestVariables() {
+	for _, pdu := range []gosnmp.SnmpPDU{
+		{Name: ".1.1", Type: gosnmp.Integer, Value: -15},
+		{Name: ".1.2", Type: gosnmp.OctetString, Value: []byte("Smart-UPS 1500")},
+		{Name: ".1.3", Type: gosnmp.OctetString, Value: []byte{0x00, 0x1a, 0xff}},
+		{Name: ".1.4", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.318"},
+		{Name: ".1.5", Type: gosnmp.IPAddress, Value: "10.0.0.1"},
+		{Name: ".1.6", Type: gosnmp.Counter32, Value: uint(4000000000)},
+		{Name: ".1.7", Type: gosnmp.Gauge32, Value: uint(10)},
+		{Name: ".1.8", Type: gosnmp.TimeTicks, Value: uint32(123456)},
+		{Name: ".1.9", Type: gosnmp.Counter64, Value: uint64(1) << 63},
+		{Name: ".1.10", Type: gosnmp.OpaqueFloat, Value: float32(0.5)},
+		{Name: ".1.11", Type: gosnmp.OpaqueDouble, Value: 2.25},
+		{Name: ".1.12", Type: gosnmp.NoSuchInstance},
+	} {
+		v := recordVariable(pdu)
+		res, err := v.pdu()
+		s.Ck("can't convert recorded variable", err)
+		s.Equal(pdu, res)
+	}
+
+	s.Equal(RecordedVariable{Oid: ".1.3", Type: "OctetString", Hex: "001aff"}, recordVariable(gosnmp.SnmpPDU{Name: ".1.3", Type: gosnmp.OctetString, Value: []byte{0x00, 0x1a, 0xff}}))
+
+	_, err := RecordedVariable{Oid: ".1.1", Type: "Integer", Value: "x"}.pdu()
+	s.Error(err)
+	_, err = RecordedVariable{Oid: ".1.1", Type: "Sequence"}.pdu()
+	s.Error(err)
+}
+
+func (s *SessionRecorderSuite) TestResponse() {
+	packet := &gosnmp.SnmpPacket{
+		PDUType:    gosnmp.GetResponse,
+		Error:      gosnmp.NoSuchName,
+		ErrorIndex: 2,
+		Variables: []gosnmp.SnmpPDU{
+			{Name: ".1.1", Type: gosnmp.Integer, Value: 1},
+			{Name: ".1.2", Type: gosnmp.Null},
+		},
+	}
+
+	r := recordPacket(packet)
+	s.Equal("NoSuchName", r.Error)
+	res, err := r.packet()
+	s.Ck("can't convert recorded response", err)
+	s.Equal(packet, res)
+
+	_, err = (&RecordedResponse{Error: "oops"}).packet()
+	s.Error(err)
+}
+
+// Sessions of model polling simulated agent are recorded
+func (s *SessionRecorderSuite) TestRecord() {
+	pdus, err := sim.ReadSnmprec(strings.NewReader(simulatorData))
+	s.Ck("can't read agent data", err)
+	agent := sim.NewAgent(pdus)
+	s.Ck("can't start agent", agent.Listen("127.0.0.1:0"))
+	defer agent.Close()
+	agent.SetBehavior(".1.3.6.1.2.1.33.1.1.2.0", sim.Behavior{Error: gosnmp.GenErr})
+
+	recorder, err := NewSessionRecorder(filepath.Join(s.dir, "sessions"))
+	s.Ck("can't create recorder", err)
+	defer recorder.Close()
+
+	config := &DeviceConfig{Id: "ups", Address: agent.Addr(), Community: "public", SnmpVersion: gosnmp.Version2c, SnmpTimeout: 1}
+	snmp, err := recorder.Wrap(NewGoSNMP)(config, false)
+	s.Ck("can't create session", err)
+
+	_, err = snmp.Get([]string{".1.3.6.1.2.1.33.1.2.4.0", ".1.3.6.1.2.1.33.1.1.2.0"})
+	s.Ck("get failed", err)
+	_, err = snmp.Walk(".1.3.6.1.2.1.2.2.1.8")
+	s.Ck("walk failed", err)
+	_, err = snmp.Set([]gosnmp.SnmpPDU{{Name: ".1.3.6.1.2.1.33.1.2.4.0", Type: gosnmp.Integer, Value: 80}})
+	s.Ck("set failed", err)
+
+	f, err := os.Open(filepath.Join(s.dir, "sessions", "ups.jsonl"))
+	s.Ck("can't open recording", err)
+	defer f.Close()
+	exchanges, err := ReadRecording(f)
+	s.Ck("can't read recording", err)
+	s.Require().Len(exchanges, 3)
+
+	get := exchanges[0]
+	s.Equal(RecordedGet, get.Request)
+	s.Equal([]string{".1.3.6.1.2.1.33.1.2.4.0", ".1.3.6.1.2.1.33.1.1.2.0"}, get.Oids)
+	s.Equal("GetResponse", get.Response.PduType)
+	s.Equal("GenErr", get.Response.Error)
+	s.Equal(uint8(2), get.Response.ErrorIndex)
+	s.True(get.LatencyMs > 0)
+
+	walk := exchanges[1]
+	s.Equal(RecordedWalk, walk.Request)
+	s.Equal([]RecordedVariable{
+		{Oid: ".1.3.6.1.2.1.2.2.1.8.1", Type: "Integer", Value: "1"},
+		{Oid: ".1.3.6.1.2.1.2.2.1.8.2", Type: "Integer", Value: "2"},
+	}, walk.Response.Variables)
+
+	set := exchanges[2]
+	s.Equal(RecordedSet, set.Request)
+	s.Equal([]RecordedVariable{{Oid: ".1.3.6.1.2.1.33.1.2.4.0", Type: "Integer", Value: "80"}}, set.Variables)
+	s.Equal("", set.Response.Error)
+}
+
+// Failed requests are recorded with errors
+func (s *SessionRecorderSuite) TestRecordTimeout() {
+	agent := sim.NewAgent(nil)
+	s.Ck("can't start agent", agent.Listen("127.0.0.1:0"))
+	defer agent.Close()
+	agent.SetBehavior(".1", sim.Behavior{Drop: true})
+
+	recorder, err := NewSessionRecorder(s.dir)
+	s.Ck("can't create recorder", err)
+	defer recorder.Close()
+
+	config := &DeviceConfig{Id: "ups", Address: agent.Addr(), Community: "public", SnmpVersion: gosnmp.Version2c, SnmpTimeout: 1}
+	snmp, err := recorder.Wrap(NewGoSNMP)(config, false)
+	s.Ck("can't create session", err)
+
+	start := time.Now()
+	_, getErr := snmp.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	s.Error(getErr)
+	latency := float64(time.Since(start)) / float64(time.Millisecond)
+
+	f, err := os.Open(filepath.Join(s.dir, "ups.jsonl"))
+	s.Ck("can't open recording", err)
+	defer f.Close()
+	exchanges, err := ReadRecording(f)
+	s.Ck("can't read recording", err)
+	s.Require().Len(exchanges, 1)
+	s.Nil(exchanges[0].Response)
+	s.Equal(getErr.Error(), exchanges[0].Error)
+	s.InDelta(latency, exchanges[0].LatencyMs, 100)
+}
+
+func TestSessionRecorder(t *testing.T) {
+	testutils.RunSuites(t, new(SessionRecorderSuite))
+}
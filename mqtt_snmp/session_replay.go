@@ -0,0 +1,276 @@
+package mqtt_snmp
+
+// Session replay module
+// Answers requests of devices with responses from recordings,
+// so conversion and publishing of real device data can be
+// reproduced in tests without the device
+// Request is answered by the first not replayed exchange of the same
+// request kind and OIDs in any order, so order of requests and batched
+// channels may differ from recorded one
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/wirenboard/wb-mqtt-snmp/snmp_oid"
+)
+
+// Read recording of device session
+func ReadRecording(input io.Reader) ([]RecordedExchange, error) {
+	res := make([]RecordedExchange, 0)
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var e RecordedExchange
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		switch e.Request {
+		case RecordedGet, RecordedSet, RecordedWalk:
+		default:
+			return nil, fmt.Errorf("line %d: unknown request %q", n, e.Request)
+		}
+		res = append(res, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Replay of recorded device sessions
+type SessionReplay struct {
+	// Reproduce recorded latencies of responses
+	Latency bool
+
+	// Exchanges and flags of replayed ones by device IDs
+	sessions map[string][]RecordedExchange
+	replayed map[string][]bool
+	mutex    sync.Mutex
+}
+
+// Create empty replay, recordings are added by Load
+func NewSessionReplay() *SessionReplay {
+	return &SessionReplay{
+		sessions: make(map[string][]RecordedExchange),
+		replayed: make(map[string][]bool),
+	}
+}
+
+// Load recordings of all devices from directory written by SessionRecorder
+func LoadSessionReplay(dir string) (*SessionReplay, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewSessionReplay()
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		err = r.Load(strings.TrimSuffix(filepath.Base(name), ".jsonl"), f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return r, nil
+}
+
+// Add recording of device, exchanges are appended to loaded ones
+func (r *SessionReplay) Load(id string, input io.Reader) error {
+	exchanges, err := ReadRecording(input)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.sessions[id] = append(r.sessions[id], exchanges...)
+	r.replayed[id] = append(r.replayed[id], make([]bool, len(exchanges))...)
+	return nil
+}
+
+// Get number of exchanges of device which are not replayed yet
+func (r *SessionReplay) Pending(id string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	n := 0
+	for _, done := range r.replayed[id] {
+		if !done {
+			n++
+		}
+	}
+	return n
+}
+
+// SNMP factory answering requests with recordings of devices
+func (r *SessionReplay) Factory(config *DeviceConfig, debug bool) (SnmpInterface, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.sessions[config.Id]; !ok {
+		return nil, fmt.Errorf("no recording of device %s", config.Id)
+	}
+	return &replaySession{replay: r, id: config.Id}, nil
+}
+
+// Get positions of OIDs in request
+func oidPositions(oids []string) map[string][]int {
+	res := make(map[string][]int, len(oids))
+	for i, oid := range oids {
+		oid = snmp_oid.Normalize(oid)
+		res[oid] = append(res[oid], i)
+	}
+	return res
+}
+
+// Check if recorded OIDs are the same as requested ones in any order,
+// model batches channels of device in varying order
+func sameOids(recorded, requested []string) bool {
+	if len(recorded) != len(requested) {
+		return false
+	}
+	positions := oidPositions(requested)
+	for _, oid := range recorded {
+		oid = snmp_oid.Normalize(oid)
+		if len(positions[oid]) == 0 {
+			return false
+		}
+		positions[oid] = positions[oid][1:]
+	}
+	return true
+}
+
+// Reorder variables and error index of response recorded for
+// recorded OIDs as if it's the response for requested ones
+func reorderResponse(packet *gosnmp.SnmpPacket, recorded, requested []string) {
+	if len(packet.Variables) != len(recorded) {
+		return
+	}
+
+	positions := oidPositions(requested)
+	order := make([]int, len(recorded))
+	for i, oid := range recorded {
+		oid = snmp_oid.Normalize(oid)
+		order[i] = positions[oid][0]
+		positions[oid] = positions[oid][1:]
+	}
+
+	vars := make([]gosnmp.SnmpPDU, len(packet.Variables))
+	for i, v := range packet.Variables {
+		vars[order[i]] = v
+	}
+	packet.Variables = vars
+
+	if bad := int(packet.ErrorIndex) - 1; bad >= 0 && bad < len(order) {
+		packet.ErrorIndex = uint8(order[bad] + 1)
+	}
+}
+
+// Take first not replayed exchange of request, it's marked as replayed
+func (r *SessionReplay) take(id, request string, oids []string) (RecordedExchange, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, e := range r.sessions[id] {
+		if r.replayed[id][i] || e.Request != request {
+			continue
+		}
+
+		recorded := e.Oids
+		if request == RecordedSet {
+			recorded = make([]string, len(e.Variables))
+			for j, v := range e.Variables {
+				recorded[j] = v.Oid
+			}
+		}
+		if sameOids(recorded, oids) {
+			r.replayed[id][i] = true
+			return e, nil
+		}
+	}
+
+	return RecordedExchange{}, fmt.Errorf("no recorded %s of %s for %s", request, strings.Join(oids, ", "), id)
+}
+
+// SNMP session of device replaying its recording
+type replaySession struct {
+	replay *SessionReplay
+	id     string
+}
+
+// Take exchange of request and wait for its latency if needed
+func (s *replaySession) exchange(request string, oids []string) (RecordedExchange, error) {
+	e, err := s.replay.take(s.id, request, oids)
+	if err != nil {
+		return e, err
+	}
+
+	if s.replay.Latency {
+		time.Sleep(time.Duration(e.LatencyMs * float64(time.Millisecond)))
+	}
+
+	if e.Error != "" {
+		return e, errors.New(e.Error)
+	}
+	if e.Response == nil {
+		return e, fmt.Errorf("recorded %s of %s has no response", request, s.id)
+	}
+	return e, nil
+}
+
+func (s *replaySession) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+	e, err := s.exchange(RecordedGet, oids)
+	if err != nil {
+		return nil, err
+	}
+
+	packet, err := e.Response.packet()
+	if err != nil {
+		return nil, err
+	}
+	reorderResponse(packet, e.Oids, oids)
+	return packet, nil
+}
+
+func (s *replaySession) Set(pdus []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error) {
+	oids := make([]string, len(pdus))
+	for i, pdu := range pdus {
+		oids[i] = pdu.Name
+	}
+
+	e, err := s.exchange(RecordedSet, oids)
+	if err != nil {
+		return nil, err
+	}
+	return e.Response.packet()
+}
+
+func (s *replaySession) Walk(rootOid string) ([]gosnmp.SnmpPDU, error) {
+	e, err := s.exchange(RecordedWalk, []string{rootOid})
+	if err != nil {
+		return nil, err
+	}
+	return e.Response.pdus()
+}
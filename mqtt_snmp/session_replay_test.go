@@ -0,0 +1,202 @@
+package mqtt_snmp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+	sim "github.com/wirenboard/wb-mqtt-snmp/snmp_simulator"
+)
+
+// Model replaying recorded sessions
+type SessionReplaySuite struct {
+	testutils.Suite
+
+	dir   string
+	model *SnmpModel
+	timer *FakeRTimer
+	obs   *MockDeviceObserver
+}
+
+// Recording of device with status channel only
+const replayStatusConfig = `{
+	"devices": [{
+		"id": "ups",
+		"address": "127.0.0.1",
+		"offline_threshold": 0,
+		"channels": [
+			{"name": "status", "oid": ".1.3.6.1.2.1.33.1.4.1.0", "control_type": "text", "enum": {"3": "normal", "5": "battery"}}
+		]
+	}]
+}`
+
+func (s *SessionReplaySuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	var err error
+	s.dir, err = os.MkdirTemp("", "wb-mqtt-snmp-replay")
+	s.Ck("can't create temp dir", err)
+}
+
+func (s *SessionReplaySuite) TearDownTest() {
+	s.stopModel()
+	os.RemoveAll(s.dir)
+	s.Suite.TearDownTest()
+}
+
+func (s *SessionReplaySuite) startModel(snmpFactory SnmpFactory, config string) {
+	c, err := NewDaemonConfig(strings.NewReader(config), s.dir)
+	s.Ck("can't parse config", err)
+
+	s.model, err = NewSnmpModel(snmpFactory, c, time.Now())
+	s.Ck("can't create model", err)
+
+	s.obs = NewMockDeviceObserver()
+	s.model.Observe(NewFakeModelObserver(s.obs))
+	s.timer = NewFakeRTimer(time.Now(), time.Millisecond)
+	s.model.SetPollTimer(s.timer)
+	s.Ck("can't start model", s.model.Start())
+}
+
+func (s *SessionReplaySuite) stopModel() {
+	if s.model != nil {
+		s.model.Stop()
+		s.model = nil
+	}
+}
+
+// Poll all channels, write charge and check published values
+func (s *SessionReplaySuite) pollAndWrite() {
+	s.timer.Tick()
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnNewControlEvent, "device ups, name voltage, type voltage, value 230.0, order 1"},
+		{OnNewControlEvent, "device ups, name status, type text, value normal, order 2"},
+		{OnNewControlEvent, "device ups, name model, type text, value Smart-UPS 1500, order 3"},
+		{OnNewControlEvent, "device ups, name charge, type value, value 95, order 4"},
+		{OnNewControlEvent, "device ups, name Port 1, type value, value 1, order 5"},
+		{OnNewControlEvent, "device ups, name Port 2, type value, value 2, order 6"},
+	}, EventTimeout))
+
+	s.False(s.model.devices[0].AcceptOnValue("charge", "80"))
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnValueEvent, "device ups, name charge, value 80"},
+	}, EventTimeout))
+	s.NoError(s.obs.WaitForNoMessages(WaitTimeout))
+}
+
+// Session recorded from simulated agent is replayed without it
+func (s *SessionReplaySuite) TestRecordAndReplay() {
+	pdus, err := sim.ReadSnmprec(strings.NewReader(simulatorData))
+	s.Ck("can't read agent data", err)
+	agent := sim.NewAgent(pdus)
+	s.Ck("can't start agent", agent.Listen("127.0.0.1:0"))
+
+	sessions := filepath.Join(s.dir, "sessions")
+	recorder, err := NewSessionRecorder(sessions)
+	s.Ck("can't create recorder", err)
+	s.startModel(recorder.Wrap(NewGoSNMP), fmt.Sprintf(simulatorConfig, agent.Addr()))
+	s.pollAndWrite()
+	s.stopModel()
+	recorder.Close()
+	agent.Close()
+
+	replay, err := LoadSessionReplay(sessions)
+	s.Ck("can't load recordings", err)
+	recorded := replay.Pending("ups")
+	s.True(recorded > 0)
+
+	s.startModel(replay.Factory, fmt.Sprintf(simulatorConfig, agent.Addr()))
+	s.pollAndWrite()
+	s.Equal(0, replay.Pending("ups"))
+}
+
+// Failed requests are replayed, unrecorded ones fail
+// Each poll changes published state, so next tick waits for its completion
+func (s *SessionReplaySuite) TestReplayErrors() {
+	replay := NewSessionReplay()
+	s.Ck("can't load recording", replay.Load("ups", strings.NewReader(`
+{"request":"get","oids":[".1.3.6.1.2.1.33.1.4.1.0"],"response":{"pdu_type":"GetResponse","variables":[{"oid":".1.3.6.1.2.1.33.1.4.1.0","type":"Integer","value":"3"}]}}
+{"request":"get","oids":[".1.3.6.1.2.1.33.1.4.1.0"],"error":"request timeout (after 0 retries)"}
+
+{"request":"get","oids":[".1.3.6.1.2.1.33.1.4.1.0"],"response":{"pdu_type":"GetResponse","variables":[{"oid":".1.3.6.1.2.1.33.1.4.1.0","type":"Integer","value":"5"}]}}
+{"request":"get","oids":["1.3.6.1.2.1.33.1.4.1.0"],"response":{"pdu_type":"GetResponse","error_status":"GenErr","error_index":1,"variables":[{"oid":".1.3.6.1.2.1.33.1.4.1.0","type":"Null"}]}}
+{"request":"get","oids":[".1.3.6.1.2.1.33.1.4.1.0"],"response":{"pdu_type":"GetResponse","variables":[{"oid":".1.3.6.1.2.1.33.1.4.1.0","type":"Integer","value":"3"}]}}
+`)))
+	s.Equal(5, replay.Pending("ups"))
+	s.startModel(replay.Factory, replayStatusConfig)
+
+	s.timer.Tick()
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnNewControlEvent, "device ups, name status, type text, value normal, order 1"},
+	}, EventTimeout))
+
+	s.timer.Tick()
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnErrorEvent, "device ups, name status, error r"},
+	}, EventTimeout))
+
+	s.timer.Tick()
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnValueEvent, "device ups, name status, value battery"},
+		{OnErrorEvent, "device ups, name status, error "},
+	}, EventTimeout))
+
+	s.timer.Tick()
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnErrorEvent, "device ups, name status, error r"},
+	}, EventTimeout))
+
+	s.timer.Tick()
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnValueEvent, "device ups, name status, value normal"},
+		{OnErrorEvent, "device ups, name status, error "},
+	}, EventTimeout))
+	s.Equal(0, replay.Pending("ups"))
+
+	s.timer.Tick()
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnErrorEvent, "device ups, name status, error r"},
+	}, EventTimeout))
+
+	s.EnsureGotErrors()
+}
+
+// Batched OIDs are matched in any order, response follows request order
+func (s *SessionReplaySuite) TestReorder() {
+	replay := NewSessionReplay()
+	s.Ck("can't load recording", replay.Load("ups", strings.NewReader(
+		`{"request":"get","oids":[".1.1",".1.2",".1.3"],"response":{"pdu_type":"GetResponse","error_status":"NoSuchName","error_index":3,"variables":[`+
+			`{"oid":".1.1","type":"Integer","value":"1"},{"oid":".1.2","type":"Integer","value":"2"},{"oid":".1.3","type":"Null"}]}}`)))
+	snmp, err := replay.Factory(&DeviceConfig{Id: "ups"}, false)
+	s.Ck("can't create session", err)
+
+	_, err = snmp.Get([]string{".1.3", ".1.1"})
+	s.Error(err)
+
+	packet, err := snmp.Get([]string{".1.3", "1.1", ".1.2"})
+	s.Ck("get failed", err)
+	s.Equal(uint8(1), packet.ErrorIndex)
+	s.Equal([]gosnmp.SnmpPDU{
+		{Name: ".1.3", Type: gosnmp.Null},
+		{Name: ".1.1", Type: gosnmp.Integer, Value: 1},
+		{Name: ".1.2", Type: gosnmp.Integer, Value: 2},
+	}, packet.Variables)
+}
+
+func (s *SessionReplaySuite) TestNoRecording() {
+	replay := NewSessionReplay()
+	_, err := replay.Factory(&DeviceConfig{Id: "ups"}, false)
+	s.Error(err)
+
+	s.Error(replay.Load("ups", strings.NewReader(`{"request":"getnext","oids":[".1.3"]}`)))
+	s.Error(replay.Load("ups", strings.NewReader(`{"request":`)))
+}
+
+func TestSessionReplay(t *testing.T) {
+	testutils.RunSuites(t, new(SessionReplaySuite))
+}
@@ -0,0 +1,186 @@
+package mqtt_snmp
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+	sim "github.com/wirenboard/wb-mqtt-snmp/snmp_simulator"
+)
+
+// Whole model polling simulated agent over UDP
+type SimulatorSuite struct {
+	testutils.Suite
+
+	dir   string
+	agent *sim.Agent
+	model *SnmpModel
+	timer *FakeRTimer
+	obs   *MockDeviceObserver
+}
+
+const simulatorData = `1.3.6.1.2.1.2.2.1.8.1|2|1
+1.3.6.1.2.1.2.2.1.8.2|2|2
+1.3.6.1.2.1.33.1.1.2.0|4|Smart-UPS 1500
+1.3.6.1.2.1.33.1.2.4.0|2|95
+1.3.6.1.2.1.33.1.3.3.1.3.1|2|2300
+1.3.6.1.2.1.33.1.4.1.0|2|3
+`
+
+// Config of UPS device polling simulated agent at address
+const simulatorConfig = `{
+	"num_workers": 2,
+	"devices": [{
+		"id": "ups",
+		"address": "%s",
+		"community": "public",
+		"snmp_timeout": 1,
+		"offline_threshold": 0,
+		"channels": [
+			{"name": "voltage", "oid": ".1.3.6.1.2.1.33.1.3.3.1.3.1", "control_type": "voltage", "scale": 0.1},
+			{"name": "status", "oid": ".1.3.6.1.2.1.33.1.4.1.0", "control_type": "text", "enum": {"3": "normal", "5": "battery"}},
+			{"name": "model", "oid": ".1.3.6.1.2.1.33.1.1.2.0", "control_type": "text"},
+			{"name": "charge", "oid": ".1.3.6.1.2.1.33.1.2.4.0", "control_type": "value", "writable": true, "set_type": "Integer"},
+			{"name": "ports", "oid": ".1.3.6.1.2.1.2.2.1.8", "control_type": "value", "table": true, "row_name": "Port {index}"}
+		]
+	}]
+}`
+
+func (s *SimulatorSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	pdus, err := sim.ReadSnmprec(strings.NewReader(simulatorData))
+	s.Ck("can't read agent data", err)
+	s.agent = sim.NewAgent(pdus)
+	s.agent.Community = "public"
+	s.Ck("can't start agent", s.agent.Listen("127.0.0.1:0"))
+
+	s.dir, err = os.MkdirTemp("", "wb-mqtt-snmp-simulator")
+	s.Ck("can't create temp dir", err)
+
+	config, err := NewDaemonConfig(strings.NewReader(fmt.Sprintf(simulatorConfig, s.agent.Addr())), s.dir)
+	s.Ck("can't parse config", err)
+
+	s.model, err = NewSnmpModel(NewGoSNMP, config, time.Now())
+	s.Ck("can't create model", err)
+
+	s.obs = NewMockDeviceObserver()
+	s.model.Observe(NewFakeModelObserver(s.obs))
+	s.timer = NewFakeRTimer(time.Now(), time.Millisecond)
+	s.model.SetPollTimer(s.timer)
+	s.Ck("can't start model", s.model.Start())
+}
+
+func (s *SimulatorSuite) TearDownTest() {
+	if s.model != nil {
+		s.model.Stop()
+	}
+	s.agent.Close()
+	os.RemoveAll(s.dir)
+	s.Suite.TearDownTest()
+}
+
+// Poll all channels and check values are published
+func (s *SimulatorSuite) pollAll() {
+	s.timer.Tick()
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnNewControlEvent, "device ups, name voltage, type voltage, value 230.0, order 1"},
+		{OnNewControlEvent, "device ups, name status, type text, value normal, order 2"},
+		{OnNewControlEvent, "device ups, name model, type text, value Smart-UPS 1500, order 3"},
+		{OnNewControlEvent, "device ups, name charge, type value, value 95, order 4"},
+		{OnNewControlEvent, "device ups, name Port 1, type value, value 1, order 5"},
+		{OnNewControlEvent, "device ups, name Port 2, type value, value 2, order 6"},
+	}, EventTimeout))
+}
+
+func (s *SimulatorSuite) TestPoll() {
+	s.pollAll()
+
+	s.agent.Set(gosnmp.SnmpPDU{Name: ".1.3.6.1.2.1.33.1.4.1.0", Type: gosnmp.Integer, Value: 5})
+	s.agent.Set(gosnmp.SnmpPDU{Name: ".1.3.6.1.2.1.2.2.1.8.3", Type: gosnmp.Integer, Value: 1})
+	s.timer.Tick()
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnValueEvent, "device ups, name status, value battery"},
+		{OnNewControlEvent, "device ups, name Port 3, type value, value 1, order 7"},
+	}, EventTimeout))
+	s.NoError(s.obs.WaitForNoMessages(WaitTimeout))
+}
+
+// Request of all channels doesn't fit into agent response,
+// so model splits it
+func (s *SimulatorSuite) TestTooBig() {
+	s.agent.SetMaxVarbinds(1)
+	s.pollAll()
+}
+
+func (s *SimulatorSuite) TestErrorStatus() {
+	s.agent.SetBehavior(".1.3.6.1.2.1.33.1.1.2.0", sim.Behavior{Error: gosnmp.GenErr})
+
+	s.timer.Tick()
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnNewControlEvent, "device ups, name voltage, type voltage, value 230.0, order 1"},
+		{OnNewControlEvent, "device ups, name status, type text, value normal, order 2"},
+		{OnNewControlEvent, "device ups, name model, type text, value , order 3"},
+		{OnErrorEvent, "device ups, name model, error r"},
+		{OnNewControlEvent, "device ups, name charge, type value, value 95, order 4"},
+		{OnNewControlEvent, "device ups, name Port 1, type value, value 1, order 5"},
+		{OnNewControlEvent, "device ups, name Port 2, type value, value 2, order 6"},
+	}, EventTimeout))
+
+	s.agent.SetBehavior(".1.3.6.1.2.1.33.1.1.2.0", sim.Behavior{})
+	s.timer.Tick()
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnValueEvent, "device ups, name model, value Smart-UPS 1500"},
+		{OnErrorEvent, "device ups, name model, error "},
+	}, EventTimeout))
+
+	s.EnsureGotErrors()
+}
+
+// Dropped requests are timed out and errors are published
+func (s *SimulatorSuite) TestTimeout() {
+	s.pollAll()
+
+	s.agent.SetBehavior(".1", sim.Behavior{Drop: true})
+	s.timer.Tick()
+
+	// requests are timed out after 1 second
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnErrorEvent, "device ups, name voltage, error r"},
+		{OnErrorEvent, "device ups, name status, error r"},
+		{OnErrorEvent, "device ups, name model, error r"},
+		{OnErrorEvent, "device ups, name charge, error r"},
+		{OnErrorEvent, "device ups, name Port 1, error r"},
+		{OnErrorEvent, "device ups, name Port 2, error r"},
+	}, 3*EventTimeout))
+
+	s.EnsureGotErrors()
+}
+
+// Delayed responses within timeout are published
+func (s *SimulatorSuite) TestDelay() {
+	s.agent.SetBehavior(".1.3.6.1.2.1.33", sim.Behavior{Delay: 300 * time.Millisecond})
+	s.pollAll()
+}
+
+// Written value is sent by SET and published after read-back
+func (s *SimulatorSuite) TestWrite() {
+	s.pollAll()
+
+	dev := s.model.devices[0]
+	s.False(dev.AcceptOnValue("charge", "80"))
+	s.NoError(s.obs.CheckEvents([]*MockDeviceEvent{
+		{OnValueEvent, "device ups, name charge, value 80"},
+	}, EventTimeout))
+
+	pdu, _ := s.agent.Get(".1.3.6.1.2.1.33.1.2.4.0")
+	s.Equal(80, pdu.Value)
+}
+
+func TestSimulator(t *testing.T) {
+	testutils.RunSuites(t, new(SimulatorSuite))
+}
@@ -1,27 +1,94 @@
 package mqtt_snmp
 
 import (
-	l "github.com/alouca/gologger"
-	"github.com/wirenboard/gosnmp"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+const (
+	// Default SNMP agent port
+	DefaultSnmpPort = 161
 )
 
 // Minimal SNMP interface
 // We need it to create fake SNMP driver for testing.
-// gosnmp.GoSNMP implements this interface
+// goSnmpSession implements this interface
 type SnmpInterface interface {
-	Get(oid string) (*gosnmp.SnmpPacket, error)
+	Get(oids []string) (*gosnmp.SnmpPacket, error)
+	Set(pdus []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error)
+	Walk(rootOid string) ([]gosnmp.SnmpPDU, error)
 }
 
 // SNMP interface factory type
-type SnmpFactory func(address, community string, version gosnmp.SnmpVersion, timeout int64, debug bool) (SnmpInterface, error)
+type SnmpFactory func(config *DeviceConfig, debug bool) (SnmpInterface, error)
+
+// SNMP session over gosnmp.GoSNMP
+type goSnmpSession struct {
+	*gosnmp.GoSNMP
+}
+
+// Walk subtree with GETBULK requests, SNMPv1 has GETNEXT only
+func (s *goSnmpSession) Walk(rootOid string) ([]gosnmp.SnmpPDU, error) {
+	if s.Version == gosnmp.Version1 {
+		return s.GoSNMP.WalkAll(rootOid)
+	}
+	return s.GoSNMP.BulkWalkAll(rootOid)
+}
+
+// Create gosnmp.GoSNMP object from device config
+// For SNMPv3 engine ID discovery and time synchronization are
+// performed by gosnmp on the first request
+func newGoSNMP(config *DeviceConfig, debug bool) *gosnmp.GoSNMP {
+	// address may contain port, i.e. "192.168.0.10:1161"
+	target, port := config.Address, uint16(DefaultSnmpPort)
+	if host, portStr, err := net.SplitHostPort(config.Address); err == nil {
+		if p, err := strconv.ParseUint(portStr, 10, 16); err == nil {
+			target, port = host, uint16(p)
+		}
+	}
+
+	g := &gosnmp.GoSNMP{
+		Target:    target,
+		Port:      port,
+		Community: config.Community,
+		Version:   config.SnmpVersion,
+		Timeout:   time.Duration(config.SnmpTimeout) * time.Second,
+		MaxOids:   gosnmp.MaxOids,
+	}
+
+	if config.SnmpVersion == gosnmp.Version3 && config.V3 != nil {
+		g.SecurityModel = gosnmp.UserSecurityModel
+		g.MsgFlags = config.V3.SecurityLevel
+		g.ContextName = config.V3.ContextName
+		g.ContextEngineID = config.V3.ContextEngineId
+		g.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 config.V3.SecurityName,
+			AuthenticationProtocol:   config.V3.AuthProtocol,
+			AuthenticationPassphrase: config.V3.AuthPassphrase,
+			PrivacyProtocol:          config.V3.PrivProtocol,
+			PrivacyPassphrase:        config.V3.PrivPassphrase,
+		}
+	}
+
+	if debug {
+		g.Logger = gosnmp.NewLogger(log.New(os.Stderr, "[gosnmp] ", log.LstdFlags))
+	}
+
+	return g
+}
 
-// GoSNMP NewGoSNMP wrapper
-func NewGoSNMP(address, community string, version gosnmp.SnmpVersion, timeout int64, debug bool) (SnmpInterface, error) {
-	i, e := gosnmp.NewGoSNMP(address, community, version, timeout)
+// GoSNMP session constructor
+func NewGoSNMP(config *DeviceConfig, debug bool) (SnmpInterface, error) {
+	g := newGoSNMP(config, debug)
 
-	if (debug && (e == nil)) {
-		i.Log = l.CreateLogger(true, true)
+	if err := g.Connect(); err != nil {
+		return nil, err
 	}
 
-	return i, e
+	return &goSnmpSession{g}, nil
 }
@@ -0,0 +1,230 @@
+package mqtt_snmp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+const (
+	// usmStatsUnknownEngineIDs counter, reported on engine ID discovery
+	usmStatsUnknownEngineIDsOid = ".1.3.6.1.6.3.15.1.1.4.0"
+
+	fakeAgentEngineId = "\x80\x00\x1f\x88\x80\x77\x62\x2d\x6d\x71\x74\x74"
+)
+
+// Fake SNMPv3 agent
+// Serves values from map and performs engine ID discovery
+type fakeV3Agent struct {
+	conn   *net.UDPConn
+	engine *gosnmp.GoSNMP
+	values map[string]string
+	start  time.Time
+}
+
+func newFakeV3Agent(v3 *SnmpV3Config, values map[string]string) (*fakeV3Agent, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+
+	usm := &gosnmp.UsmSecurityParameters{
+		AuthoritativeEngineID:    fakeAgentEngineId,
+		AuthoritativeEngineBoots: 1,
+		UserName:                 v3.SecurityName,
+		AuthenticationProtocol:   v3.AuthProtocol,
+		AuthenticationPassphrase: v3.AuthPassphrase,
+		PrivacyProtocol:          v3.PrivProtocol,
+		PrivacyPassphrase:        v3.PrivPassphrase,
+	}
+	if err := usm.InitSecurityKeys(); err != nil {
+		return nil, err
+	}
+
+	a := &fakeV3Agent{
+		conn: conn,
+		engine: &gosnmp.GoSNMP{
+			Version:            gosnmp.Version3,
+			SecurityModel:      gosnmp.UserSecurityModel,
+			MsgFlags:           v3.SecurityLevel,
+			SecurityParameters: usm,
+		},
+		values: values,
+		start:  time.Now(),
+	}
+
+	go a.serve()
+
+	return a, nil
+}
+
+func (a *fakeV3Agent) Address() string {
+	return a.conn.LocalAddr().String()
+}
+
+func (a *fakeV3Agent) Close() {
+	a.conn.Close()
+}
+
+func (a *fakeV3Agent) serve() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		req, err := a.engine.SnmpDecodePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		usm := a.engine.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+		usm.AuthoritativeEngineTime = uint32(time.Since(a.start).Seconds())
+
+		// answer with report on discovery and with value otherwise
+		resp := a.engine
+		pduType := gosnmp.GetResponse
+		var pdus []gosnmp.SnmpPDU
+
+		reqUsm := req.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+		if reqUsm.AuthoritativeEngineID == "" {
+			resp = &gosnmp.GoSNMP{
+				Version:            gosnmp.Version3,
+				SecurityModel:      gosnmp.UserSecurityModel,
+				MsgFlags:           gosnmp.NoAuthNoPriv,
+				SecurityParameters: usm.Copy(),
+			}
+			pduType = gosnmp.Report
+			pdus = []gosnmp.SnmpPDU{{Name: usmStatsUnknownEngineIDsOid, Type: gosnmp.Counter32, Value: uint32(1)}}
+		} else {
+			for _, v := range req.Variables {
+				if value, ok := a.values[v.Name]; ok {
+					pdus = append(pdus, gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.OctetString, Value: value})
+				} else {
+					pdus = append(pdus, gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.NoSuchObject})
+				}
+			}
+		}
+
+		resp.ContextEngineID = fakeAgentEngineId
+		resp.SetRequestID(req.RequestID - 1)
+		resp.SetMsgID(req.MsgID - 1)
+		out, err := resp.SnmpEncodePacket(pduType, pdus, 0, 0)
+		if err != nil {
+			continue
+		}
+
+		a.conn.WriteToUDP(out, addr)
+	}
+}
+
+type SnmpInterfaceSuite struct {
+	testutils.Suite
+}
+
+func (s *SnmpInterfaceSuite) SetupTest() {
+	s.Suite.SetupTest()
+}
+
+func (s *SnmpInterfaceSuite) TearDownTest() {
+	s.Suite.TearDownTest()
+}
+
+// Poll fake agent with given client and agent security parameters
+func (s *SnmpInterfaceSuite) getFromAgent(client, agent *SnmpV3Config) (string, error) {
+	a, err := newFakeV3Agent(agent, map[string]string{".1.3.6.1.2.1.1.5.0": "fake-agent"})
+	s.Ck("can't start fake agent", err)
+	defer a.Close()
+
+	config := NewEmptyDeviceConfig()
+	config.Address = a.Address()
+	config.SnmpVersion = gosnmp.Version3
+	config.SnmpTimeout = 1
+	config.V3 = client
+
+	snmp, err := NewGoSNMP(config, false)
+	s.Ck("can't create SNMP session", err)
+
+	packet, err := snmp.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	if err != nil {
+		return "", err
+	}
+
+	s.Equal(1, len(packet.Variables))
+	data, valid := ConvertSnmpValue(packet.Variables[0])
+	s.True(valid)
+
+	return data, nil
+}
+
+func (s *SnmpInterfaceSuite) TestV3SecurityLevels() {
+	levels := []*SnmpV3Config{
+		{
+			SecurityName:  "noauth",
+			SecurityLevel: gosnmp.NoAuthNoPriv,
+			AuthProtocol:  gosnmp.NoAuth,
+			PrivProtocol:  gosnmp.NoPriv,
+		},
+		{
+			SecurityName:   "authmd5",
+			SecurityLevel:  gosnmp.AuthNoPriv,
+			AuthProtocol:   gosnmp.MD5,
+			AuthPassphrase: "authpassword",
+			PrivProtocol:   gosnmp.NoPriv,
+		},
+		{
+			SecurityName:   "authsha256",
+			SecurityLevel:  gosnmp.AuthNoPriv,
+			AuthProtocol:   gosnmp.SHA256,
+			AuthPassphrase: "authpassword",
+			PrivProtocol:   gosnmp.NoPriv,
+		},
+		{
+			SecurityName:   "privdes",
+			SecurityLevel:  gosnmp.AuthPriv,
+			AuthProtocol:   gosnmp.SHA,
+			AuthPassphrase: "authpassword",
+			PrivProtocol:   gosnmp.DES,
+			PrivPassphrase: "privpassword",
+		},
+		{
+			SecurityName:   "privaes",
+			SecurityLevel:  gosnmp.AuthPriv,
+			AuthProtocol:   gosnmp.SHA,
+			AuthPassphrase: "authpassword",
+			PrivProtocol:   gosnmp.AES,
+			PrivPassphrase: "privpassword",
+		},
+	}
+
+	for _, v3 := range levels {
+		data, err := s.getFromAgent(v3, v3)
+		s.NoError(err, "failed to get value as %s", v3.SecurityName)
+		s.Equal("fake-agent", data)
+	}
+}
+
+func (s *SnmpInterfaceSuite) TestV3WrongPassphrase() {
+	agent := &SnmpV3Config{
+		SecurityName:   "privaes",
+		SecurityLevel:  gosnmp.AuthPriv,
+		AuthProtocol:   gosnmp.SHA,
+		AuthPassphrase: "authpassword",
+		PrivProtocol:   gosnmp.AES,
+		PrivPassphrase: "privpassword",
+	}
+
+	client := *agent
+	client.AuthPassphrase = "wrongpassword"
+
+	_, err := s.getFromAgent(&client, agent)
+	s.Error(err, "response with wrong digest is accepted")
+}
+
+func TestSnmpInterface(t *testing.T) {
+	testutils.RunSuites(t, new(SnmpInterfaceSuite))
+}
@@ -0,0 +1,319 @@
+package mqtt_snmp
+
+// Table walking module
+// Table channel is a column of SNMP table; it is walked on
+// every poll and each row gets its own control
+
+import (
+	"strings"
+
+	"github.com/contactless/wbgo"
+	"github.com/gosnmp/gosnmp"
+	"github.com/wirenboard/wb-mqtt-snmp/snmp_oid"
+)
+
+const (
+	// Placeholders for row name pattern
+	TableIndexPlaceholder = "{index}"
+	TableLabelPlaceholder = "{label}"
+)
+
+// Single row of table as it is sent from PollWorker to PublisherWorker
+// Data is processed by Convert method of table channel
+type TableRow struct {
+	Index, Name, Data string
+
+	// Raw counter of rate table, set instead of Data
+	Sample *CounterSample
+
+	// Value can't be converted, read error is published instead of Data
+	Failed bool
+}
+
+// Optional model observer extension to remove controls
+// of disappeared table rows
+type ControlRemover interface {
+	RemoveControl(dev wbgo.DeviceModel, name string)
+}
+
+// Get row index from OID of table column instance
+func tableIndex(column, oid string) (index string, ok bool) {
+	prefix := column + "."
+	oid = snmp_oid.Normalize(oid)
+	if !strings.HasPrefix(oid, prefix) {
+		return "", false
+	}
+	return oid[len(prefix):], true
+}
+
+// Make control name of row from pattern
+// Label is a value of label column, index is used if it's empty
+func (c *ChannelConfig) rowName(index, label string) string {
+	// these symbols are not allowed in MQTT topic names
+	label = strings.TrimSpace(strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(label))
+	if label == "" {
+		label = index
+	}
+
+	return strings.NewReplacer(TableIndexPlaceholder, index, TableLabelPlaceholder, label).Replace(c.RowName)
+}
+
+// Create channel config for table row
+// Row inherits all table channel settings, rows are ordered
+// after table order by their position in table
+func (c *ChannelConfig) newRowChannel(index, name string, position int) *ChannelConfig {
+	row := *c
+	row.Name = name
+	row.Order = c.Order + position
+	row.Oid = c.Oid + "." + index
+	row.Table = false
+	row.RowName = ""
+	row.LabelOid = ""
+	row.Title = nil
+
+	return &row
+}
+
+// Get copy of rows map of table channel
+func (d *SnmpDevice) tableRows(table *ChannelConfig) map[string]*ChannelConfig {
+	d.rowsMutex.RLock()
+	defer d.rowsMutex.RUnlock()
+
+	rows := make(map[string]*ChannelConfig, len(d.rows[table]))
+	for index, row := range d.rows[table] {
+		rows[index] = row
+	}
+
+	return rows
+}
+
+// Check if channel is a row of device table
+func (d *SnmpDevice) isRow(channel *ChannelConfig) bool {
+	d.rowsMutex.RLock()
+	defer d.rowsMutex.RUnlock()
+
+	for _, rows := range d.rows {
+		for _, row := range rows {
+			if row == channel {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Find channel or table row by control name
+func (d *SnmpDevice) channelByName(name string) *ChannelConfig {
+	d.rowsMutex.RLock()
+	defer d.rowsMutex.RUnlock()
+
+	if channel, ok := d.Config.Channels[name]; ok {
+		return channel
+	}
+
+	for _, rows := range d.rows {
+		for _, row := range rows {
+			if row.Name == name {
+				return row
+			}
+		}
+	}
+
+	return nil
+}
+
+// Check if name is used by pseudo-control of device:
+// last trap, availability or alarm control
+func (d *SnmpDevice) isPseudoControl(name string) bool {
+	if d.Config.LastTrapControl && name == LastTrapChannelName {
+		return true
+	}
+	if d.Config.AvailabilityControls && (name == OnlineChannelName || name == LastSeenChannelName) {
+		return true
+	}
+
+	d.rowsMutex.RLock()
+	defer d.rowsMutex.RUnlock()
+
+	for _, channel := range d.Config.Channels {
+		if channel.Alarm != nil && channel.Alarm.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Find channels and table rows by OID
+func (d *SnmpDevice) channelsByOid(oid string) []*ChannelConfig {
+	d.rowsMutex.RLock()
+	defer d.rowsMutex.RUnlock()
+
+	res := make([]*ChannelConfig, 0, 1)
+	for _, channel := range d.Config.Channels {
+		if channel.Oid == oid && !channel.Table {
+			res = append(res, channel)
+		}
+	}
+
+	for _, rows := range d.rows {
+		for _, row := range rows {
+			if row.Oid == oid {
+				res = append(res, row)
+			}
+		}
+	}
+
+	return res
+}
+
+func (d *SnmpDevice) addRow(table *ChannelConfig, index string, row *ChannelConfig) {
+	d.rowsMutex.Lock()
+	defer d.rowsMutex.Unlock()
+
+	if _, ok := d.rows[table]; !ok {
+		d.rows[table] = make(map[string]*ChannelConfig)
+	}
+	d.rows[table][index] = row
+}
+
+func (d *SnmpDevice) deleteRow(table *ChannelConfig, index string) {
+	d.rowsMutex.Lock()
+	defer d.rowsMutex.Unlock()
+
+	delete(d.rows[table], index)
+}
+
+// Walk table column and send rows (or error) to publisher worker
+func (m *SnmpModel) walkTable(id int, channel *ChannelConfig, res chan PollResult, err chan PollError) {
+	dev := m.channelDevice(channel)
+	if dev == nil {
+		wbgo.Debug.Printf("[poller %d] Drop walk of %s: device is removed", id, channel.Name)
+		return
+	}
+
+	pdus, e := dev.Walk(channel.Oid)
+	now := timeNow()
+	if e != nil {
+		wbgo.Error.Printf("failed to walk %s:%s: %s", dev.DevName, channel.Name, e)
+		err <- PollError{Channel: channel, Error: e.Error()}
+		return
+	}
+
+	// labels are optional, so use indexes on failure
+	labels := make(map[string]string)
+	if channel.LabelOid != "" {
+		if labelPdus, e := dev.Walk(channel.LabelOid); e != nil {
+			wbgo.Warn.Printf("failed to get row labels of %s:%s: %s", dev.DevName, channel.Name, e)
+		} else {
+			for _, v := range labelPdus {
+				index, ok := tableIndex(channel.LabelOid, v.Name)
+				if !ok {
+					continue
+				}
+				if data, valid := ConvertSnmpValue(v); valid {
+					labels[index] = data
+				}
+			}
+		}
+	}
+
+	// agent uptime is requested after walk to detect agent restarts
+	var uptime gosnmp.SnmpPDU
+	if channel.Rate != 0 {
+		uptime = dev.getUptime()
+	}
+
+	rows := make([]TableRow, 0, len(pdus))
+	for _, v := range pdus {
+		index, ok := tableIndex(channel.Oid, v.Name)
+		if !ok {
+			continue
+		}
+
+		if channel.Rate != 0 {
+			sample, e := newCounterSample(v, now)
+			if e != nil {
+				wbgo.Warn.Printf("row %s of %s:%s: %s", index, dev.DevName, channel.Name, e)
+				continue
+			}
+			sample.setUptime(uptime)
+			rows = append(rows, TableRow{Index: index, Name: channel.rowName(index, labels[index]), Sample: &sample})
+			continue
+		}
+
+		data, valid := channel.ConvertValue(v)
+		if !valid {
+			wbgo.Warn.Printf("row %s of %s:%s can't be converted to string", index, dev.DevName, channel.Name)
+			continue
+		}
+
+		row := TableRow{Index: index, Name: channel.rowName(index, labels[index])}
+		if row.Data, e = channel.Convert(data); e != nil {
+			wbgo.Warn.Printf("row %s of %s:%s: %s", index, dev.DevName, channel.Name, e)
+			row.Failed = true
+		}
+		rows = append(rows, row)
+	}
+
+	wbgo.Debug.Printf("[poller %d] Send %d rows for %s", id, len(rows), channel.Name)
+	res <- PollResult{Channel: channel, Rows: rows}
+}
+
+// Publish table rows
+// Controls are created for new rows and removed for disappeared ones
+func (m *SnmpModel) publishTable(dev *SnmpDevice, table *ChannelConfig, rows []TableRow) {
+	current := dev.tableRows(table)
+	seen := make(map[string]bool, len(rows))
+
+	for i, r := range rows {
+		seen[r.Index] = true
+		row, ok := current[r.Index]
+
+		// make name unique among device controls
+		name := r.Name
+		if other := dev.channelByName(name); other != nil && other != row || dev.isPseudoControl(name) {
+			name = name + "_" + r.Index
+		}
+
+		// label or position is changed, so control is recreated
+		if ok && (row.Name != name || row.Order != table.Order+i) {
+			m.removeRow(dev, table, r.Index, row)
+			ok = false
+		}
+
+		if !ok {
+			row = table.newRowChannel(r.Index, name, i)
+			dev.addRow(table, r.Index, row)
+		}
+
+		if r.Failed {
+			m.publishError(dev, row, "r")
+		} else if r.Sample != nil {
+			m.publishRate(dev, row, *r.Sample)
+		} else {
+			m.publishData(dev, row, r.Data)
+		}
+	}
+
+	for index, row := range current {
+		if !seen[index] {
+			m.removeRow(dev, table, index, row)
+		}
+	}
+}
+
+// Remove table row and its control
+func (m *SnmpModel) removeRow(dev *SnmpDevice, table *ChannelConfig, index string, row *ChannelConfig) {
+	wbgo.Debug.Printf("[publisher] Remove row %s of %s:%s", index, dev.DevName, table.Name)
+
+	dev.deleteRow(table, index)
+	delete(dev.Cache, row)
+	delete(dev.Error, row)
+	delete(dev.Samples, row)
+	delete(dev.Published, row)
+	delete(dev.Latest, row)
+
+	if m.Remover != nil {
+		m.Remover.RemoveControl(dev, row.Name)
+	}
+}
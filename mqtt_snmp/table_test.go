@@ -0,0 +1,308 @@
+package mqtt_snmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+const (
+	ifOperStatusOid = ".1.3.6.1.2.1.2.2.1.8"
+	ifNameOid       = ".1.3.6.1.2.1.31.1.1.1.1"
+)
+
+type TableSuite struct {
+	testutils.Suite
+
+	config   *DaemonConfig
+	model    *SnmpModel
+	table    *ChannelConfig
+	observer *MockDeviceObserver
+
+	queryChannel  chan PollQuery
+	writeChannel  chan WriteQuery
+	resultChannel chan PollResult
+	errorChannel  chan PollError
+	quitChannel   chan struct{}
+	pollDone      chan struct{}
+	pubDone       chan struct{}
+}
+
+func (s *TableSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	fakeSNMPMessages = make(map[string]*gosnmp.SnmpPacket)
+
+	s.table = &ChannelConfig{
+		Name:         "ports",
+		Oid:          ifOperStatusOid,
+		ControlType:  "value",
+		Conv:         AsIs,
+		InvConv:      AsIs,
+		PollInterval: 1000,
+		Order:        1,
+		Writable:     true,
+		SetType:      gosnmp.Integer,
+		Table:        true,
+		RowName:      "Port {label} status",
+		LabelOid:     ifNameOid,
+	}
+
+	device := &DeviceConfig{
+		Name:        "Switch",
+		Address:     "127.0.0.1",
+		Community:   "test",
+		Id:          "snmp_switch",
+		SnmpVersion: gosnmp.Version2c,
+		SnmpTimeout: 1,
+		Channels:    map[string]*ChannelConfig{"ports": s.table},
+	}
+	s.table.Device = device
+
+	s.config = &DaemonConfig{
+		NumWorkers: 1,
+		Devices:    map[string]*DeviceConfig{"snmp_switch": device},
+	}
+
+	var err error
+	s.model, err = NewSnmpModel(NewFakeSNMP, s.config, time.Now())
+	s.Ck("can't create model", err)
+
+	s.observer = NewMockDeviceObserver()
+	s.model.Remover = s.observer
+	s.model.devices[0].Observe(s.observer)
+
+	s.queryChannel = make(chan PollQuery, CHAN_BUFFER_SIZE)
+	s.writeChannel = make(chan WriteQuery, CHAN_BUFFER_SIZE)
+	s.resultChannel = make(chan PollResult, CHAN_BUFFER_SIZE)
+	s.errorChannel = make(chan PollError, CHAN_BUFFER_SIZE)
+	s.quitChannel = make(chan struct{}, 2)
+	s.pollDone = make(chan struct{}, CHAN_BUFFER_SIZE)
+	s.pubDone = make(chan struct{}, CHAN_BUFFER_SIZE)
+
+	s.model.devices[0].writeChannel = s.writeChannel
+
+	go s.model.PollWorker(0, s.queryChannel, s.writeChannel, s.resultChannel, s.errorChannel, s.quitChannel, s.pollDone)
+	go s.model.PublisherWorker(s.resultChannel, s.errorChannel, s.quitChannel, s.pubDone)
+}
+
+func (s *TableSuite) TearDownTest() {
+	s.quitChannel <- struct{}{}
+	s.quitChannel <- struct{}{}
+	<-s.pollDone
+	<-s.pubDone
+
+	s.Suite.TearDownTest()
+}
+
+// Walk table and wait for result to be published
+func (s *TableSuite) walk() {
+	s.queryChannel <- PollQuery{Channel: s.table, Deadline: time.Now()}
+
+	for _, done := range []chan struct{}{s.pollDone, s.pubDone} {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			s.Fail("table walk timeout")
+		}
+	}
+}
+
+func (s *TableSuite) TestRowName() {
+	s.Equal("Port ge_0_1 status", s.table.rowName("1", "ge/0/1"))
+	s.Equal("Port 2 status", s.table.rowName("2", ""))
+
+	index, ok := tableIndex(ifOperStatusOid, ifOperStatusOid+".10.1")
+	s.True(ok)
+	s.Equal("10.1", index)
+
+	_, ok = tableIndex(ifOperStatusOid, ifNameOid+".10")
+	s.False(ok)
+}
+
+func (s *TableSuite) TestRows() {
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifOperStatusOid+".1", "1")
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifOperStatusOid+".2", "2")
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifNameOid+".1", "ge/1")
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifNameOid+".2", "ge2")
+
+	s.walk()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_switch, name Port ge_1 status, type value, value 1, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_switch, name Port ge2 status, type value, value 2, order 2"},
+	}, EventTimeout))
+
+	// row 2 disappears, row 3 without label appears
+	delete(fakeSNMPMessages, "127.0.0.1@test@"+ifOperStatusOid+".2")
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifOperStatusOid+".1", "2")
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifOperStatusOid+".3", "1")
+
+	s.walk()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_switch, name Port ge_1 status, value 2"},
+		&MockDeviceEvent{OnRemoveControlEvent, "device snmp_switch, name Port ge2 status"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_switch, name Port 3 status, type value, value 1, order 2"},
+	}, EventTimeout))
+
+	// label change recreates control, duplicate labels are made unique
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifNameOid+".1", "ge1")
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifNameOid+".3", "ge1")
+
+	s.walk()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnRemoveControlEvent, "device snmp_switch, name Port ge_1 status"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_switch, name Port ge1 status, type value, value 2, order 1"},
+		&MockDeviceEvent{OnRemoveControlEvent, "device snmp_switch, name Port 3 status"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_switch, name Port ge1 status_3, type value, value 1, order 2"},
+	}, EventTimeout))
+
+	// nothing is changed
+	s.walk()
+	s.NoError(s.observer.WaitForNoMessages(WaitTimeout))
+
+	// rows are found for traps and writes
+	dev := s.model.devices[0]
+	rows := dev.channelsByOid(ifOperStatusOid + ".3")
+	s.Equal(1, len(rows))
+	s.Equal("Port ge1 status_3", rows[0].Name)
+	s.Equal(rows[0], dev.channelByName("Port ge1 status_3"))
+	s.Equal(0, len(dev.channelsByOid(ifOperStatusOid)))
+
+	s.False(dev.AcceptOnValue("Port ge1 status", "1"))
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_switch, name Port ge1 status, value 1"},
+	}, EventTimeout))
+	s.Equal(1, fakeSNMPMessages["127.0.0.1@test@"+ifOperStatusOid+".1"].Variables[0].Value)
+}
+
+// Rows don't take names of pseudo-controls and are ordered by position
+func (s *TableSuite) TestRowNamesAndOrder() {
+	s.table.RowName = "{label}"
+	s.table.Device.LastTrapControl = true
+
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifOperStatusOid+".1", "1")
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifOperStatusOid+".2", "2")
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifNameOid+".1", LastTrapChannelName)
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifNameOid+".2", "ge2")
+
+	s.walk()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_switch, name last_trap_1, type value, value 1, order 1"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_switch, name ge2, type value, value 2, order 2"},
+	}, EventTimeout))
+
+	// first row disappears, so second one moves up
+	delete(fakeSNMPMessages, "127.0.0.1@test@"+ifOperStatusOid+".1")
+
+	s.walk()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnRemoveControlEvent, "device snmp_switch, name ge2"},
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_switch, name ge2, type value, value 2, order 1"},
+		&MockDeviceEvent{OnRemoveControlEvent, "device snmp_switch, name last_trap_1"},
+	}, EventTimeout))
+}
+
+func (s *TableSuite) TestWalkError() {
+	InsertFakeSNMPMessage("127.0.0.1@test@"+ifOperStatusOid+".1", "1")
+
+	s.walk()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_switch, name Port 1 status, type value, value 1, order 1"},
+	}, EventTimeout))
+
+	// device is unreachable, rows are kept with error
+	fakeSNMPMessages = make(map[string]*gosnmp.SnmpPacket)
+
+	s.walk()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnErrorEvent, "device snmp_switch, name Port 1 status, error r"},
+	}, EventTimeout))
+
+	s.EnsureGotErrors()
+}
+
+// Row which can't be evaluated by expression is published as read error
+func (s *TableSuite) TestExpressionError() {
+	var err error
+	s.table.expr, err = ParseExpression("10 / x")
+	s.Ck("parse", err)
+	s.table.LabelOid = ""
+
+	InsertFakeSNMPVariable("127.0.0.1@test@"+ifOperStatusOid+".1", gosnmp.Integer, 2)
+	s.walk()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_switch, name Port 1 status, type value, value 5, order 1"},
+	}, EventTimeout))
+
+	InsertFakeSNMPVariable("127.0.0.1@test@"+ifOperStatusOid+".1", gosnmp.Integer, 0)
+	s.walk()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnErrorEvent, "device snmp_switch, name Port 1 status, error r"},
+	}, EventTimeout))
+
+	s.EnsureGotWarnings()
+}
+
+func (s *TableSuite) TestRateRows() {
+	now := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	s.table.Rate = time.Second
+	s.table.LabelOid = ""
+	s.table.Writable = false
+
+	InsertFakeSNMPVariable("127.0.0.1@test@"+ifOperStatusOid+".1", gosnmp.Counter64, uint64(1000))
+	s.walk()
+	s.NoError(s.observer.WaitForNoMessages(WaitTimeout))
+
+	now = now.Add(10 * time.Second)
+	InsertFakeSNMPVariable("127.0.0.1@test@"+ifOperStatusOid+".1", gosnmp.Counter64, uint64(1500))
+	s.walk()
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_switch, name Port 1 status, type value, value 50.00, order 1"},
+	}, EventTimeout))
+}
+
+// Agent uptime is requested after walk, so agent restart is detected
+func (s *TableSuite) TestRateRowsRestart() {
+	now := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	s.table.Rate = time.Second
+	s.table.LabelOid = ""
+	s.table.Writable = false
+
+	walk := func(counter uint32, uptime uint32) {
+		InsertFakeSNMPVariable("127.0.0.1@test@"+ifOperStatusOid+".1", gosnmp.Counter32, uint(counter))
+		InsertFakeSNMPVariable("127.0.0.1@test@"+sysUpTimeOid, gosnmp.TimeTicks, uptime)
+		s.walk()
+	}
+
+	walk(1000, 5000)
+	s.NoError(s.observer.WaitForNoMessages(WaitTimeout))
+
+	now = now.Add(10 * time.Second)
+	walk(1500, 6000)
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnNewControlEvent, "device snmp_switch, name Port 1 status, type value, value 50.00, order 1"},
+	}, EventTimeout))
+
+	// agent restart isn't taken for counter wraparound
+	now = now.Add(10 * time.Second)
+	walk(200, 100)
+	s.NoError(s.observer.WaitForNoMessages(WaitTimeout))
+
+	now = now.Add(10 * time.Second)
+	walk(800, 1100)
+	s.NoError(s.observer.CheckEvents([]*MockDeviceEvent{
+		&MockDeviceEvent{OnValueEvent, "device snmp_switch, name Port 1 status, value 60.00"},
+	}, EventTimeout))
+}
+
+func TestTable(t *testing.T) {
+	testutils.RunSuites(t, new(TableSuite))
+}
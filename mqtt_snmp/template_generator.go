@@ -0,0 +1,292 @@
+package mqtt_snmp
+
+// Template generator module
+// Makes skeleton of device template from walked variables:
+// channel per variable named by MIB object, control type is guessed
+// from SNMP type and MIB units, enums are taken from MIB
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/wirenboard/wb-mqtt-snmp/snmp_oid"
+)
+
+// Options of template generator
+type TemplateOptions struct {
+	DeviceType string
+
+	// SNMP version as it's written in config, "2c" if empty
+	SnmpVersion string
+
+	// Numeric OIDs of subtrees to take variables from, all if empty
+	Subtrees []string
+
+	// MIB modules to take variables from, all if empty;
+	// variables without MIB objects are skipped if modules are given
+	Modules []string
+}
+
+// Channel entry of generated template
+type templateChannel struct {
+	Name  string            `json:"name"`
+	Oid   string            `json:"oid"`
+	Type  string            `json:"type"`
+	Units string            `json:"units,omitempty"`
+	Scale float64           `json:"scale,omitempty"`
+	Enum  map[string]string `json:"enum,omitempty"`
+}
+
+// Generated template, fields are in order of template files
+type deviceTemplate struct {
+	DeviceType  string            `json:"device_type"`
+	SnmpVersion string            `json:"snmp_version"`
+	OidPrefix   string            `json:"oid_prefix,omitempty"`
+	Channels    []templateChannel `json:"channels"`
+}
+
+var (
+	// Scale factor before units like "0.1 V" or "1/10 V"
+	unitsFactor = regexp.MustCompile(`^(?:([0-9]*\.[0-9]+|1/[0-9]+)\s*)?(.*)$`)
+
+	// Scale words before units like "tenths of volts"
+	unitsScales = map[string]float64{
+		"tenths of":      0.1,
+		"hundredths of":  0.01,
+		"thousandths of": 0.001,
+	}
+
+	// Control types of units, keys are in lower case
+	unitsControlTypes = map[string]string{
+		"v": "voltage", "volt": "voltage", "volts": "voltage", "vac": "voltage", "vdc": "voltage",
+		"a": "current", "amp": "current", "amps": "current", "ampere": "current", "amperes": "current",
+		"w": "power", "watt": "power", "watts": "power",
+		"kwh":       "power_consumption",
+		"degrees c": "temperature", "degrees celsius": "temperature", "celsius": "temperature",
+		"degc": "temperature", "°c": "temperature",
+		"%rh": "rel_humidity", "percent rh": "rel_humidity",
+		"ohm": "resistance", "ohms": "resistance",
+		"lux": "lux", "lx": "lux",
+		"db": "sound_level", "dba": "sound_level",
+	}
+
+	// Metric prefixes of units without control type of their own
+	unitsPrefixes = map[string]float64{"m": 0.001, "k": 1000}
+)
+
+// Guess control type and scale by MIB units
+// Units without known control type are returned for value control
+func guessUnits(units string) (controlType, rest string, scale float64) {
+	units = strings.TrimSpace(units)
+	scale = 1
+
+	for words, s := range unitsScales {
+		if strings.HasPrefix(strings.ToLower(units), words+" ") {
+			scale, units = s, strings.TrimSpace(units[len(words):])
+		}
+	}
+
+	if m := unitsFactor.FindStringSubmatch(units); m != nil && m[1] != "" {
+		if strings.HasPrefix(m[1], "1/") {
+			d, _ := strconv.ParseFloat(m[1][2:], 64)
+			if d != 0 {
+				scale /= d
+			}
+		} else {
+			f, _ := strconv.ParseFloat(m[1], 64)
+			scale *= f
+		}
+		units = m[2]
+	}
+
+	lower := strings.ToLower(units)
+	if t, ok := unitsControlTypes[lower]; ok {
+		return t, "", scale
+	}
+	if len(lower) > 1 {
+		if t, ok := unitsControlTypes[lower[1:]]; ok && unitsPrefixes[lower[:1]] != 0 && units[:1] != "M" {
+			return t, "", scale * unitsPrefixes[lower[:1]]
+		}
+	}
+
+	if units == "" {
+		return "value", "", scale
+	}
+	return "value", units, scale
+}
+
+// Check if display hint with implied decimal point like "d-2" is set,
+// daemon applies it itself, so scale isn't needed
+func hasDecimalHint(obj *MibObject) bool {
+	if obj == nil || !strings.HasPrefix(obj.DisplayHint, "d-") {
+		return false
+	}
+	point, err := strconv.Atoi(obj.DisplayHint[2:])
+	return err == nil && point > 0
+}
+
+// Make channel of variable, ok is false for variables
+// which can't be published
+func templateChannelOf(v gosnmp.SnmpPDU, obj *MibObject, index string) (ch templateChannel, ok bool) {
+	ch.Type = "text"
+
+	switch v.Type {
+	case gosnmp.OctetString, gosnmp.IPAddress, gosnmp.TimeTicks:
+	case gosnmp.Integer, gosnmp.Gauge32, gosnmp.Counter32, gosnmp.Counter64, gosnmp.Uinteger32:
+		ch.Type = "value"
+		if obj != nil && len(obj.Enums) > 0 {
+			ch.Type = "text"
+			ch.Enum = make(map[string]string, len(obj.Enums))
+			for n, label := range obj.Enums {
+				ch.Enum[strconv.FormatInt(n, 10)] = label
+			}
+		} else if obj != nil && obj.Units != "" {
+			var scale float64
+			ch.Type, ch.Units, scale = guessUnits(obj.Units)
+			if scale != 1 && !hasDecimalHint(obj) {
+				ch.Scale = roundScale(scale)
+			}
+		}
+	default:
+		return ch, false
+	}
+
+	if obj == nil {
+		ch.Name = strings.TrimPrefix(v.Name, ".")
+		ch.Oid = v.Name
+		return ch, true
+	}
+
+	ch.Name = obj.Name
+	ch.Oid = obj.Module + "::" + obj.Name
+	if index != "" {
+		ch.Oid += "." + index
+		if index != "0" {
+			ch.Name += " " + index
+		}
+	}
+	return ch, true
+}
+
+// Check if variable is in one of subtrees
+func inSubtrees(oid string, subtrees []string) bool {
+	if len(subtrees) == 0 {
+		return true
+	}
+	for _, s := range subtrees {
+		if oid == s || strings.HasPrefix(oid, s+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate template from variables, result is indented JSON
+// in format of template files
+// Module of most channels is used as oid_prefix of template
+func GenerateTemplate(pdus []gosnmp.SnmpPDU, mib *Mib, opts TemplateOptions) ([]byte, error) {
+	if opts.DeviceType == "" {
+		return nil, fmt.Errorf("device type is required")
+	}
+
+	modules := make(map[string]bool, len(opts.Modules))
+	for _, m := range opts.Modules {
+		modules[m] = true
+	}
+
+	// variables are named by objects of any module
+	mib.LoadAll()
+
+	subtrees := make([]string, len(opts.Subtrees))
+	for i, s := range opts.Subtrees {
+		subtrees[i] = snmp_oid.Normalize(s)
+	}
+
+	tpl := deviceTemplate{DeviceType: opts.DeviceType, SnmpVersion: opts.SnmpVersion, Channels: make([]templateChannel, 0)}
+	if tpl.SnmpVersion == "" {
+		tpl.SnmpVersion = "2c"
+	}
+	channelModules := make([]string, 0)
+	names := make(map[string]bool)
+
+	for _, v := range pdus {
+		oid := snmp_oid.Normalize(v.Name)
+		if !inSubtrees(oid, subtrees) {
+			continue
+		}
+
+		obj, index := mib.Object(oid)
+		if obj != nil && obj.Kind != "OBJECT-TYPE" {
+			obj, index = nil, ""
+		}
+		if len(modules) > 0 && (obj == nil || !modules[obj.Module]) {
+			continue
+		}
+
+		ch, ok := templateChannelOf(gosnmp.SnmpPDU{Name: oid, Type: v.Type, Value: v.Value}, obj, index)
+		if !ok {
+			continue
+		}
+
+		// channel names must be unique in device
+		for name, i := ch.Name, 2; names[ch.Name]; i++ {
+			ch.Name = fmt.Sprintf("%s %d", name, i)
+		}
+		names[ch.Name] = true
+
+		module := ""
+		if obj != nil {
+			module = obj.Module
+		}
+		tpl.Channels = append(tpl.Channels, ch)
+		channelModules = append(channelModules, module)
+	}
+
+	if len(tpl.Channels) == 0 {
+		return nil, fmt.Errorf("no variables to make channels of")
+	}
+
+	// the most used module is set as prefix, so OIDs are shorter
+	count := make(map[string]int)
+	for _, m := range channelModules {
+		if m != "" {
+			count[m]++
+		}
+	}
+	best := make([]string, 0, len(count))
+	for m := range count {
+		best = append(best, m)
+	}
+	sort.Slice(best, func(i, j int) bool {
+		return count[best[i]] > count[best[j]] || count[best[i]] == count[best[j]] && best[i] < best[j]
+	})
+	if len(best) > 0 {
+		tpl.OidPrefix = best[0]
+		for i := range tpl.Channels {
+			if channelModules[i] == tpl.OidPrefix {
+				tpl.Channels[i].Oid = strings.TrimPrefix(tpl.Channels[i].Oid, tpl.OidPrefix+"::")
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(tpl); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Round scale to avoid float noise like 0.30000000000000004
+func roundScale(scale float64) float64 {
+	return math.Round(scale*1e9) / 1e9
+}
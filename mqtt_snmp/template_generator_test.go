@@ -0,0 +1,278 @@
+package mqtt_snmp
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+const testPduMib = `
+TEST-PDU-MIB DEFINITIONS ::= BEGIN
+
+IMPORTS
+    OBJECT-TYPE, Integer32, enterprises
+        FROM SNMPv2-SMI;
+
+DeciCelsius ::= TEXTUAL-CONVENTION
+    DISPLAY-HINT "d-1"
+    STATUS       current
+    DESCRIPTION  "Temperature in tenths of degree."
+    SYNTAX       Integer32
+
+pdu OBJECT IDENTIFIER ::= { enterprises 77777 }
+
+pduVoltage OBJECT-TYPE
+    SYNTAX      Integer32
+    UNITS       "0.1 V"
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "Input voltage."
+    ::= { pdu 1 }
+
+pduTemperature OBJECT-TYPE
+    SYNTAX      DeciCelsius
+    UNITS       "degrees C"
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "Temperature."
+    ::= { pdu 2 }
+
+pduPower OBJECT-TYPE
+    SYNTAX      Integer32
+    UNITS       "kW"
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "Active power."
+    ::= { pdu 3 }
+
+pduLoad OBJECT-TYPE
+    SYNTAX      Integer32
+    UNITS       "percent"
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "Load."
+    ::= { pdu 4 }
+
+pduState OBJECT-TYPE
+    SYNTAX      INTEGER { on(1), off(2) }
+    MAX-ACCESS  read-write
+    STATUS      current
+    DESCRIPTION "Output state."
+    ::= { pdu 5 }
+
+pduCurrent OBJECT-TYPE
+    SYNTAX      Integer32
+    UNITS       "mA"
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "Outlet current."
+    ::= { pdu 6 }
+
+END
+`
+
+type TemplateGeneratorSuite struct {
+	testutils.Suite
+
+	dir  string
+	mib  *Mib
+	pdus []gosnmp.SnmpPDU
+}
+
+func (s *TemplateGeneratorSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	var err error
+	s.dir, err = os.MkdirTemp("", "wb-mqtt-snmp-template")
+	s.Ck("can't create temp dir", err)
+	writeTestMibs(&s.Suite, s.dir, map[string]string{"TEST-PDU-MIB.txt": testPduMib})
+	s.mib = NewMib([]string{s.dir})
+
+	s.pdus = []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(100)},
+		{Name: ".1.3.6.1.2.1.1.5.0", Type: gosnmp.OctetString, Value: []byte("pdu")},
+		{Name: ".1.3.6.1.2.1.1.2.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.77777"},
+		{Name: ".1.3.6.1.4.1.77777.1.0", Type: gosnmp.Integer, Value: 2301},
+		{Name: ".1.3.6.1.4.1.77777.2.0", Type: gosnmp.Integer, Value: 215},
+		{Name: ".1.3.6.1.4.1.77777.3.0", Type: gosnmp.Integer, Value: 2},
+		{Name: ".1.3.6.1.4.1.77777.4.0", Type: gosnmp.Integer, Value: 30},
+		{Name: ".1.3.6.1.4.1.77777.5.0", Type: gosnmp.Integer, Value: 1},
+		{Name: ".1.3.6.1.4.1.77777.6.1", Type: gosnmp.Integer, Value: 500},
+		{Name: ".1.3.6.1.4.1.77777.6.2", Type: gosnmp.Integer, Value: 700},
+		{Name: ".1.3.6.1.4.1.77777.9.0", Type: gosnmp.Counter32, Value: uint(5)},
+	}
+}
+
+func (s *TemplateGeneratorSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+	s.Suite.TearDownTest()
+}
+
+func (s *TemplateGeneratorSuite) TestGuessUnits() {
+	for units, expected := range map[string]struct {
+		controlType, units string
+		scale              float64
+	}{
+		"V":               {"voltage", "", 1},
+		"0.1 V":           {"voltage", "", 0.1},
+		"1/100 Amps":      {"current", "", 0.01},
+		"tenths of Volts": {"voltage", "", 0.1},
+		"degrees Celsius": {"temperature", "", 1},
+		"mA":              {"current", "", 0.001},
+		"kW":              {"power", "", 1000},
+		"MW":              {"value", "MW", 1},
+		"percent":         {"value", "percent", 1},
+		"0.01 Hz":         {"value", "Hz", 0.01},
+		"":                {"value", "", 1},
+	} {
+		controlType, rest, scale := guessUnits(units)
+		s.Equal(expected.controlType, controlType, "control type of %q", units)
+		s.Equal(expected.units, rest, "units of %q", units)
+		s.InDelta(expected.scale, scale, floatEps, "scale of %q", units)
+	}
+}
+
+// Scale of units is dropped only if display hint has decimal point
+func (s *TemplateGeneratorSuite) TestUnitsWithHint() {
+	for hint, scale := range map[string]float64{
+		"":    0.1,
+		"d":   0.1,
+		"d-0": 0.1,
+		"d-1": 0,
+		"d-2": 0,
+		"x":   0.1,
+	} {
+		obj := &MibObject{Units: "tenths of Volts", DisplayHint: hint}
+		ch, ok := templateChannelOf(gosnmp.SnmpPDU{Name: ".1.2.3.0", Type: gosnmp.Integer, Value: 2301}, obj, "0")
+		s.True(ok)
+		s.Equal("voltage", ch.Type, "type of hint %q", hint)
+		s.Equal(scale, ch.Scale, "scale of hint %q", hint)
+	}
+}
+
+func (s *TemplateGeneratorSuite) TestGenerate() {
+	data, err := GenerateTemplate(s.pdus, s.mib, TemplateOptions{DeviceType: "test-pdu"})
+	s.Ck("can't generate template", err)
+
+	s.Equal(`{
+    "device_type": "test-pdu",
+    "snmp_version": "2c",
+    "oid_prefix": "TEST-PDU-MIB",
+    "channels": [
+        {
+            "name": "sysUpTime",
+            "oid": "SNMPv2-MIB::sysUpTime.0",
+            "type": "text"
+        },
+        {
+            "name": "sysName",
+            "oid": "SNMPv2-MIB::sysName.0",
+            "type": "text"
+        },
+        {
+            "name": "pduVoltage",
+            "oid": "pduVoltage.0",
+            "type": "voltage",
+            "scale": 0.1
+        },
+        {
+            "name": "pduTemperature",
+            "oid": "pduTemperature.0",
+            "type": "temperature"
+        },
+        {
+            "name": "pduPower",
+            "oid": "pduPower.0",
+            "type": "power",
+            "scale": 1000
+        },
+        {
+            "name": "pduLoad",
+            "oid": "pduLoad.0",
+            "type": "value",
+            "units": "percent"
+        },
+        {
+            "name": "pduState",
+            "oid": "pduState.0",
+            "type": "text",
+            "enum": {
+                "1": "on",
+                "2": "off"
+            }
+        },
+        {
+            "name": "pduCurrent 1",
+            "oid": "pduCurrent.1",
+            "type": "current",
+            "scale": 0.001
+        },
+        {
+            "name": "pduCurrent 2",
+            "oid": "pduCurrent.2",
+            "type": "current",
+            "scale": 0.001
+        },
+        {
+            "name": "1.3.6.1.4.1.77777.9.0",
+            "oid": ".1.3.6.1.4.1.77777.9.0",
+            "type": "value"
+        }
+    ]
+}
+`, string(data))
+}
+
+func (s *TemplateGeneratorSuite) TestFilters() {
+	data, err := GenerateTemplate(s.pdus, s.mib, TemplateOptions{
+		DeviceType:  "test",
+		SnmpVersion: "1",
+		Subtrees:    []string{".1.3.6.1.2.1.1", ".1.3.6.1.4.1.77777.6"},
+		Modules:     []string{"SNMPv2-MIB"},
+	})
+	s.Ck("can't generate template", err)
+
+	s.Equal(`{
+    "device_type": "test",
+    "snmp_version": "1",
+    "oid_prefix": "SNMPv2-MIB",
+    "channels": [
+        {
+            "name": "sysUpTime",
+            "oid": "sysUpTime.0",
+            "type": "text"
+        },
+        {
+            "name": "sysName",
+            "oid": "sysName.0",
+            "type": "text"
+        }
+    ]
+}
+`, string(data))
+
+	_, err = GenerateTemplate(s.pdus, s.mib, TemplateOptions{DeviceType: "test", Modules: []string{"NO-SUCH-MIB"}})
+	s.Error(err)
+
+	_, err = GenerateTemplate(s.pdus, s.mib, TemplateOptions{})
+	s.Error(err)
+}
+
+func (s *TemplateGeneratorSuite) TestTemplateIsLoaded() {
+	data, err := GenerateTemplate(s.pdus, s.mib, TemplateOptions{DeviceType: "test-pdu"})
+	s.Ck("can't generate template", err)
+	s.Ck("can't write template", os.WriteFile(s.dir+"/config-test-pdu.json", data, 0644))
+
+	report := CheckConfig(strings.NewReader(`{
+		"mib_dirs": ["`+s.dir+`"],
+		"devices": [{"address": "10.0.0.1", "device_type": "test-pdu"}]
+	}`), s.dir)
+	s.Empty(report.Problems)
+}
+
+func TestTemplateGenerator(t *testing.T) {
+	testutils.RunSuites(t, new(TemplateGeneratorSuite))
+}
@@ -0,0 +1,80 @@
+package mqtt_snmp
+
+// Publish throttling module
+// Noisy values are published only if they change by more than deadband
+// and not more often than min publish interval; unchanged values are
+// re-published after max publish interval
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse deadband of channel: absolute number or percent string like "5%"
+func parseDeadband(entry any) (deadband float64, percent bool, err error) {
+	switch v := entry.(type) {
+	case float64:
+		deadband = v
+	case string:
+		if !strings.HasSuffix(v, "%") {
+			return 0, false, fmt.Errorf("deadband must be number or percent string, but %q given", v)
+		}
+		if deadband, err = strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(v, "%")), 64); err != nil {
+			return 0, false, fmt.Errorf("wrong deadband percent %q", v)
+		}
+		percent = true
+	default:
+		return 0, false, fmt.Errorf("deadband must be number or percent string, but %T given", entry)
+	}
+
+	if deadband < 0 {
+		return 0, false, fmt.Errorf("deadband can't be negative")
+	}
+
+	return deadband, percent, nil
+}
+
+// Check if new value differs from published one by deadband or more
+// Non-numeric values are compared as strings
+func (c *ChannelConfig) exceedsDeadband(prev, cur string) bool {
+	if prev == cur {
+		return false
+	}
+	if c.Deadband == 0 {
+		return true
+	}
+
+	p, err := strconv.ParseFloat(prev, 64)
+	if err != nil {
+		return true
+	}
+	v, err := strconv.ParseFloat(cur, 64)
+	if err != nil {
+		return true
+	}
+
+	band := c.Deadband
+	if c.DeadbandPercent {
+		band = math.Abs(p) * c.Deadband / 100
+	}
+
+	return math.Abs(v-p) >= band
+}
+
+// Check if value of existing control should be published
+// Value clearing channel error is published immediately
+func (d *SnmpDevice) needPublish(channel *ChannelConfig, cached, data string, now time.Time) bool {
+	if d.Error[channel] != "" {
+		return cached != data
+	}
+
+	last := d.Published[channel]
+	if channel.MaxPublishInterval > 0 && now.Sub(last) >= channel.MaxPublishInterval {
+		return true
+	}
+
+	return channel.exceedsDeadband(cached, data) && now.Sub(last) >= channel.MinPublishInterval
+}
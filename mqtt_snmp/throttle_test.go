@@ -0,0 +1,90 @@
+package mqtt_snmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+)
+
+type ThrottleSuite struct {
+	testutils.Suite
+}
+
+func (s *ThrottleSuite) TestParseDeadband() {
+	deadband, percent, err := parseDeadband(0.5)
+	s.Ck("absolute", err)
+	s.Equal(0.5, deadband)
+	s.False(percent)
+
+	deadband, percent, err = parseDeadband("5 %")
+	s.Ck("percent", err)
+	s.Equal(5.0, deadband)
+	s.True(percent)
+
+	for _, entry := range []any{"5", "x%", -1.0, true} {
+		_, _, err := parseDeadband(entry)
+		s.Error(err, "deadband %v is accepted", entry)
+	}
+}
+
+func (s *ThrottleSuite) TestDeadband() {
+	cases := []struct {
+		deadband float64
+		percent  bool
+		prev     string
+		cur      string
+		exceeds  bool
+	}{
+		{0, false, "220.1", "220.1", false},
+		{0, false, "220.1", "220.2", true},
+		{0.5, false, "220.1", "220.5", false},
+		{0.5, false, "220.1", "219.6", true},
+		{1, true, "220", "222", false},
+		{1, true, "220", "217.5", true},
+		{1, true, "0", "0.01", true},
+		// non-numeric values
+		{0.5, false, "", "220.1", true},
+		{0.5, false, "on", "off", true},
+	}
+
+	for _, c := range cases {
+		ch := &ChannelConfig{Deadband: c.deadband, DeadbandPercent: c.percent}
+		s.Equal(c.exceeds, ch.exceedsDeadband(c.prev, c.cur), "deadband %v (%v) for %s -> %s", c.deadband, c.percent, c.prev, c.cur)
+	}
+}
+
+func (s *ThrottleSuite) TestIntervals() {
+	t := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	ch := &ChannelConfig{Deadband: 1, MinPublishInterval: 10 * time.Second, MaxPublishInterval: time.Minute}
+	dev := &SnmpDevice{
+		Error:     map[*ChannelConfig]string{ch: ""},
+		Published: map[*ChannelConfig]time.Time{ch: t},
+	}
+
+	cases := []struct {
+		seconds int
+		cur     string
+		publish bool
+	}{
+		{1, "12", false},
+		{9, "15", false},
+		{10, "15", true},
+		{10, "10.5", false},
+		{59, "10", false},
+		{60, "10", true},
+	}
+
+	for _, c := range cases {
+		s.Equal(c.publish, dev.needPublish(ch, "10", c.cur, t.Add(time.Duration(c.seconds)*time.Second)), "%s at %d s", c.cur, c.seconds)
+	}
+
+	// error is cleared immediately
+	dev.Error[ch] = "r"
+	s.True(dev.needPublish(ch, "10", "10.5", t.Add(time.Second)))
+	s.False(dev.needPublish(ch, "10", "10", t.Add(time.Second)))
+}
+
+func TestThrottle(t *testing.T) {
+	testutils.RunSuites(t, new(ThrottleSuite))
+}
@@ -0,0 +1,233 @@
+package mqtt_snmp
+
+// Trap receiver module
+// Receives SNMP traps and informs and pushes values
+// from them to publisher worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/contactless/wbgo"
+	"github.com/gosnmp/gosnmp"
+	"github.com/wirenboard/wb-mqtt-snmp/snmp_oid"
+)
+
+const (
+	// Name of control for traps not matched to any channel
+	LastTrapChannelName = "last_trap"
+
+	// Standard trap varbinds
+	sysUpTimeOid   = ".1.3.6.1.2.1.1.3.0"
+	snmpTrapOidOid = ".1.3.6.1.6.3.1.1.4.1.0"
+
+	// Prefix of generic SNMPv1 traps OIDs (RFC 3584)
+	snmpTrapsOid = ".1.3.6.1.6.3.1.1.5"
+)
+
+// Create pseudo-channel for last trap control
+// It is never polled and placed after all device channels
+func newTrapChannel(config *DeviceConfig) *ChannelConfig {
+	return &ChannelConfig{
+		Name:        LastTrapChannelName,
+		ControlType: "text",
+		Conv:        AsIs,
+		Readonly:    true,
+		InvConv:     AsIs,
+		Order:       len(config.Channels) + 1,
+		Device:      config,
+	}
+}
+
+// Trap as it is published to last trap control
+type trapMessage struct {
+	Source    string            `json:"source"`
+	TrapOid   string            `json:"trap_oid"`
+	Variables map[string]string `json:"variables"`
+}
+
+// Get trap OID from packet
+// SNMPv1 traps are converted to SNMPv2 form according to RFC 3584
+func getTrapOid(packet *gosnmp.SnmpPacket) string {
+	if packet.PDUType == gosnmp.Trap {
+		if packet.GenericTrap != 6 { // enterpriseSpecific
+			return fmt.Sprintf("%s.%d", snmpTrapsOid, packet.GenericTrap+1)
+		}
+		return fmt.Sprintf("%s.0.%d", snmp_oid.Normalize(packet.Enterprise), packet.SpecificTrap)
+	}
+
+	for _, v := range packet.Variables {
+		if snmp_oid.Normalize(v.Name) == snmpTrapOidOid {
+			if oid, ok := v.Value.(string); ok {
+				return snmp_oid.Normalize(oid)
+			}
+		}
+	}
+
+	return ""
+}
+
+// Get host IPs from device address
+// Address may contain port and may be a host name
+func resolveDeviceAddress(address string) ([]net.IP, error) {
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	return net.LookupIP(host)
+}
+
+// Map trap source addresses to devices
+func mapTrapDevices(devices []*SnmpDevice) map[string][]*SnmpDevice {
+	res := make(map[string][]*SnmpDevice)
+	for _, dev := range devices {
+		ips, err := resolveDeviceAddress(dev.Config.Address)
+		if err != nil {
+			wbgo.Warn.Printf("can't resolve %s address for traps: %s", dev.DevName, err)
+			continue
+		}
+		for _, ip := range ips {
+			res[ip.String()] = append(res[ip.String()], dev)
+		}
+	}
+	return res
+}
+
+// Add SNMPv3 user of device to trap listener parameters
+func addTrapUser(params *gosnmp.GoSNMP, dev *SnmpDevice) error {
+	v3 := dev.Config.V3
+	usm := &gosnmp.UsmSecurityParameters{
+		UserName:                 v3.SecurityName,
+		AuthenticationProtocol:   v3.AuthProtocol,
+		AuthenticationPassphrase: v3.AuthPassphrase,
+		PrivacyProtocol:          v3.PrivProtocol,
+		PrivacyPassphrase:        v3.PrivPassphrase,
+	}
+	if err := params.TrapSecurityParametersTable.Add(v3.SecurityName, usm); err != nil {
+		return fmt.Errorf("can't add SNMPv3 user %s of %s: %s", v3.SecurityName, dev.DevName, err)
+	}
+	return nil
+}
+
+// Start listening for traps on given UDP address
+func (m *SnmpModel) startTrapListener(addr string) error {
+	m.trapDevices = mapTrapDevices(m.devices)
+
+	params := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+	if m.config.Debug {
+		params.Logger = gosnmp.NewLogger(log.New(os.Stderr, "[gosnmp] ", log.LstdFlags))
+	}
+
+	// SNMPv3 traps are authenticated with users of v3 devices
+	for _, dev := range m.devices {
+		if dev.Config.V3 == nil {
+			continue
+		}
+		if params.TrapSecurityParametersTable == nil {
+			// gosnmp checks v3 authentication only if listener is v3 one,
+			// v1 and v2c traps are still accepted
+			params.Version = gosnmp.Version3
+			params.TrapSecurityParametersTable = gosnmp.NewSnmpV3SecurityParametersTable(params.Logger)
+		}
+		if err := addTrapUser(params, dev); err != nil {
+			return err
+		}
+	}
+
+	m.trapListener = gosnmp.NewTrapListener()
+	m.trapListener.Params = params
+	m.trapListener.OnNewTrap = m.HandleTrap
+
+	// Listen blocks until listener is closed,
+	// so wait for it to start or fail
+	errChannel := make(chan error, 1)
+	go func(l *gosnmp.TrapListener) {
+		errChannel <- l.Listen(addr)
+	}(m.trapListener)
+
+	select {
+	case <-m.trapListener.Listening():
+		wbgo.Info.Printf("receiving traps on %s", addr)
+		return nil
+	case err := <-errChannel:
+		m.trapListener = nil
+		return err
+	}
+}
+
+// Stop trap receiver if it is running
+func (m *SnmpModel) stopTrapListener() {
+	if m.trapListener != nil {
+		m.trapListener.Close()
+		m.trapListener = nil
+	}
+}
+
+// Process incoming trap or inform
+// Values of varbinds matched to channel OIDs of source device
+// are sent to publisher, traps without matched varbinds are
+// published to last trap control if it is enabled
+func (m *SnmpModel) HandleTrap(packet *gosnmp.SnmpPacket, addr *net.UDPAddr) {
+	m.devicesMutex.RLock()
+	devs, ok := m.trapDevices[addr.IP.String()]
+	m.devicesMutex.RUnlock()
+	if !ok {
+		wbgo.Debug.Printf("[traps] Drop trap from unknown source %s", addr.IP)
+		return
+	}
+
+	trapOid := getTrapOid(packet)
+	wbgo.Debug.Printf("[traps] Receive trap %s from %s", trapOid, addr.IP)
+
+	for _, dev := range devs {
+		matched := false
+		msg := trapMessage{Source: addr.IP.String(), TrapOid: trapOid, Variables: make(map[string]string)}
+
+		for _, v := range packet.Variables {
+			oid := snmp_oid.Normalize(v.Name)
+			data, valid := ConvertSnmpValue(v)
+
+			for _, ch := range dev.channelsByOid(oid) {
+				// rate is computed from polled counters only
+				if ch.Rate != 0 {
+					continue
+				}
+				chData, chValid := ch.ConvertValue(v)
+				if !chValid {
+					wbgo.Warn.Printf("trap value for %s:%s can't be converted to string", dev.DevName, ch.Name)
+					continue
+				}
+				matched = true
+				if chData, e := ch.Convert(chData); e != nil {
+					wbgo.Warn.Printf("trap value for %s:%s: %s", dev.DevName, ch.Name, e)
+					m.errorChannel <- PollError{Channel: ch, Error: e.Error(), Trap: true}
+				} else {
+					m.resultChannel <- PollResult{Channel: ch, Data: chData, Trap: true}
+				}
+			}
+
+			if oid != sysUpTimeOid && oid != snmpTrapOidOid && valid {
+				msg.Variables[oid] = data
+			}
+		}
+
+		if matched || dev.trapChannel == nil {
+			continue
+		}
+
+		data, err := json.Marshal(msg)
+		if err != nil {
+			wbgo.Error.Printf("can't encode trap from %s: %s", addr.IP, err)
+			continue
+		}
+		m.resultChannel <- PollResult{Channel: dev.trapChannel, Data: string(data), Trap: true}
+	}
+}
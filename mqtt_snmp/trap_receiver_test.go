@@ -0,0 +1,288 @@
+package mqtt_snmp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+type TrapReceiverSuite struct {
+	testutils.Suite
+
+	config *DaemonConfig
+	model  *SnmpModel
+	addr   string
+}
+
+// Get free UDP port on localhost
+func (s *TrapReceiverSuite) freeUdpAddress() string {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.Ck("can't get free UDP port", err)
+	defer conn.Close()
+
+	return conn.LocalAddr().String()
+}
+
+func (s *TrapReceiverSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	fakeSNMPMessages = make(map[string]*gosnmp.SnmpPacket)
+
+	device := &DeviceConfig{
+		Name:            "Device 1",
+		Address:         "127.0.0.1",
+		Community:       "test",
+		Id:              "snmp_device1",
+		SnmpVersion:     gosnmp.Version3,
+		SnmpTimeout:     1,
+		LastTrapControl: true,
+		V3: &SnmpV3Config{
+			SecurityName:   "trapuser",
+			SecurityLevel:  gosnmp.AuthPriv,
+			AuthProtocol:   gosnmp.SHA,
+			AuthPassphrase: "authpassword",
+			PrivProtocol:   gosnmp.AES,
+			PrivPassphrase: "privpassword",
+		},
+		Channels: map[string]*ChannelConfig{
+			"status": &ChannelConfig{
+				Name:         "status",
+				Oid:          ".1.2.3.4",
+				ControlType:  "text",
+				Conv:         AsIs,
+				PollInterval: 1000,
+				Order:        1,
+			},
+			"voltage": &ChannelConfig{
+				Name:         "voltage",
+				Oid:          ".1.2.3.5",
+				ControlType:  "voltage",
+				Conv:         Scale(0.1),
+				PollInterval: 1000,
+				Order:        2,
+			},
+		},
+	}
+	for _, ch := range device.Channels {
+		ch.Device = device
+	}
+
+	s.config = &DaemonConfig{
+		NumWorkers: 1,
+		Devices:    map[string]*DeviceConfig{"snmp_device1": device},
+	}
+
+	var err error
+	s.model, err = NewSnmpModel(NewFakeSNMP, s.config, time.Now())
+	s.Ck("can't create model", err)
+	s.model.resultChannel = make(chan PollResult, CHAN_BUFFER_SIZE)
+
+	s.addr = s.freeUdpAddress()
+	s.Ck("can't start trap receiver", s.model.startTrapListener(s.addr))
+}
+
+func (s *TrapReceiverSuite) TearDownTest() {
+	s.model.stopTrapListener()
+	s.Suite.TearDownTest()
+}
+
+// Create trap sender to receiver
+// SNMPv3 sender is created if USM parameters are given
+func (s *TrapReceiverSuite) sender(version gosnmp.SnmpVersion, usm *gosnmp.UsmSecurityParameters) *gosnmp.GoSNMP {
+	host, port, err := net.SplitHostPort(s.addr)
+	s.Ck("wrong receiver address", err)
+	p, err := net.LookupPort("udp", port)
+	s.Ck("wrong receiver port", err)
+
+	g := &gosnmp.GoSNMP{
+		Target:    host,
+		Port:      uint16(p),
+		Community: "test",
+		Version:   version,
+		Timeout:   time.Second,
+		Retries:   1,
+	}
+	if usm != nil {
+		g.SecurityModel = gosnmp.UserSecurityModel
+		g.MsgFlags = gosnmp.AuthPriv
+		g.SecurityParameters = usm
+	}
+	s.Ck("can't connect to trap receiver", g.Connect())
+
+	return g
+}
+
+// Wait for given number of results from receiver
+func (s *TrapReceiverSuite) expectResults(expect []PollResult) {
+	for i := range expect {
+		select {
+		case res := <-s.model.resultChannel:
+			s.Equal(expect[i], res)
+		case <-time.After(time.Second):
+			s.Fail("no result from trap receiver", "%d results expected", len(expect))
+			return
+		}
+	}
+
+	select {
+	case res := <-s.model.resultChannel:
+		s.Fail("unexpected result from trap receiver", "%+v", res)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *TrapReceiverSuite) TestV2cTrap() {
+	device := s.config.Devices["snmp_device1"]
+
+	g := s.sender(gosnmp.Version2c, nil)
+	defer g.Conn.Close()
+
+	_, err := g.SendTrap(gosnmp.SnmpTrap{
+		Variables: []gosnmp.SnmpPDU{
+			{Name: snmpTrapOidOid, Type: gosnmp.ObjectIdentifier, Value: ".1.2.3.100.1"},
+			{Name: ".1.2.3.4", Type: gosnmp.OctetString, Value: "onBattery"},
+			{Name: ".1.2.3.5", Type: gosnmp.Integer, Value: 2205},
+		},
+	})
+	s.Ck("can't send trap", err)
+
+	s.expectResults([]PollResult{
+		{Channel: device.Channels["status"], Data: "onBattery", Trap: true},
+		{Channel: device.Channels["voltage"], Data: "220.5", Trap: true},
+	})
+
+	// unmatched trap is published to last trap control
+	_, err = g.SendTrap(gosnmp.SnmpTrap{
+		Variables: []gosnmp.SnmpPDU{
+			{Name: snmpTrapOidOid, Type: gosnmp.ObjectIdentifier, Value: ".1.2.3.100.2"},
+			{Name: ".1.2.3.10", Type: gosnmp.OctetString, Value: "selftest"},
+		},
+	})
+	s.Ck("can't send trap", err)
+
+	s.expectResults([]PollResult{
+		{
+			Channel: s.model.devices[0].trapChannel,
+			Data:    `{"source":"127.0.0.1","trap_oid":".1.2.3.100.2","variables":{".1.2.3.10":"selftest"}}`,
+			Trap:    true,
+		},
+	})
+}
+
+func (s *TrapReceiverSuite) TestInform() {
+	device := s.config.Devices["snmp_device1"]
+
+	g := s.sender(gosnmp.Version2c, nil)
+	defer g.Conn.Close()
+
+	// inform is acknowledged by receiver
+	_, err := g.SendTrap(gosnmp.SnmpTrap{
+		IsInform: true,
+		Variables: []gosnmp.SnmpPDU{
+			{Name: snmpTrapOidOid, Type: gosnmp.ObjectIdentifier, Value: ".1.2.3.100.1"},
+			{Name: ".1.2.3.4", Type: gosnmp.OctetString, Value: "online"},
+		},
+	})
+	s.Ck("inform is not acknowledged", err)
+
+	s.expectResults([]PollResult{
+		{Channel: device.Channels["status"], Data: "online", Trap: true},
+	})
+}
+
+func (s *TrapReceiverSuite) TestV1Trap() {
+	g := s.sender(gosnmp.Version1, nil)
+	defer g.Conn.Close()
+
+	_, err := g.SendTrap(gosnmp.SnmpTrap{
+		Enterprise:   ".1.3.6.1.4.1.318",
+		AgentAddress: "127.0.0.1",
+		GenericTrap:  6,
+		SpecificTrap: 5,
+		Variables: []gosnmp.SnmpPDU{
+			{Name: ".1.2.3.11", Type: gosnmp.OctetString, Value: "UPS: On battery power"},
+		},
+	})
+	s.Ck("can't send trap", err)
+
+	s.expectResults([]PollResult{
+		{
+			Channel: s.model.devices[0].trapChannel,
+			Data:    `{"source":"127.0.0.1","trap_oid":".1.3.6.1.4.1.318.0.5","variables":{".1.2.3.11":"UPS: On battery power"}}`,
+			Trap:    true,
+		},
+	})
+
+	// generic trap
+	_, err = g.SendTrap(gosnmp.SnmpTrap{
+		Enterprise:   ".1.3.6.1.4.1.318",
+		AgentAddress: "127.0.0.1",
+		GenericTrap:  0,
+		Variables:    []gosnmp.SnmpPDU{{Name: ".1.2.3.5", Type: gosnmp.Integer, Value: 2300}},
+	})
+	s.Ck("can't send trap", err)
+
+	s.expectResults([]PollResult{
+		{Channel: s.config.Devices["snmp_device1"].Channels["voltage"], Data: "230.0", Trap: true},
+	})
+}
+
+func (s *TrapReceiverSuite) TestV3Trap() {
+	device := s.config.Devices["snmp_device1"]
+
+	g := s.sender(gosnmp.Version3, &gosnmp.UsmSecurityParameters{
+		AuthoritativeEngineID:    fakeAgentEngineId,
+		AuthoritativeEngineBoots: 1,
+		AuthoritativeEngineTime:  1,
+		UserName:                 "trapuser",
+		AuthenticationProtocol:   gosnmp.SHA,
+		AuthenticationPassphrase: "authpassword",
+		PrivacyProtocol:          gosnmp.AES,
+		PrivacyPassphrase:        "privpassword",
+	})
+	defer g.Conn.Close()
+
+	_, err := g.SendTrap(gosnmp.SnmpTrap{
+		Variables: []gosnmp.SnmpPDU{
+			{Name: snmpTrapOidOid, Type: gosnmp.ObjectIdentifier, Value: ".1.2.3.100.1"},
+			{Name: ".1.2.3.4", Type: gosnmp.OctetString, Value: "onBattery"},
+		},
+	})
+	s.Ck("can't send trap", err)
+
+	s.expectResults([]PollResult{
+		{Channel: device.Channels["status"], Data: "onBattery", Trap: true},
+	})
+
+	// trap with wrong passphrase is dropped
+	g.SecurityParameters.(*gosnmp.UsmSecurityParameters).AuthenticationPassphrase = "wrongpassword"
+	_, err = g.SendTrap(gosnmp.SnmpTrap{
+		Variables: []gosnmp.SnmpPDU{
+			{Name: snmpTrapOidOid, Type: gosnmp.ObjectIdentifier, Value: ".1.2.3.100.1"},
+			{Name: ".1.2.3.4", Type: gosnmp.OctetString, Value: "online"},
+		},
+	})
+	s.Ck("can't send trap", err)
+
+	s.expectResults([]PollResult{})
+}
+
+func (s *TrapReceiverSuite) TestUnknownSource() {
+	packet := &gosnmp.SnmpPacket{
+		Version: gosnmp.Version2c,
+		PDUType: gosnmp.SNMPv2Trap,
+		Variables: []gosnmp.SnmpPDU{
+			{Name: ".1.2.3.4", Type: gosnmp.OctetString, Value: []byte("onBattery")},
+		},
+	}
+
+	s.model.HandleTrap(packet, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 162})
+	s.expectResults([]PollResult{})
+}
+
+func TestTrapReceiver(t *testing.T) {
+	testutils.RunSuites(t, new(TrapReceiverSuite))
+}
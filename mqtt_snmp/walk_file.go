@@ -0,0 +1,192 @@
+package mqtt_snmp
+
+// Walk file module
+// Reads output of net-snmp snmpwalk saved to file, so templates can
+// be made without access to device; numeric OIDs (snmpwalk -On) are
+// read as is, symbolic ones are translated by MIB
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/wirenboard/wb-mqtt-snmp/snmp_oid"
+)
+
+var (
+	// Variable line: "<oid> = <type>: <value>" or "<oid> = <value>"
+	walkLine = regexp.MustCompile(`^(\S+)\s+=\s*(?:([A-Za-z][A-Za-z0-9-]*):\s?)?(.*)$`)
+
+	// Number in value like "up(1)" or "230 Volts"
+	walkNumber = regexp.MustCompile(`-?[0-9]+`)
+
+	// Value types of snmpwalk output
+	walkTypes = map[string]gosnmp.Asn1BER{
+		"STRING":     gosnmp.OctetString,
+		"Hex-STRING": gosnmp.OctetString,
+		"BITS":       gosnmp.OctetString,
+		"INTEGER":    gosnmp.Integer,
+		"Gauge32":    gosnmp.Gauge32,
+		"Counter32":  gosnmp.Counter32,
+		"Counter64":  gosnmp.Counter64,
+		"Unsigned32": gosnmp.Uinteger32,
+		"UInteger32": gosnmp.Uinteger32,
+		"Timeticks":  gosnmp.TimeTicks,
+		"IpAddress":  gosnmp.IPAddress,
+		"OID":        gosnmp.ObjectIdentifier,
+	}
+)
+
+// Sort variables by OIDs
+func sortPdus(pdus []gosnmp.SnmpPDU) {
+	sort.SliceStable(pdus, func(i, j int) bool { return snmp_oid.Compare(pdus[i].Name, pdus[j].Name) < 0 })
+}
+
+// Parse hex bytes like "00 1A FF", parsing stops at first non-hex word
+func parseHexBytes(s string) []byte {
+	res := make([]byte, 0)
+	for _, word := range strings.Fields(s) {
+		if len(word) != 2 {
+			break
+		}
+		b, err := hex.DecodeString(word)
+		if err != nil {
+			break
+		}
+		res = append(res, b...)
+	}
+	return res
+}
+
+// Unquote string value, net-snmp escapes quotes and backslashes only
+func unquoteWalkString(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s[1 : len(s)-1])
+}
+
+// Make variable from type and value of snmpwalk output
+// ok is false for types which can't be read
+func parseWalkValue(oid, typ, value string) (pdu gosnmp.SnmpPDU, ok bool, err error) {
+	pdu.Name = oid
+	if typ == "" {
+		// empty string is shown without type
+		if value != `""` {
+			return pdu, false, nil
+		}
+		pdu.Type, pdu.Value = gosnmp.OctetString, []byte{}
+		return pdu, true, nil
+	}
+
+	pdu.Type, ok = walkTypes[typ]
+	if !ok {
+		return pdu, false, nil
+	}
+
+	switch typ {
+	case "STRING":
+		pdu.Value = []byte(unquoteWalkString(value))
+		return
+	case "Hex-STRING", "BITS":
+		pdu.Value = parseHexBytes(value)
+		return
+	case "IpAddress", "OID":
+		pdu.Value = value
+		return
+	}
+
+	// Timeticks: (12345) 0:02:03.45, INTEGER: up(1)
+	if i := strings.Index(value, "("); i >= 0 && (typ == "Timeticks" || typ == "INTEGER") {
+		value = value[i+1:]
+	}
+	number := walkNumber.FindString(value)
+	if number == "" {
+		return pdu, false, fmt.Errorf("no number in %s value %q of %s", typ, value, oid)
+	}
+
+	switch pdu.Type {
+	case gosnmp.Integer:
+		var n int64
+		n, err = strconv.ParseInt(number, 10, 32)
+		pdu.Value = int(n)
+	case gosnmp.Counter64:
+		pdu.Value, err = strconv.ParseUint(number, 10, 64)
+	case gosnmp.TimeTicks, gosnmp.Uinteger32:
+		var n uint64
+		n, err = strconv.ParseUint(number, 10, 32)
+		pdu.Value = uint32(n)
+	default:
+		var n uint64
+		n, err = strconv.ParseUint(number, 10, 32)
+		pdu.Value = uint(n)
+	}
+	if err != nil {
+		err = fmt.Errorf("bad %s value %q of %s: %s", typ, value, oid, err)
+	}
+	return
+}
+
+// Read variables from snmpwalk output
+// Variables of unsupported types (Opaque, missing instances) are skipped,
+// result is sorted by OIDs
+func ReadWalk(input io.Reader, mib *Mib) ([]gosnmp.SnmpPDU, error) {
+	type walkEntry struct {
+		line            int
+		oid, typ, value string
+	}
+
+	// values may span several lines
+	entries := make([]*walkEntry, 0)
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if m := walkLine.FindStringSubmatch(line); m != nil {
+			entries = append(entries, &walkEntry{n, m[1], m[2], m[3]})
+		} else if len(entries) > 0 {
+			last := entries[len(entries)-1]
+			if last.typ == "Hex-STRING" {
+				last.value += " " + line
+			} else {
+				last.value += "\n" + line
+			}
+		} else if strings.TrimSpace(line) != "" {
+			return nil, fmt.Errorf("line %d: variable is expected", n)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	pdus := make([]gosnmp.SnmpPDU, 0, len(entries))
+	for _, e := range entries {
+		name := e.oid
+		// OIDs are shown like this if there are no MIBs
+		if strings.HasPrefix(name, "iso.") {
+			name = ".1" + name[3:]
+		}
+		oid, err := mib.Translate(name)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: can't resolve OID %s: %s", e.line, e.oid, err)
+		}
+
+		pdu, ok, err := parseWalkValue(oid, e.typ, strings.TrimSpace(e.value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", e.line, err)
+		}
+		if ok {
+			pdus = append(pdus, pdu)
+		}
+	}
+
+	sortPdus(pdus)
+
+	return pdus, nil
+}
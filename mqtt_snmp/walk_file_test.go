@@ -0,0 +1,101 @@
+package mqtt_snmp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+// Write standard test MIB modules and extra ones to directory
+func writeTestMibs(s *testutils.Suite, dir string, extra map[string]string) {
+	mibs := map[string]string{
+		"SNMPv2-SMI.txt": testSnmpV2Smi,
+		"SNMPv2-TC.txt":  testSnmpV2Tc,
+		"SNMPv2-MIB.txt": testSnmpV2Mib,
+	}
+	for name, text := range extra {
+		mibs[name] = text
+	}
+	for name, text := range mibs {
+		s.Ck("can't write MIB", os.WriteFile(filepath.Join(dir, name), []byte(text), 0644))
+	}
+}
+
+type WalkFileSuite struct {
+	testutils.Suite
+
+	dir string
+	mib *Mib
+}
+
+func (s *WalkFileSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	var err error
+	s.dir, err = os.MkdirTemp("", "wb-mqtt-snmp-walk")
+	s.Ck("can't create temp dir", err)
+	writeTestMibs(&s.Suite, s.dir, nil)
+	s.mib = NewMib([]string{s.dir})
+}
+
+func (s *WalkFileSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+	s.Suite.TearDownTest()
+}
+
+func (s *WalkFileSuite) TestReadWalk() {
+	walk := `SNMPv2-MIB::sysDescr.0 = STRING: "Test \"device\"
+second line"
+SNMPv2-MIB::sysUpTime.0 = Timeticks: (12345) 0:02:03.45
+SNMPv2-MIB::sysName.0 = ""
+.1.3.6.1.2.1.2.2.1.6.2 = Hex-STRING: 00 11 22 33
+44 55
+.1.3.6.1.2.1.2.2.1.8.1 = INTEGER: up(1)
+.1.3.6.1.2.1.2.2.1.10.1 = Counter32: 4000000000
+.1.3.6.1.2.1.31.1.1.1.6.1 = Counter64: 18446744073709551615
+.1.3.6.1.2.1.2.2.1.5.1 = Gauge32: 1000000000
+.1.3.6.1.2.1.4.20.1.1.10.0.0.1 = IpAddress: 10.0.0.1
+iso.3.6.1.2.1.1.2.0 = OID: .1.3.6.1.4.1.8072.3.2.10
+.1.3.6.1.4.1.99999.1.0 = INTEGER: -215 tenths of degrees
+.1.3.6.1.4.1.99999.2.0 = No Such Object available on this agent at this OID
+.1.3.6.1.4.1.99999.3.0 = Opaque: Float: 1.5
+`
+	pdus, err := ReadWalk(strings.NewReader(walk), s.mib)
+	s.Ck("can't read walk", err)
+
+	s.Equal([]gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: gosnmp.OctetString, Value: []byte("Test \"device\"\nsecond line")},
+		{Name: ".1.3.6.1.2.1.1.2.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.8072.3.2.10"},
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(12345)},
+		{Name: ".1.3.6.1.2.1.1.5.0", Type: gosnmp.OctetString, Value: []byte{}},
+		{Name: ".1.3.6.1.2.1.2.2.1.5.1", Type: gosnmp.Gauge32, Value: uint(1000000000)},
+		{Name: ".1.3.6.1.2.1.2.2.1.6.2", Type: gosnmp.OctetString, Value: []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}},
+		{Name: ".1.3.6.1.2.1.2.2.1.8.1", Type: gosnmp.Integer, Value: 1},
+		{Name: ".1.3.6.1.2.1.2.2.1.10.1", Type: gosnmp.Counter32, Value: uint(4000000000)},
+		{Name: ".1.3.6.1.2.1.4.20.1.1.10.0.0.1", Type: gosnmp.IPAddress, Value: "10.0.0.1"},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.1", Type: gosnmp.Counter64, Value: uint64(18446744073709551615)},
+		{Name: ".1.3.6.1.4.1.99999.1.0", Type: gosnmp.Integer, Value: -215},
+	}, pdus)
+}
+
+func (s *WalkFileSuite) TestReadWalkErrors() {
+	for walk, message := range map[string]string{
+		"garbage\n":                                   "line 1: variable is expected",
+		"NO-SUCH-MIB::foo.0 = INTEGER: 1\n":           "line 1: can't resolve OID NO-SUCH-MIB::foo.0",
+		".1.3.6.1.2.1.1.3.0 = INTEGER: foo\n":         "line 1: no number in INTEGER value",
+		".1.3.6.1.2.1.1.3.0 = INTEGER: 99999999999\n": "line 1: bad INTEGER value",
+	} {
+		_, err := ReadWalk(strings.NewReader(walk), s.mib)
+		if s.Error(err, "%q is read", walk) {
+			s.Contains(err.Error(), message)
+		}
+	}
+}
+
+func TestWalkFile(t *testing.T) {
+	testutils.RunSuites(t, new(WalkFileSuite))
+}
@@ -0,0 +1,33 @@
+package snmp_oid
+
+// Numeric OID helpers shared by driver and simulator
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Make sure OID starts with dot like OIDs in config
+func Normalize(oid string) string {
+	if strings.HasPrefix(oid, ".") {
+		return oid
+	}
+	return "." + oid
+}
+
+// Compare numeric OIDs component by component
+func Compare(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "."), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "."), ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, _ := strconv.ParseUint(pa[i], 10, 64)
+		nb, _ := strconv.ParseUint(pb[i], 10, 64)
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(pa) - len(pb)
+}
@@ -0,0 +1,27 @@
+package snmp_oid
+
+import (
+	"testing"
+
+	"github.com/contactless/wbgo/testutils"
+)
+
+type OidSuite struct {
+	testutils.Suite
+}
+
+func (s *OidSuite) TestNormalize() {
+	s.Equal(".1.3.6.1", Normalize("1.3.6.1"))
+	s.Equal(".1.3.6.1", Normalize(".1.3.6.1"))
+}
+
+func (s *OidSuite) TestCompare() {
+	s.Negative(Compare(".1.3.6.1.2", ".1.3.6.1.10"))
+	s.Positive(Compare(".1.3.6.1.10", ".1.3.6.1.2"))
+	s.Negative(Compare(".1.3.6", ".1.3.6.1"))
+	s.Zero(Compare(".1.3.6.1", "1.3.6.1"))
+}
+
+func TestOid(t *testing.T) {
+	testutils.RunSuites(t, new(OidSuite))
+}
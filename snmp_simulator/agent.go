@@ -0,0 +1,475 @@
+package snmp_simulator
+
+// SNMP agent simulator
+// Answers SNMPv1/v2c requests over UDP with values loaded from
+// snmprec or walk files; responses of subtrees may be delayed,
+// failed with error status or dropped to mimic misbehaving devices
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contactless/wbgo"
+	"github.com/gosnmp/gosnmp"
+	"github.com/wirenboard/wb-mqtt-snmp/snmp_oid"
+)
+
+const (
+	// Max size of SNMP datagram
+	maxPacketSize = 65535
+)
+
+// Behavior of agent for requests of subtree
+type Behavior struct {
+	// Delay of responses
+	Delay time.Duration
+
+	// Error status of responses, NoError for normal ones
+	Error gosnmp.SNMPError
+
+	// Requests are not answered at all
+	Drop bool
+}
+
+// Simulated SNMP agent
+type Agent struct {
+	// Community of requests, requests of other communities are dropped;
+	// any community is accepted if it's empty
+	// Must be set before Listen call
+	Community string
+
+	mutex sync.Mutex
+
+	// Max number of variables in response
+	maxVarbinds int
+
+	// Variables by normalized OIDs and their OIDs in order
+	values map[string]gosnmp.SnmpPDU
+	oids   []string
+
+	// Behaviors by normalized subtree OIDs
+	behaviors map[string]Behavior
+
+	// Number of requests received
+	requests int
+
+	conn net.PacketConn
+
+	// Closed when agent is closed
+	done   chan struct{}
+	closed bool
+
+	// Goroutines serving requests
+	handled sync.WaitGroup
+}
+
+// Create agent serving variables, it answers after Listen call
+func NewAgent(pdus []gosnmp.SnmpPDU) *Agent {
+	a := &Agent{
+		values:    make(map[string]gosnmp.SnmpPDU, len(pdus)),
+		behaviors: make(map[string]Behavior),
+		done:      make(chan struct{}),
+	}
+	for _, pdu := range pdus {
+		a.setLocked(pdu)
+	}
+	return a
+}
+
+// Check if OID is in subtree
+func inSubtree(oid, subtree string) bool {
+	return oid == subtree || strings.HasPrefix(oid, subtree+".")
+}
+
+// Set variable, it's created if it doesn't exist
+func (a *Agent) Set(pdu gosnmp.SnmpPDU) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.setLocked(pdu)
+}
+
+// Must be called with mutex locked or before agent is started
+func (a *Agent) setLocked(pdu gosnmp.SnmpPDU) {
+	pdu.Name = snmp_oid.Normalize(pdu.Name)
+	if _, ok := a.values[pdu.Name]; !ok {
+		i := sort.Search(len(a.oids), func(i int) bool { return snmp_oid.Compare(a.oids[i], pdu.Name) >= 0 })
+		a.oids = append(a.oids, "")
+		copy(a.oids[i+1:], a.oids[i:])
+		a.oids[i] = pdu.Name
+	}
+	a.values[pdu.Name] = pdu
+}
+
+// Remove variable
+func (a *Agent) Remove(oid string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	oid = snmp_oid.Normalize(oid)
+	if _, ok := a.values[oid]; !ok {
+		return
+	}
+	delete(a.values, oid)
+	i := sort.Search(len(a.oids), func(i int) bool { return snmp_oid.Compare(a.oids[i], oid) >= 0 })
+	a.oids = append(a.oids[:i], a.oids[i+1:]...)
+}
+
+// Get current variable
+func (a *Agent) Get(oid string) (pdu gosnmp.SnmpPDU, ok bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	pdu, ok = a.values[snmp_oid.Normalize(oid)]
+	return
+}
+
+// Set behavior for requests of subtree, zero behavior restores normal responses
+// If variables of request are in several subtrees, the longest delay is used
+// and request is dropped or failed if any of them says so
+func (a *Agent) SetBehavior(subtree string, b Behavior) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	subtree = snmp_oid.Normalize(subtree)
+	if b == (Behavior{}) {
+		delete(a.behaviors, subtree)
+	} else {
+		a.behaviors[subtree] = b
+	}
+}
+
+// Get behavior of subtree
+func (a *Agent) Behavior(subtree string) Behavior {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.behaviors[snmp_oid.Normalize(subtree)]
+}
+
+// Set max number of variables in response, requests of more
+// variables are failed with tooBig, GETBULK responses are truncated;
+// 0 for unlimited
+func (a *Agent) SetMaxVarbinds(n int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.maxVarbinds = n
+}
+
+// Get number of requests received, including dropped ones
+func (a *Agent) Requests() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.requests
+}
+
+// Start serving requests on UDP address like "127.0.0.1:1161",
+// port 0 selects free one
+func (a *Agent) Listen(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	a.conn = conn
+	a.mutex.Unlock()
+
+	a.handled.Add(1)
+	go a.serve(conn)
+	return nil
+}
+
+// Get address agent is listening on
+func (a *Agent) Addr() string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.conn == nil {
+		return ""
+	}
+	return a.conn.LocalAddr().String()
+}
+
+// Stop serving requests and scripts
+// Delayed responses are not sent
+func (a *Agent) Close() {
+	a.mutex.Lock()
+	conn, closed := a.conn, a.closed
+	a.conn, a.closed = nil, true
+	a.mutex.Unlock()
+
+	if closed {
+		return
+	}
+	close(a.done)
+	if conn != nil {
+		conn.Close()
+		a.handled.Wait()
+	}
+}
+
+// Receive requests until connection is closed
+func (a *Agent) serve(conn net.PacketConn) {
+	defer a.handled.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				wbgo.Error.Printf("simulator: can't receive request: %s", err)
+			}
+			return
+		}
+
+		req, err := (&gosnmp.GoSNMP{}).SnmpDecodePacket(append([]byte(nil), buf[:n]...))
+		if err != nil {
+			wbgo.Debug.Printf("simulator: bad request from %s: %s", addr, err)
+			continue
+		}
+
+		a.handled.Add(1)
+		go a.answer(conn, addr, req)
+	}
+}
+
+// Answer request after delay of its behavior
+func (a *Agent) answer(conn net.PacketConn, addr net.Addr, req *gosnmp.SnmpPacket) {
+	defer a.handled.Done()
+
+	resp, delay := a.Handle(req)
+	if resp == nil {
+		return
+	}
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-a.done:
+			return
+		}
+	}
+
+	data, err := resp.MarshalMsg()
+	if err != nil {
+		wbgo.Error.Printf("simulator: can't encode response to %s: %s", addr, err)
+		return
+	}
+	if _, err := conn.WriteTo(data, addr); err != nil {
+		wbgo.Debug.Printf("simulator: can't send response to %s: %s", addr, err)
+	}
+}
+
+// Make response to request and its delay, nil response means
+// request is dropped
+func (a *Agent) Handle(req *gosnmp.SnmpPacket) (resp *gosnmp.SnmpPacket, delay time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.requests++
+
+	if req.Version == gosnmp.Version3 {
+		wbgo.Debug.Printf("simulator: SNMPv3 request is dropped")
+		return nil, 0
+	}
+	if a.Community != "" && req.Community != a.Community {
+		wbgo.Debug.Printf("simulator: request of community %q is dropped", req.Community)
+		return nil, 0
+	}
+
+	resp = &gosnmp.SnmpPacket{
+		Version:   req.Version,
+		Community: req.Community,
+		PDUType:   gosnmp.GetResponse,
+		RequestID: req.RequestID,
+	}
+
+	// behaviors are applied before request is processed,
+	// so failed SET doesn't change anything
+	for i, v := range req.Variables {
+		oid := snmp_oid.Normalize(v.Name)
+		for subtree, b := range a.behaviors {
+			if !inSubtree(oid, subtree) {
+				continue
+			}
+			if b.Drop {
+				return nil, 0
+			}
+			if b.Delay > delay {
+				delay = b.Delay
+			}
+			if b.Error != gosnmp.NoError && resp.Error == gosnmp.NoError {
+				resp.Error, resp.ErrorIndex = b.Error, uint8(i+1)
+			}
+		}
+	}
+	if resp.Error != gosnmp.NoError {
+		resp.Variables = req.Variables
+		return resp, delay
+	}
+
+	if a.maxVarbinds > 0 && req.PDUType != gosnmp.GetBulkRequest && len(req.Variables) > a.maxVarbinds {
+		return a.fail(resp, req, gosnmp.TooBig, 0), delay
+	}
+
+	switch req.PDUType {
+	case gosnmp.GetRequest:
+		a.get(resp, req)
+	case gosnmp.GetNextRequest:
+		a.getNext(resp, req)
+	case gosnmp.GetBulkRequest:
+		a.getBulk(resp, req)
+	case gosnmp.SetRequest:
+		a.set(resp, req)
+	default:
+		wbgo.Debug.Printf("simulator: request of type %s is dropped", req.PDUType)
+		return nil, 0
+	}
+
+	return resp, delay
+}
+
+// Fail response with error status, request variables are sent back
+func (a *Agent) fail(resp, req *gosnmp.SnmpPacket, status gosnmp.SNMPError, index int) *gosnmp.SnmpPacket {
+	resp.Error, resp.ErrorIndex = status, uint8(index)
+	resp.Variables = req.Variables
+	return resp
+}
+
+// Find index of first OID after given one
+func (a *Agent) nextIndex(oid string) int {
+	return sort.Search(len(a.oids), func(i int) bool { return snmp_oid.Compare(a.oids[i], oid) > 0 })
+}
+
+// Check if there are variables of object, i.e. OID without last component
+// has instances
+func (a *Agent) hasObject(oid string) bool {
+	i := strings.LastIndex(oid, ".")
+	if i <= 0 {
+		return false
+	}
+	object := oid[:i]
+	next := a.nextIndex(object)
+	return next < len(a.oids) && inSubtree(a.oids[next], object)
+}
+
+func (a *Agent) get(resp, req *gosnmp.SnmpPacket) {
+	for i, v := range req.Variables {
+		oid := snmp_oid.Normalize(v.Name)
+		if pdu, ok := a.values[oid]; ok {
+			resp.Variables = append(resp.Variables, pdu)
+			continue
+		}
+
+		if req.Version == gosnmp.Version1 {
+			a.fail(resp, req, gosnmp.NoSuchName, i+1)
+			return
+		}
+		t := gosnmp.NoSuchObject
+		if a.hasObject(oid) {
+			t = gosnmp.NoSuchInstance
+		}
+		resp.Variables = append(resp.Variables, gosnmp.SnmpPDU{Name: oid, Type: t})
+	}
+}
+
+// Get variable after OID, ok is false at the end of MIB view
+func (a *Agent) next(oid string) (pdu gosnmp.SnmpPDU, ok bool) {
+	i := a.nextIndex(snmp_oid.Normalize(oid))
+	if i >= len(a.oids) {
+		return gosnmp.SnmpPDU{Name: snmp_oid.Normalize(oid), Type: gosnmp.EndOfMibView}, false
+	}
+	return a.values[a.oids[i]], true
+}
+
+func (a *Agent) getNext(resp, req *gosnmp.SnmpPacket) {
+	for i, v := range req.Variables {
+		pdu, ok := a.next(v.Name)
+		if !ok && req.Version == gosnmp.Version1 {
+			a.fail(resp, req, gosnmp.NoSuchName, i+1)
+			return
+		}
+		resp.Variables = append(resp.Variables, pdu)
+	}
+}
+
+// Process GETBULK as RFC 3416 says: non-repeaters are answered
+// once, repeaters are answered row by row until all of them
+// reach end of MIB view
+func (a *Agent) getBulk(resp, req *gosnmp.SnmpPacket) {
+	nonRepeaters := int(req.NonRepeaters)
+	if nonRepeaters > len(req.Variables) {
+		nonRepeaters = len(req.Variables)
+	}
+
+	for _, v := range req.Variables[:nonRepeaters] {
+		pdu, _ := a.next(v.Name)
+		resp.Variables = append(resp.Variables, pdu)
+	}
+
+	last := make([]string, 0, len(req.Variables)-nonRepeaters)
+	for _, v := range req.Variables[nonRepeaters:] {
+		last = append(last, v.Name)
+	}
+
+	for r := 0; r < int(req.MaxRepetitions) && len(last) > 0; r++ {
+		ended := true
+		for j, oid := range last {
+			pdu, ok := a.next(oid)
+			resp.Variables = append(resp.Variables, pdu)
+			last[j] = pdu.Name
+			ended = ended && !ok
+		}
+		if ended {
+			break
+		}
+	}
+
+	if a.maxVarbinds > 0 && len(resp.Variables) > a.maxVarbinds {
+		resp.Variables = resp.Variables[:a.maxVarbinds]
+	}
+}
+
+// Set variables if all of them exist and have the same types
+func (a *Agent) set(resp, req *gosnmp.SnmpPacket) {
+	for i, v := range req.Variables {
+		pdu, ok := a.values[snmp_oid.Normalize(v.Name)]
+		switch {
+		case !ok && req.Version == gosnmp.Version1:
+			a.fail(resp, req, gosnmp.NoSuchName, i+1)
+			return
+		case !ok:
+			a.fail(resp, req, gosnmp.NoCreation, i+1)
+			return
+		case pdu.Type != v.Type && req.Version == gosnmp.Version1:
+			a.fail(resp, req, gosnmp.BadValue, i+1)
+			return
+		case pdu.Type != v.Type:
+			a.fail(resp, req, gosnmp.WrongType, i+1)
+			return
+		}
+	}
+
+	for _, v := range req.Variables {
+		pdu := gosnmp.SnmpPDU{Name: snmp_oid.Normalize(v.Name), Type: v.Type, Value: v.Value}
+		wbgo.Debug.Printf("simulator: set %s = %v", pdu.Name, pdu.Value)
+		a.setLocked(pdu)
+		resp.Variables = append(resp.Variables, pdu)
+	}
+}
+
+// Parse error status name like "genErr", case is ignored;
+// "none" is NoError
+func ParseErrorStatus(name string) (gosnmp.SNMPError, error) {
+	if strings.EqualFold(name, "none") {
+		return gosnmp.NoError, nil
+	}
+	for s := gosnmp.NoError; s <= gosnmp.InconsistentName; s++ {
+		if strings.EqualFold(s.String(), name) {
+			return s, nil
+		}
+	}
+	return gosnmp.NoError, fmt.Errorf("unknown error status: %s", name)
+}
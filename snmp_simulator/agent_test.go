@@ -0,0 +1,178 @@
+package snmp_simulator
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+type AgentSuite struct {
+	testutils.Suite
+
+	agent *Agent
+}
+
+func (s *AgentSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	s.agent = NewAgent([]gosnmp.SnmpPDU{
+		{Name: ".1.2.3.1.0", Type: gosnmp.Integer, Value: 215},
+		{Name: ".1.2.3.2.0", Type: gosnmp.OctetString, Value: []byte("ups")},
+		{Name: ".1.2.3.10.0", Type: gosnmp.Counter64, Value: uint64(1) << 40},
+		{Name: "1.2.4.1.1", Type: gosnmp.Gauge32, Value: uint32(10)},
+		{Name: "1.2.4.1.2", Type: gosnmp.Gauge32, Value: uint32(20)},
+		{Name: "1.2.4.1.3", Type: gosnmp.Gauge32, Value: uint32(30)},
+	})
+	s.agent.Community = "public"
+	s.Ck("can't start agent", s.agent.Listen("127.0.0.1:0"))
+}
+
+func (s *AgentSuite) TearDownTest() {
+	s.agent.Close()
+	s.Suite.TearDownTest()
+}
+
+// Connect client to agent
+func (s *AgentSuite) client(version gosnmp.SnmpVersion, community string) *gosnmp.GoSNMP {
+	host, port, err := net.SplitHostPort(s.agent.Addr())
+	s.Ck("bad agent address", err)
+	p, _ := strconv.Atoi(port)
+
+	g := &gosnmp.GoSNMP{
+		Target:    host,
+		Port:      uint16(p),
+		Community: community,
+		Version:   version,
+		Timeout:   300 * time.Millisecond,
+		MaxOids:   gosnmp.MaxOids,
+	}
+	s.Ck("can't connect to agent", g.Connect())
+	return g
+}
+
+func (s *AgentSuite) TestGet() {
+	g := s.client(gosnmp.Version2c, "public")
+	defer g.Conn.Close()
+
+	packet, err := g.Get([]string{".1.2.3.1.0", ".1.2.3.2.0", ".1.2.3.10.0", ".1.2.3.1.1", ".1.2.9.0"})
+	s.Ck("get failed", err)
+	s.Equal(gosnmp.NoError, packet.Error)
+	s.Equal([]gosnmp.SnmpPDU{
+		{Name: ".1.2.3.1.0", Type: gosnmp.Integer, Value: 215},
+		{Name: ".1.2.3.2.0", Type: gosnmp.OctetString, Value: []byte("ups")},
+		{Name: ".1.2.3.10.0", Type: gosnmp.Counter64, Value: uint64(1) << 40},
+		{Name: ".1.2.3.1.1", Type: gosnmp.NoSuchInstance},
+		{Name: ".1.2.9.0", Type: gosnmp.NoSuchObject},
+	}, packet.Variables)
+}
+
+func (s *AgentSuite) TestGetV1() {
+	g := s.client(gosnmp.Version1, "public")
+	defer g.Conn.Close()
+
+	packet, err := g.Get([]string{".1.2.3.1.0", ".1.2.9.0"})
+	s.Ck("get failed", err)
+	s.Equal(gosnmp.NoSuchName, packet.Error)
+	s.Equal(uint8(2), packet.ErrorIndex)
+
+	pdus, err := g.WalkAll(".1.2.4")
+	s.Ck("walk failed", err)
+	s.Len(pdus, 3)
+}
+
+func (s *AgentSuite) TestWalk() {
+	g := s.client(gosnmp.Version2c, "public")
+	defer g.Conn.Close()
+
+	pdus, err := g.BulkWalkAll(".1.2.4")
+	s.Ck("walk failed", err)
+	s.Equal([]gosnmp.SnmpPDU{
+		{Name: ".1.2.4.1.1", Type: gosnmp.Gauge32, Value: uint(10)},
+		{Name: ".1.2.4.1.2", Type: gosnmp.Gauge32, Value: uint(20)},
+		{Name: ".1.2.4.1.3", Type: gosnmp.Gauge32, Value: uint(30)},
+	}, pdus)
+
+	packet, err := g.GetBulk([]string{".1.2.3.1.0", ".1.2.4.1.2"}, 1, 3)
+	s.Ck("getbulk failed", err)
+	s.Equal([]gosnmp.SnmpPDU{
+		{Name: ".1.2.3.2.0", Type: gosnmp.OctetString, Value: []byte("ups")},
+		{Name: ".1.2.4.1.3", Type: gosnmp.Gauge32, Value: uint(30)},
+		{Name: ".1.2.4.1.3", Type: gosnmp.EndOfMibView},
+	}, packet.Variables)
+}
+
+func (s *AgentSuite) TestSet() {
+	g := s.client(gosnmp.Version2c, "public")
+	defer g.Conn.Close()
+
+	packet, err := g.Set([]gosnmp.SnmpPDU{{Name: ".1.2.3.1.0", Type: gosnmp.Integer, Value: 230}})
+	s.Ck("set failed", err)
+	s.Equal(gosnmp.NoError, packet.Error)
+	pdu, _ := s.agent.Get("1.2.3.1.0")
+	s.Equal(230, pdu.Value)
+
+	packet, err = g.Set([]gosnmp.SnmpPDU{{Name: ".1.2.3.2.0", Type: gosnmp.Integer, Value: 1}})
+	s.Ck("set failed", err)
+	s.Equal(gosnmp.WrongType, packet.Error)
+
+	packet, err = g.Set([]gosnmp.SnmpPDU{{Name: ".1.2.9.0", Type: gosnmp.Integer, Value: 1}})
+	s.Ck("set failed", err)
+	s.Equal(gosnmp.NoCreation, packet.Error)
+}
+
+func (s *AgentSuite) TestBehaviors() {
+	g := s.client(gosnmp.Version2c, "public")
+	defer g.Conn.Close()
+
+	s.agent.SetBehavior("1.2.3.2", Behavior{Error: gosnmp.GenErr})
+	packet, err := g.Get([]string{".1.2.3.1.0", ".1.2.3.2.0"})
+	s.Ck("get failed", err)
+	s.Equal(gosnmp.GenErr, packet.Error)
+	s.Equal(uint8(2), packet.ErrorIndex)
+
+	s.agent.SetBehavior("1.2.3.2", Behavior{Delay: 100 * time.Millisecond})
+	start := time.Now()
+	_, err = g.Get([]string{".1.2.3.2.0"})
+	s.Ck("get failed", err)
+	s.True(time.Since(start) >= 100*time.Millisecond, "response isn't delayed")
+
+	s.agent.SetBehavior(".1.2", Behavior{Drop: true})
+	_, err = g.Get([]string{".1.2.3.1.0"})
+	s.Error(err, "dropped request is answered")
+
+	s.agent.SetBehavior(".1.2", Behavior{})
+	s.agent.SetBehavior("1.2.3.2", Behavior{})
+	_, err = g.Get([]string{".1.2.3.2.0"})
+	s.Ck("get failed", err)
+}
+
+func (s *AgentSuite) TestMaxVarbinds() {
+	g := s.client(gosnmp.Version2c, "public")
+	defer g.Conn.Close()
+
+	s.agent.SetMaxVarbinds(1)
+	packet, err := g.Get([]string{".1.2.3.1.0", ".1.2.3.2.0"})
+	s.Ck("get failed", err)
+	s.Equal(gosnmp.TooBig, packet.Error)
+
+	packet, err = g.GetBulk([]string{".1.2.4"}, 0, 10)
+	s.Ck("getbulk failed", err)
+	s.Len(packet.Variables, 1)
+}
+
+func (s *AgentSuite) TestCommunity() {
+	g := s.client(gosnmp.Version2c, "private")
+	defer g.Conn.Close()
+
+	_, err := g.Get([]string{".1.2.3.1.0"})
+	s.Error(err, "request of wrong community is answered")
+	s.Equal(1, s.agent.Requests())
+}
+
+func TestAgent(t *testing.T) {
+	testutils.RunSuites(t, new(AgentSuite))
+}
@@ -0,0 +1,158 @@
+package snmp_simulator
+
+// Script module
+// Script changes agent in time, one step per line:
+//
+//	<time> set <oid>|<tag>|<value>
+//	<time> remove <oid>
+//	<time> delay <subtree> <duration>
+//	<time> error <subtree> <status|none>
+//	<time> drop <subtree> on|off
+//
+// Time is Go duration since script start like "90s" or "1m30s",
+// empty lines and lines starting with "#" are skipped
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/contactless/wbgo"
+	"github.com/wirenboard/wb-mqtt-snmp/snmp_oid"
+)
+
+// Step of script
+type ScriptStep struct {
+	// Time since script start
+	At time.Duration
+
+	// Source line, for logs
+	Line string
+
+	apply func(a *Agent)
+}
+
+// Script of agent changes, steps are sorted by time
+type Script []ScriptStep
+
+// Parse action of script step
+func parseScriptAction(action string, args []string) (func(a *Agent), error) {
+	argsCount := map[string]int{"set": 1, "remove": 1, "delay": 2, "error": 2, "drop": 2}
+	n, ok := argsCount[action]
+	if !ok {
+		return nil, fmt.Errorf("unknown action %q", action)
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("%s takes %d arguments", action, n)
+	}
+
+	switch action {
+	case "set":
+		pdu, err := ParseSnmprecLine(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return func(a *Agent) { a.Set(pdu) }, nil
+
+	case "remove":
+		oid := args[0]
+		return func(a *Agent) { a.Remove(oid) }, nil
+
+	case "delay":
+		subtree := args[0]
+		d, err := time.ParseDuration(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return func(a *Agent) { a.updateBehavior(subtree, func(b *Behavior) { b.Delay = d }) }, nil
+
+	case "error":
+		subtree := args[0]
+		status, err := ParseErrorStatus(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return func(a *Agent) { a.updateBehavior(subtree, func(b *Behavior) { b.Error = status }) }, nil
+
+	default:
+		subtree := args[0]
+		if args[1] != "on" && args[1] != "off" {
+			return nil, fmt.Errorf("\"on\" or \"off\" is expected instead of %q", args[1])
+		}
+		drop := args[1] == "on"
+		return func(a *Agent) { a.updateBehavior(subtree, func(b *Behavior) { b.Drop = drop }) }, nil
+	}
+}
+
+// Read script, steps of the same time are kept in order of lines
+func ParseScript(input io.Reader) (Script, error) {
+	script := make(Script, 0)
+
+	scanner := bufio.NewScanner(input)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: \"<time> <action> <args>\" is expected", n)
+		}
+
+		at, err := time.ParseDuration(fields[0])
+		if err != nil || at < 0 {
+			return nil, fmt.Errorf("line %d: bad time %q", n, fields[0])
+		}
+
+		apply, err := parseScriptAction(fields[1], fields[2:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+
+		script = append(script, ScriptStep{At: at, Line: line, apply: apply})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(script, func(i, j int) bool { return script[i].At < script[j].At })
+
+	return script, nil
+}
+
+// Change behavior of subtree
+func (a *Agent) updateBehavior(subtree string, update func(b *Behavior)) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	subtree = snmp_oid.Normalize(subtree)
+	b := a.behaviors[subtree]
+	update(&b)
+	if b == (Behavior{}) {
+		delete(a.behaviors, subtree)
+	} else {
+		a.behaviors[subtree] = b
+	}
+}
+
+// Apply script steps in background starting from now,
+// script is stopped when agent is closed
+func (a *Agent) RunScript(script Script) {
+	start := time.Now()
+
+	go func() {
+		for _, step := range script {
+			select {
+			case <-time.After(time.Until(start.Add(step.At))):
+			case <-a.done:
+				return
+			}
+			wbgo.Debug.Printf("simulator: %s", step.Line)
+			step.apply(a)
+		}
+	}()
+}
@@ -0,0 +1,105 @@
+package snmp_simulator
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+type ScriptSuite struct {
+	testutils.Suite
+}
+
+func (s *ScriptSuite) TestParse() {
+	script, err := ParseScript(strings.NewReader(`# battery discharge
+1m set 1.2.3.1.0|2|50
+0s delay 1.2.3 150ms
+10s error 1.2.3.2 genErr
+1m remove 1.2.3.2.0
+1m30s drop 1.2 on
+`))
+	s.Ck("can't parse script", err)
+
+	at := make([]time.Duration, len(script))
+	lines := make([]string, len(script))
+	for i, step := range script {
+		at[i], lines[i] = step.At, step.Line
+	}
+	s.Equal([]time.Duration{0, 10 * time.Second, time.Minute, time.Minute, 90 * time.Second}, at)
+	s.Equal("1m set 1.2.3.1.0|2|50", lines[2])
+	s.Equal("1m remove 1.2.3.2.0", lines[3])
+
+	a := NewAgent([]gosnmp.SnmpPDU{
+		{Name: ".1.2.3.1.0", Type: gosnmp.Integer, Value: 100},
+		{Name: ".1.2.3.2.0", Type: gosnmp.Integer, Value: 1},
+	})
+	for _, step := range script {
+		step.apply(a)
+	}
+
+	pdu, _ := a.Get(".1.2.3.1.0")
+	s.Equal(50, pdu.Value)
+	_, ok := a.Get(".1.2.3.2.0")
+	s.False(ok)
+	s.Equal(Behavior{Delay: 150 * time.Millisecond}, a.Behavior("1.2.3"))
+	s.Equal(Behavior{Error: gosnmp.GenErr}, a.Behavior(".1.2.3.2"))
+	s.Equal(Behavior{Drop: true}, a.Behavior(".1.2"))
+}
+
+func (s *ScriptSuite) TestParseErrors() {
+	for _, line := range []string{
+		"set 1.2.3|2|1",
+		"1s",
+		"-1s set 1.2.3|2|1",
+		"1s jump 1.2.3",
+		"1s set 1.2.3|2|x",
+		"1s delay 1.2.3",
+		"1s delay 1.2.3 soon",
+		"1s error 1.2.3 bad",
+		"1s drop 1.2.3 yes",
+	} {
+		_, err := ParseScript(strings.NewReader(line))
+		s.Error(err, line)
+	}
+}
+
+func (s *ScriptSuite) TestRun() {
+	a := NewAgent([]gosnmp.SnmpPDU{{Name: ".1.2.3.1.0", Type: gosnmp.Integer, Value: 100}})
+	defer a.Close()
+
+	script, err := ParseScript(strings.NewReader(`
+0s set 1.2.3.1.0|2|90
+50ms error 1.2.3 genErr
+100ms error 1.2.3 none
+1h set 1.2.3.1.0|2|0
+`))
+	s.Ck("can't parse script", err)
+	a.RunScript(script)
+
+	s.Eventually(func() bool {
+		pdu, _ := a.Get(".1.2.3.1.0")
+		return pdu.Value == 90
+	}, time.Second, 10*time.Millisecond)
+	s.Eventually(func() bool { return a.Behavior(".1.2.3").Error == gosnmp.GenErr }, time.Second, 10*time.Millisecond)
+	s.Eventually(func() bool { return a.Behavior(".1.2.3") == Behavior{} }, time.Second, 10*time.Millisecond)
+}
+
+func (s *ScriptSuite) TestErrorStatus() {
+	status, err := ParseErrorStatus("noSuchName")
+	s.Ck("can't parse status", err)
+	s.Equal(gosnmp.NoSuchName, status)
+
+	status, err = ParseErrorStatus("none")
+	s.Ck("can't parse status", err)
+	s.Equal(gosnmp.NoError, status)
+
+	_, err = ParseErrorStatus("oops")
+	s.Error(err)
+}
+
+func TestScript(t *testing.T) {
+	testutils.RunSuites(t, new(ScriptSuite))
+}
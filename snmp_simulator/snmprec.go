@@ -0,0 +1,125 @@
+package snmp_simulator
+
+// Snmprec file module
+// Reads variables in snmprec format of snmpsim: "<oid>|<tag>|<value>"
+// per line, tag is BER type number, "x" after it means value is in hex
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/wirenboard/wb-mqtt-snmp/snmp_oid"
+)
+
+// Types of snmprec tags
+var snmprecTypes = map[int]gosnmp.Asn1BER{
+	2:  gosnmp.Integer,
+	4:  gosnmp.OctetString,
+	5:  gosnmp.Null,
+	6:  gosnmp.ObjectIdentifier,
+	64: gosnmp.IPAddress,
+	65: gosnmp.Counter32,
+	66: gosnmp.Gauge32,
+	67: gosnmp.TimeTicks,
+	68: gosnmp.Opaque,
+	70: gosnmp.Counter64,
+}
+
+// Parse variable from snmprec line
+func ParseSnmprecLine(line string) (pdu gosnmp.SnmpPDU, err error) {
+	parts := strings.SplitN(line, "|", 3)
+	if len(parts) != 3 {
+		return pdu, fmt.Errorf("\"<oid>|<tag>|<value>\" is expected")
+	}
+	oid, tag, value := strings.TrimSpace(parts[0]), parts[1], parts[2]
+
+	if strings.Trim(oid, ".0123456789") != "" || strings.Trim(oid, ".") == "" {
+		return pdu, fmt.Errorf("bad OID %q", oid)
+	}
+	pdu.Name = snmp_oid.Normalize(oid)
+
+	hexValue := strings.HasSuffix(tag, "x")
+	n, err := strconv.Atoi(strings.TrimSuffix(tag, "x"))
+	if err != nil {
+		return pdu, fmt.Errorf("unsupported tag %q of %s", tag, oid)
+	}
+	var ok bool
+	if pdu.Type, ok = snmprecTypes[n]; !ok {
+		return pdu, fmt.Errorf("unsupported tag %q of %s", tag, oid)
+	}
+
+	if hexValue {
+		b, err := hex.DecodeString(value)
+		if err != nil {
+			return pdu, fmt.Errorf("bad hex value of %s: %s", oid, err)
+		}
+		value = string(b)
+	}
+
+	switch pdu.Type {
+	case gosnmp.OctetString, gosnmp.Opaque:
+		pdu.Value = []byte(value)
+	case gosnmp.Null:
+	case gosnmp.ObjectIdentifier:
+		pdu.Value = snmp_oid.Normalize(value)
+	case gosnmp.IPAddress:
+		// binary addresses are written in hex
+		if hexValue && len(value) == net.IPv4len {
+			value = net.IP(value).String()
+		}
+		if ip := net.ParseIP(value); ip == nil || ip.To4() == nil {
+			return pdu, fmt.Errorf("bad IP address %q of %s", value, oid)
+		}
+		pdu.Value = value
+	case gosnmp.Integer:
+		var i int64
+		i, err = strconv.ParseInt(value, 10, 32)
+		pdu.Value = int(i)
+	case gosnmp.Counter64:
+		pdu.Value, err = strconv.ParseUint(value, 10, 64)
+	default:
+		var u uint64
+		u, err = strconv.ParseUint(value, 10, 32)
+		pdu.Value = uint32(u)
+	}
+	if err != nil {
+		return pdu, fmt.Errorf("bad value %q of %s: %s", value, oid, err)
+	}
+
+	return pdu, nil
+}
+
+// Read variables from snmprec file, result is sorted by OIDs
+// Empty lines and lines starting with "#" are skipped
+func ReadSnmprec(input io.Reader) ([]gosnmp.SnmpPDU, error) {
+	pdus := make([]gosnmp.SnmpPDU, 0)
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pdu, err := ParseSnmprecLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		pdus = append(pdus, pdu)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(pdus, func(i, j int) bool { return snmp_oid.Compare(pdus[i].Name, pdus[j].Name) < 0 })
+
+	return pdus, nil
+}
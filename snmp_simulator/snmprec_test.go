@@ -0,0 +1,68 @@
+package snmp_simulator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/contactless/wbgo/testutils"
+	"github.com/gosnmp/gosnmp"
+)
+
+type SnmprecSuite struct {
+	testutils.Suite
+}
+
+func (s *SnmprecSuite) TestRead() {
+	pdus, err := ReadSnmprec(strings.NewReader(`# UPS
+1.3.6.1.2.1.1.3.0|67|12345
+1.3.6.1.2.1.1.1.0|4|Smart UPS 1500
+1.3.6.1.2.1.1.2.0|6|1.3.6.1.4.1.318
+1.3.6.1.2.1.2.2.1.6.1|4x|001aff
+
+1.3.6.1.2.1.4.20.1.1.10.0.0.1|64|10.0.0.1
+1.3.6.1.2.1.4.20.1.1.10.0.0.2|64x|0a000002
+1.3.6.1.2.1.31.1.1.1.6.1|70|1099511627776
+1.3.6.1.2.1.33.1.2.3.0|2|-15
+1.3.6.1.2.1.33.1.3.3.1.3.1|66|230
+1.3.6.1.2.1.2.2.1.10.1|65|4000000000
+1.3.6.1.2.1.1.10.0|5|
+`))
+	s.Ck("can't read snmprec", err)
+
+	s.Equal([]gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: gosnmp.OctetString, Value: []byte("Smart UPS 1500")},
+		{Name: ".1.3.6.1.2.1.1.2.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.318"},
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(12345)},
+		{Name: ".1.3.6.1.2.1.1.10.0", Type: gosnmp.Null},
+		{Name: ".1.3.6.1.2.1.2.2.1.6.1", Type: gosnmp.OctetString, Value: []byte{0x00, 0x1a, 0xff}},
+		{Name: ".1.3.6.1.2.1.2.2.1.10.1", Type: gosnmp.Counter32, Value: uint32(4000000000)},
+		{Name: ".1.3.6.1.2.1.4.20.1.1.10.0.0.1", Type: gosnmp.IPAddress, Value: "10.0.0.1"},
+		{Name: ".1.3.6.1.2.1.4.20.1.1.10.0.0.2", Type: gosnmp.IPAddress, Value: "10.0.0.2"},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.1", Type: gosnmp.Counter64, Value: uint64(1099511627776)},
+		{Name: ".1.3.6.1.2.1.33.1.2.3.0", Type: gosnmp.Integer, Value: -15},
+		{Name: ".1.3.6.1.2.1.33.1.3.3.1.3.1", Type: gosnmp.Gauge32, Value: uint32(230)},
+	}, pdus)
+}
+
+func (s *SnmprecSuite) TestErrors() {
+	for _, line := range []string{
+		"1.3.6.1.2.1.1.3.0|67",
+		"1.3.6.1.2.1.1.3.0|99|1",
+		"1.3.6.1.2.1.1.3.0|4:numeric|rate=10",
+		"sysUpTime.0|67|1",
+		"1.3.6.1.2.1.1.3.0|67|-1",
+		"1.3.6.1.2.1.1.3.0|2|x",
+		"1.3.6.1.2.1.1.3.0|4x|zz",
+		"1.3.6.1.2.1.1.3.0|64|host",
+	} {
+		_, err := ReadSnmprec(strings.NewReader("# comment\n" + line + "\n"))
+		s.Error(err, line)
+		if err != nil {
+			s.Contains(err.Error(), "line 2: ", line)
+		}
+	}
+}
+
+func TestSnmprec(t *testing.T) {
+	testutils.RunSuites(t, new(SnmprecSuite))
+}